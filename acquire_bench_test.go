@@ -0,0 +1,222 @@
+package rlock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// benchTable is an in-memory stand-in for the lock table, understanding just
+// the statements of the uncontended acquire and unlock paths, so that
+// benchmarks measure rlock rather than a database (or sqlmock).
+type benchTable struct {
+	mu         sync.Mutex
+	rows       map[string]*benchRow
+	statements int64
+}
+
+type benchRow struct {
+	owner string
+	inUse bool
+	count int64
+}
+
+type benchResult struct {
+	insertID, affected int64
+}
+
+func (r benchResult) LastInsertId() (int64, error) { return r.insertID, nil }
+func (r benchResult) RowsAffected() (int64, error) { return r.affected, nil }
+
+func (t *benchTable) Connect(context.Context) (driver.Conn, error) { return t, nil }
+func (t *benchTable) Driver() driver.Driver                        { return nil }
+
+func (t *benchTable) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+
+func (t *benchTable) Close() error { return nil }
+func (t *benchTable) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+func (t *benchTable) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	atomic.AddInt64(&t.statements, 1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO"):
+		name, owner := args[0].Value.(string), args[1].Value.(string)
+
+		row, ok := t.rows[name]
+		if !ok {
+			t.rows[name] = &benchRow{owner: owner, inUse: true, count: 1}
+			return benchResult{int64(len(t.rows)), 1}, nil
+		}
+
+		if !strings.Contains(query, "ON DUPLICATE KEY UPDATE") {
+			return nil, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+		}
+
+		if row.inUse || strings.Contains(query, "UPDATE name=name") {
+			return benchResult{0, 0}, nil
+		}
+
+		row.owner, row.inUse = owner, true
+		row.count++
+
+		return benchResult{row.count, 2}, nil
+	case strings.Contains(query, "SET in_use=0"):
+		name := args[1].Value.(string)
+
+		t.rows[name].inUse = false
+
+		return benchResult{0, 1}, nil
+	case strings.Contains(query, "in_use=1 WHERE name=? AND in_use=0"):
+		name := args[len(args)-2].Value.(string)
+
+		row := t.rows[name]
+		if row.inUse {
+			return benchResult{0, 0}, nil
+		}
+
+		row.owner, row.inUse = args[0].Value.(string), true
+		row.count++
+
+		return benchResult{row.count, 1}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected statement: %v", query)
+}
+
+func (t *benchTable) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	atomic.AddInt64(&t.statements, 1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !strings.HasPrefix(query, "SELECT * FROM") {
+		return nil, fmt.Errorf("unexpected query: %v", query)
+	}
+
+	row, ok := t.rows[args[0].Value.(string)]
+	if !ok {
+		return &benchRows{}, nil
+	}
+
+	inUse := []byte{0}
+	if row.inUse {
+		inUse = []byte{1}
+	}
+
+	return &benchRows{values: [][]driver.Value{
+		{args[0].Value, row.owner, inUse, time.Now(), row.count},
+	}}, nil
+}
+
+type benchRows struct {
+	values [][]driver.Value
+}
+
+func (r *benchRows) Columns() []string {
+	return []string{"name", "owner", "in_use", "last_used", "acquire_count"}
+}
+
+func (r *benchRows) Close() error { return nil }
+
+func (r *benchRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+func newBenchRLock(b *testing.B) (*RLock, *benchTable) {
+	table := &benchTable{rows: make(map[string]*benchRow)}
+
+	rl, err := New(sqlx.NewDb(sql.OpenDB(table), "mysql"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return rl, table
+}
+
+func reportStatements(b *testing.B, table *benchTable) {
+	b.ReportMetric(float64(atomic.LoadInt64(&table.statements))/float64(b.N), "stmts/op")
+}
+
+// BenchmarkAcquireReleased acquires (and unlocks) a lock whose row exists but
+// is not in use, the steady state of a lock used over and over.
+func BenchmarkAcquireReleased(b *testing.B) {
+	rl, table := newBenchRLock(b)
+
+	l, err := rl.Lock("bench", 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if err := l.Unlock(nil); err != nil {
+		b.Fatal(err)
+	}
+
+	atomic.StoreInt64(&table.statements, 0)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l, err := rl.Lock("bench", 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if err := l.Unlock(nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	reportStatements(b, table)
+}
+
+// BenchmarkAcquireFresh acquires (and unlocks) locks that do not exist yet.
+func BenchmarkAcquireFresh(b *testing.B) {
+	rl, table := newBenchRLock(b)
+
+	names := make([]string, b.N)
+	for i := range names {
+		names[i] = fmt.Sprintf("bench-%d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l, err := rl.Lock(names[i], 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if err := l.Unlock(nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	reportStatements(b, table)
+}
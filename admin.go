@@ -0,0 +1,217 @@
+package rlock
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ListLocks returns every lock entry in the lock table, ordered by name. When
+// given labels, only entries whose holder was labeled with every one of them
+// (see WithOwnerLabels) are returned.
+func (r *RLock) ListLocks(labels ...Label) ([]*LockEntry, error) {
+	conds, args := r.environmentConditions()
+
+	where, args, err := whereLabels(conds, args, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := r.selectLocks(db, r.tables(), where+"ORDER BY name", args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list locks: %v", err)
+	}
+
+	return entries, nil
+}
+
+// FindLocks returns every lock entry whose name matches pattern, ordered by
+// name. In pattern, '*' matches any number of characters and '?' matches a
+// single character (ie. "customer-*"); patterns starting with a literal
+// prefix are resolved using the index on name.
+func (r *RLock) FindLocks(pattern string) ([]*LockEntry, error) {
+	pattern = r.normalizeName(pattern)
+
+	db, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := r.selectLocks(db, r.tables(), "WHERE name LIKE ? ESCAPE '!' ORDER BY name", globToLike(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("unable to find locks matching '%v': %v", pattern, err)
+	}
+
+	return entries, nil
+}
+
+// globToLike converts a glob pattern into a LIKE pattern using '!' as the
+// escape character (which, unlike backslash, is not affected by sql_mode).
+func globToLike(pattern string) string {
+	var b strings.Builder
+
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteRune('%')
+		case '?':
+			b.WriteRune('_')
+		case '%', '_', '!':
+			b.WriteRune('!')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// globToRegexp compiles a glob pattern (see FindLocks()) into a regular
+// expression matching whole names.
+func globToRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+
+	b.WriteRune('^')
+
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteRune('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteRune('$')
+
+	return regexp.MustCompile(b.String())
+}
+
+// escapeLike escapes LIKE wildcards in s using '!' as the escape character.
+func escapeLike(s string) string {
+	var b strings.Builder
+
+	for _, c := range s {
+		if c == '%' || c == '_' || c == '!' {
+			b.WriteRune('!')
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// GetLocksByOwner returns every lock entry attributed to owner (ie. the
+// Owner() of another RLock instance), ordered by name. Entries are returned
+// whether or not they are in use; check InUse to tell held locks apart. When
+// given labels, only entries labeled with every one of them are returned (see
+// ListLocks()).
+func (r *RLock) GetLocksByOwner(owner string, labels ...Label) ([]*LockEntry, error) {
+	conds, args := r.environmentConditions()
+
+	where, args, err := whereLabels(append(conds, "owner=?"), append(args, owner), labels)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := r.selectLocks(db, r.tables(), where+"ORDER BY name", args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list locks owned by '%v': %v", owner, err)
+	}
+
+	return entries, nil
+}
+
+// Owner returns the ID this RLock instance records as the owner of the locks
+// it acquires.
+func (r *RLock) Owner() string {
+	return r.owner
+}
+
+// Status returns the lock entry for the given name or KeyNotFoundErr if the
+// lock does not exist.
+func (r *RLock) Status(name string) (*LockEntry, error) {
+	name = r.normalizeName(name)
+
+	if entry := r.statusCache.get(name, r.clock.Now()); entry != nil {
+		return entry, nil
+	}
+
+	entry, err := r.status(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.statusCache.put(entry, r.clock.Now())
+
+	return entry, nil
+}
+
+func (r *RLock) status(name string) (*LockEntry, error) {
+	db, err := r.reader(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.trackWaiters {
+		return r.getLockEntry(db, name)
+	}
+
+	entries, err := r.selectLocks(db, []string{r.tableFor(name)}, "WHERE name=?", name)
+	if err != nil {
+		r.observeError(err)
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, KeyNotFoundErr
+	}
+
+	return entries[0], nil
+}
+
+// ForceUnlock releases a lock regardless of who owns it. This is intended for
+// operators dealing with a wedged lock holder; the current holder will NOT be
+// notified and will continue to believe it holds the lock. Returns
+// KeyNotFoundErr if there is no such lock in use.
+func (r *RLock) ForceUnlock(name, reason string) error {
+	name = r.normalizeName(name)
+	reason = r.lastErrorText(reason)
+
+	query := fmt.Sprintf("UPDATE %v SET in_use=0, last_error=? WHERE name=? AND in_use=1", r.tableFor(name))
+
+	res, err := r.exec(query, reason, name)
+	if err != nil {
+		return fmt.Errorf("unable to force unlock '%v': %v", name, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to determine affected rows after force unlock for '%v': %v", name, err)
+	}
+
+	if affected == 0 {
+		return KeyNotFoundErr
+	}
+
+	r.mutated(name)
+	r.auditRelease(name, "", ExitForceUnlocked, reason)
+	r.emit(EventForceUnlocked, name, "", reason)
+	r.notifyRelease(name)
+
+	return nil
+}
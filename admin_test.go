@@ -0,0 +1,147 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var lockEntryColumns = []string{
+	"id", "name", "owner", "in_use", "last_error", "last_used", "created_at",
+}
+
+var _ = Describe("Admin", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	Describe("ListLocks", func() {
+		It("returns every lock entry", func() {
+			rows := sqlmock.NewRows(lockEntryColumns).
+				AddRow(1, "a", "owner-a", []byte{1}, "", time.Now(), time.Now()).
+				AddRow(2, "b", "owner-b", []byte{0}, "foo", time.Now(), time.Now())
+
+			mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM %v ORDER BY name`, TableName)).
+				WillReturnRows(rows)
+
+			entries, err := rl.ListLocks()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Name).To(Equal("a"))
+			Expect(bool(entries[0].InUse)).To(BeTrue())
+			Expect(entries[1].LastError).To(Equal("foo"))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("returns an error when the query fails", func() {
+			mock.ExpectQuery(`SELECT \* FROM`).WillReturnError(fmt.Errorf("boom"))
+
+			entries, err := rl.ListLocks()
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("boom"))
+			Expect(entries).To(BeNil())
+		})
+	})
+
+	Describe("Status", func() {
+		It("includes holder details", func() {
+			acquiredAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+			rows := sqlmock.NewRows(append(lockEntryColumns, "acquired_at", "acquire_count", "host", "pid")).
+				AddRow(1, "a", "owner-a", []byte{1}, "", time.Now(), time.Now(), acquiredAt, 42, "worker-1", 1234)
+
+			mock.ExpectQuery(`SELECT \* FROM`).WithArgs("a").WillReturnRows(rows)
+
+			entry, err := rl.Status("a")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.AcquiredAt).To(Equal(acquiredAt))
+			Expect(entry.AcquireCount).To(Equal(int64(42)))
+			Expect(entry.Host).To(Equal("worker-1"))
+			Expect(entry.PID).To(Equal(1234))
+		})
+	})
+
+	Describe("FindLocks", func() {
+		It("matches names using an escaped LIKE pattern", func() {
+			rows := sqlmock.NewRows(lockEntryColumns).
+				AddRow(1, "customer-1", "owner-a", []byte{1}, "", time.Now(), time.Now())
+
+			mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM %v WHERE name LIKE \? ESCAPE '!' ORDER BY name`, TableName)).
+				WithArgs("customer-%").
+				WillReturnRows(rows)
+
+			entries, err := rl.FindLocks("customer-*")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("converts globs to LIKE patterns", func() {
+			Expect(globToLike("invoice-*")).To(Equal("invoice-%"))
+			Expect(globToLike("job-?")).To(Equal("job-_"))
+			Expect(globToLike("100%_done!")).To(Equal("100!%!_done!!"))
+		})
+	})
+
+	Describe("GetLocksByOwner", func() {
+		It("returns the lock entries attributed to the owner", func() {
+			rows := sqlmock.NewRows(lockEntryColumns).
+				AddRow(1, "a", "owner-a", []byte{1}, "", time.Now(), time.Now())
+
+			mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM %v WHERE owner=\? ORDER BY name`, TableName)).
+				WithArgs("owner-a").
+				WillReturnRows(rows)
+
+			entries, err := rl.GetLocksByOwner("owner-a")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Owner).To(Equal("owner-a"))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("returns an error when the query fails", func() {
+			mock.ExpectQuery(`SELECT \* FROM`).WillReturnError(fmt.Errorf("boom"))
+
+			_, err := rl.GetLocksByOwner("owner-a")
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ForceUnlock", func() {
+		It("releases the lock regardless of owner", func() {
+			events, cancel := rl.Subscribe(1)
+			defer cancel()
+
+			mock.ExpectExec(fmt.Sprintf(`^UPDATE %v SET in_use=0, last_error=.+ WHERE name=.+ AND in_use=1$`, TableName)).
+				WithArgs("wedged", "a").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Expect(rl.ForceUnlock("a", "wedged")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+			var event *Event
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(EventForceUnlocked))
+		})
+
+		It("returns KeyNotFoundErr when no lock is in use", func() {
+			mock.ExpectExec(`UPDATE`).WillReturnResult(sqlmock.NewResult(1, 0))
+
+			Expect(rl.ForceUnlock("a", "")).To(Equal(KeyNotFoundErr))
+		})
+	})
+})
@@ -0,0 +1,169 @@
+package rlock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ArchiveDisabledErr is returned by ArchivedLocks unless WithArchive is used.
+var ArchiveDisabledErr = errors.New("archive is not enabled (see WithArchive)")
+
+const archiveSchemaDDL = "CREATE TABLE IF NOT EXISTS `%v` (\n" +
+	"  `id` BIGINT NOT NULL AUTO_INCREMENT,\n" +
+	"  `name` VARCHAR(255) NOT NULL,\n" +
+	"  `owner` VARCHAR(255) NOT NULL,\n" +
+	"  `in_use` BIT(1) NOT NULL DEFAULT b'0',\n" +
+	"  `last_error` VARCHAR(2048) NOT NULL DEFAULT '',\n" +
+	"  `last_used` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"%v" +
+	"  `archived_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  KEY `name_id` (`name`, `id`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+// WithArchive moves lock rows to the archive table ("<table>_history", see
+// ArchiveSchema()) when they are unlocked and when they are purged (see
+// Purge() and PurgeDeleted()) instead of leaving them in (or deleting them
+// from) the lock table. This keeps the lock table, and with it the unique
+// index every acquisition goes through, down to the locks in use, while
+// retaining every hold for analysis (see ArchivedLocks()). Locks acquired
+// again continue the acquire count (see Lock.Token()) and last error of their
+// newest archived row. Locks claimed via Claim() must stay in the lock table
+// and should not be used with this option.
+func WithArchive() Option {
+	return func(r *RLock) error {
+		r.archive = true
+		return nil
+	}
+}
+
+// ArchiveSchema returns the MySQL DDL creating the archive table for a lock
+// table called table (see WithArchive).
+func ArchiveSchema(table string) string {
+	return fmt.Sprintf(archiveSchemaDDL, table+"_history", columnDDL(schemaColumns))
+}
+
+func (r *RLock) archiveTable() string {
+	return r.table + "_history"
+}
+
+// ArchivedLocks returns the last limit archived rows of the lock called name,
+// most recent first (see LockEntry.ArchivedAt). Returns ArchiveDisabledErr
+// unless WithArchive is used.
+func (r *RLock) ArchivedLocks(name string, limit int) ([]*LockEntry, error) {
+	name = r.normalizeName(name)
+
+	if !r.archive {
+		return nil, ArchiveDisabledErr
+	}
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+
+	db, err := r.reader(name)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %v WHERE name=? ORDER BY id DESC LIMIT ?", r.archiveTable())
+
+	entries := make([]*LockEntry, 0)
+
+	if err := r.selectFrom(db, &entries, query, name, limit); err != nil {
+		return nil, fmt.Errorf("unable to fetch archived locks of '%v': %v", name, err)
+	}
+
+	return entries, nil
+}
+
+// archiveColumns returns the lock table columns kept in the archive.
+func archiveColumns() []string {
+	columns := make([]string, 0, len(initialSchemaColumns)+len(schemaColumns))
+
+	for _, c := range append(initialSchemaColumns, schemaColumns...) {
+		if c.name != "id" {
+			columns = append(columns, c.name)
+		}
+	}
+
+	return columns
+}
+
+// archived returns the expression selecting column of the newest archived
+// row of the lock whose name is the expression's only arg.
+func (r *RLock) archived(column string) string {
+	return fmt.Sprintf("(SELECT %v FROM %v WHERE name=? ORDER BY id DESC LIMIT 1)", column, r.archiveTable())
+}
+
+// moveToArchive moves the rows of table matching cond (with args) to the
+// archive in a single transaction, returning the result of deleting them.
+// Columns in set are archived as the given expressions rather than as is;
+// setArgs are the args of those expressions, in column order.
+func (r *RLock) moveToArchive(table, cond string, args []interface{}, set map[string]string, setArgs ...interface{}) (sql.Result, error) {
+	columns := archiveColumns()
+	values := make([]string, len(columns))
+
+	for i, c := range columns {
+		values[i] = c
+
+		if expr, ok := set[c]; ok {
+			values[i] = expr
+		}
+	}
+
+	ctx, cancel := r.statementContext()
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start archive transaction: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("INSERT INTO %v (%v) SELECT %v FROM %v WHERE %v", r.archiveTable(),
+		strings.Join(columns, ", "), strings.Join(values, ", "), table, cond)
+
+	if _, err := tx.ExecContext(ctx, query, append(setArgs, args...)...); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %v WHERE %v", table, cond), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("unable to commit archive transaction: %v", err)
+	}
+
+	return res, nil
+}
+
+// insertReleased inserts the lock called name as held by us, which is how
+// waiters take over released locks when archiving (their rows are gone once
+// released, so takeover() has nothing to update). Returns lockInUseErr while
+// the lock's row still exists.
+func (r *RLock) insertReleased(ctx context.Context, name string) (int64, error) {
+	query, args := r.insertQuery(ctx, name)
+
+	res, err := r.exec(query, args...)
+	if err != nil {
+		if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1062 {
+			return 0, lockInUseErr
+		}
+
+		r.observeError(err)
+
+		return 0, fmt.Errorf("unable to insert lock for '%s': %v", name, err)
+	}
+
+	return r.insertedToken(res)
+}
@@ -0,0 +1,171 @@
+package rlock
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithArchive", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithArchive())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("continues the acquire count and last error of archived rows", func() {
+		mock.ExpectExec(`INSERT INTO rlock \(name, owner, in_use, acquired_at, acquire_count, host, pid, last_error\) `+
+			`VALUES\(\?, \?, 1, NOW\(\), LAST_INSERT_ID\(COALESCE\(\(SELECT acquire_count FROM rlock_history WHERE name=\? ORDER BY id DESC LIMIT 1\), 0\)\+1\), \?, \?, `+
+			`COALESCE\(\(SELECT last_error FROM rlock_history WHERE name=\? ORDER BY id DESC LIMIT 1\), ''\)\)`).
+			WithArgs("foo", rl.owner, "foo", rl.host, rl.pid, "foo").
+			WillReturnResult(sqlmock.NewResult(8, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Token()).To(Equal(int64(8)))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("moves unlocked rows to the archive", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO rlock_history \(name, owner, in_use, last_error, last_used, created_at, acquired_at, .*\) `+
+			`SELECT name, owner, 0, \?, NOW\(\), created_at, acquired_at, .* FROM rlock WHERE name=\? AND owner=\?`).
+			WithArgs("boom", "foo", rl.owner).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DELETE FROM rlock WHERE name=\? AND owner=\?`).
+			WithArgs("foo", rl.owner).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(l.Unlock(errors.New("boom"))).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("hands locks to waiters once their row is archived", func() {
+		clock := NewFakeClock(time.Now())
+
+		waiter, err := New(rl.db, WithArchive(), WithClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock").WithArgs("foo", rl.owner, "foo", rl.host, rl.pid, "foo").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		// The waiter finds the lock in use, and still in use when trying
+		// again right away
+		mock.ExpectExec("INSERT INTO rlock").WithArgs("foo", waiter.owner, "foo", waiter.host, waiter.pid, "foo").
+			WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(1, "foo", rl.owner, []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec("INSERT INTO rlock").WithArgs("foo", waiter.owner, "foo", waiter.host, waiter.pid, "foo").
+			WillReturnError(&mysql.MySQLError{Number: 1062})
+
+		acquired := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := waiter.Lock("foo", WaitForever)
+			Expect(err).ToNot(HaveOccurred())
+
+			acquired <- l
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO rlock_history").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("DELETE FROM rlock").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(l.Unlock(nil)).To(Succeed())
+
+		// With the row gone, inserting it again is what acquires it
+		mock.ExpectExec("INSERT INTO rlock").WithArgs("foo", waiter.owner, "foo", waiter.host, waiter.pid, "foo").
+			WillReturnResult(sqlmock.NewResult(2, 1))
+
+		clock.Advance(time.Minute)
+
+		var next *Lock
+		Eventually(acquired).Should(Receive(&next))
+		Expect(next.Token()).To(Equal(int64(2)))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("leaves rows alone when archiving fails", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO rlock_history`).WillReturnError(errors.New("table is full"))
+		mock.ExpectRollback()
+
+		Expect(l.Unlock(nil)).ToNot(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("moves purged rows to the archive", func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO rlock_history \(.*\) SELECT name, owner, in_use, last_error, last_used, .* FROM rlock WHERE in_use=0 AND last_used < \?`).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`DELETE FROM rlock WHERE in_use=0 AND last_used < \?`).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		purged, err := rl.Purge(time.Hour)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(purged).To(Equal(int64(2)))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("creates locks continuing archived rows", func() {
+		mock.ExpectExec(`INSERT IGNORE INTO rlock \(name, owner, in_use, acquire_count, last_error\) VALUES `+
+			`\(\?, '', 0, COALESCE\(\(SELECT acquire_count FROM rlock_history .*\), 0\), COALESCE\(\(SELECT last_error FROM rlock_history .*\), ''\)\)`).
+			WithArgs("foo", "foo", "foo").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(rl.CreateLocks("foo")).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("returns archived rows", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock_history WHERE name=\? ORDER BY id DESC LIMIT \?`).
+			WithArgs("foo", 10).
+			WillReturnRows(sqlmock.NewRows(append(lockEntryColumns, "archived_at")).
+				AddRow(1, "foo", "owner-a", []byte{0}, "", time.Now(), time.Now(), time.Now()))
+
+		entries, err := rl.ArchivedLocks("foo", 10)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].ArchivedAt).ToNot(BeNil())
+	})
+
+	It("requires the archive to be enabled", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = rl.ArchivedLocks("foo", 10)
+		Expect(err).To(Equal(ArchiveDisabledErr))
+	})
+})
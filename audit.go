@@ -0,0 +1,158 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var AuditDisabledErr = errors.New("audit log is not enabled (see WithAuditLog)")
+
+// ExitStatus describes how a lock hold ended.
+type ExitStatus string
+
+const (
+	// ExitHeld means the hold has not ended (yet)
+	ExitHeld ExitStatus = ""
+
+	// ExitUnlocked means the holder unlocked the lock cleanly
+	ExitUnlocked ExitStatus = "unlocked"
+
+	// ExitError means the holder unlocked the lock passing an error
+	ExitError ExitStatus = "error"
+
+	// ExitTakenOver means the lock went stale and was taken over
+	ExitTakenOver ExitStatus = "taken_over"
+
+	// ExitForceUnlocked means an operator force unlocked the lock
+	ExitForceUnlocked ExitStatus = "force_unlocked"
+
+	// ExitReaped means the lock went stale and was released by the reaper
+	ExitReaped ExitStatus = "reaped"
+)
+
+// AcquireMode describes how a lock was acquired.
+type AcquireMode string
+
+const (
+	// AcquireFresh means nobody held the lock before (its row was created)
+	AcquireFresh AcquireMode = "fresh"
+
+	// AcquireHandoff means the lock was acquired after the previous holder
+	// (or an operator, see ForceUnlock) released it
+	AcquireHandoff AcquireMode = "handoff"
+
+	// AcquireStaleTakeover means the previous holder still held the lock but
+	// it went stale and was forcibly taken over
+	AcquireStaleTakeover AcquireMode = "stale_takeover"
+)
+
+// HistoryEntry describes a single hold of a lock, as recorded in the audit
+// log.
+type HistoryEntry struct {
+	ID         int64      `db:"id" json:"id"`
+	Name       string     `db:"name" json:"name"`
+	Owner      string     `db:"owner" json:"owner"`
+	Host       string     `db:"host" json:"host"`
+	PID        int        `db:"pid" json:"pid"`
+	AcquiredAt time.Time  `db:"acquired_at" json:"acquired_at"`
+	ReleasedAt *time.Time `db:"released_at" json:"released_at,omitempty"`
+	ExitStatus ExitStatus `db:"exit_status" json:"exit_status"`
+	LastError  string     `db:"last_error" json:"last_error"`
+
+	// How the lock was acquired, from whom and why (ie. how stale the
+	// previous hold was when it was taken over)
+	AcquireMode   AcquireMode `db:"acquire_mode" json:"acquire_mode"`
+	PreviousOwner string      `db:"previous_owner" json:"previous_owner"`
+	Evidence      string      `db:"evidence" json:"evidence"`
+
+	// See WithCorrelationID
+	CorrelationID string `db:"correlation_id" json:"correlation_id,omitempty"`
+}
+
+// WithAuditLog records every hold (who held the lock, when and how the hold
+// ended) in an audit table named after the lock table with an "_audit"
+// suffix; see History(). EnsureSchema() creates the audit table. Audit writes
+// are best effort; failing to record one does not fail the lock operation.
+func WithAuditLog() Option {
+	return func(r *RLock) error {
+		r.audit = true
+		return nil
+	}
+}
+
+func (r *RLock) auditTable() string {
+	return r.table + "_audit"
+}
+
+// History returns the last limit holds of the lock called name, most recent
+// first. Returns AuditDisabledErr unless WithAuditLog is used.
+func (r *RLock) History(name string, limit int) ([]*HistoryEntry, error) {
+	name = r.normalizeName(name)
+
+	if !r.audit {
+		return nil, AuditDisabledErr
+	}
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %v WHERE name=? ORDER BY id DESC LIMIT ?", r.auditTable())
+
+	db, err := r.reader(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*HistoryEntry, 0)
+
+	if err := r.selectFrom(db, &entries, query, name, limit); err != nil {
+		return nil, fmt.Errorf("unable to fetch history for '%v': %v", name, err)
+	}
+
+	return entries, nil
+}
+
+// auditAcquire records that we started holding name (acquired with ctx), how
+// we got it (mode), from whom and why.
+func (r *RLock) auditAcquire(ctx context.Context, name string, mode AcquireMode, previousOwner, evidence string) {
+	if !r.audit {
+		return
+	}
+
+	query := fmt.Sprintf("INSERT INTO %v (name, owner, host, pid, acquired_at, acquire_mode, previous_owner, evidence) "+
+		"VALUES(?, ?, ?, ?, NOW(), ?, ?, ?)", r.auditTable())
+	args := []interface{}{name, r.owner, r.host, r.pid, string(mode), previousOwner, evidence}
+
+	if r.correlationIDs {
+		query = fmt.Sprintf("INSERT INTO %v (name, owner, host, pid, acquired_at, acquire_mode, previous_owner, evidence, correlation_id) "+
+			"VALUES(?, ?, ?, ?, NOW(), ?, ?, ?, ?)", r.auditTable())
+		args = append(args, r.correlationIDFrom(ctx))
+	}
+
+	if _, err := r.exec(query, args...); err != nil {
+		withError(r.logFor(name), err).Warn("unable to record acquisition in audit log")
+	}
+}
+
+// auditRelease records that the hold of name by owner (or by whoever holds it,
+// if owner is empty) ended.
+func (r *RLock) auditRelease(name, owner string, status ExitStatus, lastError string) {
+	if !r.audit {
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE %v SET released_at=NOW(), exit_status=?, last_error=? WHERE name=? AND released_at IS NULL", r.auditTable())
+	args := []interface{}{string(status), lastError, name}
+
+	if owner != "" {
+		query += " AND owner=?"
+		args = append(args, owner)
+	}
+
+	if _, err := r.exec(query, args...); err != nil {
+		withError(r.logFor(name), err).Warn("unable to record release in audit log")
+	}
+}
@@ -0,0 +1,142 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Audit log", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithAuditLog())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("records holds and how they ended", func() {
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO rlock_audit").
+			WithArgs("foo", rl.owner, rl.host, rl.pid, "fresh", "", "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE rlock_audit SET released_at=NOW\(\), exit_status=\?, last_error=\? WHERE name=\? AND released_at IS NULL AND owner=\?`).
+			WithArgs("error", "boom", "foo", rl.owner).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(l.Unlock(fmt.Errorf("boom"))).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("closes the displaced holder's entry on forced takeovers", func() {
+		evidence := fmt.Sprintf("in_use=true, last used %v ago (max age %v)", 2*MaxAge, MaxAge)
+
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now().Add(-2*MaxAge), time.Now()))
+		mock.ExpectExec("UPDATE rlock SET .*takeover_count=takeover_count\\+1").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock_audit").
+			WithArgs("taken_over", evidence, "foo", "other-owner").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO rlock_audit").
+			WithArgs("foo", rl.owner, rl.host, rl.pid, "stale_takeover", "other-owner", evidence).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("records clean handoffs of released locks without a forced takeover", func() {
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{0}, "", time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET .* WHERE name=\? AND in_use=0 AND owner=\?`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO rlock_audit").
+			WithArgs("foo", rl.owner, rl.host, rl.pid, "handoff", "other-owner", "in_use=false").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.tookOver).To(BeFalse())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("does not fail lock operations when the audit log cannot be written", func() {
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO rlock_audit").WillReturnError(fmt.Errorf("no such table"))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("History", func() {
+		It("returns the most recent holds first", func() {
+			released := time.Now()
+
+			rows := sqlmock.NewRows([]string{"id", "name", "owner", "host", "pid", "acquired_at", "released_at", "exit_status", "last_error"}).
+				AddRow(2, "foo", "b", "host-b", 2, time.Now(), nil, "", "").
+				AddRow(1, "foo", "a", "host-a", 1, time.Now(), released, "unlocked", "")
+
+			mock.ExpectQuery(`SELECT \* FROM rlock_audit WHERE name=\? ORDER BY id DESC LIMIT \?`).
+				WithArgs("foo", 2).
+				WillReturnRows(rows)
+
+			history, err := rl.History("foo", 2)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].ExitStatus).To(Equal(ExitHeld))
+			Expect(history[0].ReleasedAt).To(BeNil())
+			Expect(history[1].ExitStatus).To(Equal(ExitUnlocked))
+			Expect(*history[1].ReleasedAt).To(BeTemporally("~", released))
+		})
+
+		It("validates the limit", func() {
+			_, err := rl.History("foo", 0)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("requires the audit log", func() {
+			_, _, plain := setupMocks()
+
+			_, err := plain.History("foo", 10)
+			Expect(err).To(Equal(AuditDisabledErr))
+		})
+	})
+
+	It("creates the audit table in EnsureSchema", func() {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock_audit`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT column_name").WillReturnRows(sqlmock.NewRows([]string{"column_name"}))
+
+		for range schemaColumns {
+			mock.ExpectExec("ALTER TABLE `rlock` ADD").WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+
+		mock.ExpectQuery("SELECT column_name").WithArgs("rlock_audit").
+			WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow("acquire_mode"))
+		mock.ExpectExec("ALTER TABLE `rlock_audit` ADD COLUMN `previous_owner`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("ALTER TABLE `rlock_audit` ADD COLUMN `evidence`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("ALTER TABLE `rlock_audit` ADD COLUMN `correlation_id`").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectSchemaVersion(mock, SchemaVersion)
+
+		Expect(rl.EnsureSchema()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,255 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+// claimRow is the part of a lock row telling whether a batch claim (see
+// claimAll()) acquired it.
+type claimRow struct {
+	Name         string        `db:"name"`
+	Owner        string        `db:"owner"`
+	InUse        types.BitBool `db:"in_use"`
+	AcquireCount int64         `db:"acquire_count"`
+	Host         string        `db:"host"`
+	PID          int           `db:"pid"`
+}
+
+// claimAll acquires whichever of names (ie. the members of a LockAll() set)
+// are free right away, with a single multi-row INSERT ... ON DUPLICATE KEY
+// UPDATE per lock table rather than one round trip per lock: missing rows are
+// inserted and rows not in use are taken over, while rows in use are left
+// alone. MySQL does not report per-row outcomes, so the rows are read after
+// the statement to tell which locks it acquired (see claimBatch()). Locks it did not
+// acquire (or that are being acquired by other goroutines of this process)
+// are left to the regular acquire path, as are session locks and, when
+// archiving, every lock.
+func (r *RLock) claimAll(ctx context.Context, names []string, acquireTimeout time.Duration) map[string]*Lock {
+	claimed := make(map[string]*Lock)
+
+	if len(names) < 2 || r.sessionLocks || r.archive {
+		return claimed
+	}
+
+	start := r.clock.Now()
+
+	// Make sure no other goroutine of this process acquires the locks
+	// while we do; see lockContext()
+	gates := make(map[string]func(), len(names))
+	candidates := make([]string, 0, len(names))
+
+	for _, name := range names {
+		// Striped locks have no row of their own; see WithStripes
+		if r.stripesFor(name) > 1 {
+			continue
+		}
+
+		if release := r.gates.tryEnter(name); release != nil {
+			gates[name] = release
+			candidates = append(candidates, name)
+		}
+	}
+
+	defer func() {
+		for _, release := range gates {
+			release()
+		}
+	}()
+
+	unreserve, err := r.reserveQuota(len(candidates))
+	if err != nil {
+		return claimed
+	}
+
+	defer unreserve()
+
+	for table, names := range r.byTable(candidates) {
+		tokens, previous, err := r.claimBatch(ctx, table, names)
+		if err != nil {
+			withError(r.logger, err).Warn("unable to claim locks in a batch; acquiring them one by one")
+			continue
+		}
+
+		for _, name := range names {
+			token, ok := tokens[name]
+			if !ok {
+				continue
+			}
+
+			l := r.newLock(name, acquireTimeout, token)
+
+			if previousOwner, handoff := previous[name]; handoff {
+				r.auditAcquire(ctx, name, AcquireHandoff, previousOwner, "in_use=false")
+				r.emit(EventAcquired, name, previousOwner, "")
+			} else {
+				r.auditAcquire(ctx, name, AcquireFresh, "", "")
+				r.emit(EventAcquired, name, "", "")
+			}
+
+			r.recordAcquire(name, l, nil, r.clock.Now().Sub(start))
+
+			// Hybrid mode; keep the local mutex until the lock is unlocked
+			if r.localMutex {
+				l.releaseGate = gates[name]
+				delete(gates, name)
+			}
+
+			claimed[name] = l
+		}
+	}
+
+	return claimed
+}
+
+// claimBatch claims the locks called names in table (see claimAll()),
+// returning the acquire counts of the locks it acquired and the previous
+// owners of those it took over ("" when they need not be known; see
+// needsPreviousOwner()).
+//
+// The locks it acquired are the rows the statement leaves in use by us,
+// except the ones we held already: those held by this instance are known,
+// and those held by a previous incarnation of this owner (see WithOwner) were
+// acquired from another host or process. Only when the previous owners of
+// taken over locks are needed are the rows read before the statement as well.
+func (r *RLock) claimBatch(ctx context.Context, table string, names []string) (map[string]int64, map[string]string, error) {
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+
+	nameArgs := make([]interface{}, len(names))
+	for i, name := range names {
+		nameArgs[i] = name
+	}
+
+	op := r.startOp("claim_all", "")
+	defer op.done()
+
+	read := fmt.Sprintf("SELECT name, owner, in_use, acquire_count, host, pid FROM %v WHERE name IN (%v)", table, in)
+
+	prior := make(map[string]*claimRow)
+	readPrior := r.needsPreviousOwner()
+
+	if readPrior {
+		before := make([]*claimRow, 0, len(names))
+
+		if err := r.selectAll(&before, read, nameArgs...); err != nil {
+			r.observeError(err)
+			return nil, nil, fmt.Errorf("unable to read locks before claiming them: %v", err)
+		}
+
+		op.step("select")
+
+		for _, row := range before {
+			prior[row.Name] = row
+		}
+	}
+
+	columns, values := r.insertColumns()
+
+	rows := make([]string, 0, len(names))
+	args := make([]interface{}, 0)
+
+	for _, name := range names {
+		rows = append(rows, "("+values+")")
+		args = append(args, r.insertArgs(ctx, name)...)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %v (%v) VALUES %v ON DUPLICATE KEY UPDATE %v",
+		table, columns, strings.Join(rows, ", "), r.claimUpdate(columns))
+
+	if _, err := r.exec(query, args...); err != nil {
+		r.observeError(err)
+		return nil, nil, fmt.Errorf("unable to claim locks: %v", err)
+	}
+
+	op.step("insert")
+
+	after := make([]*claimRow, 0, len(names))
+
+	if err := r.selectAll(&after, read, nameArgs...); err != nil {
+		r.observeError(err)
+		return nil, nil, fmt.Errorf("unable to read locks after claiming them: %v", err)
+	}
+
+	op.step("select")
+
+	tokens := make(map[string]int64)
+	previous := make(map[string]string)
+
+	for _, row := range after {
+		if row.Owner != r.owner || !bool(row.InUse) || row.Host != r.host || row.PID != r.pid || r.holds(row.Name) {
+			continue
+		}
+
+		prev, existed := prior[row.Name]
+
+		// Already ours before the statement; it left the row alone
+		if existed && prev.AcquireCount == row.AcquireCount {
+			continue
+		}
+
+		tokens[row.Name] = row.AcquireCount
+
+		switch {
+		case existed:
+			previous[row.Name] = prev.Owner
+		case !readPrior && row.AcquireCount > 1:
+			// Taken over rather than inserted
+			previous[row.Name] = ""
+		}
+	}
+
+	return tokens, previous, nil
+}
+
+// holds returns whether this instance holds the lock called name.
+func (r *RLock) holds(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.held[name]
+
+	return ok
+}
+
+// claimUpdate returns the ON DUPLICATE KEY UPDATE clause of a batch claim
+// inserting columns (see insertColumns()): rows not in use are taken over
+// like takeover() does, rows in use are left alone.
+func (r *RLock) claimUpdate(columns string) string {
+	// Leave released locks to the waiters they were handed to; only missing
+	// rows are claimed
+	if r.queuedHandoff {
+		return "name=name"
+	}
+
+	sets := make([]string, 0)
+
+	for _, column := range strings.Split(columns, ", ") {
+		value := fmt.Sprintf("VALUES(%v)", column)
+
+		switch column {
+		case "name", "in_use":
+			continue
+		case "acquired_at":
+			value = "NOW()"
+		case "acquire_count":
+			// See acquireToken()
+			value = "LAST_INSERT_ID(acquire_count+1)"
+		}
+
+		sets = append(sets, fmt.Sprintf("%v=IF(in_use=0, %v, %v)", column, value, column))
+	}
+
+	if r.softDelete {
+		sets = append(sets, "deleted_at=IF(in_use=0, NULL, deleted_at)")
+	}
+
+	// Assignments are evaluated left to right; in_use goes last so that the
+	// ones above see its previous value
+	sets = append(sets, "in_use=1")
+
+	return strings.Join(sets, ", ")
+}
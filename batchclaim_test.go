@@ -0,0 +1,139 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var claimColumns = []string{"name", "owner", "in_use", "acquire_count", "host", "pid"}
+
+var _ = Describe("Batch claims", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	const (
+		claim     = `INSERT INTO rlock \(name, owner, in_use, acquired_at, acquire_count, host, pid\) VALUES \(\?, \?, 1, NOW\(\), 1, \?, \?\), \(\?, \?, 1, NOW\(\), 1, \?, \?\) ON DUPLICATE KEY UPDATE`
+		claimRead = `SELECT name, owner, in_use, acquire_count, host, pid FROM rlock WHERE name IN \(\?, \?\)`
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	// ours returns a row of the lock called name held by us
+	ours := func(rows *sqlmock.Rows, name string, token int64) *sqlmock.Rows {
+		return rows.AddRow(name, rl.owner, []byte{1}, token, rl.host, rl.pid)
+	}
+
+	It("claims every free lock with a single statement", func() {
+		mock.ExpectExec(claim).WithArgs("a", rl.owner, rl.host, rl.pid, "b", rl.owner, rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(1, 2))
+		mock.ExpectQuery(claimRead).WithArgs("a", "b").
+			WillReturnRows(ours(ours(sqlmock.NewRows(claimColumns), "a", 1), "b", 1))
+
+		tokens, previous, err := rl.claimBatch(context.Background(), "rlock", []string{"a", "b"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokens).To(Equal(map[string]int64{"a": 1, "b": 1}))
+		Expect(previous).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("tells taken over locks from inserted ones", func() {
+		mock.ExpectExec(claim).WillReturnResult(sqlmock.NewResult(1, 3))
+		mock.ExpectQuery(claimRead).WillReturnRows(ours(ours(sqlmock.NewRows(claimColumns), "a", 5), "b", 1))
+
+		tokens, previous, err := rl.claimBatch(context.Background(), "rlock", []string{"a", "b"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokens).To(Equal(map[string]int64{"a": 5, "b": 1}))
+		Expect(previous).To(Equal(map[string]string{"a": ""}))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("leaves locks in use by someone else alone", func() {
+		mock.ExpectExec(claim).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(claimRead).WillReturnRows(ours(sqlmock.NewRows(claimColumns), "a", 1).
+			AddRow("b", "other-owner", []byte{1}, 3, "other-host", 1))
+
+		tokens, _, err := rl.claimBatch(context.Background(), "rlock", []string{"a", "b"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokens).To(Equal(map[string]int64{"a": 1}))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("claims nothing when every lock is in use", func() {
+		mock.ExpectExec(claim).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(claimRead).WillReturnRows(sqlmock.NewRows(claimColumns).
+			AddRow("a", "other-owner", []byte{1}, 2, "other-host", 1).
+			AddRow("b", "other-owner", []byte{1}, 3, "other-host", 1))
+
+		tokens, previous, err := rl.claimBatch(context.Background(), "rlock", []string{"a", "b"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokens).To(BeEmpty())
+		Expect(previous).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("leaves locks we held already alone", func() {
+		rl.newLock("a", time.Minute, 4)
+
+		mock.ExpectExec(claim).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// a is held by this instance, b by a previous incarnation of its owner
+		mock.ExpectQuery(claimRead).WillReturnRows(ours(sqlmock.NewRows(claimColumns), "a", 4).
+			AddRow("b", rl.owner, []byte{1}, 2, rl.host, rl.pid+1))
+
+		tokens, _, err := rl.claimBatch(context.Background(), "rlock", []string{"a", "b"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokens).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("reads the previous owners of taken over locks when they are needed", func() {
+		_, cancel := rl.Subscribe(10)
+		defer cancel()
+
+		mock.ExpectQuery(claimRead).WillReturnRows(sqlmock.NewRows(claimColumns).
+			AddRow("a", "old-owner", []byte{0}, 4, "old-host", 1))
+		mock.ExpectExec(claim).WillReturnResult(sqlmock.NewResult(1, 3))
+		mock.ExpectQuery(claimRead).WillReturnRows(ours(ours(sqlmock.NewRows(claimColumns), "a", 5), "b", 1))
+
+		tokens, previous, err := rl.claimBatch(context.Background(), "rlock", []string{"a", "b"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokens).To(Equal(map[string]int64{"a": 5, "b": 1}))
+		Expect(previous).To(Equal(map[string]string{"a": "old-owner"}))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("returns errors of the claim", func() {
+		mock.ExpectExec(claim).WillReturnError(fmt.Errorf("boom"))
+
+		_, _, err := rl.claimBatch(context.Background(), "rlock", []string{"a", "b"})
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("boom"))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("returns errors reading the claimed locks", func() {
+		mock.ExpectExec(claim).WillReturnResult(sqlmock.NewResult(1, 2))
+		mock.ExpectQuery(claimRead).WillReturnError(fmt.Errorf("boom"))
+
+		_, _, err := rl.claimBatch(context.Background(), "rlock", []string{"a", "b"})
+
+		Expect(err).To(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
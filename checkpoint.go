@@ -0,0 +1,128 @@
+package rlock
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SaveCheckpoint records data (ie. how far the holder got through a batch of
+// work) on the lock's row, replacing any previous checkpoint, so that the next
+// holder (in particular one taking the lock over once it went stale) can
+// resume from there rather than start from scratch; see LoadCheckpoint().
+// Checkpoints are kept across holders until replaced, so save nil once the
+// work is done. Returns LockLostErr if the lock is no longer ours or, if it has
+// a fencing token (see Token()), was acquired again since we acquired it.
+func (l *Lock) SaveCheckpoint(data []byte) error {
+	err := l.saveCheckpoint(data)
+	if err == LockLostErr {
+		l.rl.lockLost(l, err)
+	}
+
+	return err
+}
+
+func (l *Lock) saveCheckpoint(data []byte) error {
+	if l.client != nil {
+		return fmt.Errorf("checkpoints are not supported via proxy")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlocked {
+		return AlreadyUnlockedErr
+	}
+
+	if l.expired {
+		return LockExpiredErr
+	}
+
+	// An empty (rather than NULL) checkpoint keeps LoadCheckpoint() from
+	// falling back to an archived one
+	if data == nil {
+		data = []byte{}
+	}
+
+	fenced := l.token != 0
+
+	query := fmt.Sprintf("UPDATE %v SET checkpoint=? WHERE name=? AND owner=? AND in_use=1", l.rl.tableFor(l.name))
+	args := []interface{}{data, l.name, l.rl.owner}
+
+	if fenced {
+		query += " AND acquire_count=?"
+		args = append(args, l.token)
+	}
+
+	result, err := l.rl.exec(query, args...)
+	if err != nil {
+		l.rl.observeError(err)
+		return fmt.Errorf("unable to save checkpoint of '%v': %v", l.name, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to determine affected rows after saving checkpoint of '%v': %v", l.name, err)
+	}
+
+	// Saving the same checkpoint again does not count as an affected row
+	if affected == 0 {
+		if err := l.rl.revalidate(l, fenced); err != nil {
+			withError(l.rl.logFor(l.name), err).Warn("unable to save checkpoint")
+			return LockLostErr
+		}
+	}
+
+	l.rl.mutated(l.name)
+
+	return nil
+}
+
+// LoadCheckpoint returns the checkpoint last saved on the lock (see
+// SaveCheckpoint()), by us or by a previous holder, or nil if there is none.
+// When archiving (see WithArchive), locks whose row was archived since the
+// checkpoint was saved fall back to the newest archived row's. Returns
+// LockLostErr if the lock is no longer ours.
+func (l *Lock) LoadCheckpoint() ([]byte, error) {
+	if l.client != nil {
+		return nil, fmt.Errorf("checkpoints are not supported via proxy")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlocked {
+		return nil, AlreadyUnlockedErr
+	}
+
+	table := l.rl.tableFor(l.name)
+
+	column := "checkpoint"
+	args := []interface{}{l.name, l.rl.owner}
+
+	if l.rl.archive {
+		column = fmt.Sprintf("COALESCE(checkpoint, %v)", l.rl.archived("checkpoint"))
+		args = append([]interface{}{l.name}, args...)
+	}
+
+	// Read from the primary; a replica may not have seen the previous
+	// holder's last checkpoint yet
+	query := fmt.Sprintf("SELECT %v FROM %v WHERE name=? AND owner=? AND in_use=1", column, table)
+
+	var checkpoint []byte
+
+	if err := l.rl.get(&checkpoint, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, LockLostErr
+		}
+
+		l.rl.observeError(err)
+
+		return nil, fmt.Errorf("unable to load checkpoint of '%v': %v", l.name, err)
+	}
+
+	if len(checkpoint) == 0 {
+		return nil, nil
+	}
+
+	return checkpoint, nil
+}
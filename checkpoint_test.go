@@ -0,0 +1,92 @@
+package rlock
+
+import (
+	"database/sql"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Checkpoints", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+		l    *Lock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		var err error
+
+		l, err = rl.Lock("foo", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("saves the checkpoint of the holder, fenced by its token", func() {
+		mock.ExpectExec(`UPDATE rlock SET checkpoint=\? WHERE name=\? AND owner=\? AND in_use=1 AND acquire_count=\?`).
+			WithArgs([]byte("offset=42"), "foo", rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(l.SaveCheckpoint([]byte("offset=42"))).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("clears the checkpoint with an empty one", func() {
+		mock.ExpectExec("UPDATE rlock SET checkpoint").
+			WithArgs([]byte{}, "foo", rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(l.SaveCheckpoint(nil)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("returns LockLostErr when the lock was taken over", func() {
+		mock.ExpectExec("UPDATE rlock SET checkpoint").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "someone-else", []byte{1}, "", time.Now(), time.Now()))
+
+		Expect(l.SaveCheckpoint([]byte("offset=42"))).To(Equal(LockLostErr))
+	})
+
+	It("loads the previous holder's checkpoint", func() {
+		mock.ExpectQuery(`SELECT checkpoint FROM rlock WHERE name=\? AND owner=\? AND in_use=1`).
+			WithArgs("foo", rl.owner).
+			WillReturnRows(sqlmock.NewRows([]string{"checkpoint"}).AddRow([]byte("offset=42")))
+
+		Expect(l.LoadCheckpoint()).To(Equal([]byte("offset=42")))
+
+		mock.ExpectQuery("SELECT checkpoint").WillReturnRows(sqlmock.NewRows([]string{"checkpoint"}).AddRow(nil))
+		Expect(l.LoadCheckpoint()).To(BeNil())
+
+		mock.ExpectQuery("SELECT checkpoint").WillReturnError(sql.ErrNoRows)
+		_, err := l.LoadCheckpoint()
+		Expect(err).To(Equal(LockLostErr))
+
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("falls back to the archived checkpoint when archiving", func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		archiving, err := New(db, WithArchive())
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(3, 1))
+
+		l, err := archiving.Lock("foo", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectQuery(`SELECT COALESCE\(checkpoint, \(SELECT checkpoint FROM rlock_history WHERE name=\? ORDER BY id DESC LIMIT 1\)\) FROM rlock`).
+			WithArgs("foo", "foo", archiving.owner).
+			WillReturnRows(sqlmock.NewRows([]string{"checkpoint"}).AddRow([]byte("offset=7")))
+
+		Expect(l.LoadCheckpoint()).To(Equal([]byte("offset=7")))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,198 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NothingToClaimErr is returned by Claim when every lock of the set is in
+// use (or being claimed by someone else).
+var NothingToClaimErr = errors.New("no free lock to claim")
+
+// CreateLocks makes sure a (not in use) lock row exists for every name; see
+// Claim(). Existing rows are left alone.
+func (r *RLock) CreateLocks(names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	for table, names := range r.byTable(r.normalizeNames(names)) {
+		query := fmt.Sprintf("INSERT IGNORE INTO %v (name, owner, in_use) VALUES %v", table,
+			strings.TrimSuffix(strings.Repeat("(?, '', 0), ", len(names)), ", "))
+
+		args := make([]interface{}, len(names))
+
+		for i, name := range names {
+			args[i] = name
+		}
+
+		// Continue where the newest archived rows left off (see WithArchive)
+		if r.archive {
+			row := fmt.Sprintf("(?, '', 0, COALESCE(%v, 0), COALESCE(%v, '')), ", r.archived("acquire_count"), r.archived("last_error"))
+
+			query = fmt.Sprintf("INSERT IGNORE INTO %v (name, owner, in_use, acquire_count, last_error) VALUES %v", table,
+				strings.TrimSuffix(strings.Repeat(row, len(names)), ", "))
+
+			args = make([]interface{}, 0, 3*len(names))
+
+			for _, name := range names {
+				args = append(args, name, name, name)
+			}
+		}
+
+		if _, err := r.exec(query, args...); err != nil {
+			return fmt.Errorf("unable to create locks: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Claim atomically claims a free lock out of names without waiting or
+// polling, returning NothingToClaimErr if there is none. Concurrent claimers
+// skip rows that are being claimed (SELECT ... FOR UPDATE SKIP LOCKED, which
+// requires MySQL 8.0+) instead of contending on them, which makes Claim a
+// good fit for queue-like workloads with many workers.
+//
+// Only existing rows that are not in use are considered; see CreateLocks().
+// Stale locks are left to ReapStale().
+func (r *RLock) Claim(ctx context.Context, names []string) (*Lock, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("at least one lock name is required")
+	}
+
+	cond := fmt.Sprintf("name IN (%v) AND in_use=0", strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+	args := make([]interface{}, len(names))
+
+	for i, name := range r.normalizeNames(names) {
+		args[i] = name
+	}
+
+	l, _, err := r.claim(ctx, cond, args...)
+
+	return l, err
+}
+
+// claim claims the least recently used lock matching cond, returning it along
+// with its row as it was before the claim. Matching locks that are still in
+// use (ie. stale ones, depending on cond) are taken over.
+func (r *RLock) claim(ctx context.Context, cond string, args ...interface{}) (*Lock, *LockEntry, error) {
+	locks, entries, err := r.claimN(ctx, 1, cond, "last_used", true, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return locks[0], entries[0], nil
+}
+
+// claimN claims the first n (by order, which is either "last_used" or "name")
+// of the locks matching cond in a single transaction, or none of them if fewer
+// than n match. With skipLocked, rows being claimed by others are skipped
+// rather than waited for.
+func (r *RLock) claimN(ctx context.Context, n int, cond, order string, skipLocked bool, args ...interface{}) ([]*Lock, []*LockEntry, error) {
+	start := r.clock.Now()
+
+	unreserve, err := r.reserveQuota(n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer unreserve()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to start claim transaction: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	suffix := fmt.Sprintf("ORDER BY %v LIMIT %d FOR UPDATE", order, n)
+	if skipLocked {
+		suffix += " SKIP LOCKED"
+	}
+
+	entries := make([]*LockEntry, 0)
+
+	// Shards are always locked in the same order, avoiding deadlocks
+	for _, table := range r.tables() {
+		query := fmt.Sprintf("SELECT * FROM %v WHERE %v %v", table, cond, suffix)
+
+		found := make([]*LockEntry, 0)
+
+		if err := tx.SelectContext(ctx, &found, query, args...); err != nil {
+			r.observeError(err)
+			return nil, nil, fmt.Errorf("unable to find a lock to claim: %v", err)
+		}
+
+		entries = append(entries, found...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if order == "last_used" {
+			return entries[i].LastUsed.Before(entries[j].LastUsed)
+		}
+
+		return entries[i].Name < entries[j].Name
+	})
+
+	if len(entries) < n {
+		return nil, nil, NothingToClaimErr
+	}
+
+	entries = entries[:n]
+	tokens := make([]int64, n)
+
+	for i, entry := range entries {
+		set, args := r.holderColumns(ctx)
+		if entry.InUse {
+			set += ", takeover_count=takeover_count+1"
+		}
+
+		query := fmt.Sprintf("UPDATE %v SET %v, in_use=1 WHERE name=?", r.tableFor(entry.Name), set)
+
+		res, err := tx.ExecContext(ctx, query, append(args, entry.Name)...)
+		if err != nil {
+			r.observeError(err)
+			return nil, nil, fmt.Errorf("unable to claim '%v': %v", entry.Name, err)
+		}
+
+		if tokens[i], err = acquireToken(res); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("unable to commit claim: %v", err)
+	}
+
+	locks := make([]*Lock, len(entries))
+
+	for i, entry := range entries {
+		locks[i] = r.newLock(entry.Name, 0, tokens[i])
+
+		r.recordAcquire(entry.Name, locks[i], nil, r.clock.Now().Sub(start))
+
+		switch {
+		case bool(entry.InUse):
+			evidence := fmt.Sprintf("in_use=true, last used %v ago", r.clock.Now().Sub(entry.LastUsed).Round(time.Second))
+
+			r.auditRelease(entry.Name, entry.Owner, ExitTakenOver, evidence)
+			r.auditAcquire(ctx, entry.Name, AcquireStaleTakeover, entry.Owner, evidence)
+			r.emit(EventTakeover, entry.Name, entry.Owner, "")
+			r.notify(EventTakeover, entry.Name, entry, evidence)
+		case entry.Owner == "":
+			// Created by CreateLocks() and never held
+			r.auditAcquire(ctx, entry.Name, AcquireFresh, "", "claimed")
+			r.emit(EventAcquired, entry.Name, "", "")
+		default:
+			r.auditAcquire(ctx, entry.Name, AcquireHandoff, entry.Owner, "claimed")
+			r.emit(EventAcquired, entry.Name, entry.Owner, "")
+		}
+	}
+
+	return locks, entries, nil
+}
@@ -0,0 +1,72 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Claim", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	It("claims a free lock skipping rows claimed by others", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name IN \(\?, \?\) AND in_use=0 ORDER BY last_used LIMIT 1 FOR UPDATE SKIP LOCKED`).
+			WithArgs("job-1", "job-2").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns).AddRow(2, "job-2", "previous", []byte{0}, "", time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET owner=\?, host=\?, pid=\?, .*in_use=1 WHERE name=\?`).
+			WithArgs(rl.owner, rl.host, rl.pid, "job-2").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		l, err := rl.Claim(context.Background(), []string{"job-1", "job-2"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Name()).To(Equal("job-2"))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("returns NothingToClaimErr when every lock is taken", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM rlock`).WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+		mock.ExpectRollback()
+
+		_, err := rl.Claim(context.Background(), []string{"job-1"})
+
+		Expect(err).To(Equal(NothingToClaimErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("rolls back when the claim fails", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM rlock`).
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns).AddRow(1, "job-1", "", []byte{0}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE").WillReturnError(fmt.Errorf("boom"))
+		mock.ExpectRollback()
+
+		_, err := rl.Claim(context.Background(), []string{"job-1"})
+
+		Expect(err).To(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("creates missing lock rows", func() {
+		mock.ExpectExec(`INSERT IGNORE INTO rlock \(name, owner, in_use\) VALUES \(\?, '', 0\), \(\?, '', 0\)`).
+			WithArgs("job-1", "job-2").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(rl.CreateLocks("job-1", "job-2")).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
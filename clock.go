@@ -0,0 +1,152 @@
+package rlock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time used by rlock for staleness checks, acquire
+// timeouts and polling. It defaults to the real clock; tests can substitute a
+// FakeClock via WithClock to exercise time-dependent behavior without
+// sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of *time.Timer used by rlock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// WithClock overrides the clock used by rlock (defaults to real time).
+func WithClock(clock Clock) Option {
+	return func(r *RLock) error {
+		if clock == nil {
+			return fmt.Errorf("clock cannot be nil")
+		}
+
+		r.clock = clock
+
+		return nil
+	}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t *realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t *realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// FakeClock is a Clock that only moves when told to; see Advance().
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{
+		clock:    f,
+		deadline: f.now.Add(d),
+		ch:       make(chan time.Time, 1),
+	}
+
+	if d <= 0 {
+		t.ch <- f.now
+		return t
+	}
+
+	f.timers = append(f.timers, t)
+
+	return t
+}
+
+// Advance moves the clock forward by d, firing every timer that expires on
+// the way.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	pending := f.timers[:0]
+
+	for _, t := range f.timers {
+		if t.deadline.After(f.now) {
+			pending = append(pending, t)
+			continue
+		}
+
+		t.ch <- f.now
+	}
+
+	f.timers = pending
+}
+
+// Waiters returns the number of timers that have not fired or been stopped
+// yet; useful to wait for a goroutine to block on the clock before calling
+// Advance().
+func (f *FakeClock) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.timers)
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package rlock
+
+import (
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Clock", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		rl    *RLock
+		clock *FakeClock
+		epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		clock = NewFakeClock(epoch)
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	expectContended := func(lastUsed time.Time) {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", lastUsed, lastUsed))
+	}
+
+	It("rejects a nil clock", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithClock(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("FakeClock", func() {
+		It("only fires timers once their deadline has been reached", func() {
+			t := clock.NewTimer(time.Minute)
+
+			clock.Advance(59 * time.Second)
+			Expect(t.C()).ToNot(Receive())
+
+			clock.Advance(time.Second)
+			Expect(t.C()).To(Receive(Equal(epoch.Add(time.Minute))))
+			Expect(clock.Waiters()).To(Equal(0))
+		})
+
+		It("does not fire stopped timers", func() {
+			t := clock.NewTimer(time.Minute)
+
+			Expect(t.Stop()).To(BeTrue())
+			Expect(t.Stop()).To(BeFalse())
+
+			clock.Advance(time.Hour)
+			Expect(t.C()).ToNot(Receive())
+		})
+	})
+
+	It("attempts a takeover before sleeping", func() {
+		expectContended(epoch)
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		locks := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := rl.Lock("foo", time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+
+			locks <- l
+		}()
+
+		// The clock never moves; sleeping first would block forever
+		Eventually(locks).Should(Receive(Not(BeNil())))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("uses the clock to determine staleness", func() {
+		clock.Advance(MaxAge + time.Minute)
+
+		expectContended(epoch)
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l).ToNot(BeNil())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("makes a single attempt when acquireTimeout is 0", func() {
+		expectContended(epoch)
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+
+		_, err := rl.Lock("foo", 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+		Expect(clock.Waiters()).To(Equal(0))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("keeps polling past any deadline with WaitForever", func() {
+		expectContended(epoch)
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		locks := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := rl.Lock("foo", WaitForever)
+			Expect(err).ToNot(HaveOccurred())
+
+			locks <- l
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(PollInterval)
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(PollInterval)
+
+		Eventually(locks).Should(Receive(Not(BeNil())))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("uses the clock for polling and never sleeps past the deadline", func() {
+		expectContended(epoch)
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+
+		errs := make(chan error, 1)
+
+		go func() {
+			_, err := rl.Lock("foo", PollInterval+PollInterval/2)
+			errs <- err
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		Consistently(errs, 50*time.Millisecond).ShouldNot(Receive())
+
+		clock.Advance(PollInterval)
+
+		// The second sleep is cut short to end exactly at the deadline
+		Eventually(clock.Waiters).Should(Equal(1))
+		Consistently(errs, 50*time.Millisecond).ShouldNot(Receive())
+
+		clock.Advance(PollInterval / 2)
+
+		Eventually(errs).Should(Receive(Equal(AcquireTimeoutErr)))
+		Expect(clock.Waiters()).To(Equal(0))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,172 @@
+// rlock-exporter periodically inspects the lock table and exposes the state of
+// all locks as Prometheus metrics.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dselans/rlock"
+	"github.com/dselans/rlock/config"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// Upper bound of rlock_lock_hold_seconds series (one per lock name); the
+// locks held the longest are exported
+const maxLockSeries = 1024
+
+type exporter struct {
+	rl  *rlock.RLock
+	cfg *config.Config
+
+	mu      sync.RWMutex
+	metrics []byte
+}
+
+func main() {
+	defaults := config.Defaults()
+	defaults.Listen = ":9400"
+
+	cfg, err := config.Load("rlock-exporter", os.Args[1:], defaults)
+	if err != nil {
+		logrus.Fatalf("unable to load config: %v", err)
+	}
+
+	db, err := sqlx.Connect("mysql", cfg.DSN)
+	if err != nil {
+		logrus.Fatalf("unable to connect to db: %v", err)
+	}
+
+	rl, err := rlock.New(db, rlock.WithTableName(cfg.Table), rlock.WithShards(cfg.Shards))
+	if err != nil {
+		logrus.Fatalf("unable to create rlock: %v", err)
+	}
+
+	e := &exporter{rl: rl, cfg: cfg}
+
+	go e.run()
+
+	http.Handle("/metrics", e)
+
+	logrus.Infof("rlock-exporter listening on %v", cfg.Listen)
+
+	if err := http.ListenAndServe(cfg.Listen, nil); err != nil {
+		logrus.Fatalf("server exited: %v", err)
+	}
+}
+
+func (e *exporter) run() {
+	for {
+		e.collect()
+		time.Sleep(e.cfg.Interval)
+	}
+}
+
+func (e *exporter) collect() {
+	entries, err := e.rl.ListLocks()
+	if err != nil {
+		logrus.Errorf("unable to list locks: %v", err)
+
+		var buf bytes.Buffer
+
+		writeMetric(&buf, "rlock_up", "gauge", "Whether the last query of the lock table succeeded.", "", 0)
+		e.set(buf.Bytes())
+
+		return
+	}
+
+	e.set(e.render(entries, time.Now()))
+}
+
+// render returns the metrics describing entries as of now.
+func (e *exporter) render(entries []*rlock.LockEntry, now time.Time) []byte {
+	var buf bytes.Buffer
+
+	var inUse, stale int
+	var oldest time.Duration
+
+	var holds []*rlock.LockEntry
+
+	for _, entry := range entries {
+		if !entry.InUse {
+			continue
+		}
+
+		held := now.Sub(entry.LastUsed)
+
+		inUse++
+		holds = append(holds, entry)
+
+		if held > e.cfg.MaxAge {
+			stale++
+		}
+
+		if held > oldest {
+			oldest = held
+		}
+	}
+
+	writeMetric(&buf, "rlock_up", "gauge", "Whether the last query of the lock table succeeded.", "", 1)
+	writeMetric(&buf, "rlock_locks", "gauge", "Number of lock rows.", "", float64(len(entries)))
+	writeMetric(&buf, "rlock_locks_in_use", "gauge", "Number of locks currently in use.", "", float64(inUse))
+	writeMetric(&buf, "rlock_locks_stale", "gauge", "Number of in use locks older than max age.", "", float64(stale))
+	writeMetric(&buf, "rlock_oldest_lock_age_seconds", "gauge", "Age of the oldest in use lock.", "", oldest.Seconds())
+
+	// Lock names are unbounded, so keep only the longest held ones
+	var dropped int
+
+	if len(holds) > maxLockSeries {
+		sort.Slice(holds, func(i, j int) bool {
+			return holds[i].LastUsed.Before(holds[j].LastUsed)
+		})
+
+		dropped = len(holds) - maxLockSeries
+		holds = holds[:maxLockSeries]
+	}
+
+	writeMetric(&buf, "rlock_lock_hold_series_dropped", "gauge", "Number of in use locks left out of rlock_lock_hold_seconds.", "", float64(dropped))
+
+	fmt.Fprintf(&buf, "# HELP rlock_lock_hold_seconds How long each in use lock has been held.\n")
+	fmt.Fprintf(&buf, "# TYPE rlock_lock_hold_seconds gauge\n")
+
+	for _, entry := range holds {
+		fmt.Fprintf(&buf, "rlock_lock_hold_seconds{name=\"%s\"} %v\n", escapeLabel(entry.Name), now.Sub(entry.LastUsed).Seconds())
+	}
+
+	return buf.Bytes()
+}
+
+func (e *exporter) set(metrics []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.metrics = metrics
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(e.metrics)
+}
+
+func writeMetric(buf *bytes.Buffer, name, metricType, help, labels string, value float64) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, metricType)
+	fmt.Fprintf(buf, "%s%s %v\n", name, labels, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
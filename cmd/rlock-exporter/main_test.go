@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dselans/rlock"
+	"github.com/dselans/rlock/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Exporter", func() {
+	var (
+		e   *exporter
+		now time.Time
+	)
+
+	BeforeEach(func() {
+		cfg := config.Defaults()
+		cfg.MaxAge = time.Minute
+
+		e = &exporter{cfg: cfg}
+		now = time.Now()
+	})
+
+	// held returns an in use lock acquired age ago
+	held := func(name string, age time.Duration) *rlock.LockEntry {
+		return &rlock.LockEntry{Name: name, InUse: true, LastUsed: now.Add(-age)}
+	}
+
+	// series returns the rlock_lock_hold_seconds samples of metrics
+	series := func(metrics []byte) []string {
+		var samples []string
+
+		for _, line := range strings.Split(string(metrics), "\n") {
+			if strings.HasPrefix(line, "rlock_lock_hold_seconds{") {
+				samples = append(samples, line)
+			}
+		}
+
+		return samples
+	}
+
+	It("describes the locks in use", func() {
+		metrics := string(e.render([]*rlock.LockEntry{
+			held("a", 10*time.Second),
+			held("b\"c", 2*time.Minute),
+			{Name: "free", LastUsed: now.Add(-time.Hour)},
+		}, now))
+
+		Expect(metrics).To(ContainSubstring("rlock_up 1\n"))
+		Expect(metrics).To(ContainSubstring("rlock_locks 3\n"))
+		Expect(metrics).To(ContainSubstring("rlock_locks_in_use 2\n"))
+		Expect(metrics).To(ContainSubstring("rlock_locks_stale 1\n"))
+		Expect(metrics).To(ContainSubstring("rlock_oldest_lock_age_seconds 120\n"))
+		Expect(metrics).To(ContainSubstring("rlock_lock_hold_series_dropped 0\n"))
+
+		Expect(series([]byte(metrics))).To(ConsistOf(
+			`rlock_lock_hold_seconds{name="a"} 10`,
+			`rlock_lock_hold_seconds{name="b\"c"} 120`,
+		))
+	})
+
+	It("exports only the locks held the longest", func() {
+		var entries []*rlock.LockEntry
+
+		for i := 0; i < maxLockSeries+10; i++ {
+			entries = append(entries, held(fmt.Sprintf("lock-%v", i), time.Duration(i)*time.Second))
+		}
+
+		metrics := e.render(entries, now)
+
+		Expect(string(metrics)).To(ContainSubstring(fmt.Sprintf("rlock_locks_in_use %v\n", maxLockSeries+10)))
+		Expect(string(metrics)).To(ContainSubstring("rlock_lock_hold_series_dropped 10\n"))
+
+		samples := series(metrics)
+
+		Expect(samples).To(HaveLen(maxLockSeries))
+		Expect(samples).To(ContainElement(fmt.Sprintf(`rlock_lock_hold_seconds{name="lock-%v"} %v`, maxLockSeries+9, maxLockSeries+9)))
+		Expect(samples).ToNot(ContainElement(`rlock_lock_hold_seconds{name="lock-9"} 9`))
+	})
+})
@@ -0,0 +1,92 @@
+// rlock-reaper releases stale locks and purges old, unused locks. It can run
+// once (ie. from cron) or as a daemon.
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/dselans/rlock"
+	"github.com/dselans/rlock/config"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	// Unlike the other binaries, run once unless told otherwise
+	defaults := config.Defaults()
+	defaults.Interval = 0
+
+	cfg, err := config.Load("rlock-reaper", os.Args[1:], defaults)
+	if err != nil {
+		logrus.Fatalf("unable to load config: %v", err)
+	}
+
+	db, err := sqlx.Connect("mysql", cfg.DSN)
+	if err != nil {
+		logrus.Fatalf("unable to connect to db: %v", err)
+	}
+
+	opts := []rlock.Option{rlock.WithTableName(cfg.Table), rlock.WithShards(cfg.Shards)}
+	if cfg.HardPurgeAfter > 0 {
+		opts = append(opts, rlock.WithSoftDelete())
+	}
+
+	rl, err := rlock.New(db, opts...)
+	if err != nil {
+		logrus.Fatalf("unable to create rlock: %v", err)
+	}
+
+	if cfg.Interval == 0 {
+		if err := reap(rl, cfg); err != nil {
+			logrus.Fatal(err)
+		}
+
+		return
+	}
+
+	for {
+		if err := reap(rl, cfg); err != nil {
+			logrus.Error(err)
+		}
+
+		time.Sleep(cfg.Interval)
+	}
+}
+
+func reap(rl *rlock.RLock, cfg *config.Config) error {
+	reaped, err := rl.ReapStale(cfg.MaxAge)
+
+	for _, name := range reaped {
+		logrus.Infof("reaped stale lock '%v'", name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if cfg.PurgeAfter == 0 {
+		return nil
+	}
+
+	purged, err := rl.Purge(cfg.PurgeAfter)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("purged %d unused lock(s)", purged)
+
+	if cfg.HardPurgeAfter == 0 {
+		return nil
+	}
+
+	deleted, err := rl.PurgeDeleted(cfg.HardPurgeAfter)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("deleted %d soft-deleted lock(s)", deleted)
+
+	return nil
+}
@@ -0,0 +1,167 @@
+// rlockctl is a command line tool for operating on a lock table.
+//
+// Usage:
+//
+//	rlockctl snapshot [-audit-tail N] [-out FILE] [config flags]
+//	rlockctl restore -in FILE [-force] [config flags]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/dselans/rlock"
+	"github.com/dselans/rlock/config"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+type command func(name string, args []string) error
+
+var commands = map[string]command{
+	"snapshot": snapshot,
+	"restore":  restore,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		fmt.Fprintf(os.Stderr, "usage: rlockctl <command> [flags]\n\ncommands:\n")
+		fmt.Fprintf(os.Stderr, "  snapshot    dump the lock table (and audit log tail) as JSON\n")
+		fmt.Fprintf(os.Stderr, "  restore     load a snapshot into an (empty) lock table\n")
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+
+	if err := commands[name]("rlockctl "+name, os.Args[2:]); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func snapshot(name string, args []string) error {
+	var (
+		auditTail int
+		out       string
+	)
+
+	cfg, err := config.Load(name, args, config.Defaults(), func(fs *flag.FlagSet) {
+		fs.IntVar(&auditTail, "audit-tail", 0, "include this many of the most recent audit log entries")
+		fs.StringVar(&out, "out", "", "file to write the snapshot to (default stdout)")
+	})
+	if err != nil {
+		return fmt.Errorf("unable to load config: %v", err)
+	}
+
+	opts := []rlock.Option{rlock.WithTableName(cfg.Table), rlock.WithShards(cfg.Shards)}
+
+	if auditTail > 0 {
+		opts = append(opts, rlock.WithAuditLog())
+	}
+
+	rl, err := connect(cfg, opts...)
+	if err != nil {
+		return err
+	}
+
+	snap, err := rl.Snapshot(context.Background(), auditTail)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("unable to create '%v': %v", out, err)
+		}
+
+		defer f.Close()
+
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(snap); err != nil {
+		return fmt.Errorf("unable to write snapshot: %v", err)
+	}
+
+	logrus.Infof("snapshotted %d lock(s) and %d audit entries", len(snap.Locks), len(snap.Audit))
+
+	return nil
+}
+
+func restore(name string, args []string) error {
+	var (
+		in    string
+		force bool
+	)
+
+	cfg, err := config.Load(name, args, config.Defaults(), func(fs *flag.FlagSet) {
+		fs.StringVar(&in, "in", "", "snapshot file to restore")
+		fs.BoolVar(&force, "force", false, "overwrite locks that are in use")
+	})
+	if err != nil {
+		return fmt.Errorf("unable to load config: %v", err)
+	}
+
+	if in == "" {
+		return fmt.Errorf("-in must be set")
+	}
+
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		return fmt.Errorf("unable to read snapshot: %v", err)
+	}
+
+	snap := &rlock.Snapshot{}
+
+	if err := json.Unmarshal(data, snap); err != nil {
+		return fmt.Errorf("unable to parse snapshot '%v': %v", in, err)
+	}
+
+	rl, err := connect(cfg, rlock.WithTableName(cfg.Table), rlock.WithShards(cfg.Shards))
+	if err != nil {
+		return err
+	}
+
+	conflicts, err := rl.Restore(context.Background(), snap, force)
+	if err == rlock.RestoreConflictErr {
+		return fmt.Errorf("%v: %v (use -force to overwrite them)", err, strings.Join(conflicts, ", "))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range conflicts {
+		logrus.Warnf("overwrote in-use lock '%v'", name)
+	}
+
+	logrus.Infof("restored %d lock(s) into '%v'", len(snap.Locks), cfg.Table)
+
+	return nil
+}
+
+func connect(cfg *config.Config, opts ...rlock.Option) (*rlock.RLock, error) {
+	db, err := sqlx.Connect("mysql", cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to db: %v", err)
+	}
+
+	rl, err := rlock.New(db, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create rlock: %v", err)
+	}
+
+	return rl, nil
+}
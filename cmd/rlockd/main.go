@@ -0,0 +1,110 @@
+// rlockd owns the database connection and exposes lock acquire/release over
+// HTTP so that services can use rlock without holding DB credentials.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/dselans/rlock"
+	"github.com/dselans/rlock/config"
+	"github.com/dselans/rlock/server"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	cfg, err := config.Load("rlockd", os.Args[1:], config.Defaults())
+	if err != nil {
+		logrus.Fatalf("unable to load config: %v", err)
+	}
+
+	db, err := sqlx.Connect("mysql", cfg.DSN)
+	if err != nil {
+		logrus.Fatalf("unable to connect to db: %v", err)
+	}
+
+	rl, err := rlock.New(db, rlock.WithTableName(cfg.Table), rlock.WithShards(cfg.Shards))
+	if err != nil {
+		logrus.Fatalf("unable to create rlock: %v", err)
+	}
+
+	var opts []server.Option
+
+	if cfg.AuthFile != "" {
+		auth, err := loadAuth(cfg.AuthFile)
+		if err != nil {
+			logrus.Fatalf("unable to load auth file: %v", err)
+		}
+
+		opts = append(opts, server.WithAuth(auth))
+	}
+
+	srv, err := server.New(rl, opts...)
+	if err != nil {
+		logrus.Fatalf("unable to create server: %v", err)
+	}
+
+	httpServer := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: srv,
+	}
+
+	if cfg.ClientCA != "" {
+		pool, err := loadCertPool(cfg.ClientCA)
+		if err != nil {
+			logrus.Fatalf("unable to load client CA: %v", err)
+		}
+
+		httpServer.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
+
+	logrus.Infof("rlockd listening on %v", cfg.Listen)
+
+	if cfg.TLSCert != "" {
+		err = httpServer.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+
+	logrus.Fatalf("server exited: %v", err)
+}
+
+func loadAuth(path string) (*server.Auth, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &server.Auth{}
+
+	if err := json.Unmarshal(data, auth); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in '%v'", path)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,122 @@
+package rlock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// These tests are mostly useful with the race detector enabled (go test -race)
+var _ = Describe("Concurrency", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("only unlocks once", func() {
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l := rl.newLock("foo", time.Second, 1)
+
+		Expect(l.Unlock(nil)).To(Succeed())
+		Expect(l.Unlock(nil)).To(Equal(AlreadyUnlockedErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("allows retrying a failed unlock", func() {
+		mock.ExpectExec("UPDATE").WillReturnError(fmt.Errorf("connection reset"))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l := rl.newLock("foo", time.Second, 1)
+
+		Expect(l.Unlock(nil)).ToNot(Succeed())
+		Expect(l.Unlock(nil)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("releases the lock exactly once when unlocked concurrently", func() {
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l := rl.newLock("foo", time.Second, 1)
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			succeeded int
+		)
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				err := l.Unlock(nil)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				if err == nil {
+					succeeded++
+				} else {
+					Expect(err).To(Equal(AlreadyUnlockedErr))
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		Expect(succeeded).To(Equal(1))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("acquires and releases different locks concurrently", func() {
+		mock.MatchExpectationsInOrder(false)
+
+		const n = 10
+
+		for i := 0; i < n; i++ {
+			mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+
+		events, cancel := rl.Subscribe(2 * n)
+		defer cancel()
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				l, err := rl.Lock(fmt.Sprintf("lock-%d", i), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(l.Unlock(nil)).To(Succeed())
+			}(i)
+		}
+
+		wg.Wait()
+
+		Expect(events).To(HaveLen(2 * n))
+		Expect(rl.heldLocks()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,219 @@
+// Package config loads configuration for the bundled rlock binaries (rlockd,
+// rlock-exporter, rlock-reaper and rlockctl).
+//
+// Settings are resolved in the following order, later sources overriding
+// earlier ones: defaults, YAML config file (-config or RLOCK_CONFIG),
+// environment variables (RLOCK_<SETTING>, ie. RLOCK_DSN) and flags.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/dselans/rlock"
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	// Shared settings
+	DSN    string `yaml:"dsn"`
+	Table  string `yaml:"table"`
+	Shards int    `yaml:"shards"`
+
+	// HTTP settings (rlockd, rlock-exporter)
+	Listen   string `yaml:"listen"`
+	AuthFile string `yaml:"auth_file"`
+	TLSCert  string `yaml:"tls_cert"`
+	TLSKey   string `yaml:"tls_key"`
+	ClientCA string `yaml:"client_ca"`
+
+	// Intervals (rlock-exporter, rlock-reaper)
+	Interval   time.Duration `yaml:"interval"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	PurgeAfter time.Duration `yaml:"purge_after"`
+
+	// Soft-delete purged locks and delete them for good after this long
+	// (rlock-reaper); 0 deletes them right away
+	HardPurgeAfter time.Duration `yaml:"hard_purge_after"`
+}
+
+// Defaults returns a config with sensible defaults for every setting.
+func Defaults() *Config {
+	return &Config{
+		Table:    rlock.TableName,
+		Shards:   1,
+		Listen:   ":8080",
+		Interval: 15 * time.Second,
+		MaxAge:   rlock.MaxAge,
+	}
+}
+
+var validTableName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Validate returns an error describing the first invalid setting.
+func (c *Config) Validate() error {
+	if c.DSN == "" {
+		return fmt.Errorf("dsn must be set")
+	}
+
+	if !validTableName.MatchString(c.Table) {
+		return fmt.Errorf("invalid table name '%v'", c.Table)
+	}
+
+	if c.Shards <= 0 {
+		return fmt.Errorf("shards must be positive")
+	}
+
+	if c.Interval < 0 || c.MaxAge < 0 || c.PurgeAfter < 0 || c.HardPurgeAfter < 0 {
+		return fmt.Errorf("interval, max_age, purge_after and hard_purge_after cannot be negative")
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+
+	if c.ClientCA != "" && c.TLSCert == "" {
+		return fmt.Errorf("client_ca requires tls_cert and tls_key")
+	}
+
+	return nil
+}
+
+// Load resolves the config for the binary called name from defaults, the
+// config file, environment variables and args (typically os.Args[1:]). Flags
+// specific to the binary can be registered via extra.
+func Load(name string, args []string, defaults *Config, extra ...func(fs *flag.FlagSet)) (*Config, error) {
+	cfg := *defaults
+	fromFlags := &Config{}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	configFile := fs.String("config", os.Getenv("RLOCK_CONFIG"), "YAML config file")
+
+	fs.StringVar(&fromFlags.DSN, "dsn", "", "MySQL DSN (ie. user:pass@tcp(127.0.0.1:3306)/dbname?parseTime=true)")
+	fs.StringVar(&fromFlags.Table, "table", "", "lock table name (default \""+defaults.Table+"\")")
+	fs.IntVar(&fromFlags.Shards, "shards", 0, "number of tables locks are spread across (default "+strconv.Itoa(defaults.Shards)+")")
+	fs.StringVar(&fromFlags.Listen, "listen", "", "address to listen on (default \""+defaults.Listen+"\")")
+	fs.StringVar(&fromFlags.AuthFile, "auth-file", "", "JSON file containing API keys, client certs and their grants")
+	fs.StringVar(&fromFlags.TLSCert, "tls-cert", "", "TLS certificate file")
+	fs.StringVar(&fromFlags.TLSKey, "tls-key", "", "TLS key file")
+	fs.StringVar(&fromFlags.ClientCA, "client-ca", "", "CA file used to verify client certificates (enables mTLS)")
+	fs.DurationVar(&fromFlags.Interval, "interval", 0, "how often to run (default "+defaults.Interval.String()+")")
+	fs.DurationVar(&fromFlags.MaxAge, "max-age", 0, "age after which an in-use lock is considered stale (default "+defaults.MaxAge.String()+")")
+	fs.DurationVar(&fromFlags.PurgeAfter, "purge-after", 0, "delete unused locks not used for this long; 0 disables purging")
+	fs.DurationVar(&fromFlags.HardPurgeAfter, "hard-purge-after", 0, "soft-delete purged locks and delete them for good after this long; 0 deletes them right away")
+
+	for _, register := range extra {
+		register(fs)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read config file: %v", err)
+		}
+
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return nil, fmt.Errorf("unable to parse config file '%v': %v", *configFile, err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return nil, err
+	}
+
+	// Only flags that were explicitly passed override other sources
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dsn":
+			cfg.DSN = fromFlags.DSN
+		case "table":
+			cfg.Table = fromFlags.Table
+		case "shards":
+			cfg.Shards = fromFlags.Shards
+		case "listen":
+			cfg.Listen = fromFlags.Listen
+		case "auth-file":
+			cfg.AuthFile = fromFlags.AuthFile
+		case "tls-cert":
+			cfg.TLSCert = fromFlags.TLSCert
+		case "tls-key":
+			cfg.TLSKey = fromFlags.TLSKey
+		case "client-ca":
+			cfg.ClientCA = fromFlags.ClientCA
+		case "interval":
+			cfg.Interval = fromFlags.Interval
+		case "max-age":
+			cfg.MaxAge = fromFlags.MaxAge
+		case "purge-after":
+			cfg.PurgeAfter = fromFlags.PurgeAfter
+		case "hard-purge-after":
+			cfg.HardPurgeAfter = fromFlags.HardPurgeAfter
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func applyEnv(cfg *Config) error {
+	stringVars := map[string]*string{
+		"RLOCK_DSN":       &cfg.DSN,
+		"RLOCK_TABLE":     &cfg.Table,
+		"RLOCK_LISTEN":    &cfg.Listen,
+		"RLOCK_AUTH_FILE": &cfg.AuthFile,
+		"RLOCK_TLS_CERT":  &cfg.TLSCert,
+		"RLOCK_TLS_KEY":   &cfg.TLSKey,
+		"RLOCK_CLIENT_CA": &cfg.ClientCA,
+	}
+
+	for env, dst := range stringVars {
+		if v, ok := os.LookupEnv(env); ok {
+			*dst = v
+		}
+	}
+
+	if v, ok := os.LookupEnv("RLOCK_SHARDS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("unable to parse RLOCK_SHARDS: %v", err)
+		}
+
+		cfg.Shards = n
+	}
+
+	durations := map[string]*time.Duration{
+		"RLOCK_INTERVAL":         &cfg.Interval,
+		"RLOCK_MAX_AGE":          &cfg.MaxAge,
+		"RLOCK_PURGE_AFTER":      &cfg.PurgeAfter,
+		"RLOCK_HARD_PURGE_AFTER": &cfg.HardPurgeAfter,
+	}
+
+	for env, dst := range durations {
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("unable to parse %v: %v", env, err)
+		}
+
+		*dst = d
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var configFile string
+
+	BeforeEach(func() {
+		f, err := ioutil.TempFile("", "rlock-config")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = f.WriteString("dsn: file-dsn\ntable: file_table\ninterval: 30s\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		configFile = f.Name()
+	})
+
+	AfterEach(func() {
+		os.Remove(configFile)
+		os.Unsetenv("RLOCK_TABLE")
+		os.Unsetenv("RLOCK_MAX_AGE")
+	})
+
+	Describe("Load", func() {
+		It("uses defaults for unset settings", func() {
+			cfg, err := Load("test", []string{"-dsn", "flag-dsn"}, Defaults())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.DSN).To(Equal("flag-dsn"))
+			Expect(cfg.Table).To(Equal("rlock"))
+			Expect(cfg.Shards).To(Equal(1))
+			Expect(cfg.Interval).To(Equal(15 * time.Second))
+		})
+
+		It("lets env override the config file and flags override env", func() {
+			os.Setenv("RLOCK_TABLE", "env_table")
+			os.Setenv("RLOCK_MAX_AGE", "2h")
+
+			cfg, err := Load("test", []string{"-config", configFile, "-max-age", "3h"}, Defaults())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.DSN).To(Equal("file-dsn"))
+			Expect(cfg.Table).To(Equal("env_table"))
+			Expect(cfg.Interval).To(Equal(30 * time.Second))
+			Expect(cfg.MaxAge).To(Equal(3 * time.Hour))
+		})
+
+		It("errors on unknown config file settings", func() {
+			Expect(ioutil.WriteFile(configFile, []byte("dns: typo\n"), 0600)).To(Succeed())
+
+			_, err := Load("test", []string{"-config", configFile}, Defaults())
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("errors on invalid env durations", func() {
+			os.Setenv("RLOCK_MAX_AGE", "forever")
+
+			_, err := Load("test", []string{"-dsn", "foo"}, Defaults())
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("RLOCK_MAX_AGE"))
+		})
+
+		It("parses extra flags registered by the binary", func() {
+			var out string
+
+			_, err := Load("test", []string{"-dsn", "foo", "-out", "snapshot.json"}, Defaults(), func(fs *flag.FlagSet) {
+				fs.StringVar(&out, "out", "", "output file")
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(out).To(Equal("snapshot.json"))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("requires a dsn", func() {
+			Expect(Defaults().Validate()).ToNot(Succeed())
+		})
+
+		It("rejects invalid table names", func() {
+			cfg := Defaults()
+			cfg.DSN = "foo"
+			cfg.Table = "bad-table"
+
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+
+		It("requires tls cert and key together", func() {
+			cfg := Defaults()
+			cfg.DSN = "foo"
+			cfg.TLSCert = "cert.pem"
+
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+	})
+})
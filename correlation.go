@@ -0,0 +1,54 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+)
+
+type correlationKey struct{}
+
+// WithCorrelationID records a correlation ID (ie. the ID of the request or
+// trace an acquisition is made for) with every lock row we acquire and its
+// audit entry, so a hold can be joined back to what created it. Acquisitions
+// whose context carries an ID (see ContextWithCorrelationID) record that one;
+// all others record id, which may be empty. Without this option, correlation
+// IDs are not recorded at all. The correlation_id columns are added by
+// EnsureSchema() (schema version 5).
+func WithCorrelationID(id string) Option {
+	return func(r *RLock) error {
+		if len(id) > maxCorrelationIDLength {
+			return fmt.Errorf("correlation id cannot be longer than %d bytes", maxCorrelationIDLength)
+		}
+
+		r.correlationIDs = true
+		r.correlationID = id
+
+		return nil
+	}
+}
+
+// Size of the correlation_id columns
+const maxCorrelationIDLength = 255
+
+// ContextWithCorrelationID returns a copy of ctx that makes acquisitions
+// using it (ie. LockAll(), AcquireAny() or Claim()) record id as their
+// correlation ID; see WithCorrelationID.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// correlationIDFrom returns the correlation ID to record for acquisitions
+// made with ctx.
+func (r *RLock) correlationIDFrom(ctx context.Context) string {
+	id, ok := ctx.Value(correlationKey{}).(string)
+	if !ok {
+		return r.correlationID
+	}
+
+	// Longer IDs would fail the acquisition under strict SQL modes
+	if len(id) > maxCorrelationIDLength {
+		id = id[:maxCorrelationIDLength]
+	}
+
+	return id
+}
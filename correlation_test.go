@@ -0,0 +1,81 @@
+package rlock
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithCorrelationID", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithCorrelationID("deploy-42"), WithAuditLog())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the id", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithCorrelationID(strings.Repeat("x", 256)))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("records the default id with the lock row and its audit entry", func() {
+		mock.ExpectExec(`INSERT INTO rlock \(name, owner, in_use, acquired_at, acquire_count, host, pid, correlation_id\)`).
+			WithArgs("foo", rl.owner, rl.host, rl.pid, "deploy-42").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO rlock_audit \(.*, correlation_id\)`).
+			WithArgs("foo", rl.owner, rl.host, rl.pid, string(AcquireFresh), "", "", "deploy-42").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("prefers the id carried by the context", func() {
+		ctx := ContextWithCorrelationID(context.Background(), "trace-abc")
+
+		Expect(rl.correlationIDFrom(ctx)).To(Equal("trace-abc"))
+		Expect(rl.correlationIDFrom(context.Background())).To(Equal("deploy-42"))
+	})
+
+	It("records the id when taking locks over", func() {
+		mock.ExpectExec(`UPDATE rlock SET owner=\?, host=\?, pid=\?, acquired_at=NOW\(\), acquire_count=LAST_INSERT_ID\(acquire_count\+1\), correlation_id=\?, in_use=1`).
+			WithArgs(rl.owner, rl.host, rl.pid, "trace-abc", "foo", "previous-owner").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		ctx := ContextWithCorrelationID(context.Background(), "trace-abc")
+
+		_, err := rl.takeover(ctx, "foo", "previous-owner", false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("does not record ids without the option", func() {
+		_, m, plain := setupMocks()
+
+		m.ExpectExec(`INSERT INTO rlock \(name, owner, in_use, acquired_at, acquire_count, host, pid\) VALUES`).
+			WithArgs("foo", plain.owner, plain.host, plain.pid).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := plain.lockContext(ContextWithCorrelationID(context.Background(), "trace-abc"), "foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,62 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Per-lock counters", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	expectContended := func(inUse byte, lastUsed time.Time) {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{inUse}, "", lastUsed, lastUsed))
+	}
+
+	It("counts forced takeovers", func() {
+		expectContended(1, time.Now().Add(-2*MaxAge))
+		mock.ExpectExec(`UPDATE rlock SET .*takeover_count=takeover_count\+1, in_use=1 WHERE name=\? AND owner=\?`).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("counts timeouts without touching last_used", func() {
+		expectContended(1, time.Now())
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec(`UPDATE rlock SET timeout_count=timeout_count\+1, last_used=last_used WHERE name=\?`).
+			WithArgs("foo").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("still times out when counting fails", func() {
+		expectContended(1, time.Now())
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnError(fmt.Errorf("boom"))
+
+		_, err := rl.Lock("foo", 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+	})
+})
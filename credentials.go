@@ -0,0 +1,99 @@
+package rlock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// CredentialProvider returns the DB credentials to use for new connections.
+// Implementations backed by Vault, AWS Secrets Manager, etc. should cache
+// credentials and only refresh them when they are about to expire.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (user, password string, err error)
+}
+
+// CredentialProviderFunc adapts a func to a CredentialProvider.
+type CredentialProviderFunc func(ctx context.Context) (user, password string, err error)
+
+func (f CredentialProviderFunc) Credentials(ctx context.Context) (string, string, error) {
+	return f(ctx)
+}
+
+type credentialConnector struct {
+	cfg      *mysql.Config
+	provider CredentialProvider
+}
+
+// NewCredentialConnector returns a connector that asks provider for
+// credentials every time a new connection is opened, so password rotations
+// are picked up without restarting. User and password in cfg are ignored.
+func NewCredentialConnector(cfg *mysql.Config, provider CredentialProvider) (driver.Connector, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("cfg cannot be nil")
+	}
+
+	if provider == nil {
+		return nil, fmt.Errorf("provider cannot be nil")
+	}
+
+	return &credentialConnector{
+		cfg:      cfg.Clone(),
+		provider: provider,
+	}, nil
+}
+
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	user, password, err := c.provider.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch db credentials: %v", err)
+	}
+
+	cfg := c.cfg.Clone()
+	cfg.User = user
+	cfg.Passwd = password
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return connector.Connect(ctx)
+}
+
+func (c *credentialConnector) Driver() driver.Driver {
+	return &mysql.MySQLDriver{}
+}
+
+// NewFromConnector opens a DB handle using connector (ie. one returned by
+// NewCredentialConnector) and returns an RLock that owns it; call Close() to
+// release the handle.
+func NewFromConnector(connector driver.Connector, opts ...Option) (*RLock, error) {
+	if connector == nil {
+		return nil, fmt.Errorf("connector cannot be nil")
+	}
+
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+
+	r, err := newRLock(db, true, opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Close closes the underlying DB handle if it was opened by rlock (ie. via
+// NewFromConnector or NewFromDSN); handles passed to New() are left alone.
+func (r *RLock) Close() error {
+	if !r.ownsDB {
+		return nil
+	}
+
+	return r.db.Close()
+}
@@ -0,0 +1,86 @@
+package rlock
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeConnector struct {
+	connects int
+}
+
+func (f *fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	f.connects++
+	return nil, fmt.Errorf("fake connector")
+}
+
+func (f *fakeConnector) Driver() driver.Driver {
+	return &mysql.MySQLDriver{}
+}
+
+var _ = Describe("Credentials", func() {
+	Describe("NewCredentialConnector", func() {
+		It("fetches credentials for every new connection", func() {
+			calls := 0
+
+			provider := CredentialProviderFunc(func(ctx context.Context) (string, string, error) {
+				calls++
+				return "", "", fmt.Errorf("vault is sealed")
+			})
+
+			connector, err := NewCredentialConnector(mysql.NewConfig(), provider)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = connector.Connect(context.Background())
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("vault is sealed"))
+
+			_, err = connector.Connect(context.Background())
+			Expect(err).To(HaveOccurred())
+			Expect(calls).To(Equal(2))
+		})
+
+		It("requires a config and provider", func() {
+			_, err := NewCredentialConnector(nil, nil)
+			Expect(err).To(HaveOccurred())
+
+			_, err = NewCredentialConnector(mysql.NewConfig(), nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("NewFromConnector", func() {
+		It("returns an rlock that owns the db handle", func() {
+			connector := &fakeConnector{}
+
+			rl, err := NewFromConnector(connector, WithTableName("locks"))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rl.ownsDB).To(BeTrue())
+			Expect(rl.table).To(Equal("locks"))
+
+			Expect(rl.db.Ping()).ToNot(Succeed())
+			Expect(connector.connects).To(BeNumerically(">", 0))
+			Expect(rl.Close()).To(Succeed())
+		})
+
+		It("errors on nil connector", func() {
+			_, err := NewFromConnector(nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Close", func() {
+		It("does not close handles passed to New", func() {
+			db, _, rl := setupMocks()
+
+			Expect(rl.Close()).To(Succeed())
+			Expect(db.Ping()).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,196 @@
+package rlock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron spec; see parseCron().
+type cronSchedule struct {
+	// Bit i is set if the field matches i
+	minute, hour, dom, month, dow uint64
+
+	// Whether the day of month (day of week) field was restricted; when both
+	// are, a day matching either one matches (as in cron(8))
+	domRestricted, dowRestricted bool
+
+	// Set for "@every <duration>" specs; ticks are the multiples of every
+	// since the Unix epoch
+	every time.Duration
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Bounds of the fields of a cron spec, in order
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron parses a cron spec: five fields (minute, hour, day of month,
+// month and day of week, where 0 and 7 are Sunday), each "*" or a list of
+// values, ranges ("1-5") and steps ("*/15", "0-30/10"); one of the macros
+// ("@hourly", "@daily", "@weekly", "@monthly" and "@yearly"); or
+// "@every <duration>" (ie. "@every 30s").
+func parseCron(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron spec '%v': %v", spec, err)
+		}
+
+		if every < time.Second {
+			return nil, fmt.Errorf("invalid cron spec '%v': interval must be at least 1s", spec)
+		}
+
+		return &cronSchedule{every: every}, nil
+	}
+
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron spec '%v': expected %d fields", spec, len(cronFields))
+	}
+
+	bits := make([]uint64, len(fields))
+
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v in cron spec '%v': %v", cronFields[i].name, spec, err)
+		}
+
+		bits[i] = b
+	}
+
+	s := &cronSchedule{
+		minute:        bits[0],
+		hour:          bits[1],
+		dom:           bits[2],
+		month:         bits[3],
+		dow:           bits[4],
+		domRestricted: fields[2] != "*",
+		dowRestricted: fields[4] != "*",
+	}
+
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+// parseCronField returns the bits of the values between min and max that
+// field matches.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in '%v'", part)
+			}
+
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+
+			var err error
+
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value '%v'", bounds[0])
+			}
+
+			hi = lo
+
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value '%v'", bounds[1])
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the end in steps of 15
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("'%v' is out of range (%d-%d)", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// next returns the first tick of the schedule after t, in UTC, or the zero
+// time if there is none within the next five years (ie. "0 0 30 2 *").
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC()
+
+	if s.every > 0 {
+		return time.Unix(0, 0).UTC().Add((t.Sub(time.Unix(0, 0))/s.every + 1) * s.every)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+
+	return dom && dow
+}
@@ -0,0 +1,65 @@
+package rlock
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cron schedules", func() {
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		Expect(err).ToNot(HaveOccurred())
+
+		return t
+	}
+
+	next := func(spec, after string) time.Time {
+		s, err := parseCron(spec)
+		Expect(err).ToNot(HaveOccurred())
+
+		return s.next(at(after))
+	}
+
+	It("rejects invalid specs", func() {
+		for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+			"* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@every 10ms", "@every soon", "@fortnightly"} {
+			_, err := parseCron(spec)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+	})
+
+	It("computes the next tick", func() {
+		Expect(next("* * * * *", "2020-01-01T10:00:30Z")).To(Equal(at("2020-01-01T10:01:00Z")))
+		Expect(next("*/15 * * * *", "2020-01-01T10:15:00Z")).To(Equal(at("2020-01-01T10:30:00Z")))
+		Expect(next("5/20 * * * *", "2020-01-01T10:30:00Z")).To(Equal(at("2020-01-01T10:45:00Z")))
+		Expect(next("0 9-17/4 * * *", "2020-01-01T14:00:00Z")).To(Equal(at("2020-01-01T17:00:00Z")))
+		Expect(next("30 2 * * 1,3", "2020-01-01T03:00:00Z")).To(Equal(at("2020-01-06T02:30:00Z")))
+		Expect(next("@daily", "2020-12-31T23:59:00Z")).To(Equal(at("2021-01-01T00:00:00Z")))
+		Expect(next("@monthly", "2020-01-31T12:00:00Z")).To(Equal(at("2020-02-01T00:00:00Z")))
+		Expect(next("0 0 29 2 *", "2021-01-01T00:00:00Z")).To(Equal(at("2024-02-29T00:00:00Z")))
+	})
+
+	It("treats 7 as Sunday", func() {
+		Expect(next("0 0 * * 7", "2020-01-01T00:00:00Z")).To(Equal(at("2020-01-05T00:00:00Z")))
+		Expect(next("@weekly", "2020-01-01T00:00:00Z")).To(Equal(at("2020-01-05T00:00:00Z")))
+	})
+
+	It("matches either day field when both are restricted", func() {
+		// The 10th, or any Friday
+		Expect(next("0 0 10 * 5", "2020-01-01T00:00:00Z")).To(Equal(at("2020-01-03T00:00:00Z")))
+		Expect(next("0 0 10 * 5", "2020-01-08T00:00:00Z")).To(Equal(at("2020-01-10T00:00:00Z")))
+		Expect(next("0 0 10 * *", "2020-01-01T00:00:00Z")).To(Equal(at("2020-01-10T00:00:00Z")))
+	})
+
+	It("aligns intervals to the epoch", func() {
+		Expect(next("@every 30s", "2020-01-01T10:00:10Z")).To(Equal(at("2020-01-01T10:00:30Z")))
+		Expect(next("@every 30s", "2020-01-01T10:00:30Z")).To(Equal(at("2020-01-01T10:01:00Z")))
+		Expect(next("@every 1h", "2020-01-01T10:20:00+02:00")).To(Equal(at("2020-01-01T09:00:00Z")))
+	})
+
+	It("gives up on schedules without ticks", func() {
+		Expect(next("0 0 30 2 *", "2020-01-01T00:00:00Z")).To(BeZero())
+	})
+})
@@ -0,0 +1,202 @@
+package rlock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// How many errors Dump() reports
+const maxRecentErrors = 20
+
+// StateDump is a snapshot of an RLock's in-process state, meant for support
+// bundles and crash handlers; see Dump(). It marshals to JSON.
+type StateDump struct {
+	Time        time.Time `json:"time"`
+	Owner       string    `json:"owner"`
+	Host        string    `json:"host"`
+	PID         int       `json:"pid"`
+	Table       string    `json:"table"`
+	Environment string    `json:"environment,omitempty"`
+
+	Held    []*HeldLockDump `json:"held"`
+	Pending []*PendingDump  `json:"pending"`
+	Reaper  ReaperDump      `json:"reaper"`
+
+	// Whether failover handling (see WithFailover) is in progress
+	FailingOver bool `json:"failing_over"`
+
+	// The most recent errors returned by the DB, oldest first
+	RecentErrors []*ErrorDump `json:"recent_errors"`
+}
+
+// HeldLockDump describes a lock held by the RLock; see StateDump.
+type HeldLockDump struct {
+	Name       string    `json:"name"`
+	Token      int64     `json:"token"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	TookOver   bool      `json:"took_over"`
+
+	// Running heartbeats (see Lock.Heartbeat()) and how they fared
+	Heartbeats        int        `json:"heartbeats"`
+	LastHeartbeat     *time.Time `json:"last_heartbeat,omitempty"`
+	HeartbeatFailures int        `json:"heartbeat_failures"`
+	HeartbeatError    string     `json:"heartbeat_error,omitempty"`
+}
+
+// PendingDump describes an acquisition in progress; see StateDump.
+type PendingDump struct {
+	Name     string    `json:"name"`
+	Since    time.Time `json:"since"`
+	Priority int       `json:"priority"`
+}
+
+// ReaperDump describes the last ReapStale() run; see StateDump.
+type ReaperDump struct {
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastReaped int        `json:"last_reaped"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// ErrorDump is an error as reported by StateDump.
+type ErrorDump struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+type pendingAcquire struct {
+	name     string
+	since    time.Time
+	priority int
+}
+
+// heartbeatStatus tracks how a lock's heartbeats fare; guarded by Lock.mu.
+type heartbeatStatus struct {
+	running  int
+	lastBeat time.Time
+	failures int
+	lastErr  string
+}
+
+type debugState struct {
+	mu      sync.Mutex
+	pending map[*pendingAcquire]struct{}
+	reaper  ReaperDump
+	errors  []*ErrorDump
+}
+
+// Dump returns a snapshot of the RLock's in-process state: the locks it
+// holds (and how their heartbeats fare), the acquisitions in progress, the
+// last ReapStale() run and the most recent errors. It does not query the DB.
+func (r *RLock) Dump() *StateDump {
+	dump := &StateDump{
+		Time:         r.clock.Now(),
+		Owner:        r.owner,
+		Host:         r.host,
+		PID:          r.pid,
+		Table:        r.table,
+		Environment:  r.environment,
+		Held:         make([]*HeldLockDump, 0),
+		Pending:      make([]*PendingDump, 0),
+		RecentErrors: make([]*ErrorDump, 0),
+	}
+
+	r.mu.Lock()
+	held := make([]*Lock, 0, len(r.held))
+
+	for _, l := range r.held {
+		held = append(held, l)
+	}
+	r.mu.Unlock()
+
+	for _, l := range held {
+		l.mu.Lock()
+
+		d := &HeldLockDump{
+			Name:              l.name,
+			Token:             l.token,
+			AcquiredAt:        l.acquiredAt,
+			TookOver:          l.tookOver,
+			Heartbeats:        l.heartbeats.running,
+			HeartbeatFailures: l.heartbeats.failures,
+			HeartbeatError:    l.heartbeats.lastErr,
+		}
+
+		if !l.heartbeats.lastBeat.IsZero() {
+			lastBeat := l.heartbeats.lastBeat
+			d.LastHeartbeat = &lastBeat
+		}
+
+		l.mu.Unlock()
+
+		dump.Held = append(dump.Held, d)
+	}
+
+	sort.Slice(dump.Held, func(i, j int) bool { return dump.Held[i].Name < dump.Held[j].Name })
+
+	r.debug.mu.Lock()
+
+	for p := range r.debug.pending {
+		dump.Pending = append(dump.Pending, &PendingDump{Name: p.name, Since: p.since, Priority: p.priority})
+	}
+
+	dump.Reaper = r.debug.reaper
+	dump.RecentErrors = append(dump.RecentErrors, r.debug.errors...)
+
+	r.debug.mu.Unlock()
+
+	sort.Slice(dump.Pending, func(i, j int) bool { return dump.Pending[i].Since.Before(dump.Pending[j].Since) })
+
+	r.failover.mu.Lock()
+	dump.FailingOver = r.failover.handling
+	r.failover.mu.Unlock()
+
+	return dump
+}
+
+// trackPending records that name is being acquired until the returned
+// function is called.
+func (r *RLock) trackPending(name string, priority int) func() {
+	p := &pendingAcquire{name: name, since: r.clock.Now(), priority: priority}
+
+	r.debug.mu.Lock()
+
+	if r.debug.pending == nil {
+		r.debug.pending = make(map[*pendingAcquire]struct{})
+	}
+
+	r.debug.pending[p] = struct{}{}
+	r.debug.mu.Unlock()
+
+	return func() {
+		r.debug.mu.Lock()
+		delete(r.debug.pending, p)
+		r.debug.mu.Unlock()
+	}
+}
+
+// recordError keeps err for Dump().
+func (r *RLock) recordError(err error) {
+	r.debug.mu.Lock()
+	defer r.debug.mu.Unlock()
+
+	r.debug.errors = append(r.debug.errors, &ErrorDump{Time: r.clock.Now(), Error: err.Error()})
+
+	if len(r.debug.errors) > maxRecentErrors {
+		r.debug.errors = r.debug.errors[len(r.debug.errors)-maxRecentErrors:]
+	}
+}
+
+// recordReap keeps the outcome of a ReapStale() run for Dump().
+func (r *RLock) recordReap(reaped []string, err error) {
+	now := r.clock.Now()
+
+	r.debug.mu.Lock()
+	defer r.debug.mu.Unlock()
+
+	r.debug.reaper = ReaperDump{LastRun: &now, LastReaped: len(reaped)}
+
+	if err != nil {
+		r.debug.reaper.LastError = err.Error()
+	}
+}
@@ -0,0 +1,87 @@
+package rlock
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Dump", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	It("reports held locks", func() {
+		rl.newLock("foo", time.Minute, 3)
+		rl.newLock("bar", time.Minute, 1)
+
+		dump := rl.Dump()
+
+		Expect(dump.Owner).To(Equal(rl.owner))
+		Expect(dump.Held).To(HaveLen(2))
+		Expect(dump.Held[0].Name).To(Equal("bar"))
+		Expect(dump.Held[1].Token).To(Equal(int64(3)))
+		Expect(dump.Held[1].Heartbeats).To(BeZero())
+		Expect(dump.Held[1].LastHeartbeat).To(BeNil())
+	})
+
+	It("reports pending acquisitions", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		done := make(chan struct{})
+
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+
+			_, err := rl.Lock("foo", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+		}()
+
+		Eventually(func() []*PendingDump { return rl.Dump().Pending }).Should(HaveLen(1))
+		Expect(rl.Dump().Pending[0].Name).To(Equal("foo"))
+
+		Eventually(done, 5*time.Second).Should(BeClosed())
+		Expect(rl.Dump().Pending).To(BeEmpty())
+	})
+
+	It("reports the last reaper run and recent errors", func() {
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnError(errors.New("boom"))
+
+		_, err := rl.ReapStale(MaxAge)
+		Expect(err).To(HaveOccurred())
+
+		for i := 0; i < maxRecentErrors+5; i++ {
+			rl.observeError(errors.New("boom"))
+		}
+
+		dump := rl.Dump()
+
+		Expect(dump.Reaper.LastRun).ToNot(BeNil())
+		Expect(dump.Reaper.LastError).To(ContainSubstring("boom"))
+		Expect(dump.RecentErrors).To(HaveLen(maxRecentErrors))
+	})
+
+	It("marshals to JSON", func() {
+		rl.newLock("foo", time.Minute, 1)
+
+		encoded, err := json.Marshal(rl.Dump())
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(encoded)).To(ContainSubstring(`"name":"foo"`))
+	})
+})
@@ -0,0 +1,98 @@
+package rlock
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// EnvironmentSeparator separates the environment from the rest of the name of
+// a lock scoped to an environment; see WithEnvironment.
+const EnvironmentSeparator = ":"
+
+var environmentName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}$`)
+
+// WithEnvironment scopes every operation to env (ie. "staging" or
+// "production"), so that services of different environments sharing a
+// database never contend on each other's locks: names are prefixed with
+// "<env>:" wherever they are passed in (names already carrying the prefix are
+// used as is) and operations spanning many locks (ie. ListLocks(), Purge(),
+// ReapStale() or Snapshot()) only see the environment's locks. Names are
+// reported with their prefix (ie. LockEntry.Name and Lock.Name()). env may
+// only contain lowercase letters, digits, '_', '.' and '-'.
+func WithEnvironment(env string) Option {
+	return func(r *RLock) error {
+		if !environmentName.MatchString(env) {
+			return fmt.Errorf("invalid environment '%v'", env)
+		}
+
+		r.environment = env
+
+		return nil
+	}
+}
+
+// Environment returns the environment this RLock is scoped to (see
+// WithEnvironment) or an empty string.
+func (r *RLock) Environment() string {
+	return r.environment
+}
+
+// environmentPrefix returns the prefix of the names of our environment's
+// locks.
+func (r *RLock) environmentPrefix() string {
+	if r.environment == "" {
+		return ""
+	}
+
+	return r.environment + EnvironmentSeparator
+}
+
+// scopeName returns name scoped to our environment.
+func (r *RLock) scopeName(name string) string {
+	prefix := r.environmentPrefix()
+
+	if prefix == "" || strings.HasPrefix(name, prefix) {
+		return name
+	}
+
+	return prefix + name
+}
+
+// environmentConditions returns the conditions (and their args) restricting
+// a query to our environment's locks.
+func (r *RLock) environmentConditions() ([]string, []interface{}) {
+	if r.environment == "" {
+		return nil, nil
+	}
+
+	return []string{"name LIKE ? ESCAPE '!'"}, []interface{}{escapeLike(r.environmentPrefix()) + "%"}
+}
+
+// scoped returns cond (with args) further restricted to our environment's
+// locks.
+func (r *RLock) scoped(cond string, args []interface{}) (string, []interface{}) {
+	conds, envArgs := r.environmentConditions()
+
+	for _, c := range conds {
+		cond += " AND " + c
+	}
+
+	return cond, append(args, envArgs...)
+}
+
+// environmentWhere returns the WHERE clause (if any, and its args)
+// restricting a query to our environment's locks.
+func (r *RLock) environmentWhere() (string, []interface{}) {
+	conds, args := r.environmentConditions()
+	if len(conds) == 0 {
+		return "", nil
+	}
+
+	return "WHERE " + strings.Join(conds, " AND ") + " ", args
+}
+
+// inEnvironment returns whether name belongs to our environment.
+func (r *RLock) inEnvironment(name string) bool {
+	return strings.HasPrefix(name, r.environmentPrefix())
+}
@@ -0,0 +1,118 @@
+package rlock
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithEnvironment", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithEnvironment("staging"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the environment", func() {
+		db, _, _ := setupMocks()
+
+		for _, env := range []string{"", "Staging", "stag%ing", "staging:eu"} {
+			_, err := New(db, WithEnvironment(env))
+			Expect(err).To(HaveOccurred(), env)
+		}
+	})
+
+	It("scopes lock names", func() {
+		mock.ExpectExec("INSERT INTO rlock").
+			WithArgs("staging:foo", rl.owner, rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Name()).To(Equal("staging:foo"))
+		Expect(rl.Environment()).To(Equal("staging"))
+	})
+
+	It("does not scope names twice", func() {
+		Expect(rl.normalizeName("staging:foo")).To(Equal("staging:foo"))
+		Expect(rl.normalizeName("production:foo")).To(Equal("staging:production:foo"))
+	})
+
+	It("scopes names after normalizing them", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db, WithEnvironment("staging"), WithNameNormalization(NormalizeTrim|NormalizeCaseFold))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rl.normalizeName(" Foo ")).To(Equal("staging:foo"))
+		Expect(rl.normalizeNames([]string{"Foo"})).To(Equal([]string{"staging:foo"}))
+	})
+
+	It("only lists the environment's locks", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name LIKE \? ESCAPE '!' AND owner=\? ORDER BY name`).
+			WithArgs("staging:%", "owner-a").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name LIKE \? ESCAPE '!' ORDER BY name`).
+			WithArgs("staging:foo-%").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+
+		_, err := rl.GetLocksByOwner("owner-a")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = rl.FindLocks("foo-*")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("only purges the environment's locks", func() {
+		mock.ExpectExec(`DELETE FROM rlock WHERE in_use=0 AND last_used < \? AND name LIKE \? ESCAPE '!'`).
+			WithArgs(sqlmock.AnyArg(), "staging:%").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE in_use=1 AND last_used < \? AND name LIKE \? ESCAPE '!'`).
+			WithArgs(sqlmock.AnyArg(), "staging:%").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+
+		_, err := rl.Purge(time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = rl.ReapStale(time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("only snapshots the environment's locks", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name LIKE \? ESCAPE '!' ORDER BY name`).
+			WithArgs("staging:%").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+		mock.ExpectCommit()
+
+		_, err := rl.Snapshot(context.Background(), 0)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("refuses to restore locks of other environments", func() {
+		snapshot := &Snapshot{Locks: []*LockEntry{{Name: "production:foo"}}}
+
+		_, err := rl.Restore(context.Background(), snapshot, false)
+
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,105 @@
+package rlock
+
+import (
+	"sync"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+type EventType string
+
+const (
+	// EventAcquired is emitted when a lock is acquired, either by inserting a
+	// new lock or by taking over a lock that was released by its previous owner
+	EventAcquired EventType = "acquired"
+
+	// EventReleased is emitted when a lock is unlocked
+	EventReleased EventType = "released"
+
+	// EventTakeover is emitted when a stale lock is forcibly taken over
+	EventTakeover EventType = "takeover"
+
+	// EventForceUnlocked is emitted when an operator force unlocks a lock
+	EventForceUnlocked EventType = "force_unlocked"
+
+	// EventReaped is emitted when a stale lock is released by ReapStale
+	EventReaped EventType = "reaped"
+
+	// EventTopologyChanged is emitted when a DB failover is detected (see
+	// WithFailoverDetection); Name is empty and LastError holds the cause
+	EventTopologyChanged EventType = "topology_changed"
+
+	// EventLockLost is emitted when a lock we believed we held turns out to
+	// no longer be ours
+	EventLockLost EventType = "lock_lost"
+)
+
+// Event describes a change in lock state made by this RLock instance.
+type Event struct {
+	Type          EventType `json:"type"`
+	Name          string    `json:"name"`
+	Owner         string    `json:"owner"`
+	PreviousOwner string    `json:"previous_owner,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+type subscribers struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan *Event
+}
+
+// Subscribe returns a channel that receives every event emitted by this RLock
+// along with a func to cancel the subscription. Events are dropped (rather
+// than blocking lock operations) if the subscriber falls more than buffer
+// events behind.
+func (r *RLock) Subscribe(buffer int) (<-chan *Event, func()) {
+	r.subscribers.mu.Lock()
+	defer r.subscribers.mu.Unlock()
+
+	if r.subscribers.subs == nil {
+		r.subscribers.subs = make(map[int]chan *Event)
+	}
+
+	id := r.subscribers.next
+	r.subscribers.next++
+
+	ch := make(chan *Event, buffer)
+	r.subscribers.subs[id] = ch
+
+	var once sync.Once
+
+	return ch, func() {
+		once.Do(func() {
+			r.subscribers.mu.Lock()
+			defer r.subscribers.mu.Unlock()
+
+			delete(r.subscribers.subs, id)
+			close(ch)
+		})
+	}
+}
+
+func (r *RLock) emit(eventType EventType, name, previousOwner, lastError string) {
+	event := &Event{
+		Type:          eventType,
+		Name:          name,
+		Owner:         r.owner,
+		PreviousOwner: previousOwner,
+		LastError:     lastError,
+		Time:          r.clock.Now(),
+	}
+
+	r.subscribers.mu.Lock()
+	defer r.subscribers.mu.Unlock()
+
+	for _, ch := range r.subscribers.subs {
+		select {
+		case ch <- event:
+		default:
+			r.logFor(name).WithFields(golog.Fields{"event": string(eventType)}).Warn("dropping event; subscriber is not keeping up")
+		}
+	}
+}
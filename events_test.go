@@ -0,0 +1,69 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Events", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	It("emits acquired and released events to subscribers", func() {
+		events, cancel := rl.Subscribe(10)
+		defer cancel()
+
+		mock.ExpectExec(fmt.Sprintf(`INSERT INTO %v`, TableName)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(fmt.Sprintf(`UPDATE %v SET in_use=0`, TableName)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("events-lock", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Unlock(fmt.Errorf("foo"))).To(Succeed())
+
+		var event *Event
+
+		Eventually(events).Should(Receive(&event))
+		Expect(event.Type).To(Equal(EventAcquired))
+		Expect(event.Name).To(Equal("events-lock"))
+		Expect(event.Owner).To(Equal(rl.owner))
+
+		Eventually(events).Should(Receive(&event))
+		Expect(event.Type).To(Equal(EventReleased))
+		Expect(event.LastError).To(Equal("foo"))
+	})
+
+	It("closes the channel on cancel", func() {
+		events, cancel := rl.Subscribe(1)
+
+		cancel()
+		cancel()
+
+		Eventually(events).Should(BeClosed())
+	})
+
+	It("drops events for subscribers that are not keeping up", func() {
+		events, cancel := rl.Subscribe(1)
+		defer cancel()
+
+		rl.emit(EventAcquired, "a", "", "")
+		rl.emit(EventAcquired, "b", "", "")
+
+		var event *Event
+
+		Eventually(events).Should(Receive(&event))
+		Expect(event.Name).To(Equal("a"))
+		Consistently(events).ShouldNot(Receive())
+	})
+})
@@ -0,0 +1,104 @@
+package rlock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LockExpiredErr is returned when refreshing a lock acquired with LockFor()
+// once its hold window has ended; it is also recorded as the lock's last
+// error when the lock is released because of it.
+var LockExpiredErr = errors.New("lock hold window has ended")
+
+// LockFor acquires the lock called name without waiting for it (like
+// Lock(name, 0)) and holds it for at most hold: once hold has passed, the
+// lock is unlocked (see UnlockFenced(), recording LockExpiredErr) unless it
+// was unlocked before, and its fencing token is invalidated (Token() returns
+// 0, so that guarded writes, see GuardExec(), fail). This suits callers that
+// prefer bounded holds over heartbeats (see Heartbeat()); a hold longer than
+// the takeover policy's age (see WithTakeoverPolicy) still needs refreshing
+// for the lock not to go stale before its end.
+func (r *RLock) LockFor(name string, hold time.Duration) (*Lock, error) {
+	if hold <= 0 {
+		return nil, fmt.Errorf("hold must be positive")
+	}
+
+	l, err := r.Lock(name, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	l.expireAfter(hold)
+
+	return l, nil
+}
+
+// ExpiresAt returns when the lock's hold window ends (see LockFor()), or the
+// zero time if it does not have one.
+func (l *Lock) ExpiresAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.expiresAt
+}
+
+// Expired returns whether the lock's hold window has ended; see LockFor().
+func (l *Lock) Expired() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.expired
+}
+
+// expireAfter releases the lock once hold has passed since we acquired it.
+func (l *Lock) expireAfter(hold time.Duration) {
+	timer := l.rl.clock.NewTimer(hold)
+	done := make(chan struct{})
+	once := &sync.Once{}
+
+	l.mu.Lock()
+	l.expiresAt = l.acquiredAt.Add(hold)
+	l.stopHeartbeats = append(l.stopHeartbeats, func() {
+		once.Do(func() { close(done) })
+	})
+	l.mu.Unlock()
+
+	go func() {
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+			l.expire()
+		case <-done:
+		}
+	}()
+}
+
+// expire ends the lock's hold window, releasing the lock if it is still held.
+func (l *Lock) expire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlocked {
+		return
+	}
+
+	l.expired = true
+
+	// Leave a new hold of the lock alone (ie. one that took it over once it
+	// went stale)
+	err := l.unlock(LockExpiredErr, l.token != 0)
+
+	switch err {
+	case nil, LockLostErr:
+		l.unlocked = true
+		l.stopHeartbeat()
+		l.rl.forget(l)
+	default:
+		// Still ours in the DB; Unlock() can retry, and the lock goes stale
+		// if it does not
+		withError(l.rl.logFor(l.name), err).Warn("unable to release lock at the end of its hold window")
+	}
+}
@@ -0,0 +1,75 @@
+package rlock
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("LockFor", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		rl    *RLock
+		clock *FakeClock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		clock = NewFakeClock(time.Now())
+
+		var err error
+
+		rl, err = New(db, WithClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the hold", func() {
+		_, err := rl.LockFor("foo", 0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("releases the lock and invalidates its token at the end of the hold", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE rlock SET in_use=0, last_error=\? WHERE name=\? AND owner=\? AND acquire_count=\?`).
+			WithArgs(LockExpiredErr.Error(), "foo", rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		l, err := rl.LockFor("foo", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.ExpiresAt()).To(Equal(clock.Now().Add(time.Minute)))
+		Expect(l.Token()).To(Equal(int64(1)))
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(time.Minute)
+
+		Eventually(l.Expired).Should(BeTrue())
+		Expect(l.Token()).To(BeZero())
+		Expect(l.Unlock(nil)).To(Equal(AlreadyUnlockedErr))
+		Expect(rl.heldLocks()).To(BeEmpty())
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("stops the expiry when unlocked before the end of the hold", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		l, err := rl.LockFor("foo", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		Expect(l.Unlock(nil)).To(Succeed())
+		Eventually(clock.Waiters).Should(Equal(0))
+
+		clock.Advance(time.Minute)
+
+		Consistently(l.Expired).Should(BeFalse())
+		Expect(l.Token()).To(Equal(int64(1)))
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
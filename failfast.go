@@ -0,0 +1,87 @@
+package rlock
+
+import (
+	"fmt"
+	"os"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// LockLostHandler is called with the name of a lock we believed we held and
+// why it turned out to be lost; see WithFailFast.
+type LockLostHandler func(name string, err error)
+
+// WithFailFast calls handler as soon as a held lock turns out to be lost (by
+// Refresh(), RefreshFenced(), SetMetadata() or the re-validation following a
+// failover), rather than leaving it to the caller to notice and stop a
+// critical section that is no longer exclusive. A nil handler logs and exits
+// the process (see ExitOnLockLost). The handler runs on the goroutine that
+// noticed the loss.
+func WithFailFast(handler LockLostHandler) Option {
+	return func(r *RLock) error {
+		if handler == nil {
+			handler = ExitOnLockLost
+		}
+
+		r.onLockLost = handler
+
+		return nil
+	}
+}
+
+// ExitOnLockLost is the default LockLostHandler of WithFailFast: it logs the
+// loss and exits the process with status 1. Unlike a panic, this cannot be
+// recovered from by ie. net/http's handler recovery.
+func ExitOnLockLost(name string, err error) {
+	log.WithFields(golog.Fields{"lock": name, "error": err.Error()}).Error("lock was lost; exiting")
+	os.Exit(1)
+}
+
+// PanicOnLockLost is a LockLostHandler panicking with the loss.
+func PanicOnLockLost(name string, err error) {
+	panic(fmt.Sprintf("lock '%v' was lost: %v", name, err))
+}
+
+// lockLost reports that l turned out to be lost.
+func (r *RLock) lockLost(l *Lock, cause error) {
+	l.markLost()
+
+	r.emit(EventLockLost, l.name, "", cause.Error())
+
+	if r.onLockLost != nil {
+		r.onLockLost(l.name, cause)
+	}
+}
+
+// Lost returns a channel that is closed once the lock turns out to be lost,
+// ie. when its heartbeat gives up (see Heartbeat()) or Refresh() returns
+// LockLostErr; the same losses WithFailFast reacts to.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lostChan()
+}
+
+// markLost closes the channel returned by Lost().
+func (l *Lock) markLost() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lost := l.lostChan()
+
+	select {
+	case <-lost:
+	default:
+		close(lost)
+	}
+}
+
+// lostChan returns the channel returned by Lost(); l.mu must be held.
+func (l *Lock) lostChan() chan struct{} {
+	if l.lost == nil {
+		l.lost = make(chan struct{})
+	}
+
+	return l.lost
+}
@@ -0,0 +1,60 @@
+package rlock
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithFailFast", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+		lost []string
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+		lost = nil
+
+		var err error
+
+		rl, err = New(db, WithFailFast(func(name string, err error) {
+			lost = append(lost, name)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("defaults to exiting", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db, WithFailFast(nil))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rl.onLockLost).ToNot(BeNil())
+	})
+
+	It("calls the handler when refreshing finds the lock lost", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM rlock`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+
+		Expect(l.Refresh()).To(Equal(LockLostErr))
+		Expect(lost).To(Equal([]string{"foo"}))
+	})
+
+	It("leaves other errors alone", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(l.Refresh()).To(Succeed())
+		Expect(l.Unlock(nil)).ToNot(Succeed())
+		Expect(lost).To(BeEmpty())
+	})
+})
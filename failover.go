@@ -0,0 +1,201 @@
+package rlock
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL/Galera error codes indicating we are talking to a node that can no
+// longer accept writes (ie. a demoted primary after failover).
+var failoverErrorCodes = map[uint16]bool{
+	1290: true, // ER_OPTION_PREVENTS_STATEMENT (--read-only; Aurora readers)
+	1792: true, // ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+	1836: true, // ER_READ_ONLY_MODE
+	1047: true, // ER_UNKNOWN_COM_ERROR (Galera: WSREP has not yet prepared node)
+	1053: true, // ER_SERVER_SHUTDOWN
+}
+
+// Re-validating a lock after a failover is retried this many times, this far
+// apart (doubling every time), while the lock cannot be fetched
+const (
+	failoverRevalidateAttempts = 5
+	failoverRevalidateBackoff  = 100 * time.Millisecond
+)
+
+// lockMismatchErr is returned by revalidate() when the DB shows that a lock is
+// no longer ours, as opposed to revalidate() being unable to tell.
+type lockMismatchErr struct {
+	reason string
+}
+
+func (e *lockMismatchErr) Error() string {
+	return e.reason
+}
+
+type failover struct {
+	enabled    bool
+	flushConns func()
+
+	mu       sync.Mutex
+	handling bool
+}
+
+// WithFailoverDetection makes rlock react to errors indicating a primary
+// failover (read-only errors, Galera/Aurora specific codes, broken
+// connections) by flushing pooled connections so that new ones re-resolve the
+// writer endpoint, re-validating every lock held by this instance and emitting
+// EventTopologyChanged (and EventLockLost for locks that did not survive).
+//
+// database/sql offers no way to flush idle connections other than lowering
+// the idle limit; maxIdleConns is what the limit is restored to afterwards
+// (database/sql defaults to 2).
+func WithFailoverDetection(maxIdleConns int) Option {
+	return func(r *RLock) error {
+		if maxIdleConns < 0 {
+			return fmt.Errorf("maxIdleConns cannot be negative")
+		}
+
+		r.failover.enabled = true
+		r.failover.flushConns = func() {
+			r.db.SetMaxIdleConns(0)
+			r.db.SetMaxIdleConns(maxIdleConns)
+		}
+
+		return nil
+	}
+}
+
+// isFailoverError returns true if err indicates that the node we are talking
+// to is no longer the writer.
+func isFailoverError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if err == driver.ErrBadConn || err == mysql.ErrInvalidConn {
+		return true
+	}
+
+	if me, ok := err.(*mysql.MySQLError); ok {
+		return failoverErrorCodes[me.Number]
+	}
+
+	return false
+}
+
+// observeError is called with every error returned by the DB; it kicks off
+// failover handling (in the background) if the error indicates a failover.
+func (r *RLock) observeError(err error) {
+	r.recordError(err)
+
+	if !r.failover.enabled || !isFailoverError(err) {
+		return
+	}
+
+	r.failover.mu.Lock()
+	defer r.failover.mu.Unlock()
+
+	// Failover is already being dealt with
+	if r.failover.handling {
+		return
+	}
+
+	r.failover.handling = true
+
+	go r.handleFailover(err)
+}
+
+func (r *RLock) handleFailover(cause error) {
+	defer func() {
+		r.failover.mu.Lock()
+		r.failover.handling = false
+		r.failover.mu.Unlock()
+	}()
+
+	withError(r.logger, cause).Warn("possible db failover detected; flushing connections and re-validating held locks")
+
+	// Drop idle connections so new ones re-resolve the writer endpoint
+	r.failover.flushConns()
+
+	r.emit(EventTopologyChanged, "", "", cause.Error())
+
+	for _, l := range r.heldLocks() {
+		err := r.revalidateAfterFailover(l)
+
+		switch err.(type) {
+		case nil:
+		case *lockMismatchErr:
+			withError(r.logFor(l.name), err).Error("lock did not survive failover")
+
+			r.forget(l)
+			r.lockLost(l, err)
+		default:
+			// Heartbeats and refreshes find out if it is not
+			withError(r.logFor(l.name), err).Warn("unable to re-validate lock after failover; assuming it is still ours")
+		}
+	}
+}
+
+// revalidateAfterFailover is revalidate() retrying, with backoff, while the
+// lock cannot be fetched, as is to be expected while the DB fails over.
+func (r *RLock) revalidateAfterFailover(l *Lock) error {
+	backoff := failoverRevalidateBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := r.revalidate(l, false)
+		if _, mismatch := err.(*lockMismatchErr); err == nil || mismatch || attempt == failoverRevalidateAttempts {
+			return err
+		}
+
+		withError(r.logFor(l.name), err).Debug("unable to re-validate lock after failover; retrying")
+
+		r.waitForRelease(context.Background(), nil, backoff)
+		backoff *= 2
+	}
+}
+
+// revalidate verifies that we still hold the lock according to the DB; when
+// fenced, that it was not acquired again since we acquired it. Returns a
+// *lockMismatchErr if we do not.
+func (r *RLock) revalidate(l *Lock, fenced bool) error {
+	entry, err := r.getExistingByName(l.name)
+	if err == KeyNotFoundErr {
+		return &lockMismatchErr{"lock no longer exists"}
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to fetch lock: %v", err)
+	}
+
+	if fenced && entry.AcquireCount != l.token {
+		return &lockMismatchErr{fmt.Sprintf("lock was acquired again since (acquire count %d, ours %d)", entry.AcquireCount, l.token)}
+	}
+
+	if entry.Owner != r.owner {
+		return &lockMismatchErr{fmt.Sprintf("lock is now owned by '%v'", entry.Owner)}
+	}
+
+	if !entry.InUse {
+		return &lockMismatchErr{"lock is no longer in use"}
+	}
+
+	return nil
+}
+
+func (r *RLock) heldLocks() []*Lock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	locks := make([]*Lock, 0, len(r.held))
+
+	for _, l := range r.held {
+		locks = append(locks, l)
+	}
+
+	return locks
+}
@@ -0,0 +1,155 @@
+package rlock
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Failover", func() {
+	Describe("isFailoverError", func() {
+		It("recognizes read-only and galera errors", func() {
+			Expect(isFailoverError(&mysql.MySQLError{Number: 1290})).To(BeTrue())
+			Expect(isFailoverError(&mysql.MySQLError{Number: 1836})).To(BeTrue())
+			Expect(isFailoverError(&mysql.MySQLError{Number: 1047})).To(BeTrue())
+			Expect(isFailoverError(driver.ErrBadConn)).To(BeTrue())
+		})
+
+		It("ignores other errors", func() {
+			Expect(isFailoverError(nil)).To(BeFalse())
+			Expect(isFailoverError(&mysql.MySQLError{Number: 1062})).To(BeFalse())
+			Expect(isFailoverError(fmt.Errorf("foo"))).To(BeFalse())
+		})
+	})
+
+	Describe("WithFailoverDetection", func() {
+		var (
+			mock  sqlmock.Sqlmock
+			rl    *RLock
+			clock *FakeClock
+		)
+
+		BeforeEach(func() {
+			mockDB, m, err := sqlmock.New()
+			Expect(err).ToNot(HaveOccurred())
+			mock = m
+
+			clock = NewFakeClock(time.Now())
+
+			rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithFailoverDetection(2), WithClock(clock))
+			Expect(err).ToNot(HaveOccurred())
+
+			// sqlmock cannot reopen connections once they are closed
+			rl.failover.flushConns = func() {}
+		})
+
+		It("rejects a negative idle connection count", func() {
+			db, _, _ := setupMocks()
+
+			_, err := New(db, WithFailoverDetection(-1))
+			Expect(err).To(HaveOccurred())
+		})
+
+		expectRevalidation := func(name, currentOwner string) {
+			mock.ExpectQuery(`SELECT \* FROM`).WithArgs(name).WillReturnRows(
+				sqlmock.NewRows(lockEntryColumns).AddRow(1, name, currentOwner, []byte{1}, "", time.Now(), time.Now()))
+		}
+
+		expectFetchError := func(name string) {
+			mock.ExpectQuery(`SELECT \* FROM`).WithArgs(name).WillReturnError(fmt.Errorf("connection refused"))
+		}
+
+		// handled returns whether the failover is done being handled
+		handled := func() bool {
+			rl.failover.mu.Lock()
+			defer rl.failover.mu.Unlock()
+
+			return !rl.failover.handling
+		}
+
+		holdAndFailover := func(name string, expectRevalidations func()) {
+			mock.ExpectExec("INSERT INTO").WithArgs(name, rl.owner, rl.host, rl.pid).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO").WithArgs("other", rl.owner, rl.host, rl.pid).WillReturnError(&mysql.MySQLError{Number: 1290, Message: "read only"})
+			expectRevalidations()
+
+			_, err := rl.Lock(name, 0)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = rl.Lock("other", 0)
+			Expect(err).To(HaveOccurred())
+		}
+
+		It("reports locks that did not survive the failover", func() {
+			events, cancel := rl.Subscribe(10)
+			defer cancel()
+
+			holdAndFailover("lost", func() { expectRevalidation("lost", "someone-else") })
+
+			var event *Event
+
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(EventAcquired))
+
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(EventTopologyChanged))
+
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(EventLockLost))
+			Expect(event.Name).To(Equal("lost"))
+
+			Expect(rl.heldLocks()).To(BeEmpty())
+		})
+
+		It("keeps locks that are still ours", func() {
+			holdAndFailover("kept", func() { expectRevalidation("kept", rl.owner) })
+
+			Eventually(handled).Should(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(rl.heldLocks()).To(HaveLen(1))
+		})
+
+		It("retries locks that cannot be fetched during the failover", func() {
+			events, cancel := rl.Subscribe(10)
+			defer cancel()
+
+			holdAndFailover("kept", func() {
+				expectFetchError("kept")
+				expectRevalidation("kept", rl.owner)
+			})
+
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(failoverRevalidateBackoff)
+
+			Eventually(handled).Should(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(rl.heldLocks()).To(HaveLen(1))
+
+			for len(events) > 0 {
+				Expect((<-events).Type).ToNot(Equal(EventLockLost))
+			}
+		})
+
+		It("keeps locks it cannot tell were lost", func() {
+			holdAndFailover("kept", func() {
+				for i := 0; i < failoverRevalidateAttempts; i++ {
+					expectFetchError("kept")
+				}
+			})
+
+			for i := 1; i < failoverRevalidateAttempts; i++ {
+				Eventually(clock.Waiters).Should(Equal(1))
+				clock.Advance(failoverRevalidateBackoff << uint(i-1))
+			}
+
+			Eventually(handled).Should(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(rl.heldLocks()).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,65 @@
+package rlock
+
+import "fmt"
+
+// Token returns the lock's fencing token: the lock's acquire count as of our
+// acquisition, which grows with every acquisition of the lock (for as long as
+// its row exists; see Purge()). Pass it along with writes to storage that
+// rejects tokens older than the newest it has seen, so that a holder whose
+// lock was taken over cannot clobber the new holder's writes. Locks acquired
+// via proxy have no token (0), nor do locks whose hold window has ended (see
+// LockFor()).
+func (l *Lock) Token() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.expired {
+		return 0
+	}
+
+	return l.token
+}
+
+// UnlockFenced is Unlock() conditioned on the fencing token (see Token()):
+// if the lock was acquired again since we acquired it (ie. it was reaped and
+// then acquired by another goroutine using the same RLock, which Unlock()
+// cannot tell apart from our own hold), the new hold is left alone and
+// LockLostErr is returned.
+func (l *Lock) UnlockFenced(lastError error) error {
+	if l.token == 0 {
+		return fmt.Errorf("lock '%v' has no fencing token", l.name)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlocked {
+		return AlreadyUnlockedErr
+	}
+
+	err := l.unlock(lastError, true)
+
+	if err == nil || err == LockLostErr {
+		l.unlocked = true
+		l.stopHeartbeat()
+		l.rl.forget(l)
+	}
+
+	return err
+}
+
+// RefreshFenced is Refresh() conditioned on the fencing token (see Token()):
+// returns LockLostErr rather than refreshing someone else's hold if the lock
+// was acquired again since we acquired it.
+func (l *Lock) RefreshFenced() error {
+	if l.token == 0 {
+		return fmt.Errorf("lock '%v' has no fencing token", l.name)
+	}
+
+	err := l.refresh(true)
+	if err == LockLostErr {
+		l.rl.lockLost(l, err)
+	}
+
+	return err
+}
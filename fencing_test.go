@@ -0,0 +1,75 @@
+package rlock
+
+import (
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Fencing", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	It("hands out a token of 1 for fresh locks", func() {
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(42, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Token()).To(Equal(int64(1)))
+	})
+
+	It("hands out the new acquire count when taking locks over", func() {
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{0}, "", time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET .*acquire_count=LAST_INSERT_ID\(acquire_count\+1\)`).
+			WillReturnResult(sqlmock.NewResult(7, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Token()).To(Equal(int64(7)))
+	})
+
+	It("only unlocks the hold the token belongs to", func() {
+		l := rl.newLock("foo", time.Minute, 7)
+
+		mock.ExpectExec(`UPDATE rlock SET in_use=0, last_error=\? WHERE name=\? AND owner=\? AND acquire_count=\?`).
+			WithArgs("", "foo", rl.owner, 7).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(l.UnlockFenced(nil)).To(Equal(LockLostErr))
+		Expect(l.Unlock(nil)).To(Equal(AlreadyUnlockedErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("only refreshes the hold the token belongs to", func() {
+		l := rl.newLock("foo", time.Minute, 7)
+
+		mock.ExpectExec(`UPDATE rlock SET last_used=NOW\(\) WHERE name=\? AND owner=\? AND in_use=1 AND acquire_count=\?`).
+			WithArgs("foo", rl.owner, 7).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "acquire_count"}).AddRow(1, "foo", rl.owner, []byte{1}, 8))
+
+		Expect(l.RefreshFenced()).To(Equal(LockLostErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("requires a token", func() {
+		l := rl.newLock("foo", time.Minute, 0)
+
+		Expect(l.UnlockFenced(nil)).ToNot(Succeed())
+		Expect(l.RefreshFenced()).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,139 @@
+package rlock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// gates make sure that only one goroutine per lock name (per RLock) talks to
+// the DB at a time; other goroutines acquiring the same lock wait in-process
+// instead of each polling the DB independently.
+type gates struct {
+	mu sync.Mutex
+	m  map[string]*gate
+}
+
+type gate struct {
+	ch   chan struct{}
+	refs int
+}
+
+// enter blocks until the gate for name is free, timeout is reached (in which
+// case AcquireTimeoutErr is returned) or ctx is done; a negative timeout
+// waits forever. On success, it returns a func releasing the gate and how
+// much of timeout is left.
+func (g *gates) enter(ctx context.Context, name string, timeout time.Duration, clock Clock) (func(), time.Duration, error) {
+	g.mu.Lock()
+
+	if g.m == nil {
+		g.m = make(map[string]*gate)
+	}
+
+	entry, ok := g.m[name]
+	if !ok {
+		entry = &gate{ch: make(chan struct{}, 1)}
+		g.m[name] = entry
+	}
+
+	entry.refs++
+
+	g.mu.Unlock()
+
+	release := func() {
+		<-entry.ch
+		g.unref(name, entry)
+	}
+
+	// Fast path: nobody else in this process is acquiring the lock
+	select {
+	case entry.ch <- struct{}{}:
+		return release, timeout, nil
+	default:
+	}
+
+	if timeout < 0 {
+		select {
+		case entry.ch <- struct{}{}:
+			return release, timeout, nil
+		case <-ctx.Done():
+			g.unref(name, entry)
+			return nil, 0, ctx.Err()
+		}
+	}
+
+	start := clock.Now()
+
+	timer := clock.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case entry.ch <- struct{}{}:
+		remaining := timeout - clock.Now().Sub(start)
+		if remaining < 0 {
+			// Out of time, but a negative timeout would mean waiting forever
+			remaining = 0
+		}
+
+		return release, remaining, nil
+	case <-timer.C():
+		g.unref(name, entry)
+		return nil, 0, AcquireTimeoutErr
+	case <-ctx.Done():
+		g.unref(name, entry)
+		return nil, 0, ctx.Err()
+	}
+}
+
+// tryEnter enters the gate for name if it is free, returning a func releasing
+// it, or nil if it is not.
+func (g *gates) tryEnter(name string) func() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.m == nil {
+		g.m = make(map[string]*gate)
+	}
+
+	entry, ok := g.m[name]
+	if !ok {
+		entry = &gate{ch: make(chan struct{}, 1)}
+		g.m[name] = entry
+	}
+
+	select {
+	case entry.ch <- struct{}{}:
+	default:
+		return nil
+	}
+
+	entry.refs++
+
+	return func() {
+		<-entry.ch
+		g.unref(name, entry)
+	}
+}
+
+func (g *gates) unref(name string, entry *gate) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	entry.refs--
+
+	if entry.refs == 0 {
+		delete(g.m, name)
+	}
+}
+
+// WithLocalMutex enables hybrid mode: on top of the DB lock, a lock is also
+// held in-process until it is unlocked. Goroutines in this process contending
+// for a lock held by a sibling wait on the in-process mutex and do not touch
+// the DB at all; only the local winner talks to the database. Useful when
+// most of the contention is between goroutines of the same process.
+func WithLocalMutex() Option {
+	return func(r *RLock) error {
+		r.localMutex = true
+		return nil
+	}
+}
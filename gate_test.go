@@ -0,0 +1,179 @@
+package rlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("gates", func() {
+	var g *gates
+
+	BeforeEach(func() {
+		g = &gates{}
+	})
+
+	It("lets the first caller through immediately with the full timeout", func() {
+		release, remaining, err := g.enter(context.Background(), "foo", time.Second, realClock{})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal(time.Second))
+
+		release()
+
+		Expect(g.m).To(BeEmpty())
+	})
+
+	It("does not block callers for other lock names", func() {
+		release, _, err := g.enter(context.Background(), "foo", time.Second, realClock{})
+		Expect(err).ToNot(HaveOccurred())
+		defer release()
+
+		releaseBar, _, err := g.enter(context.Background(), "bar", 0, realClock{})
+		Expect(err).ToNot(HaveOccurred())
+		releaseBar()
+	})
+
+	It("makes a second caller for the same name wait for the first", func() {
+		release, _, err := g.enter(context.Background(), "foo", time.Second, realClock{})
+		Expect(err).ToNot(HaveOccurred())
+
+		entered := make(chan time.Duration, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			releaseSecond, remaining, err := g.enter(context.Background(), "foo", time.Minute, realClock{})
+			Expect(err).ToNot(HaveOccurred())
+
+			entered <- remaining
+
+			releaseSecond()
+		}()
+
+		Consistently(entered, 100*time.Millisecond).ShouldNot(Receive())
+
+		release()
+
+		var remaining time.Duration
+		Eventually(entered).Should(Receive(&remaining))
+		Expect(remaining).To(BeNumerically("<", time.Minute))
+
+		Eventually(func() int {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+
+			return len(g.m)
+		}).Should(Equal(0))
+	})
+
+	It("waits for the gate without a deadline when timeout is negative", func() {
+		release, _, err := g.enter(context.Background(), "foo", time.Second, realClock{})
+		Expect(err).ToNot(HaveOccurred())
+
+		entered := make(chan time.Duration, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			releaseSecond, remaining, err := g.enter(context.Background(), "foo", WaitForever, realClock{})
+			Expect(err).ToNot(HaveOccurred())
+
+			entered <- remaining
+
+			releaseSecond()
+		}()
+
+		Consistently(entered, 100*time.Millisecond).ShouldNot(Receive())
+
+		release()
+
+		Eventually(entered).Should(Receive(Equal(WaitForever)))
+	})
+
+	It("returns AcquireTimeoutErr when the gate is not freed in time", func() {
+		release, _, err := g.enter(context.Background(), "foo", time.Second, realClock{})
+		Expect(err).ToNot(HaveOccurred())
+		defer release()
+
+		_, _, err = g.enter(context.Background(), "foo", 10*time.Millisecond, realClock{})
+		Expect(err).To(Equal(AcquireTimeoutErr))
+
+		Expect(g.m["foo"].refs).To(Equal(1))
+	})
+
+	It("gives up waiting once the context is done", func() {
+		release, _, err := g.enter(context.Background(), "foo", time.Second, realClock{})
+		Expect(err).ToNot(HaveOccurred())
+		defer release()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, _, err = g.enter(ctx, "foo", WaitForever, realClock{})
+		Expect(err).To(Equal(context.Canceled))
+
+		Expect(g.m["foo"].refs).To(Equal(1))
+	})
+})
+
+var _ = Describe("WithLocalMutex", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithLocalMutex())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("makes siblings wait in-process without touching the DB", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		// No DB expectations; any query would fail with an unexpected error
+		_, err = rl.Lock("foo", 10*time.Millisecond)
+		Expect(err).To(Equal(AcquireTimeoutErr))
+
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+		Expect(l.Unlock(nil)).To(Succeed())
+
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err = rl.Lock("foo", 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("frees the local mutex even if the DB unlock fails", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		Expect(l.Unlock(nil)).ToNot(Succeed())
+
+		Expect(rl.gates.m).To(BeEmpty())
+	})
+
+	It("frees the local mutex when the DB lock cannot be acquired", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(sqlmock.ErrCancelled)
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).To(HaveOccurred())
+
+		Expect(rl.gates.m).To(BeEmpty())
+	})
+})
@@ -0,0 +1,96 @@
+package rlock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FenceColumn is the column tables guarded by GuardExec() keep the fencing
+// token (see Lock.Token()) of their last write in; see FenceColumnDDL().
+const FenceColumn = "fence_token"
+
+// Execer is implemented by *sql.DB, *sql.Tx, *sqlx.DB and *sqlx.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+var (
+	updateSet   = regexp.MustCompile(`(?is)^\s*UPDATE\s.+?\sSET\s`)
+	updateWhere = regexp.MustCompile(`(?i)\sWHERE\s`)
+)
+
+// FenceColumnDDL returns the MySQL DDL adding FenceColumn to table.
+func FenceColumnDDL(table string) string {
+	return fmt.Sprintf("ALTER TABLE `%v` ADD COLUMN `%v` BIGINT NOT NULL DEFAULT 0", table, FenceColumn)
+}
+
+// GuardExec runs the UPDATE statement query (with args) against rows whose
+// FenceColumn is not newer than token only, recording token along with the
+// write; a holder whose lock was taken over (and whose successor wrote with
+// its newer token) can no longer clobber the successor's writes:
+//
+//	UPDATE jobs SET state=? WHERE id=?
+//
+// runs as
+//
+//	UPDATE jobs SET state=?, fence_token=? WHERE (id=?) AND fence_token <= ?
+//
+// query must end with its WHERE clause (no ORDER BY or LIMIT) and must not
+// contain '?' other than placeholders. Rows that were skipped for having a
+// newer token do not count as affected; the result does not tell them apart
+// from rows the WHERE clause did not match.
+func GuardExec(ctx context.Context, db Execer, token int64, query string, args ...interface{}) (sql.Result, error) {
+	guarded, args, err := guardQuery(token, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.ExecContext(ctx, guarded, args...)
+}
+
+// guardQuery rewrites an UPDATE statement (and its args) as described in
+// GuardExec().
+func guardQuery(token int64, query string, args []interface{}) (string, []interface{}, error) {
+	if token <= 0 {
+		return "", nil, fmt.Errorf("fencing token must be positive")
+	}
+
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+
+	if !updateSet.MatchString(query) {
+		return "", nil, fmt.Errorf("only UPDATE statements can be guarded")
+	}
+
+	// The statement's own WHERE, not one of a subquery
+	var where []int
+
+	for _, w := range updateWhere.FindAllStringIndex(query, -1) {
+		if strings.Count(query[:w[0]], "(") == strings.Count(query[:w[0]], ")") {
+			where = w
+			break
+		}
+	}
+
+	if where == nil {
+		return "", nil, fmt.Errorf("guarded statements need a WHERE clause")
+	}
+
+	setArgs := strings.Count(query[:where[0]], "?")
+
+	if setArgs > len(args) {
+		return "", nil, fmt.Errorf("statement has more placeholders than args")
+	}
+
+	guarded := fmt.Sprintf("%v, %v=? WHERE (%v) AND %v <= ?", query[:where[0]], FenceColumn, query[where[1]:], FenceColumn)
+
+	guardedArgs := make([]interface{}, 0, len(args)+2)
+	guardedArgs = append(guardedArgs, args[:setArgs]...)
+	guardedArgs = append(guardedArgs, token)
+	guardedArgs = append(guardedArgs, args[setArgs:]...)
+	guardedArgs = append(guardedArgs, token)
+
+	return guarded, guardedArgs, nil
+}
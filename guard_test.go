@@ -0,0 +1,52 @@
+package rlock
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("GuardExec", func() {
+	It("makes updates conditional on the fencing token", func() {
+		db, mock, _ := setupMocks()
+
+		mock.ExpectExec(`UPDATE jobs SET state=\?, fence_token=\? WHERE \(id=\? OR parent=\?\) AND fence_token <= \?`).
+			WithArgs("done", 7, 1, 2, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := GuardExec(context.Background(), db, 7, "UPDATE jobs SET state=? WHERE id=? OR parent=?;", "done", 1, 2)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("guards the statement's own WHERE clause", func() {
+		query, args, err := guardQuery(7, "UPDATE jobs SET n=(SELECT COUNT(*) FROM t WHERE t.job=?) WHERE id IN (SELECT id FROM q WHERE q.x=?)", []interface{}{1, 2})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(query).To(Equal("UPDATE jobs SET n=(SELECT COUNT(*) FROM t WHERE t.job=?), fence_token=? WHERE (id IN (SELECT id FROM q WHERE q.x=?)) AND fence_token <= ?"))
+		Expect(args).To(Equal([]interface{}{1, int64(7), 2, int64(7)}))
+	})
+
+	It("rejects statements it cannot guard", func() {
+		for _, query := range []string{
+			"INSERT INTO jobs (state) VALUES (?)",
+			"UPDATE jobs SET state=?",
+		} {
+			_, _, err := guardQuery(7, query, []interface{}{"done"})
+			Expect(err).To(HaveOccurred())
+		}
+
+		_, _, err := guardQuery(0, "UPDATE jobs SET state=? WHERE id=?", []interface{}{"done", 1})
+		Expect(err).To(HaveOccurred())
+
+		_, _, err = guardQuery(7, "UPDATE jobs SET state=?, other=? WHERE id=?", []interface{}{"done"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns the DDL of the fence column", func() {
+		Expect(FenceColumnDDL("jobs")).To(Equal("ALTER TABLE `jobs` ADD COLUMN `fence_token` BIGINT NOT NULL DEFAULT 0"))
+	})
+})
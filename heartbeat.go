@@ -0,0 +1,179 @@
+package rlock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// HeartbeatPolicy decides how Lock.Heartbeat() copes with failing refreshes;
+// see WithHeartbeatPolicy.
+type HeartbeatPolicy struct {
+	// How many consecutive refreshes may fail before the lock is considered
+	// lost (see WithFailFast)
+	MaxFailures int
+
+	// How long to wait before retrying a failed refresh
+	RetryInterval time.Duration
+
+	// Called (if set) for every failed refresh with the number of
+	// consecutive failures so far
+	OnHeartbeatFailure func(name string, failures int, err error)
+}
+
+// DefaultHeartbeatPolicy tolerates a couple of failed refreshes, ie. while
+// the DB fails over.
+var DefaultHeartbeatPolicy = HeartbeatPolicy{
+	MaxFailures:   3,
+	RetryInterval: time.Second,
+}
+
+// WithHeartbeatPolicy overrides how heartbeats (see Lock.Heartbeat()) cope
+// with failing refreshes (defaults to DefaultHeartbeatPolicy), so that a
+// flaky network does not make holders give up locks they still hold.
+func WithHeartbeatPolicy(policy HeartbeatPolicy) Option {
+	return func(r *RLock) error {
+		if policy.MaxFailures <= 0 {
+			return fmt.Errorf("max heartbeat failures must be positive")
+		}
+
+		if policy.RetryInterval <= 0 {
+			return fmt.Errorf("heartbeat retry interval must be positive")
+		}
+
+		r.heartbeat = policy
+
+		return nil
+	}
+}
+
+// Heartbeat refreshes the lock (see Refresh()) every interval in the
+// background until the returned function is called or the lock is unlocked.
+// Once more consecutive refreshes than the heartbeat policy tolerates fail
+// (see WithHeartbeatPolicy), or as soon as one finds the lock owned by someone
+// else or no longer in use (LockLostErr), the lock is considered lost:
+// EventLockLost is emitted, the fail-fast handler (see WithFailFast) is called
+// and the heartbeat stops.
+//
+// Locks acquired via proxy (see Client) are kept alive by renewing their
+// lease already; for them Heartbeat does nothing.
+func (l *Lock) Heartbeat(interval time.Duration) (stop func()) {
+	if l.client != nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	once := &sync.Once{}
+
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+
+	if l.rl.batchedHeartbeats && l.session == nil {
+		l.rl.joinHeartbeatBatch(l, interval, done)
+	} else {
+		go l.heartbeat(interval, done)
+	}
+
+	l.mu.Lock()
+	l.stopHeartbeats = append(l.stopHeartbeats, stop)
+	l.heartbeats.running++
+	l.mu.Unlock()
+
+	return stop
+}
+
+// stopHeartbeat stops the lock's heartbeats; l.mu must be held.
+func (l *Lock) stopHeartbeat() {
+	for _, stop := range l.stopHeartbeats {
+		stop()
+	}
+
+	l.stopHeartbeats = nil
+}
+
+func (l *Lock) heartbeat(interval time.Duration, done <-chan struct{}) {
+	policy := l.rl.heartbeat
+	failures := 0
+	wait := interval
+
+	defer l.heartbeatStopped()
+
+	for {
+		timer := l.rl.clock.NewTimer(wait)
+
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		err := l.refresh(false)
+
+		switch {
+		case err == nil:
+			failures = 0
+			wait = interval
+
+			l.beatSucceeded()
+
+			continue
+		case err == AlreadyUnlockedErr || err == LockExpiredErr:
+			return
+		}
+
+		failures++
+
+		if l.beatFailed(failures, err) {
+			return
+		}
+
+		wait = policy.RetryInterval
+	}
+}
+
+// beatSucceeded records a successful heartbeat.
+func (l *Lock) beatSucceeded() {
+	l.mu.Lock()
+	l.heartbeats.lastBeat = l.rl.clock.Now()
+	l.heartbeats.failures = 0
+	l.mu.Unlock()
+}
+
+// beatFailed records the failures-th consecutive failed heartbeat, returning
+// whether the lock is now considered lost (see WithHeartbeatPolicy).
+func (l *Lock) beatFailed(failures int, err error) bool {
+	policy := l.rl.heartbeat
+
+	l.mu.Lock()
+	l.heartbeats.failures = failures
+	l.heartbeats.lastErr = err.Error()
+	l.mu.Unlock()
+
+	withError(l.rl.logFor(l.name), err).WithFields(golog.Fields{"failures": failures, "max_failures": policy.MaxFailures}).
+		Warn("heartbeat failed")
+
+	if policy.OnHeartbeatFailure != nil {
+		policy.OnHeartbeatFailure(l.name, failures, err)
+	}
+
+	// LockLostErr means the lock is someone else's by now, which retrying
+	// cannot change
+	if failures < policy.MaxFailures && err != LockLostErr {
+		return false
+	}
+
+	l.rl.lockLost(l, err)
+
+	return true
+}
+
+// heartbeatStopped records that one of the lock's heartbeats stopped.
+func (l *Lock) heartbeatStopped() {
+	l.mu.Lock()
+	l.heartbeats.running--
+	l.mu.Unlock()
+}
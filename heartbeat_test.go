@@ -0,0 +1,181 @@
+package rlock
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Heartbeat", func() {
+	var (
+		mock     sqlmock.Sqlmock
+		rl       *RLock
+		clock    *FakeClock
+		mu       sync.Mutex
+		lost     []string
+		failures []int
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		clock = NewFakeClock(time.Now())
+		lost = nil
+		failures = nil
+
+		policy := HeartbeatPolicy{
+			MaxFailures:   2,
+			RetryInterval: time.Second,
+			OnHeartbeatFailure: func(name string, n int, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				failures = append(failures, n)
+			},
+		}
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock), WithHeartbeatPolicy(policy),
+			WithFailFast(func(name string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				lost = append(lost, name)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	tick := func(d time.Duration) {
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(d)
+	}
+
+	lostLocks := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), lost...)
+	}
+
+	It("rejects invalid policies", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithHeartbeatPolicy(HeartbeatPolicy{RetryInterval: time.Second}))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithHeartbeatPolicy(HeartbeatPolicy{MaxFailures: 1}))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refreshes the lock every interval", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		stop := l.Heartbeat(10 * time.Second)
+
+		tick(10 * time.Second)
+		tick(10 * time.Second)
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		stop()
+
+		Eventually(clock.Waiters).Should(Equal(0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("tolerates failures up to the policy's limit", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnError(errors.New("connection reset"))
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnError(errors.New("connection reset"))
+
+		stop := l.Heartbeat(10 * time.Second)
+		defer stop()
+
+		tick(10 * time.Second)
+		tick(time.Second)
+		tick(10 * time.Second)
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		Expect(lostLocks()).To(BeEmpty())
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		Expect(failures).To(Equal([]int{1, 1}))
+	})
+
+	It("considers the lock lost after too many consecutive failures", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnError(errors.New("connection reset"))
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnError(errors.New("connection reset"))
+
+		l.Heartbeat(10 * time.Second)
+
+		tick(10 * time.Second)
+		tick(time.Second)
+
+		Eventually(lostLocks).Should(Equal([]string{"foo"}))
+		Consistently(clock.Waiters).Should(Equal(0))
+	})
+
+	It("considers the lock lost right away once it is someone else's", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(1, "foo", "someone-else", []byte{1}, "", clock.Now(), clock.Now()))
+
+		l.Heartbeat(10 * time.Second)
+
+		tick(10 * time.Second)
+
+		Eventually(lostLocks).Should(Equal([]string{"foo"}))
+		Consistently(clock.Waiters).Should(Equal(0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		Expect(failures).To(Equal([]int{1}))
+	})
+
+	It("retries refreshes that cannot tell whether the lock is still ours", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WillReturnError(errors.New("connection reset"))
+
+		stop := l.Heartbeat(10 * time.Second)
+		defer stop()
+
+		tick(10 * time.Second)
+
+		// Waiting to retry
+		Eventually(clock.Waiters).Should(Equal(1))
+		Expect(lostLocks()).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("stops once the lock is unlocked", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		l.Heartbeat(10 * time.Second)
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(l.Unlock(nil)).To(Succeed())
+		Eventually(clock.Waiters).Should(Equal(0))
+	})
+})
@@ -0,0 +1,247 @@
+package rlock
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithBatchedHeartbeats makes heartbeats (see Lock.Heartbeat()) sharing an
+// interval refresh all their locks with a single statement per lock table
+// every interval, rather than one statement per lock, which adds up for
+// processes holding dozens of locks. When fewer rows than locks are
+// refreshed, the locks still held are looked up to tell which refreshes
+// failed; those count towards the heartbeat policy (see
+// WithHeartbeatPolicy) like failed refreshes of unbatched heartbeats, but
+// are retried at the next tick rather than after the policy's retry
+// interval. Session locks (see WithSessionLocks) keep heartbeating one by
+// one.
+func WithBatchedHeartbeats() Option {
+	return func(r *RLock) error {
+		r.batchedHeartbeats = true
+		return nil
+	}
+}
+
+type heartbeatBatches struct {
+	mu sync.Mutex
+	m  map[time.Duration]*heartbeatBatch
+}
+
+// heartbeatBatch refreshes the locks of every heartbeat with its interval;
+// members is guarded by heartbeatBatches.mu.
+type heartbeatBatch struct {
+	interval time.Duration
+	members  map[*batchMember]struct{}
+}
+
+type batchMember struct {
+	l        *Lock
+	done     <-chan struct{}
+	failures int
+}
+
+// joinHeartbeatBatch refreshes l every interval, along with the locks of
+// other heartbeats with the same interval, until done is closed.
+func (r *RLock) joinHeartbeatBatch(l *Lock, interval time.Duration, done <-chan struct{}) {
+	r.beats.mu.Lock()
+	defer r.beats.mu.Unlock()
+
+	if r.beats.m == nil {
+		r.beats.m = make(map[time.Duration]*heartbeatBatch)
+	}
+
+	b, ok := r.beats.m[interval]
+	if !ok {
+		b = &heartbeatBatch{interval: interval, members: make(map[*batchMember]struct{})}
+		r.beats.m[interval] = b
+
+		go r.runHeartbeatBatch(b)
+	}
+
+	b.members[&batchMember{l: l, done: done}] = struct{}{}
+}
+
+func (r *RLock) runHeartbeatBatch(b *heartbeatBatch) {
+	for {
+		timer := r.clock.NewTimer(b.interval)
+		<-timer.C()
+
+		members, stopped := r.heartbeatMembers(b)
+
+		for _, m := range stopped {
+			m.l.heartbeatStopped()
+		}
+
+		if members == nil {
+			return
+		}
+
+		for _, m := range r.beatBatch(members) {
+			r.leaveHeartbeatBatch(b, m)
+			m.l.heartbeatStopped()
+		}
+	}
+}
+
+// heartbeatMembers returns the members of b to refresh and removes (and
+// returns) those whose heartbeat was stopped; members is nil once b is empty,
+// in which case b is gone.
+func (r *RLock) heartbeatMembers(b *heartbeatBatch) ([]*batchMember, []*batchMember) {
+	r.beats.mu.Lock()
+	defer r.beats.mu.Unlock()
+
+	members := make([]*batchMember, 0, len(b.members))
+	stopped := make([]*batchMember, 0)
+
+	for m := range b.members {
+		select {
+		case <-m.done:
+			delete(b.members, m)
+			stopped = append(stopped, m)
+		default:
+			members = append(members, m)
+		}
+	}
+
+	if len(members) == 0 {
+		delete(r.beats.m, b.interval)
+		return nil, stopped
+	}
+
+	return members, stopped
+}
+
+// isUnlocked returns whether the lock was unlocked (or its hold window ended;
+// see LockFor()).
+func (l *Lock) isUnlocked() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.unlocked || l.expired
+}
+
+func (r *RLock) leaveHeartbeatBatch(b *heartbeatBatch, m *batchMember) {
+	r.beats.mu.Lock()
+	delete(b.members, m)
+	r.beats.mu.Unlock()
+}
+
+// beatBatch refreshes the locks of members, returning the members whose lock
+// is gone (unlocked or lost).
+func (r *RLock) beatBatch(members []*batchMember) []*batchMember {
+	byName := make(map[string][]*batchMember, len(members))
+	gone := make([]*batchMember, 0)
+
+	for _, m := range members {
+		if m.l.isUnlocked() {
+			gone = append(gone, m)
+			continue
+		}
+
+		byName[m.l.name] = append(byName[m.l.name], m)
+	}
+
+	names := make([]string, 0, len(byName))
+
+	for name := range byName {
+		names = append(names, name)
+	}
+
+	for table, names := range r.byTable(names) {
+		failed := r.refreshBatch(table, names)
+
+		for _, name := range names {
+			err, ok := failed[name]
+
+			for _, m := range byName[name] {
+				if !ok {
+					m.failures = 0
+					m.l.beatSucceeded()
+
+					continue
+				}
+
+				// Unlocked while we were refreshing it
+				if m.l.isUnlocked() {
+					gone = append(gone, m)
+					continue
+				}
+
+				m.failures++
+
+				if m.l.beatFailed(m.failures, err) {
+					gone = append(gone, m)
+				}
+			}
+		}
+	}
+
+	return gone
+}
+
+// refreshBatch refreshes the locks called names in table, returning why the
+// refreshes that failed did.
+func (r *RLock) refreshBatch(table string, names []string) map[string]error {
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+
+	args := []interface{}{r.owner}
+	for _, name := range names {
+		args = append(args, name)
+	}
+
+	failed := make(map[string]error)
+
+	failAll := func(err error) map[string]error {
+		for _, name := range names {
+			failed[name] = err
+		}
+
+		return failed
+	}
+
+	query := fmt.Sprintf("UPDATE %v SET last_used=NOW() WHERE owner=? AND in_use=1 AND name IN (%v)", table, in)
+
+	result, err := r.exec(query, args...)
+	if err != nil {
+		r.observeError(err)
+		return failAll(fmt.Errorf("unable to refresh: %v", err))
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return failAll(fmt.Errorf("unable to determine affected rows after refresh: %v", err))
+	}
+
+	r.mutated(names...)
+
+	if affected == int64(len(names)) {
+		return failed
+	}
+
+	// MySQL does not count rows whose values did not change (ie. when
+	// refreshing twice within a second); find out which locks are gone
+	held := make([]string, 0, len(names))
+
+	query = fmt.Sprintf("SELECT name FROM %v WHERE owner=? AND in_use=1 AND name IN (%v)", table, in)
+
+	if err := r.selectAll(&held, query, args...); err != nil {
+		r.observeError(err)
+		return failAll(fmt.Errorf("unable to determine refreshed locks: %v", err))
+	}
+
+	still := make(map[string]bool, len(held))
+
+	for _, name := range held {
+		still[name] = true
+	}
+
+	for _, name := range names {
+		if !still[name] {
+			failed[name] = LockLostErr
+		}
+	}
+
+	return failed
+}
@@ -0,0 +1,128 @@
+package rlock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Batched heartbeats", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		rl    *RLock
+		clock *FakeClock
+		mu    sync.Mutex
+		lost  []string
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		clock = NewFakeClock(time.Now())
+		lost = nil
+
+		policy := HeartbeatPolicy{MaxFailures: 1, RetryInterval: time.Second}
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock), WithBatchedHeartbeats(),
+			WithHeartbeatPolicy(policy), WithFailFast(func(name string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				lost = append(lost, name)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	tick := func(d time.Duration) {
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(d)
+	}
+
+	lostLocks := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), lost...)
+	}
+
+	batchUpdate := `UPDATE rlock SET last_used=NOW\(\) WHERE owner=\? AND in_use=1 AND name IN \(\?, \?\)`
+
+	It("refreshes every lock with a single statement", func() {
+		foo := rl.newLock("foo", time.Minute, 1)
+		bar := rl.newLock("bar", time.Minute, 1)
+
+		mock.ExpectExec(batchUpdate).WithArgs(rl.owner, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		stopFoo := foo.Heartbeat(10 * time.Second)
+		stopBar := bar.Heartbeat(10 * time.Second)
+
+		tick(10 * time.Second)
+		Eventually(clock.Waiters).Should(Equal(1))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+
+		stopFoo()
+		stopBar()
+
+		tick(10 * time.Second)
+		Eventually(clock.Waiters).Should(Equal(0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("finds out which locks are gone when fewer rows are refreshed", func() {
+		foo := rl.newLock("foo", time.Minute, 1)
+		bar := rl.newLock("bar", time.Minute, 1)
+
+		mock.ExpectExec(batchUpdate).WithArgs(rl.owner, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT name FROM rlock WHERE owner=\? AND in_use=1 AND name IN \(\?, \?\)`).
+			WithArgs(rl.owner, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("bar"))
+
+		foo.Heartbeat(10 * time.Second)
+		stop := bar.Heartbeat(10 * time.Second)
+		defer stop()
+
+		tick(10 * time.Second)
+
+		Eventually(lostLocks).Should(Equal([]string{"foo"}))
+		Eventually(clock.Waiters).Should(Equal(1))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+
+		// bar keeps heartbeating on its own
+		mock.ExpectExec(`UPDATE rlock SET last_used=NOW\(\) WHERE owner=\? AND in_use=1 AND name IN \(\?\)`).
+			WithArgs(rl.owner, "bar").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		tick(10 * time.Second)
+		Eventually(clock.Waiters).Should(Equal(1))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("drops locks once they are unlocked", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+
+		l.Heartbeat(10 * time.Second)
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(l.Unlock(nil)).To(Succeed())
+
+		tick(10 * time.Second)
+		Eventually(clock.Waiters).Should(Equal(0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("heartbeats locks with different intervals separately", func() {
+		rl.newLock("foo", time.Minute, 1).Heartbeat(10 * time.Second)
+		rl.newLock("bar", time.Minute, 1).Heartbeat(20 * time.Second)
+
+		Eventually(clock.Waiters).Should(Equal(2))
+	})
+})
@@ -0,0 +1,71 @@
+package rlock
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator generates the owner IDs RLocks acquire locks as (see
+// WithIDGenerator), which must be unique across every process sharing the
+// lock table.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// IDGeneratorFunc adapts a function to the IDGenerator interface.
+type IDGeneratorFunc func() (string, error)
+
+// NewID calls f.
+func (f IDGeneratorFunc) NewID() (string, error) {
+	return f()
+}
+
+// UUIDGenerator generates random (version 4) UUIDs; it is the default
+// IDGenerator.
+var UUIDGenerator IDGenerator = IDGeneratorFunc(func() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+})
+
+// WithIDGenerator generates the RLock's owner ID using gen rather than
+// UUIDGenerator, ie. to use ULIDs or snowflake IDs, or deterministic IDs in
+// tests. IDs are subject to the same rules as those given to WithOwnerID,
+// which takes precedence.
+func WithIDGenerator(gen IDGenerator) Option {
+	return func(r *RLock) error {
+		if gen == nil {
+			return fmt.Errorf("ID generator cannot be nil")
+		}
+
+		r.idGenerator = gen
+
+		return nil
+	}
+}
+
+// generateOwner sets the RLock's owner ID once its options are applied,
+// unless it was given (see WithOwnerID).
+func (r *RLock) generateOwner() error {
+	if r.ownerID != "" {
+		r.owner = r.ownerID
+		return nil
+	}
+
+	owner, err := r.idGenerator.NewID()
+	if err != nil {
+		return fmt.Errorf("unable to generate owner ID: %v", err)
+	}
+
+	if err := validateOwner(owner); err != nil {
+		return fmt.Errorf("invalid generated owner ID: %v", err)
+	}
+
+	r.owner = owner
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package rlock
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WithIDGenerator", func() {
+	It("generates UUIDs by default", func() {
+		db, _, _ := setupMocks()
+
+		a, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		b, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(a.owner).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Expect(a.owner).ToNot(Equal(b.owner))
+	})
+
+	It("uses the given generator", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db, WithIDGenerator(IDGeneratorFunc(func() (string, error) { return "worker-1", nil })))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rl.owner).To(Equal("worker-1"))
+	})
+
+	It("rejects invalid IDs", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithIDGenerator(nil))
+		Expect(err).To(HaveOccurred())
+
+		for _, gen := range []IDGeneratorFunc{
+			func() (string, error) { return "", errors.New("boom") },
+			func() (string, error) { return "", nil },
+			func() (string, error) { return strings.Repeat("a", maxOwnerLength+1), nil },
+		} {
+			_, err := New(db, WithIDGenerator(gen))
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
@@ -0,0 +1,171 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// JobQueue is a lightweight work queue built on locks: every job is a lock
+// row ("<queue>/job/<id>") that workers claim (see Claim()) while working on
+// it. A job whose worker goes away is re-delivered once it has not been used
+// for longer than the queue's visibility timeout; see NewJobQueue().
+type JobQueue struct {
+	rl         *RLock
+	name       string
+	visibility time.Duration
+}
+
+// Job is a job claimed from a JobQueue. Exactly one of Complete() or Fail()
+// should be called once the work is done.
+type Job struct {
+	queue     *JobQueue
+	lock      *Lock
+	id        string
+	attempts  int64
+	lastError string
+}
+
+// NewJobQueue returns the queue called name. Claimed jobs are re-delivered
+// to other workers if their worker does not Extend() them within visibility.
+func (r *RLock) NewJobQueue(name string, visibility time.Duration) (*JobQueue, error) {
+	name = r.normalizeName(name)
+
+	if name == "" {
+		return nil, fmt.Errorf("queue name cannot be empty")
+	}
+
+	if visibility <= 0 {
+		return nil, fmt.Errorf("visibility timeout must be positive")
+	}
+
+	return &JobQueue{
+		rl:         r,
+		name:       name,
+		visibility: visibility,
+	}, nil
+}
+
+// Name returns the name of the queue.
+func (q *JobQueue) Name() string {
+	return q.name
+}
+
+// Enqueue adds jobs to the queue. Jobs that are already queued (or being
+// worked on) are left alone.
+func (q *JobQueue) Enqueue(ids ...string) error {
+	names := make([]string, len(ids))
+
+	for i, id := range ids {
+		if id == "" {
+			return fmt.Errorf("job id cannot be empty")
+		}
+
+		names[i] = q.prefix() + id
+	}
+
+	return q.rl.CreateLocks(names...)
+}
+
+// Claim claims the job that has been waiting the longest, returning
+// NothingToClaimErr if there is none. Jobs that failed (see Fail()) or whose
+// visibility timeout expired are delivered again.
+func (q *JobQueue) Claim(ctx context.Context) (*Job, error) {
+	cutoff := q.rl.clock.Now().Add(-q.visibility)
+
+	l, entry, err := q.rl.claim(ctx, "name LIKE ? ESCAPE '!' AND (in_use=0 OR last_used < ?)",
+		escapeLike(q.prefix())+"%", cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{
+		queue:     q,
+		lock:      l,
+		id:        entry.Name[len(q.prefix()):],
+		attempts:  entry.AcquireCount + 1,
+		lastError: entry.LastError,
+	}, nil
+}
+
+func (q *JobQueue) prefix() string {
+	return q.name + "/job/"
+}
+
+// ID returns the id the job was enqueued with.
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Attempts returns how many times the job has been delivered, including this
+// delivery.
+func (j *Job) Attempts() int64 {
+	return j.attempts
+}
+
+// LastError returns the error the job's previous delivery failed with, if
+// any; see Fail().
+func (j *Job) LastError() error {
+	if j.lastError == "" {
+		return nil
+	}
+
+	return errors.New(j.lastError)
+}
+
+// Extend keeps the job from being re-delivered for another visibility
+// timeout. Returns LockLostErr if the job was re-delivered already.
+func (j *Job) Extend() error {
+	return j.lock.Refresh()
+}
+
+// Complete removes the finished job from the queue. Returns LockLostErr if
+// the job was re-delivered (and is now another worker's).
+func (j *Job) Complete() error {
+	l := j.lock
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlocked {
+		return AlreadyUnlockedErr
+	}
+
+	query := fmt.Sprintf("DELETE FROM %v WHERE name=? AND owner=? AND in_use=1", l.rl.tableFor(l.name))
+
+	res, err := l.rl.exec(query, l.name, l.rl.owner)
+	if err != nil {
+		l.rl.observeError(err)
+		return fmt.Errorf("unable to complete job '%v': %v", j.id, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to determine affected rows after completing job '%v': %v", j.id, err)
+	}
+
+	l.unlocked = true
+	l.stopHeartbeat()
+	l.rl.forget(l)
+
+	if affected == 0 {
+		return LockLostErr
+	}
+
+	l.rl.recordHold(l.name, l.rl.clock.Now().Sub(l.acquiredAt))
+	l.rl.auditRelease(l.name, l.rl.owner, ExitUnlocked, "completed")
+	l.rl.emit(EventReleased, l.name, "", "")
+
+	return nil
+}
+
+// Fail records jobErr as the job's last error (see LastError()) and puts the
+// job back in the queue to be delivered again.
+func (j *Job) Fail(jobErr error) error {
+	if jobErr == nil {
+		return fmt.Errorf("job error cannot be nil")
+	}
+
+	return j.lock.Unlock(jobErr)
+}
@@ -0,0 +1,100 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("JobQueue", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		rl    *RLock
+		queue *JobQueue
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+
+		var err error
+
+		queue, err = rl.NewJobQueue("emails", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	claim := func(owner string, inUse byte, lastError string) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name LIKE \? ESCAPE '!' AND \(in_use=0 OR last_used < \?\) ORDER BY last_used LIMIT 1 FOR UPDATE SKIP LOCKED`).
+			WithArgs("emails/job/%", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at", "acquire_count"}).
+				AddRow(1, "emails/job/42", owner, []byte{inUse}, lastError, time.Now().Add(-2*time.Minute), time.Now(), 2))
+		mock.ExpectExec("UPDATE rlock SET owner=").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	It("validates its arguments", func() {
+		_, err := rl.NewJobQueue("", time.Minute)
+		Expect(err).To(HaveOccurred())
+
+		_, err = rl.NewJobQueue("emails", 0)
+		Expect(err).To(HaveOccurred())
+
+		Expect(queue.Enqueue("")).ToNot(Succeed())
+	})
+
+	It("enqueues jobs", func() {
+		mock.ExpectExec(`INSERT IGNORE INTO rlock`).WithArgs("emails/job/1", "emails/job/2").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		Expect(queue.Enqueue("1", "2")).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("claims and completes a job", func() {
+		claim("", 0, "")
+
+		job, err := queue.Claim(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.ID()).To(Equal("42"))
+		Expect(job.Attempts()).To(Equal(int64(3)))
+		Expect(job.LastError()).To(BeNil())
+
+		mock.ExpectExec(`DELETE FROM rlock WHERE name=\? AND owner=\? AND in_use=1`).
+			WithArgs("emails/job/42", rl.owner).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(job.Complete()).To(Succeed())
+		Expect(job.Complete()).To(Equal(AlreadyUnlockedErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("re-delivers failed and expired jobs", func() {
+		claim("worker-1", 1, "smtp unavailable")
+
+		job, err := queue.Claim(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.LastError()).To(MatchError("smtp unavailable"))
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0, last_error=?").
+			WithArgs("still unavailable", "emails/job/42", rl.owner).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(job.Fail(fmt.Errorf("still unavailable"))).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("reports jobs lost to another worker", func() {
+		claim("", 0, "")
+
+		job, err := queue.Claim(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("DELETE FROM rlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(job.Complete()).To(Equal(LockLostErr))
+	})
+})
@@ -0,0 +1,141 @@
+package rlock
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Labels are key/value pairs describing the owner of a lock (ie. its team,
+// service or version); see WithOwnerLabels.
+type Labels map[string]string
+
+// Label restricts ListLocks() and GetLocksByOwner() to locks whose holder
+// was labeled Key=Value.
+type Label struct {
+	Key   string
+	Value string
+}
+
+// Label keys end up in JSON paths; keeping them simple avoids quoting them
+var labelKey = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,63}$`)
+
+// Size of the owner_labels column
+const maxOwnerLabelsLength = 65535
+
+// WithOwnerLabels records labels with every lock row we acquire, so that
+// ListLocks() and GetLocksByOwner() can be filtered by them (ie. for a
+// per-team view of a lock table shared by several teams). Label keys may
+// only contain letters, digits, '_', '.' and '-'. Labels are recorded by
+// instances using this option only; locks last acquired by others keep the
+// labels of their previous holder (if any). The owner_labels column is added
+// by EnsureSchema() (schema version 7) and queried using JSON functions,
+// which require MySQL 5.7+.
+func WithOwnerLabels(labels Labels) Option {
+	return func(r *RLock) error {
+		if len(labels) == 0 {
+			return fmt.Errorf("owner labels cannot be empty")
+		}
+
+		copied := make(Labels, len(labels))
+
+		for k, v := range labels {
+			if !labelKey.MatchString(k) {
+				return fmt.Errorf("invalid owner label key '%v'", k)
+			}
+
+			copied[k] = v
+		}
+
+		encoded, err := json.Marshal(copied)
+		if err != nil {
+			return fmt.Errorf("unable to encode owner labels: %v", err)
+		}
+
+		if len(encoded) > maxOwnerLabelsLength {
+			return fmt.Errorf("owner labels cannot be longer than %d bytes encoded", maxOwnerLabelsLength)
+		}
+
+		r.ownerLabels = copied
+
+		return nil
+	}
+}
+
+// Value implements driver.Valuer; empty labels are stored as NULL.
+func (l Labels) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	encoded, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(encoded), nil
+}
+
+// Scan implements sql.Scanner.
+func (l *Labels) Scan(src interface{}) error {
+	var data []byte
+
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unable to scan %T into labels", src)
+	}
+
+	decoded := make(Labels)
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("unable to decode labels: %v", err)
+	}
+
+	*l = decoded
+
+	return nil
+}
+
+// labelConditions returns the conditions (and their args) restricting a
+// query to locks whose holder was labeled with every label.
+func labelConditions(labels []Label) ([]string, []interface{}, error) {
+	conds := make([]string, 0, len(labels))
+	args := make([]interface{}, 0, 2*len(labels))
+
+	for _, label := range labels {
+		if !labelKey.MatchString(label.Key) {
+			return nil, nil, fmt.Errorf("invalid label key '%v'", label.Key)
+		}
+
+		conds = append(conds, "JSON_UNQUOTE(JSON_EXTRACT(owner_labels, ?))=?")
+		args = append(args, fmt.Sprintf(`$."%v"`, label.Key), label.Value)
+	}
+
+	return conds, args, nil
+}
+
+// whereLabels returns the WHERE clause (and its args) combining conds (whose
+// args are args) with those restricting it to locks labeled with labels.
+func whereLabels(conds []string, args []interface{}, labels []Label) (string, []interface{}, error) {
+	labelConds, labelArgs, err := labelConditions(labels)
+	if err != nil {
+		return "", nil, err
+	}
+
+	conds = append(conds, labelConds...)
+
+	if len(conds) == 0 {
+		return "", args, nil
+	}
+
+	return "WHERE " + strings.Join(conds, " AND ") + " ", append(args, labelArgs...), nil
+}
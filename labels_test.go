@@ -0,0 +1,100 @@
+package rlock
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithOwnerLabels", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithOwnerLabels(Labels{"team": "billing", "service": "invoicer"}))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the labels", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithOwnerLabels(nil))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithOwnerLabels(Labels{`te"am`: "billing"}))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("records the labels with the lock row", func() {
+		mock.ExpectExec(`INSERT INTO rlock \(name, owner, in_use, acquired_at, acquire_count, host, pid, owner_labels\)`).
+			WithArgs("foo", rl.owner, rl.host, rl.pid, `{"service":"invoicer","team":"billing"}`).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("records the labels when taking locks over", func() {
+		mock.ExpectExec(`UPDATE rlock SET owner=\?, .*, owner_labels=\?, in_use=1`).
+			WithArgs(rl.owner, rl.host, rl.pid, `{"service":"invoicer","team":"billing"}`, "foo", "previous-owner").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := rl.takeover(context.Background(), "foo", "previous-owner", false)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("reads labels back", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock ORDER BY name`).WillReturnRows(
+			sqlmock.NewRows(append(lockEntryColumns, "owner_labels")).
+				AddRow(1, "foo", "owner-a", []byte{1}, "", time.Now(), time.Now(), `{"team":"billing"}`).
+				AddRow(2, "bar", "owner-b", []byte{1}, "", time.Now(), time.Now(), nil))
+
+		entries, err := rl.ListLocks()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[0].OwnerLabels).To(Equal(Labels{"team": "billing"}))
+		Expect(entries[1].OwnerLabels).To(BeNil())
+	})
+
+	It("filters listed locks by label", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE JSON_UNQUOTE\(JSON_EXTRACT\(owner_labels, \?\)\)=\? AND JSON_UNQUOTE\(JSON_EXTRACT\(owner_labels, \?\)\)=\? ORDER BY name`).
+			WithArgs(`$."team"`, "billing", `$."service"`, "invoicer").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+
+		_, err := rl.ListLocks(Label{Key: "team", Value: "billing"}, Label{Key: "service", Value: "invoicer"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("filters locks by owner and label", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE owner=\? AND JSON_UNQUOTE\(JSON_EXTRACT\(owner_labels, \?\)\)=\? ORDER BY name`).
+			WithArgs("owner-a", `$."team"`, "billing").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+
+		_, err := rl.GetLocksByOwner("owner-a", Label{Key: "team", Value: "billing"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("rejects invalid label keys in filters", func() {
+		_, err := rl.ListLocks(Label{Key: "team')", Value: "billing"})
+
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,69 @@
+package rlock
+
+import (
+	"fmt"
+)
+
+// DefaultMaxLastErrorLength is the longest last_error (in characters) stored
+// unless overridden via WithMaxLastErrorLength; it matches the size of the
+// last_error column.
+const DefaultMaxLastErrorLength = 2048
+
+// Appended to last_error values that were truncated
+const truncatedMarker = "..."
+
+// WithMaxLastErrorLength truncates the errors passed to Unlock() (and the
+// reasons passed to ForceUnlock()) to n characters, marking them with a
+// trailing "...", before they are stored. Values longer than the last_error
+// column would otherwise fail the unlock under strict SQL modes.
+func WithMaxLastErrorLength(n int) Option {
+	return func(r *RLock) error {
+		if n <= len(truncatedMarker) {
+			return fmt.Errorf("max last error length must be greater than %d", len(truncatedMarker))
+		}
+
+		if n > DefaultMaxLastErrorLength {
+			return fmt.Errorf("max last error length cannot exceed the size of the last_error column (%d)", DefaultMaxLastErrorLength)
+		}
+
+		r.maxLastError = n
+
+		return nil
+	}
+}
+
+// WithLastErrorRedactor applies redact to the text of errors passed to
+// Unlock() (and the reasons passed to ForceUnlock()) before it is stored,
+// ie. to scrub secrets or PII that wrapped errors tend to carry (DSNs, tokens,
+// email addresses) from the shared lock table. The redacted text is also what
+// the audit log, events and notifications see.
+func WithLastErrorRedactor(redact func(text string) string) Option {
+	return func(r *RLock) error {
+		if redact == nil {
+			return fmt.Errorf("redactor cannot be nil")
+		}
+
+		r.redactLastError = redact
+
+		return nil
+	}
+}
+
+// lastErrorText returns text as it should be stored in last_error.
+func (r *RLock) lastErrorText(text string) string {
+	if r.redactLastError != nil {
+		text = r.redactLastError(text)
+	}
+
+	max := r.maxLastError
+	if max == 0 {
+		max = DefaultMaxLastErrorLength
+	}
+
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+
+	return string(runes[:max-len(truncatedMarker)]) + truncatedMarker
+}
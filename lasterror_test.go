@@ -0,0 +1,87 @@
+package rlock
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("last_error", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithMaxLastErrorLength(10))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the max length", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithMaxLastErrorLength(3))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithMaxLastErrorLength(DefaultMaxLastErrorLength+1))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("truncates long errors with a marker", func() {
+		Expect(rl.lastErrorText("short")).To(Equal("short"))
+		Expect(rl.lastErrorText("0123456789")).To(Equal("0123456789"))
+		Expect(rl.lastErrorText("0123456789abc")).To(Equal("0123456..."))
+		Expect(rl.lastErrorText("ééééééééééé")).To(Equal("ééééééé..."))
+	})
+
+	It("truncates to the column size by default", func() {
+		_, _, plain := setupMocks()
+
+		text := plain.lastErrorText(strings.Repeat("x", 5000))
+
+		Expect(text).To(HaveLen(DefaultMaxLastErrorLength))
+		Expect(text).To(HaveSuffix("..."))
+	})
+
+	It("redacts errors before truncating them", func() {
+		db, _, _ := setupMocks()
+
+		redacted, err := New(db, WithMaxLastErrorLength(20), WithLastErrorRedactor(func(text string) string {
+			return regexp.MustCompile(`password=\S+`).ReplaceAllString(text, "password=REDACTED")
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(redacted.lastErrorText("password=hunter2")).To(Equal("password=REDACTED"))
+		Expect(redacted.lastErrorText("dial: password=hunter2 refused")).To(Equal("dial: password=RE..."))
+	})
+
+	It("refuses nil redactors", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithLastErrorRedactor(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("stores truncated errors on unlock", func() {
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("wrapped...", "foo", rl.owner).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(l.Unlock(fmt.Errorf("wrapped: %v", strings.Repeat("cause: ", 100)))).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
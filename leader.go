@@ -0,0 +1,119 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultLeaderHeartbeat is how often RunWhileLeader() (and Scheduler runs)
+// refresh the locks they hold by default; see WithLeaderHeartbeat.
+const DefaultLeaderHeartbeat = 10 * time.Second
+
+// WithLeaderHeartbeat overrides how often RunWhileLeader() (and Scheduler
+// runs) refresh the locks they hold (defaults to DefaultLeaderHeartbeat).
+func WithLeaderHeartbeat(interval time.Duration) Option {
+	return func(r *RLock) error {
+		if interval <= 0 {
+			return fmt.Errorf("leader heartbeat must be positive")
+		}
+
+		r.leaderHeartbeat = interval
+
+		return nil
+	}
+}
+
+// RunWhileLeader campaigns for leadership (ie. waits for the lock called
+// name) and runs fn once it is gained, heartbeating the lock (see
+// Heartbeat() and WithLeaderHeartbeat) for as long as fn runs. The context
+// passed to fn is cancelled when leadership is lost (see Lock.Lost()), in
+// which case RunWhileLeader waits for fn to return and campaigns again. If fn
+// fails, leadership is given up (recording fn's error as the lock's last
+// error) and, after PollInterval, campaigned for again. Returns nil once fn
+// returns nil, or ctx.Err() once ctx is done. Leaders that die hold on to
+// the lock until it goes stale; see WithStaleAfter to fail over faster.
+func (r *RLock) RunWhileLeader(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	for {
+		l, err := r.lockContext(ctx, name, WaitForever)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			withError(r.logFor(name), err).Warn("unable to campaign for leadership; retrying")
+
+			if _, err := r.waitForRelease(ctx, nil, PollInterval); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		r.logFor(name).Info("gained leadership")
+
+		err = r.lead(ctx, l, fn)
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err == nil:
+			return nil
+		case err == LockLostErr:
+			r.logFor(name).Warn("lost leadership; campaigning again")
+			continue
+		}
+
+		withError(r.logFor(name), err).Warn("leader failed; campaigning again")
+
+		if _, err := r.waitForRelease(ctx, nil, PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// lead runs fn while we hold l (see RunWhileLeader() and Scheduler.Run()) and
+// releases l once fn returns, returning fn's error, or LockLostErr if l was lost first.
+func (r *RLock) lead(ctx context.Context, l *Lock, fn func(ctx context.Context) error) error {
+	stop := l.Heartbeat(r.leaderHeartbeat)
+	defer stop()
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- fn(leaderCtx)
+	}()
+
+	var err error
+
+	select {
+	case err = <-done:
+	case <-l.Lost():
+		cancel()
+		<-done
+
+		err = LockLostErr
+	}
+
+	// Being cancelled is not worth recording as the lock's last error
+	lastError := err
+	if err == LockLostErr || ctx.Err() != nil {
+		lastError = nil
+	}
+
+	// A lost lock may still be ours (ie. when its heartbeat failed because
+	// the DB was unreachable); leave a newer hold alone
+	unlock := l.Unlock
+	if l.Token() != 0 {
+		unlock = l.UnlockFenced
+	}
+
+	if unlockErr := unlock(lastError); unlockErr != nil && unlockErr != AlreadyUnlockedErr && unlockErr != LockLostErr {
+		withError(r.logFor(l.name), unlockErr).Warn("unable to release lock")
+	}
+
+	return err
+}
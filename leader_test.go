@@ -0,0 +1,166 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("RunWhileLeader", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		clock *FakeClock
+	)
+
+	newRLock := func(opts ...Option) *RLock {
+		db, m, _ := setupMocks()
+		mock = m
+
+		clock = NewFakeClock(time.Now())
+
+		rl, err := New(db, append([]Option{WithClock(clock)}, opts...)...)
+		Expect(err).ToNot(HaveOccurred())
+
+		return rl
+	}
+
+	// leader runs RunWhileLeader() in the background, calling fn with the
+	// number of the call
+	leader := func(rl *RLock, ctx context.Context, fn func(ctx context.Context, call int) error) (<-chan error, func() int) {
+		var (
+			mu    sync.Mutex
+			calls int
+		)
+
+		errs := make(chan error, 1)
+
+		go func() {
+			errs <- rl.RunWhileLeader(ctx, "leader", func(ctx context.Context) error {
+				mu.Lock()
+				calls++
+				call := calls
+				mu.Unlock()
+
+				return fn(ctx, call)
+			})
+		}()
+
+		return errs, func() int {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return calls
+		}
+	}
+
+	It("validates the heartbeat", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithLeaderHeartbeat(0))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("runs fn once leadership is gained and gives it up once fn is done", func() {
+		rl := newRLock()
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE rlock SET in_use=0, last_error=\? WHERE name=\? AND owner=\? AND acquire_count=\?`).
+			WithArgs("", "leader", rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		errs, calls := leader(rl, context.Background(), func(ctx context.Context, call int) error {
+			return nil
+		})
+
+		Eventually(errs).Should(Receive(BeNil()))
+		Expect(calls()).To(Equal(1))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("cancels fn when leadership is lost and campaigns again", func() {
+		rl := newRLock(WithLeaderHeartbeat(time.Second),
+			WithHeartbeatPolicy(HeartbeatPolicy{MaxFailures: 1, RetryInterval: time.Second}))
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnError(fmt.Errorf("boom"))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		cancelled := make(chan error, 1)
+
+		errs, calls := leader(rl, context.Background(), func(ctx context.Context, call int) error {
+			if call > 1 {
+				return nil
+			}
+
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+
+			return ctx.Err()
+		})
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(time.Second)
+
+		Eventually(cancelled).Should(Receive(Equal(context.Canceled)))
+		Eventually(errs).Should(Receive(BeNil()))
+		Expect(calls()).To(Equal(2))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("gives up leadership when fn fails and campaigns again", func() {
+		rl := newRLock(WithLeaderHeartbeat(time.Hour))
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("boom", "leader", rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", "leader", rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		errs, calls := leader(rl, context.Background(), func(ctx context.Context, call int) error {
+			if call == 1 {
+				return fmt.Errorf("boom")
+			}
+
+			return nil
+		})
+
+		// Campaigning again waits for PollInterval
+		Eventually(func() int {
+			clock.Advance(PollInterval)
+			return calls()
+		}).Should(Equal(2))
+
+		Eventually(errs).Should(Receive(BeNil()))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("stops campaigning once ctx is done", func() {
+		rl := newRLock()
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM rlock`).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(1, "leader", "other-leader", []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec(`UPDATE rlock SET .*in_use=1`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errs, calls := leader(rl, ctx, func(ctx context.Context, call int) error {
+			return nil
+		})
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		cancel()
+
+		Eventually(errs).Should(Receive(Equal(context.Canceled)))
+		Expect(calls()).To(BeZero())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
@@ -0,0 +1,231 @@
+package rlock
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+	"github.com/jmoiron/sqlx"
+)
+
+// PinnedConn describes a connection pinned by this RLock for a feature
+// needing session affinity (ie. a session lock, see WithSessionLocks).
+type PinnedConn struct {
+	Purpose string
+	Since   time.Time
+
+	// How many times the connection dropped and its session state was
+	// restored on a new one
+	Restored int
+}
+
+type leases struct {
+	mu     sync.Mutex
+	active map[*connLease]struct{}
+}
+
+// connLease is a pinned connection along with how to restore its session
+// state on a new connection should it drop.
+type connLease struct {
+	rl      *RLock
+	purpose string
+	since   time.Time
+
+	// Guards the fields below
+	mu       sync.Mutex
+	conn     *sqlx.Conn
+	restore  func(ctx context.Context, conn *sqlx.Conn) error
+	restored int
+}
+
+// PinnedConns returns the connections currently pinned by this RLock.
+func (r *RLock) PinnedConns() []PinnedConn {
+	r.leases.mu.Lock()
+	defer r.leases.mu.Unlock()
+
+	pinned := make([]PinnedConn, 0, len(r.leases.active))
+
+	for lease := range r.leases.active {
+		lease.mu.Lock()
+		pinned = append(pinned, PinnedConn{Purpose: lease.purpose, Since: lease.since, Restored: lease.restored})
+		lease.mu.Unlock()
+	}
+
+	return pinned
+}
+
+// CheckPinnedConns pings every pinned connection, restoring the session state
+// of those that dropped on new connections. Returns an error describing the
+// ones whose state could not be restored (ie. a session lock that someone
+// else got hold of meanwhile); see Lock.Refresh(), which checks the lock's
+// connection as well.
+func (r *RLock) CheckPinnedConns(ctx context.Context) error {
+	r.leases.mu.Lock()
+
+	active := make([]*connLease, 0, len(r.leases.active))
+	for lease := range r.leases.active {
+		active = append(active, lease)
+	}
+
+	r.leases.mu.Unlock()
+
+	var firstErr error
+	failed := 0
+
+	for _, lease := range active {
+		if err := lease.check(ctx); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to restore %d pinned connection(s): %v", failed, firstErr)
+	}
+
+	return nil
+}
+
+// pinConn pins a connection for purpose until it is unpinned.
+func (r *RLock) pinConn(ctx context.Context, purpose string) (*connLease, error) {
+	conn, err := r.db.Connx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to pin a connection for %v: %v", purpose, err)
+	}
+
+	lease := &connLease{rl: r, purpose: purpose, since: r.clock.Now(), conn: conn}
+
+	r.leases.mu.Lock()
+	defer r.leases.mu.Unlock()
+
+	if r.leases.active == nil {
+		r.leases.active = make(map[*connLease]struct{})
+	}
+
+	r.leases.active[lease] = struct{}{}
+
+	return lease, nil
+}
+
+// unpin runs release (if any) on the lease's connection and returns it to the
+// pool; if release fails, the connection is closed instead, which drops its
+// session state along with it.
+func (r *RLock) unpin(lease *connLease, release func(ctx context.Context, conn *sqlx.Conn) error) {
+	r.leases.mu.Lock()
+	delete(r.leases.active, lease)
+	r.leases.mu.Unlock()
+
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	if lease.conn == nil {
+		return
+	}
+
+	if release != nil {
+		ctx, cancel := r.statementContext()
+		defer cancel()
+
+		if err := release(ctx, lease.conn); err != nil {
+			withError(r.logger, err).WithFields(golog.Fields{"purpose": lease.purpose}).Warn("unable to release pinned connection")
+
+			discardConn(lease.conn)
+			lease.conn = nil
+
+			return
+		}
+	}
+
+	lease.conn.Close()
+	lease.conn = nil
+}
+
+// discardPin unpins the lease, closing its connection rather than returning
+// it to the pool (ie. when its session state is unknown).
+func (r *RLock) discardPin(lease *connLease) {
+	r.leases.mu.Lock()
+	delete(r.leases.active, lease)
+	r.leases.mu.Unlock()
+
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	if lease.conn != nil {
+		discardConn(lease.conn)
+		lease.conn = nil
+	}
+}
+
+// use runs fn on the lease's connection.
+func (l *connLease) use(fn func(conn *sqlx.Conn) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return fmt.Errorf("%v is no longer pinned", l.purpose)
+	}
+
+	return fn(l.conn)
+}
+
+// setRestore sets how to restore the lease's session state on a new
+// connection.
+func (l *connLease) setRestore(restore func(ctx context.Context, conn *sqlx.Conn) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.restore = restore
+}
+
+// check pings the lease's connection and, if it dropped, restores its session
+// state on a new one.
+func (l *connLease) check(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return fmt.Errorf("%v is no longer pinned", l.purpose)
+	}
+
+	if err := l.conn.PingContext(ctx); err == nil {
+		return nil
+	}
+
+	l.rl.logger.WithFields(golog.Fields{"purpose": l.purpose}).Warn("pinned connection dropped, restoring")
+
+	discardConn(l.conn)
+	l.conn = nil
+
+	conn, err := l.rl.db.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to restore %v: %v", l.purpose, err)
+	}
+
+	if l.restore != nil {
+		if err := l.restore(ctx, conn); err != nil {
+			discardConn(conn)
+			return fmt.Errorf("unable to restore %v: %v", l.purpose, err)
+		}
+	}
+
+	l.conn = conn
+	l.restored++
+
+	return nil
+}
+
+// discardConn closes conn's underlying connection rather than returning it to
+// the pool.
+func discardConn(conn *sqlx.Conn) {
+	conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+
+	conn.Close()
+}
@@ -0,0 +1,215 @@
+package rlock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// pingConnector hands out connections that can be dropped, which sqlmock's
+// cannot (its pings always succeed).
+type pingConnector struct {
+	mu    sync.Mutex
+	conns []*pingConn
+}
+
+type pingConn struct {
+	mu      sync.Mutex
+	dropped bool
+	closed  bool
+}
+
+func (c *pingConnector) Connect(context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn := &pingConn{}
+	c.conns = append(c.conns, conn)
+
+	return conn, nil
+}
+
+func (c *pingConnector) Driver() driver.Driver { return nil }
+
+// conn returns the i-th connection handed out.
+func (c *pingConnector) conn(i int) *pingConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conns[i]
+}
+
+func (c *pingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+
+func (c *pingConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+func (c *pingConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+
+	return nil
+}
+
+func (c *pingConn) Ping(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dropped {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+func (c *pingConn) drop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dropped = true
+}
+
+func (c *pingConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+var _ = Describe("Pinned connections", func() {
+	var (
+		connector *pingConnector
+		clock     *FakeClock
+		rl        *RLock
+	)
+
+	BeforeEach(func() {
+		connector = &pingConnector{}
+		clock = NewFakeClock(time.Now())
+
+		var err error
+
+		rl, err = New(sqlx.NewDb(sql.OpenDB(connector), "mysql"), WithClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		rl.db.Close()
+	})
+
+	It("pins a connection until it is unpinned", func() {
+		lease, err := rl.pinConn(context.Background(), "test")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(rl.PinnedConns()).To(Equal([]PinnedConn{{Purpose: "test", Since: clock.Now()}}))
+		Expect(lease.use(func(conn *sqlx.Conn) error { return nil })).To(Succeed())
+
+		released := 0
+
+		rl.unpin(lease, func(ctx context.Context, conn *sqlx.Conn) error {
+			released++
+			return nil
+		})
+
+		Expect(released).To(Equal(1))
+		Expect(rl.PinnedConns()).To(BeEmpty())
+		Expect(lease.use(func(conn *sqlx.Conn) error { return nil })).ToNot(Succeed())
+
+		// Back in the pool
+		Expect(connector.conn(0).isClosed()).To(BeFalse())
+	})
+
+	It("closes connections whose release failed", func() {
+		lease, err := rl.pinConn(context.Background(), "test")
+		Expect(err).ToNot(HaveOccurred())
+
+		rl.unpin(lease, func(ctx context.Context, conn *sqlx.Conn) error {
+			return fmt.Errorf("boom")
+		})
+
+		Expect(rl.PinnedConns()).To(BeEmpty())
+		Expect(connector.conn(0).isClosed()).To(BeTrue())
+	})
+
+	It("closes connections whose session state is unknown", func() {
+		lease, err := rl.pinConn(context.Background(), "test")
+		Expect(err).ToNot(HaveOccurred())
+
+		rl.discardPin(lease)
+
+		Expect(rl.PinnedConns()).To(BeEmpty())
+		Expect(connector.conn(0).isClosed()).To(BeTrue())
+	})
+
+	It("keeps connections that are still up", func() {
+		lease, err := rl.pinConn(context.Background(), "test")
+		Expect(err).ToNot(HaveOccurred())
+
+		restored := 0
+
+		lease.setRestore(func(ctx context.Context, conn *sqlx.Conn) error {
+			restored++
+			return nil
+		})
+
+		Expect(rl.CheckPinnedConns(context.Background())).To(Succeed())
+		Expect(restored).To(Equal(0))
+		Expect(rl.PinnedConns()[0].Restored).To(Equal(0))
+	})
+
+	It("restores the session state of dropped connections on new ones", func() {
+		lease, err := rl.pinConn(context.Background(), "test")
+		Expect(err).ToNot(HaveOccurred())
+
+		restored := 0
+
+		lease.setRestore(func(ctx context.Context, conn *sqlx.Conn) error {
+			restored++
+			return nil
+		})
+
+		connector.conn(0).drop()
+
+		Expect(rl.CheckPinnedConns(context.Background())).To(Succeed())
+		Expect(restored).To(Equal(1))
+		Expect(rl.PinnedConns()[0].Restored).To(Equal(1))
+		Expect(connector.conn(0).isClosed()).To(BeTrue())
+
+		// Pinned to the new connection from now on
+		Expect(lease.use(func(conn *sqlx.Conn) error { return nil })).To(Succeed())
+		Expect(rl.CheckPinnedConns(context.Background())).To(Succeed())
+		Expect(restored).To(Equal(1))
+	})
+
+	It("reports connections whose session state cannot be restored", func() {
+		lease, err := rl.pinConn(context.Background(), "test")
+		Expect(err).ToNot(HaveOccurred())
+
+		lease.setRestore(func(ctx context.Context, conn *sqlx.Conn) error {
+			return fmt.Errorf("someone else got hold of it")
+		})
+
+		connector.conn(0).drop()
+
+		err = rl.CheckPinnedConns(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("unable to restore 1 pinned connection(s)"))
+		Expect(err.Error()).To(ContainSubstring("someone else got hold of it"))
+
+		// The lease expired along with its session state
+		Expect(lease.use(func(conn *sqlx.Conn) error { return nil })).ToNot(Succeed())
+		Expect(rl.CheckPinnedConns(context.Background())).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,74 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Limiter bounds how many executions of a code path run concurrently across
+// all instances; see Limiter().
+type Limiter struct {
+	pool *Pool
+}
+
+// Slot is a slot of a Limiter taken by Enter().
+type Slot struct {
+	limiter *Limiter
+	permit  *Permit
+}
+
+// Limiter returns the limiter called name, allowing at most max concurrent
+// executions. Unlike a Pool's permits, slots are interchangeable and their
+// locks ("<name>/slot-<n>") are not meant to be used by name.
+func (r *RLock) Limiter(name string, max int) (*Limiter, error) {
+	pool, err := r.newPool(name, "/slot-", max)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Limiter{pool: pool}, nil
+}
+
+// Name returns the name of the limiter.
+func (l *Limiter) Name() string {
+	return l.pool.name
+}
+
+// Max returns how many concurrent executions the limiter allows.
+func (l *Limiter) Max() int {
+	return l.pool.Size()
+}
+
+// Enter waits up to timeout (see Lock()) or until ctx is done for a free slot.
+// Every successful Enter must be followed by an Exit() of the slot.
+func (l *Limiter) Enter(ctx context.Context, timeout time.Duration) (*Slot, error) {
+	permit, err := l.pool.Checkout(ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Slot{limiter: l, permit: permit}, nil
+}
+
+// Exit frees the slot, recording lastError (see Lock.Unlock()).
+func (l *Limiter) Exit(slot *Slot, lastError error) error {
+	if slot.limiter != l {
+		return fmt.Errorf("slot does not belong to limiter '%v'", l.pool.name)
+	}
+
+	return l.pool.Return(slot.permit, lastError)
+}
+
+// Reclaim frees slots abandoned by executions that have not refreshed them
+// for longer than maxAge (see ReapStale()), returning the names of the
+// reclaimed slots. Abandoned slots are also taken over once they go stale.
+func (l *Limiter) Reclaim(maxAge time.Duration) ([]string, error) {
+	return l.pool.Reclaim(maxAge)
+}
+
+// Refresh keeps the slot of a long running execution from being reclaimed;
+// see Lock.Refresh().
+func (s *Slot) Refresh() error {
+	return s.permit.Refresh()
+}
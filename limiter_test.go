@@ -0,0 +1,68 @@
+package rlock
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Limiter", func() {
+	var (
+		mock    sqlmock.Sqlmock
+		rl      *RLock
+		limiter *Limiter
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+
+		var err error
+
+		limiter, err = rl.Limiter("exports", 1)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates its arguments", func() {
+		_, err := rl.Limiter("exports", 0)
+		Expect(err).To(HaveOccurred())
+
+		Expect(limiter.Name()).To(Equal("exports"))
+		Expect(limiter.Max()).To(Equal(1))
+	})
+
+	It("enters and exits a slot", func() {
+		mock.ExpectExec("INSERT INTO").WithArgs("exports/slot-0", rl.owner, rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		slot, err := limiter.Enter(context.Background(), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", "exports/slot-0", rl.owner).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(limiter.Exit(slot, nil)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("reclaims abandoned slots", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE in_use=1 AND last_used < \? AND name LIKE \? ESCAPE '!'`).
+			WithArgs(sqlmock.AnyArg(), "exports/slot-%").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+
+		reclaimed, err := limiter.Reclaim(time.Hour)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reclaimed).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("refuses slots of other limiters", func() {
+		other, err := rl.Limiter("other", 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(limiter.Exit(&Slot{limiter: other}, nil)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,154 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// lockHeldErr is returned by lockTx() when someone else holds the lock.
+var lockHeldErr = errors.New("lock is held")
+
+// LockTx acquires the lock called name like Lock(), waiting up to timeout or
+// until ctx is done, but does so in a transaction that fn's statements are
+// part of: the lock is acquired only if fn returns nil and the transaction
+// commits, so acquiring the lock and recording what it is held for cannot
+// diverge should the process die in between. fn must neither commit nor roll
+// back tx; errors it returns are returned as is.
+func (r *RLock) LockTx(ctx context.Context, name string, timeout time.Duration, fn func(tx *sqlx.Tx) error) (*Lock, error) {
+	name = r.normalizeName(name)
+	start := r.clock.Now()
+
+	unreserve, err := r.reserveQuota(1)
+	if err != nil {
+		r.recordAcquire(name, nil, err, 0)
+		return nil, err
+	}
+
+	defer unreserve()
+
+	if err := r.CreateLocks(name); err != nil {
+		return nil, err
+	}
+
+	deadline := start.Add(timeout)
+
+	released, cancel := r.subscribeReleases(name)
+	defer cancel()
+
+	for {
+		l, err := r.lockTx(ctx, name, timeout, fn)
+		if err != lockHeldErr {
+			r.recordAcquire(name, l, err, r.clock.Now().Sub(start))
+			return l, err
+		}
+
+		wait := r.jitter(r.pollInterval(name, r.clock.Now().Sub(start)), false)
+
+		if timeout >= 0 {
+			left := deadline.Sub(r.clock.Now())
+			if left <= 0 {
+				r.countTimeout(name)
+				r.recordAcquire(name, nil, AcquireTimeoutErr, r.clock.Now().Sub(start))
+
+				return nil, AcquireTimeoutErr
+			}
+
+			if wait > left {
+				wait = left
+			}
+		}
+
+		released, err = r.waitForRelease(ctx, released, wait)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lockTx makes a single LockTx() attempt, returning lockHeldErr if the lock
+// is (validly) held by someone else.
+func (r *RLock) lockTx(ctx context.Context, name string, timeout time.Duration, fn func(tx *sqlx.Tx) error) (*Lock, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start lock transaction: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	existing := &LockEntry{}
+
+	query := fmt.Sprintf("SELECT * FROM %v WHERE name=? FOR UPDATE", r.tableFor(name))
+
+	if err := tx.GetContext(ctx, existing, query, name); err != nil {
+		r.observeError(err)
+		return nil, fmt.Errorf("unable to fetch lock '%v': %v", name, err)
+	}
+
+	if isValid(r.policyFor(name), existing, name, timeout, r.clock.Now()) == nil {
+		return nil, lockHeldErr
+	}
+
+	stale := bool(existing.InUse)
+
+	if stale && r.noForcedTakeover {
+		return nil, &StaleLockErr{Entry: existing}
+	}
+
+	if stale && r.takeoverVeto != nil {
+		if err := r.takeoverVeto(existing); err != nil {
+			return nil, err
+		}
+	}
+
+	set, args := r.holderColumns(ctx)
+	if stale {
+		set += ", takeover_count=takeover_count+1"
+	}
+
+	query = fmt.Sprintf("UPDATE %v SET %v, in_use=1 WHERE name=?", r.tableFor(name), set)
+
+	res, err := tx.ExecContext(ctx, query, append(args, name)...)
+	if err != nil {
+		r.observeError(err)
+		return nil, fmt.Errorf("unable to acquire '%v': %v", name, err)
+	}
+
+	token, err := acquireToken(res)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fn(tx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("unable to commit lock transaction: %v", err)
+	}
+
+	l := r.newLock(name, timeout, token)
+
+	switch {
+	case stale:
+		evidence := r.staleEvidence(existing)
+
+		r.auditRelease(name, existing.Owner, ExitTakenOver, evidence)
+		r.auditAcquire(ctx, name, AcquireStaleTakeover, existing.Owner, evidence)
+		r.emit(EventTakeover, name, existing.Owner, "")
+		r.notify(EventTakeover, name, existing, evidence)
+
+		l.tookOver = true
+	case existing.Owner == "":
+		r.auditAcquire(ctx, name, AcquireFresh, "", "")
+		r.emit(EventAcquired, name, "", "")
+	default:
+		r.auditAcquire(ctx, name, AcquireHandoff, existing.Owner, "in_use=false")
+		r.emit(EventAcquired, name, existing.Owner, "")
+	}
+
+	return l, nil
+}
@@ -0,0 +1,90 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("LockTx", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+
+		mock.ExpectExec("INSERT IGNORE INTO rlock").WithArgs("foo").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+	})
+
+	record := func(tx *sqlx.Tx) error {
+		_, err := tx.Exec("INSERT INTO deploys (name) VALUES (?)", "api")
+		return err
+	}
+
+	It("acquires the lock along with the caller's statements", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\? FOR UPDATE`).WithArgs("foo").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "", []byte{0}, "", time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET owner=\?, host=\?, pid=\?, .*in_use=1 WHERE name=\?`).
+			WithArgs(rl.owner, rl.host, rl.pid, "foo").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO deploys").WithArgs("api").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		l, err := rl.LockTx(context.Background(), "foo", time.Minute, record)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Name()).To(Equal("foo"))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("does not acquire the lock when the caller's statements fail", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock`).
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "", []byte{0}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE rlock").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		boom := fmt.Errorf("boom")
+
+		_, err := rl.LockTx(context.Background(), "foo", time.Minute, func(tx *sqlx.Tx) error {
+			return boom
+		})
+
+		Expect(err).To(Equal(boom))
+		Expect(rl.held).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("times out while the lock is held", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock`).
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectRollback()
+		mock.ExpectExec("UPDATE rlock SET timeout_count").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := rl.LockTx(context.Background(), "foo", 0, record)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("takes stale locks over", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock`).
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now().Add(-2*MaxAge), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET .*takeover_count=takeover_count\+1, in_use=1`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO deploys").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		l, err := rl.LockTx(context.Background(), "foo", 0, record)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.tookOver).To(BeTrue())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,236 @@
+package rlock
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// LogLevel is the least severe level an RLock logs at; see WithLogLevel.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+
+	// LogOff silences the RLock altogether
+	LogOff
+)
+
+// WithLogger makes the RLock log to logger rather than the package's default
+// logger. Every line carries structured fields rather than values formatted
+// into the message: "pkg", "owner" and, for lines about a lock, "lock";
+// others (ie. "error", "attempt", "wait_ms" or "outcome") depend on the line.
+func WithLogger(logger golog.Logger) Option {
+	return func(r *RLock) error {
+		if logger == nil {
+			return fmt.Errorf("logger cannot be nil")
+		}
+
+		r.logBase = logger.WithFields(golog.Fields{"pkg": "rlock"})
+
+		return nil
+	}
+}
+
+// WithLogLevel drops the RLock's log lines less severe than level (ie.
+// LogWarn skips debug and info lines), independently of other RLocks sharing
+// the logger (see WithLogger).
+func WithLogLevel(level LogLevel) Option {
+	return func(r *RLock) error {
+		if level < LogDebug || level > LogOff {
+			return fmt.Errorf("invalid log level %d", level)
+		}
+
+		r.logLevel = level
+
+		return nil
+	}
+}
+
+// WithLogSampling logs only one in every n debug and info lines (ie. the
+// lines logged for every poll of a contended lock and every acquisition), so
+// that high-churn lock usage does not flood the logs; sampled lines carry a
+// "sample_rate" field. Warnings and errors are always logged.
+func WithLogSampling(n int) Option {
+	return func(r *RLock) error {
+		if n <= 0 {
+			return fmt.Errorf("log sampling rate must be positive")
+		}
+
+		r.logSampling = n
+
+		return nil
+	}
+}
+
+// configureLogger sets up the RLock's logger once its options are applied.
+func (r *RLock) configureLogger() {
+	base := r.logBase
+	if base == nil {
+		base = log
+	}
+
+	if r.logSampling > 1 {
+		base = &samplingLogger{Logger: base, every: uint64(r.logSampling), count: new(uint64)}
+	}
+
+	if r.logLevel > LogDebug {
+		base = &levelLogger{Logger: base, level: r.logLevel}
+	}
+
+	r.logger = base.WithFields(golog.Fields{"owner": r.owner})
+}
+
+// logFor returns the logger for lines about the lock called name.
+func (r *RLock) logFor(name string) golog.Logger {
+	return r.logger.WithFields(golog.Fields{"lock": name})
+}
+
+// withError adds err to logger's fields.
+func withError(logger golog.Logger, err error) golog.Logger {
+	return logger.WithFields(golog.Fields{"error": err.Error()})
+}
+
+// levelLogger drops lines less severe than level; see WithLogLevel.
+type levelLogger struct {
+	golog.Logger
+	level LogLevel
+}
+
+func (l *levelLogger) WithFields(fields golog.Fields) golog.Logger {
+	return &levelLogger{Logger: l.Logger.WithFields(fields), level: l.level}
+}
+
+func (l *levelLogger) Debug(msg ...interface{}) {
+	if l.level <= LogDebug {
+		l.Logger.Debug(msg...)
+	}
+}
+
+func (l *levelLogger) Info(msg ...interface{}) {
+	if l.level <= LogInfo {
+		l.Logger.Info(msg...)
+	}
+}
+
+func (l *levelLogger) Warn(msg ...interface{}) {
+	if l.level <= LogWarn {
+		l.Logger.Warn(msg...)
+	}
+}
+
+func (l *levelLogger) Error(msg ...interface{}) {
+	if l.level <= LogError {
+		l.Logger.Error(msg...)
+	}
+}
+
+func (l *levelLogger) Debugln(msg ...interface{}) {
+	if l.level <= LogDebug {
+		l.Logger.Debugln(msg...)
+	}
+}
+
+func (l *levelLogger) Infoln(msg ...interface{}) {
+	if l.level <= LogInfo {
+		l.Logger.Infoln(msg...)
+	}
+}
+
+func (l *levelLogger) Warnln(msg ...interface{}) {
+	if l.level <= LogWarn {
+		l.Logger.Warnln(msg...)
+	}
+}
+
+func (l *levelLogger) Errorln(msg ...interface{}) {
+	if l.level <= LogError {
+		l.Logger.Errorln(msg...)
+	}
+}
+
+func (l *levelLogger) Debugf(format string, args ...interface{}) {
+	if l.level <= LogDebug {
+		l.Logger.Debugf(format, args...)
+	}
+}
+
+func (l *levelLogger) Infof(format string, args ...interface{}) {
+	if l.level <= LogInfo {
+		l.Logger.Infof(format, args...)
+	}
+}
+
+func (l *levelLogger) Warnf(format string, args ...interface{}) {
+	if l.level <= LogWarn {
+		l.Logger.Warnf(format, args...)
+	}
+}
+
+func (l *levelLogger) Errorf(format string, args ...interface{}) {
+	if l.level <= LogError {
+		l.Logger.Errorf(format, args...)
+	}
+}
+
+// samplingLogger logs one in every every debug and info lines; see
+// WithLogSampling. Loggers derived using WithFields share the count.
+type samplingLogger struct {
+	golog.Logger
+	every uint64
+	count *uint64
+}
+
+// sample returns the logger to log a debug or info line to, or nil if the
+// line is to be dropped.
+func (l *samplingLogger) sample() golog.Logger {
+	if (atomic.AddUint64(l.count, 1)-1)%l.every != 0 {
+		return nil
+	}
+
+	return l.Logger.WithFields(golog.Fields{"sample_rate": l.every})
+}
+
+func (l *samplingLogger) WithFields(fields golog.Fields) golog.Logger {
+	return &samplingLogger{Logger: l.Logger.WithFields(fields), every: l.every, count: l.count}
+}
+
+func (l *samplingLogger) Debug(msg ...interface{}) {
+	if logger := l.sample(); logger != nil {
+		logger.Debug(msg...)
+	}
+}
+
+func (l *samplingLogger) Info(msg ...interface{}) {
+	if logger := l.sample(); logger != nil {
+		logger.Info(msg...)
+	}
+}
+
+func (l *samplingLogger) Debugln(msg ...interface{}) {
+	if logger := l.sample(); logger != nil {
+		logger.Debugln(msg...)
+	}
+}
+
+func (l *samplingLogger) Infoln(msg ...interface{}) {
+	if logger := l.sample(); logger != nil {
+		logger.Infoln(msg...)
+	}
+}
+
+func (l *samplingLogger) Debugf(format string, args ...interface{}) {
+	if logger := l.sample(); logger != nil {
+		logger.Debugf(format, args...)
+	}
+}
+
+func (l *samplingLogger) Infof(format string, args ...interface{}) {
+	if logger := l.sample(); logger != nil {
+		logger.Infof(format, args...)
+	}
+}
@@ -0,0 +1,129 @@
+package rlock
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// countingLogger counts debug lines and warnings
+type countingLogger struct {
+	golog.Logger
+	debug, warn *int
+}
+
+func (l *countingLogger) Debug(msg ...interface{}) { *l.debug++ }
+func (l *countingLogger) Warn(msg ...interface{})  { *l.warn++ }
+
+func (l *countingLogger) WithFields(fields golog.Fields) golog.Logger {
+	return l
+}
+
+var _ = Describe("Logging", func() {
+	var (
+		mu       sync.Mutex
+		warnings []golog.Fields
+		logger   *recordingLogger
+	)
+
+	recorded := func() []golog.Fields {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return warnings
+	}
+
+	BeforeEach(func() {
+		warnings = nil
+		logger = &recordingLogger{record: func(f golog.Fields) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			warnings = append(warnings, f)
+		}}
+	})
+
+	It("validates its options", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithLogger(nil))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithLogLevel(LogOff+1))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("logs structured fields", func() {
+		db, mock, _ := setupMocks()
+
+		rl, err := New(db, WithLogger(logger), WithAuditLog())
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO rlock_audit").WillReturnError(errors.New("boom"))
+
+		_, err = rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(recorded()).To(HaveLen(1))
+		Expect(recorded()[0]).To(HaveKeyWithValue("pkg", "rlock"))
+		Expect(recorded()[0]).To(HaveKeyWithValue("owner", rl.owner))
+		Expect(recorded()[0]).To(HaveKeyWithValue("lock", "foo"))
+		Expect(recorded()[0]).To(HaveKeyWithValue("error", "boom"))
+	})
+
+	It("logs as the owner of derived instances", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db, WithLogger(logger))
+		Expect(err).ToNot(HaveOccurred())
+
+		worker, err := rl.WithOwner("worker-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		worker.logFor("foo").Warn("test")
+
+		Expect(recorded()).To(HaveLen(1))
+		Expect(recorded()[0]).To(HaveKeyWithValue("owner", "worker-1"))
+	})
+
+	It("drops lines below the log level", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db, WithLogger(logger), WithLogLevel(LogError))
+		Expect(err).ToNot(HaveOccurred())
+
+		rl.logFor("foo").Warn("test")
+		Expect(recorded()).To(BeEmpty())
+
+		rl, err = New(db, WithLogger(logger), WithLogLevel(LogWarn))
+		Expect(err).ToNot(HaveOccurred())
+
+		rl.logFor("foo").Warn("test")
+		Expect(recorded()).To(HaveLen(1))
+	})
+	It("samples debug lines but not warnings", func() {
+		db, _, _ := setupMocks()
+
+		var debug, warn int
+
+		rl, err := New(db, WithLogger(&countingLogger{Logger: golog.NewNoop(), debug: &debug, warn: &warn}), WithLogSampling(10))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 25; i++ {
+			rl.logFor("foo").Debug("test")
+			rl.logFor("foo").Warn("test")
+		}
+
+		Expect(debug).To(Equal(3))
+		Expect(warn).To(Equal(25))
+
+		_, err = New(db, WithLogSampling(0))
+		Expect(err).To(HaveOccurred())
+	})
+})
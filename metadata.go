@@ -0,0 +1,126 @@
+package rlock
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec marshals metadata values; see SetMetadataValue() and
+// LockEntry.DecodeMetadata(). Protobuf users can wrap proto.Marshal() and
+// proto.Unmarshal() in a Codec of their own.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec marshals metadata as JSON
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec marshals metadata with encoding/gob
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// SetMetadata attaches an opaque payload (ie. a descriptor of the job the
+// holder is working on) to the lock, replacing any previous payload; others
+// read it via Status() (LockEntry.Metadata). The payload is kept until the
+// next holder replaces it. Returns LockLostErr if the lock is no longer ours.
+func (l *Lock) SetMetadata(data []byte) error {
+	err := l.setMetadata(data)
+	if err == LockLostErr {
+		l.rl.lockLost(l, err)
+	}
+
+	return err
+}
+
+func (l *Lock) setMetadata(data []byte) error {
+	if l.client != nil {
+		return fmt.Errorf("setting metadata is not supported via proxy")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlocked {
+		return AlreadyUnlockedErr
+	}
+
+	query := fmt.Sprintf("UPDATE %v SET metadata=? WHERE name=? AND owner=? AND in_use=1", l.rl.tableFor(l.name))
+
+	result, err := l.rl.exec(query, data, l.name, l.rl.owner)
+	if err != nil {
+		l.rl.observeError(err)
+		return fmt.Errorf("unable to set metadata of '%v': %v", l.name, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to determine affected rows after setting metadata of '%v': %v", l.name, err)
+	}
+
+	// Setting the same payload again does not count as an affected row
+	if affected == 0 {
+		if err := l.rl.revalidate(l, false); err != nil {
+			withError(l.rl.logFor(l.name), err).Warn("unable to set metadata")
+			return LockLostErr
+		}
+	}
+
+	l.rl.mutated(l.name)
+
+	return nil
+}
+
+// SetMetadataValue is SetMetadata() for v marshaled with codec (ie.
+// JSONCodec).
+func (l *Lock) SetMetadataValue(codec Codec, v interface{}) error {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("unable to marshal metadata: %v", err)
+	}
+
+	return l.SetMetadata(data)
+}
+
+// DecodeMetadata unmarshals the lock's metadata (see SetMetadataValue()) into
+// v using codec. Returns an error if the lock has no metadata.
+func (e *LockEntry) DecodeMetadata(codec Codec, v interface{}) error {
+	if len(e.Metadata) == 0 {
+		return fmt.Errorf("lock '%v' has no metadata", e.Name)
+	}
+
+	if err := codec.Unmarshal(e.Metadata, v); err != nil {
+		return fmt.Errorf("unable to unmarshal metadata of '%v': %v", e.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type jobDescriptor struct {
+	Job     string
+	Attempt int
+}
+
+var _ = Describe("Metadata", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+		l    *Lock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		var err error
+
+		l, err = rl.Lock("foo", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("stores the payload of the holder", func() {
+		mock.ExpectExec(`UPDATE rlock SET metadata=\? WHERE name=\? AND owner=\? AND in_use=1`).
+			WithArgs([]byte("payload"), "foo", rl.owner).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(l.SetMetadata([]byte("payload"))).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("returns LockLostErr when the lock was taken over", func() {
+		mock.ExpectExec("UPDATE rlock SET metadata").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "someone-else", []byte{1}, "", time.Now(), time.Now()))
+
+		Expect(l.SetMetadata([]byte("payload"))).To(Equal(LockLostErr))
+	})
+
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		codec := codec
+
+		It(fmt.Sprintf("round trips typed values with %T", codec), func() {
+			mock.ExpectExec("UPDATE rlock SET metadata").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(l.SetMetadataValue(codec, &jobDescriptor{Job: "reindex", Attempt: 2})).To(Succeed())
+
+			stored, err := codec.Marshal(&jobDescriptor{Job: "reindex", Attempt: 2})
+			Expect(err).ToNot(HaveOccurred())
+
+			decoded := &jobDescriptor{}
+			entry := &LockEntry{Name: "foo", Metadata: stored}
+
+			Expect(entry.DecodeMetadata(codec, decoded)).To(Succeed())
+			Expect(decoded).To(Equal(&jobDescriptor{Job: "reindex", Attempt: 2}))
+		})
+	}
+
+	It("refuses to decode missing metadata", func() {
+		entry := &LockEntry{Name: "foo"}
+
+		Expect(entry.DecodeMetadata(JSONCodec, &jobDescriptor{})).ToNot(Succeed())
+	})
+})
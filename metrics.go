@@ -0,0 +1,94 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Metric names reported to a MetricsSink; every metric is tagged with the
+// name of the lock ("lock").
+const (
+	// MetricAcquire counts acquisitions, tagged with their "result"
+	// (acquired, takeover, timeout, max_attempts, cancelled, quota_exceeded or
+	// error)
+	MetricAcquire = "acquire"
+
+	// MetricWait is how long an acquisition waited, whatever its result
+	MetricWait = "wait"
+
+	// MetricHold is how long a lock was held before it was unlocked
+	MetricHold = "hold"
+)
+
+// MetricsSink receives metrics about lock operations (ie. to forward them to
+// StatsD; see the statsd package). Implementations must be safe for
+// concurrent use and should not block.
+type MetricsSink interface {
+	Count(name string, value int64, tags map[string]string)
+	Timing(name string, d time.Duration, tags map[string]string)
+}
+
+// WithMetricsSink reports acquisition counts, wait times and hold times to
+// sink.
+func WithMetricsSink(sink MetricsSink) Option {
+	return func(r *RLock) error {
+		if sink == nil {
+			return fmt.Errorf("metrics sink cannot be nil")
+		}
+
+		r.metrics = sink
+
+		return nil
+	}
+}
+
+func (r *RLock) recordAcquire(name string, l *Lock, err error, waited time.Duration) {
+	r.stats.observe(name, &waited, nil)
+
+	if err == nil && l != nil {
+		r.totalAcquire(name, l.tookOver)
+	}
+
+	if err == AcquireTimeoutErr || err == MaxAttemptsErr {
+		r.notify(EventTimeout, name, nil, fmt.Sprintf("%v after waiting %v", err, waited))
+	}
+
+	if r.metrics == nil {
+		return
+	}
+
+	r.metrics.Count(MetricAcquire, 1, map[string]string{"lock": name, "result": acquireResult(l, err)})
+	r.metrics.Timing(MetricWait, waited, map[string]string{"lock": name})
+}
+
+// acquireResult classifies the outcome of acquiring l (see MetricAcquire).
+func acquireResult(l *Lock, err error) string {
+	switch {
+	case err == AcquireTimeoutErr:
+		return "timeout"
+	case err == MaxAttemptsErr:
+		return "max_attempts"
+	case err == context.Canceled || err == context.DeadlineExceeded:
+		return "cancelled"
+	case err == QuotaExceededErr:
+		return "quota_exceeded"
+	case err != nil:
+		return "error"
+	case l.tookOver:
+		return "takeover"
+	}
+
+	return "acquired"
+}
+
+func (r *RLock) recordHold(name string, held time.Duration) {
+	r.stats.observe(name, nil, &held)
+	r.totalHold(name, held)
+
+	if r.metrics == nil {
+		return
+	}
+
+	r.metrics.Timing(MetricHold, held, map[string]string{"lock": name})
+}
@@ -0,0 +1,107 @@
+package rlock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type recordedMetric struct {
+	name  string
+	value interface{}
+	tags  map[string]string
+}
+
+type fakeSink struct {
+	mu      sync.Mutex
+	metrics []recordedMetric
+}
+
+func (f *fakeSink) Count(name string, value int64, tags map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.metrics = append(f.metrics, recordedMetric{name, value, tags})
+}
+
+func (f *fakeSink) Timing(name string, d time.Duration, tags map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.metrics = append(f.metrics, recordedMetric{name, d, tags})
+}
+
+var _ = Describe("WithMetricsSink", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		rl    *RLock
+		sink  *fakeSink
+		clock *FakeClock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		sink = &fakeSink{}
+		clock = NewFakeClock(time.Now())
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithMetricsSink(sink), WithClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("rejects a nil sink", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithMetricsSink(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports acquisitions and hold times", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		clock.Advance(3 * time.Second)
+
+		Expect(l.Unlock(nil)).To(Succeed())
+
+		Expect(sink.metrics).To(Equal([]recordedMetric{
+			{MetricAcquire, int64(1), map[string]string{"lock": "foo", "result": "acquired"}},
+			{MetricWait, time.Duration(0), map[string]string{"lock": "foo"}},
+			{MetricHold, 3 * time.Second, map[string]string{"lock": "foo"}},
+		}))
+	})
+
+	It("reports takeovers", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", clock.Now().Add(-2*MaxAge), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(sink.metrics[0].tags).To(HaveKeyWithValue("result", "takeover"))
+	})
+
+	It("reports timeouts", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+
+		_, err := rl.Lock("foo", 0)
+		Expect(err).To(Equal(AcquireTimeoutErr))
+
+		Expect(sink.metrics[0].tags).To(HaveKeyWithValue("result", "timeout"))
+	})
+})
@@ -0,0 +1,286 @@
+package rlock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// SchemaVersion is the version of the schema this version of rlock expects;
+// see Migrations().
+const SchemaVersion = 8
+
+// SchemaOutdatedErr is returned by EnsureSchema (with WithExternalMigrations)
+// when the recorded schema version is older than SchemaVersion.
+var SchemaOutdatedErr = errors.New("schema is older than this version of rlock expects")
+
+const schemaVersionDDL = "CREATE TABLE IF NOT EXISTS `%v` (\n" +
+	"  `id` TINYINT NOT NULL,\n" +
+	"  `version` INT NOT NULL,\n" +
+	"  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n" +
+	"  PRIMARY KEY (`id`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+// Migration brings the lock table from the previous schema version to
+// Version; see Migrations().
+type Migration struct {
+	Version     int
+	Description string
+
+	// Statements to run, in order and on a single connection (they may share
+	// session variables); running them again is harmless
+	Statements []string
+}
+
+type migration struct {
+	version     int
+	description string
+
+	// statements returns the DDL migrating the lock table called table
+	statements func(table string) []string
+
+	// Whether the migration only adds columns, which EnsureSchema() adds on
+	// its own (see schemaColumns); its statements are for external tools
+	addsColumns bool
+}
+
+// Every schema change gets a migration (in version order), and SchemaVersion
+// is bumped to the latest one
+var migrations = []migration{
+	{
+		version:     1,
+		description: "lock table",
+		statements: func(table string) []string {
+			// The original table; every later column has a migration of its
+			// own, which tables predating schema versioning need too
+			return []string{fmt.Sprintf(schemaDDL, table, "")}
+		},
+	},
+	{
+		version:     2,
+		description: "holder details columns",
+		statements: func(table string) []string {
+			return addColumnsDDL(table, "acquired_at", "acquire_count", "host", "pid")
+		},
+		addsColumns: true,
+	},
+	{
+		version:     3,
+		description: "usage counter columns",
+		statements: func(table string) []string {
+			return addColumnsDDL(table, "takeover_count", "timeout_count")
+		},
+		addsColumns: true,
+	},
+	{
+		version:     4,
+		description: "metadata column",
+		statements: func(table string) []string {
+			return addColumnDDL(table, "metadata")
+		},
+		addsColumns: true,
+	},
+	{
+		version:     5,
+		description: "correlation_id column",
+		statements: func(table string) []string {
+			return addColumnDDL(table, "correlation_id")
+		},
+		addsColumns: true,
+	},
+	{
+		version:     6,
+		description: "deleted_at column",
+		statements: func(table string) []string {
+			return addColumnDDL(table, "deleted_at")
+		},
+		addsColumns: true,
+	},
+	{
+		version:     7,
+		description: "owner_labels column",
+		statements: func(table string) []string {
+			return addColumnDDL(table, "owner_labels")
+		},
+		addsColumns: true,
+	},
+	{
+		version:     8,
+		description: "checkpoint column",
+		statements: func(table string) []string {
+			return addColumnDDL(table, "checkpoint")
+		},
+		addsColumns: true,
+	},
+}
+
+// WithExternalMigrations is for deployments managing the schema with a
+// migration tool (ie. golang-migrate or goose; see Migrations()):
+// EnsureSchema() no longer creates or alters tables, it only checks that the
+// recorded schema version is current and returns SchemaOutdatedErr if not.
+func WithExternalMigrations() Option {
+	return func(r *RLock) error {
+		r.externalMigrations = true
+		return nil
+	}
+}
+
+// Migrations returns the migrations bringing the lock table called table
+// (which is expected to be unsharded) up to SchemaVersion, oldest first, for
+// use with migration tools (see WithExternalMigrations). Every migration also
+// records its version in the schema version table ("<table>_schema_version").
+func Migrations(table string) []*Migration {
+	versionTable := table + "_schema_version"
+	result := make([]*Migration, len(migrations))
+
+	for i, m := range migrations {
+		statements := m.statements(table)
+
+		if i == 0 {
+			statements = append([]string{fmt.Sprintf(schemaVersionDDL, versionTable)}, statements...)
+		}
+
+		result[i] = &Migration{
+			Version:     m.version,
+			Description: m.description,
+			Statements:  append(statements, recordSchemaVersionQuery(versionTable, m.version)),
+		}
+	}
+
+	return result
+}
+
+func (r *RLock) schemaVersionTable() string {
+	return r.table + "_schema_version"
+}
+
+// SchemaVersion returns the recorded version of the schema, 0 if none was
+// recorded yet (ie. for tables created by rlock versions predating schema
+// versioning).
+func (r *RLock) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+
+	query := fmt.Sprintf("SELECT version FROM %v WHERE id=1", r.schemaVersionTable())
+
+	if err := r.db.GetContext(ctx, &version, query); err != nil {
+		if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1146 {
+			return 0, nil
+		}
+
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("unable to read schema version: %v", err)
+	}
+
+	return version, nil
+}
+
+// migrate runs the migrations newer than the recorded schema version against
+// every lock table, recording the version after each. Concurrent runs are
+// safe since migrations are idempotent and the recorded version never goes
+// back.
+func (r *RLock) migrate(ctx context.Context) error {
+	if _, err := r.exec(fmt.Sprintf(schemaVersionDDL, r.schemaVersionTable())); err != nil {
+		return fmt.Errorf("unable to create table '%v': %v", r.schemaVersionTable(), err)
+	}
+
+	current, err := r.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := r.runMigration(m); err != nil {
+			return err
+		}
+
+		if _, err := r.exec(recordSchemaVersionQuery(r.schemaVersionTable(), m.version)); err != nil {
+			return fmt.Errorf("unable to record schema version %d: %v", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// runMigration runs the statements of m against every lock table.
+func (r *RLock) runMigration(m migration) error {
+	if m.addsColumns {
+		return nil
+	}
+
+	for _, table := range r.tables() {
+		for _, statement := range m.statements(table) {
+			if _, err := r.exec(statement); err != nil {
+				return fmt.Errorf("unable to migrate '%v' to schema version %d: %v", table, m.version, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkSchemaVersion returns SchemaOutdatedErr unless the recorded schema
+// version is current.
+func (r *RLock) checkSchemaVersion(ctx context.Context) error {
+	version, err := r.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version < SchemaVersion {
+		return SchemaOutdatedErr
+	}
+
+	return nil
+}
+
+// addColumnDDL returns the DDL adding the lock table column called name to
+// table unless it has it already (MySQL has no ADD COLUMN IF NOT EXISTS, so
+// the ALTER is prepared only if the column is missing).
+func addColumnDDL(table, name string) []string {
+	for _, c := range schemaColumns {
+		if c.name != name {
+			continue
+		}
+
+		alter := fmt.Sprintf("ALTER TABLE `%v` ADD COLUMN `%v` %v", table, c.name, c.definition)
+
+		return []string{
+			fmt.Sprintf("SET @rlock_ddl = IF((SELECT COUNT(*) FROM information_schema.columns "+
+				"WHERE table_schema=DATABASE() AND table_name='%v' AND column_name='%v') = 0, '%v', 'DO 0')",
+				table, c.name, strings.Replace(alter, "'", "''", -1)),
+			"PREPARE rlock_ddl FROM @rlock_ddl",
+			"EXECUTE rlock_ddl",
+			"DEALLOCATE PREPARE rlock_ddl",
+		}
+	}
+
+	panic(fmt.Sprintf("unknown column '%v'", name))
+}
+
+// addColumnsDDL returns the DDL adding the lock table columns called names to
+// table (see addColumnDDL).
+func addColumnsDDL(table string, names ...string) []string {
+	var statements []string
+
+	for _, name := range names {
+		statements = append(statements, addColumnDDL(table, name)...)
+	}
+
+	return statements
+}
+
+func recordSchemaVersionQuery(table string, version int) string {
+	return fmt.Sprintf("INSERT INTO %v (id, version) VALUES (1, %d) ON DUPLICATE KEY UPDATE version=GREATEST(version, VALUES(version))",
+		table, version)
+}
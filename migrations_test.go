@@ -0,0 +1,221 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// expectSchemaVersion expects EnsureSchema() to find the schema at version
+// (and therefore not to run any migrations if it is current).
+func expectSchemaVersion(mock sqlmock.Sqlmock, version int) {
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock_schema_version`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version FROM rlock_schema_version").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+}
+
+var (
+	createTableRe = regexp.MustCompile("^CREATE TABLE IF NOT EXISTS `(\\w+)`")
+	columnRe      = regexp.MustCompile("(?m)^  `(\\w+)` (.+),$")
+	guardedAddRe  = regexp.MustCompile(`^SET @rlock_ddl = IF\(.* table_name='(\w+)' AND column_name='(\w+)'\) = 0, '(.*)', 'DO 0'\)$`)
+	addColumnRe   = regexp.MustCompile("^ALTER TABLE `(\\w+)` ADD COLUMN `(\\w+)` (.+)$")
+)
+
+// applyMigrations applies the statements of migrations to tables (the columns
+// of each table by name) the way MySQL would, failing like it on duplicate
+// columns.
+func applyMigrations(tables map[string][]column, migrations []*Migration) error {
+	add := func(table, name, definition string) error {
+		for _, c := range tables[table] {
+			if c.name == name {
+				return fmt.Errorf("Error 1060: Duplicate column name '%v'", name)
+			}
+		}
+
+		tables[table] = append(tables[table], column{name, definition})
+
+		return nil
+	}
+
+	for _, m := range migrations {
+		for _, statement := range m.Statements {
+			if match := createTableRe.FindStringSubmatch(statement); match != nil {
+				if _, ok := tables[match[1]]; !ok {
+					tables[match[1]] = parseColumns(statement)
+				}
+
+				continue
+			}
+
+			if match := guardedAddRe.FindStringSubmatch(statement); match != nil {
+				exists := false
+
+				for _, c := range tables[match[1]] {
+					exists = exists || c.name == match[2]
+				}
+
+				if exists {
+					continue
+				}
+
+				statement = strings.Replace(match[3], "''", "'", -1)
+			}
+
+			if match := addColumnRe.FindStringSubmatch(statement); match != nil {
+				if err := add(match[1], match[2], match[3]); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if !strings.HasPrefix(statement, "INSERT INTO") && !strings.Contains(statement, "PREPARE rlock_ddl") &&
+				statement != "EXECUTE rlock_ddl" {
+				return fmt.Errorf("unexpected statement: %v", statement)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseColumns returns the columns created by ddl.
+func parseColumns(ddl string) []column {
+	var columns []column
+
+	for _, match := range columnRe.FindAllStringSubmatch(ddl, -1) {
+		columns = append(columns, column{match[1], match[2]})
+	}
+
+	return columns
+}
+
+var _ = Describe("Migrations", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	It("are in version order and end at SchemaVersion", func() {
+		all := Migrations("locks")
+
+		Expect(all).ToNot(BeEmpty())
+
+		for i, m := range all {
+			Expect(m.Version).To(Equal(i + 1))
+			Expect(m.Description).ToNot(BeEmpty())
+			Expect(m.Statements[len(m.Statements)-1]).To(HavePrefix("INSERT INTO locks_schema_version"))
+		}
+
+		Expect(all[0].Statements[0]).To(HavePrefix("CREATE TABLE IF NOT EXISTS `locks_schema_version`"))
+		Expect(all[len(all)-1].Version).To(Equal(SchemaVersion))
+	})
+
+	It("create the current schema when applied in order, and again", func() {
+		tables := make(map[string][]column)
+
+		Expect(applyMigrations(tables, Migrations("locks"))).To(Succeed())
+		Expect(tables["locks"]).To(Equal(parseColumns(Schema("locks"))))
+
+		Expect(applyMigrations(tables, Migrations("locks"))).To(Succeed())
+		Expect(tables["locks"]).To(Equal(parseColumns(Schema("locks"))))
+	})
+
+	It("add the columns missing from tables predating schema versioning", func() {
+		original := parseColumns(fmt.Sprintf(schemaDDL, "locks", ""))
+
+		// Created by a version of rlock that added some of the columns
+		tables := map[string][]column{
+			"locks": append(original, schemaColumns[0], schemaColumns[2]),
+		}
+
+		Expect(applyMigrations(tables, Migrations("locks"))).To(Succeed())
+		Expect(tables["locks"]).To(ConsistOf(parseColumns(Schema("locks"))))
+	})
+
+	Describe("SchemaVersion", func() {
+		It("returns the recorded version", func() {
+			mock.ExpectQuery("SELECT version FROM rlock_schema_version WHERE id=1").
+				WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+
+			Expect(rl.SchemaVersion(context.Background())).To(Equal(3))
+		})
+
+		It("returns 0 when no version was recorded", func() {
+			mock.ExpectQuery("SELECT version").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+			Expect(rl.SchemaVersion(context.Background())).To(Equal(0))
+
+			mock.ExpectQuery("SELECT version").WillReturnError(&mysql.MySQLError{Number: 1146})
+			Expect(rl.SchemaVersion(context.Background())).To(Equal(0))
+		})
+	})
+
+	It("runs pending migrations and records the version", func() {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock_schema_version`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 1\) ON DUPLICATE KEY UPDATE version=GREATEST`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// Columns are added by EnsureSchema() before migrating
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 2\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 3\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 4\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 5\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 6\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 7\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 8\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(rl.migrate(context.Background())).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("skips migrations that were run already", func() {
+		expectSchemaVersion(mock, SchemaVersion)
+
+		Expect(rl.migrate(context.Background())).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	Describe("WithExternalMigrations", func() {
+		BeforeEach(func() {
+			db, m, _ := setupMocks()
+			mock = m
+
+			var err error
+
+			rl, err = New(db, WithExternalMigrations())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("only checks the schema version in EnsureSchema", func() {
+			mock.ExpectQuery("SELECT version").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(SchemaVersion))
+
+			Expect(rl.EnsureSchema()).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("refuses outdated schemas", func() {
+			mock.ExpectQuery("SELECT version").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(SchemaVersion - 1))
+
+			Expect(rl.EnsureSchema()).To(Equal(SchemaOutdatedErr))
+		})
+	})
+})
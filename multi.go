@@ -0,0 +1,285 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultLockAllParallelism is how many locks LockAll() acquires at once by
+// default; see WithLockAllParallelism().
+const DefaultLockAllParallelism = 8
+
+// WithLockAllParallelism overrides how many locks LockAll() acquires
+// concurrently (defaults to DefaultLockAllParallelism).
+func WithLockAllParallelism(n int) Option {
+	return func(r *RLock) error {
+		if n <= 0 {
+			return fmt.Errorf("lock all parallelism must be positive")
+		}
+
+		r.lockAllParallelism = n
+
+		return nil
+	}
+}
+
+// LockSet is a handle to a set of locks acquired together; see LockAll().
+type LockSet struct {
+	locks []*Lock
+}
+
+// Locks returns the members of the set, in the order they were requested.
+func (s *LockSet) Locks() []*Lock {
+	return s.locks
+}
+
+// Unlock unlocks every member of the set (see Lock.Unlock()), recording
+// lastError on each of them. Members that fail to unlock can be retried by
+// calling Unlock again; AlreadyUnlockedErr is returned once every member is
+// unlocked.
+func (s *LockSet) Unlock(lastError error) error {
+	var (
+		unlocked int
+		failed   int
+		firstErr error
+	)
+
+	for _, l := range s.locks {
+		err := l.Unlock(lastError)
+
+		switch err {
+		case nil:
+		case AlreadyUnlockedErr:
+			unlocked++
+		default:
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			failed++
+		}
+	}
+
+	if unlocked == len(s.locks) {
+		return AlreadyUnlockedErr
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to unlock %d of %d locks: %v", failed, len(s.locks), firstErr)
+	}
+
+	return nil
+}
+
+// LockAll acquires a set of independent locks concurrently (see
+// WithLockAllParallelism), all or nothing: it returns once all of them are
+// held or, as soon as one of them cannot be acquired, releases the ones that
+// were and returns the error. Locks that are free are first claimed with a
+// single statement per lock table; only the others are acquired one by one. It waits until ctx is done (with
+// AcquireTimeoutErr if ctx has a deadline, ctx.Err() otherwise); without a
+// deadline, it waits for as long as it takes.
+func (r *RLock) LockAll(ctx context.Context, names ...string) (*LockSet, error) {
+	names = r.normalizeNames(names)
+
+	if err := distinct(names); err != nil {
+		return nil, err
+	}
+
+	timeout := WaitForever
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = deadline.Sub(r.clock.Now()); timeout < 0 {
+			timeout = 0
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Free locks are claimed in one go; the others are waited for below
+	claimed := r.claimAll(ctx, names, timeout)
+
+	parallelism := r.lockAllParallelism
+	if parallelism == 0 {
+		parallelism = DefaultLockAllParallelism
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		started  int
+	)
+
+	locks := make([]*Lock, len(names))
+	slots := make(chan struct{}, parallelism)
+
+	for i, name := range names {
+		if l, ok := claimed[name]; ok {
+			locks[i] = l
+			started++
+
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		// Something failed already (or ctx is done); don't start any more
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		started++
+
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			l, err := r.lockContext(ctx, name, timeout)
+			if err == context.DeadlineExceeded {
+				err = AcquireTimeoutErr
+			}
+
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+
+				return
+			}
+
+			locks[i] = l
+		}(i, name)
+	}
+
+	wg.Wait()
+
+	// ctx was done before every acquisition was started
+	if firstErr == nil && started < len(names) {
+		firstErr = ctx.Err()
+
+		if firstErr == context.DeadlineExceeded {
+			firstErr = AcquireTimeoutErr
+		}
+	}
+
+	if firstErr != nil {
+		// Roll back; don't leak the locks we did get
+		for _, l := range locks {
+			if l == nil {
+				continue
+			}
+
+			if err := l.Unlock(nil); err != nil {
+				withError(r.logFor(l.name), err).Warn("unable to release lock after failing to acquire the set")
+			}
+		}
+
+		return nil, firstErr
+	}
+
+	return &LockSet{locks: locks}, nil
+}
+
+// AcquireAny acquires whichever of names it wins first, waiting up to timeout
+// (see Lock()) or until ctx is done. Waiting happens concurrently for every
+// name, so a lock released by its holder (or gone stale) is picked up without
+// trying the names one by one. Useful for worker pools that just need "one
+// free shard". Returns AcquireTimeoutErr if none of the locks could be
+// acquired in time.
+func (r *RLock) AcquireAny(ctx context.Context, names []string, timeout time.Duration) (*Lock, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("at least one lock name is required")
+	}
+
+	names = r.normalizeNames(names)
+
+	if err := distinct(names); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		l   *Lock
+		err error
+	}
+
+	results := make(chan *result, len(names))
+
+	for _, name := range names {
+		go func(name string) {
+			l, err := r.lockContext(ctx, name, timeout)
+			results <- &result{l, err}
+		}(name)
+	}
+
+	var (
+		won      *Lock
+		firstErr error
+	)
+
+	for range names {
+		res := <-results
+
+		if res.err != nil {
+			// Timeouts (and losers being cancelled) are expected; anything
+			// else is worth reporting if nothing is won
+			if firstErr == nil && res.err != AcquireTimeoutErr && res.err != context.Canceled {
+				firstErr = res.err
+			}
+
+			continue
+		}
+
+		if won != nil {
+			// Won more than one at the same time; keep the first
+			if err := res.l.Unlock(nil); err != nil {
+				withError(r.logFor(res.l.name), err).Warn("unable to release surplus lock")
+			}
+
+			continue
+		}
+
+		won = res.l
+		cancel()
+	}
+
+	if won != nil {
+		return won, nil
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	// ctx (not us) cancelled the acquisitions
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, AcquireTimeoutErr
+}
+
+// distinct returns an error if names contains a name more than once.
+func distinct(names []string) error {
+	seen := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		if seen[name] {
+			return fmt.Errorf("lock '%v' is requested more than once", name)
+		}
+
+		seen[name] = true
+	}
+
+	return nil
+}
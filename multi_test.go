@@ -0,0 +1,251 @@
+package rlock
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("LockAll", func() {
+	var (
+		mock sqlmock.Sqlmock
+		db   *sqlx.DB
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+		db = sqlx.NewDb(mockDB, "sqlmock")
+	})
+
+	claimRead := `SELECT name, owner, in_use, acquire_count, host, pid FROM rlock WHERE name IN`
+
+	// expectClaim expects a batch claim of names claiming all of them
+	expectClaim := func(rl *RLock, names ...string) {
+		args := make([]driver.Value, 0)
+		after := sqlmock.NewRows(claimColumns)
+
+		for _, name := range names {
+			args = append(args, name, rl.owner, rl.host, rl.pid)
+			after.AddRow(name, rl.owner, []byte{1}, 1, rl.host, rl.pid)
+		}
+
+		mock.ExpectExec(`INSERT INTO rlock \(name, owner, in_use, acquired_at, acquire_count, host, pid\) VALUES \(\?, \?, 1, NOW\(\), 1, \?, \?\), .* ON DUPLICATE KEY UPDATE owner=IF\(in_use=0, VALUES\(owner\), owner\), .*, in_use=1$`).
+			WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, int64(len(names))))
+		mock.ExpectQuery(claimRead).WillReturnRows(after)
+	}
+
+	It("acquires every lock", func() {
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		expectClaim(rl, "a", "b", "c")
+
+		set, err := rl.LockAll(context.Background(), "a", "b", "c")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(set.Locks()).To(HaveLen(3))
+
+		for i, name := range []string{"a", "b", "c"} {
+			Expect(set.Locks()[i].Name()).To(Equal(name))
+			Expect(set.Locks()[i].Token()).To(Equal(int64(1)))
+		}
+
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("takes over released locks and waits for those in use", func() {
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock .* ON DUPLICATE KEY UPDATE").WillReturnResult(sqlmock.NewResult(1, 2))
+		mock.ExpectQuery(claimRead).WithArgs("a", "b", "c").WillReturnRows(sqlmock.NewRows(claimColumns).
+			AddRow("a", rl.owner, []byte{1}, 5, rl.host, rl.pid).
+			AddRow("b", "other-owner", []byte{1}, 2, "other-host", 1).
+			AddRow("c", rl.owner, []byte{1}, 7, rl.host, rl.pid+1))
+
+		claimed := rl.claimAll(context.Background(), []string{"a", "b", "c"}, time.Minute)
+
+		Expect(claimed).To(HaveLen(1))
+		Expect(claimed).To(HaveKey("a"))
+		Expect(claimed["a"].Token()).To(Equal(int64(5)))
+
+		// The others are left to the regular acquire path
+		release := rl.gates.tryEnter("b")
+		Expect(release).ToNot(BeNil())
+		release()
+
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("falls back to acquiring locks one by one when the claim fails", func() {
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock .* ON DUPLICATE KEY UPDATE").WillReturnError(fmt.Errorf("boom"))
+
+		mock.MatchExpectationsInOrder(false)
+
+		for _, name := range []string{"a", "b"} {
+			mock.ExpectExec("INSERT INTO").WithArgs(name, rl.owner, rl.host, rl.pid).WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+
+		set, err := rl.LockAll(context.Background(), "a", "b")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(set.Locks()).To(HaveLen(2))
+
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("only claims missing rows when handing off locks to queued waiters", func() {
+		rl, err := New(db, WithQueuedHandoff())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(rl.claimUpdate("name, owner, in_use")).To(Equal("name=name"))
+	})
+
+	It("unlocks every member of the set", func() {
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		expectClaim(rl, "a", "b")
+
+		set, err := rl.LockAll(context.Background(), "a", "b")
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("boom", "a", rl.owner).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("boom", "b", rl.owner).WillReturnError(fmt.Errorf("db down"))
+
+		err = set.Unlock(fmt.Errorf("boom"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("unable to unlock 1 of 2 locks"))
+
+		// Failed members can be retried
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("boom", "b", rl.owner).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(set.Unlock(fmt.Errorf("boom"))).To(Succeed())
+		Expect(set.Unlock(nil)).To(Equal(AlreadyUnlockedErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("releases the locks it acquired when a member cannot be acquired", func() {
+		rl, err := New(db, WithLockAllParallelism(1))
+		Expect(err).ToNot(HaveOccurred())
+
+		// b is in use; a and c are claimed
+		mock.ExpectExec("INSERT INTO rlock .* ON DUPLICATE KEY UPDATE").WillReturnResult(sqlmock.NewResult(1, 2))
+		mock.ExpectQuery(claimRead).WillReturnRows(sqlmock.NewRows(claimColumns).
+			AddRow("a", rl.owner, []byte{1}, 1, rl.host, rl.pid).
+			AddRow("b", "other-owner", []byte{1}, 1, "other-host", 1).
+			AddRow("c", rl.owner, []byte{1}, 1, rl.host, rl.pid))
+
+		mock.ExpectExec("INSERT INTO").WithArgs("b", rl.owner, rl.host, rl.pid).WillReturnError(fmt.Errorf("boom"))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", "a", rl.owner).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", "c", rl.owner).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		set, err := rl.LockAll(context.Background(), "a", "b", "c")
+
+		Expect(err).To(HaveOccurred())
+		Expect(set).To(BeNil())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("gives up with AcquireTimeoutErr when the context deadline is reached", func() {
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "a", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err = rl.LockAll(ctx, "a")
+		Expect(err).To(Equal(AcquireTimeoutErr))
+	})
+
+	It("rejects duplicate names", func() {
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = rl.LockAll(context.Background(), "a", "a")
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithLockAllParallelism(0))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("AcquireAny", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"))
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.MatchExpectationsInOrder(false)
+	})
+
+	held := func(name string) {
+		mock.ExpectExec("INSERT INTO").WithArgs(name, rl.owner, rl.host, rl.pid).WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WithArgs(name).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, name, "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE").WithArgs(rl.owner, rl.host, rl.pid, name, "other-owner").WillReturnResult(sqlmock.NewResult(1, 0))
+	}
+
+	It("returns the lock it wins", func() {
+		held("shard-1")
+		mock.ExpectExec("INSERT INTO").WithArgs("shard-2", rl.owner, rl.host, rl.pid).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.AcquireAny(context.Background(), []string{"shard-1", "shard-2"}, time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Name()).To(Equal("shard-2"))
+	})
+
+	It("returns AcquireTimeoutErr when every lock is held", func() {
+		held("shard-1")
+		held("shard-2")
+
+		_, err := rl.AcquireAny(context.Background(), []string{"shard-1", "shard-2"}, 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+	})
+
+	It("gives up when the context is cancelled", func() {
+		held("shard-1")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err := rl.AcquireAny(ctx, []string{"shard-1"}, WaitForever)
+
+		Expect(err).To(Equal(context.Canceled))
+	})
+
+	It("validates the names", func() {
+		_, err := rl.AcquireAny(context.Background(), nil, time.Second)
+		Expect(err).To(HaveOccurred())
+
+		_, err = rl.AcquireAny(context.Background(), []string{"a", "a"}, time.Second)
+		Expect(err).To(HaveOccurred())
+	})
+})
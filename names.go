@@ -0,0 +1,72 @@
+package rlock
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameNormalization selects how lock names are normalized; see
+// WithNameNormalization. Normalizations can be combined, ie.
+// NormalizeTrim|NormalizeCaseFold.
+type NameNormalization int
+
+const (
+	// NormalizeTrim strips leading and trailing whitespace
+	NormalizeTrim NameNormalization = 1 << iota
+
+	// NormalizeCaseFold folds case, making "Deploy-Lock" and "deploy-lock"
+	// the same lock
+	NormalizeCaseFold
+
+	// NormalizeNFC converts names to Unicode normalization form C, so that
+	// differently composed accents make for the same lock
+	NormalizeNFC
+)
+
+// WithNameNormalization normalizes lock names wherever they are passed in
+// (ie. to Lock(), Status() or NewPool()). Without it names are used as is and
+// whether "Deploy-Lock" and "deploy-lock" are the same lock depends on the
+// collation of the lock table (they are with MySQL's default, case
+// insensitive, collation). Every instance sharing the locks should use the
+// same normalization.
+func WithNameNormalization(n NameNormalization) Option {
+	return func(r *RLock) error {
+		r.nameNormalization = n
+		return nil
+	}
+}
+
+// normalizeName returns name normalized as configured and scoped to our
+// environment (see WithEnvironment).
+func (r *RLock) normalizeName(name string) string {
+	if r.nameNormalization&NormalizeNFC != 0 {
+		name = norm.NFC.String(name)
+	}
+
+	if r.nameNormalization&NormalizeTrim != 0 {
+		name = strings.TrimSpace(name)
+	}
+
+	if r.nameNormalization&NormalizeCaseFold != 0 {
+		name = cases.Fold().String(name)
+	}
+
+	return r.scopeName(name)
+}
+
+// normalizeNames returns a normalized copy of names.
+func (r *RLock) normalizeNames(names []string) []string {
+	if r.nameNormalization == 0 && r.environment == "" {
+		return names
+	}
+
+	normalized := make([]string, len(names))
+
+	for i, name := range names {
+		normalized[i] = r.normalizeName(name)
+	}
+
+	return normalized
+}
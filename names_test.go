@@ -0,0 +1,66 @@
+package rlock
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithNameNormalization", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithNameNormalization(NormalizeTrim|NormalizeCaseFold|NormalizeNFC))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("normalizes names", func() {
+		Expect(rl.normalizeName("  Deploy-Lock\n")).To(Equal("deploy-lock"))
+		Expect(rl.normalizeName("Café")).To(Equal("café"))
+		Expect(rl.normalizeName("STRASSE")).To(Equal(rl.normalizeName("straße")))
+	})
+
+	It("leaves names alone by default", func() {
+		_, _, plain := setupMocks()
+
+		Expect(plain.normalizeName(" Deploy-Lock ")).To(Equal(" Deploy-Lock "))
+	})
+
+	It("normalizes names passed to Lock and Status", func() {
+		mock.ExpectExec("INSERT INTO rlock").WithArgs("deploy-lock", rl.owner, rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("Deploy-Lock ", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Name()).To(Equal("deploy-lock"))
+
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WithArgs("deploy-lock").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns).AddRow(1, "deploy-lock", rl.owner, []byte{1}, "", time.Now(), time.Now()))
+
+		_, err = rl.Status("DEPLOY-LOCK")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("treats names differing only by case as duplicates", func() {
+		_, err := rl.LockAll(context.Background(), "Deploy-Lock", "deploy-lock")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("normalizes pool names", func() {
+		pool, err := rl.NewPool(" Runners", 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool.Name()).To(Equal("runners"))
+	})
+})
@@ -0,0 +1,177 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// Notification describes a notable lock event (see NotifyEvents) delivered
+// to a Notifier.
+type Notification struct {
+	Type EventType `json:"type"`
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+
+	// The RLock instance the event happened in (ie. the new holder on
+	// takeovers)
+	Owner string `json:"owner"`
+	Host  string `json:"host"`
+	PID   int    `json:"pid"`
+
+	// Human readable details (ie. why a lock was deemed stale)
+	Details string `json:"details"`
+
+	// The lock row as it was before the event, if known; on takeovers and
+	// reaps its Owner, Host and PID point at the (probably wedged) process
+	// that lost the lock, on wait SLA breaches at the current holder
+	Lock *LockEntry `json:"lock,omitempty"`
+}
+
+// Notifier delivers notifications to humans (ie. via Slack or PagerDuty); see
+// WithNotifier. Notify is called from its own goroutine and errors are only
+// logged.
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+const (
+	// EventTimeout is delivered to notifiers when an acquisition gives up
+	// waiting for a lock (AcquireTimeoutErr or MaxAttemptsErr)
+	EventTimeout EventType = "timeout"
+
+	// EventLongHold is delivered to notifiers when a lock that was held for
+	// longer than the long hold threshold (see WithLongHoldThreshold) is
+	// unlocked
+	EventLongHold EventType = "long_hold"
+)
+
+// NotifyEvents are the event classes notifiers can be registered for.
+var NotifyEvents = []EventType{EventTakeover, EventTimeout, EventLongHold, EventReaped, EventWaitSLA}
+
+type notifierSub struct {
+	notifier Notifier
+	events   map[EventType]bool
+}
+
+// WithNotifier delivers the given classes of events (any of NotifyEvents;
+// all of them if none are given) to n. May be used more than once.
+func WithNotifier(n Notifier, events ...EventType) Option {
+	return func(r *RLock) error {
+		if n == nil {
+			return fmt.Errorf("notifier cannot be nil")
+		}
+
+		if len(events) == 0 {
+			events = NotifyEvents
+		}
+
+		sub := &notifierSub{
+			notifier: n,
+			events:   make(map[EventType]bool, len(events)),
+		}
+
+		for _, event := range events {
+			if !isNotifyEvent(event) {
+				return fmt.Errorf("unsupported notification event '%v'", event)
+			}
+
+			sub.events[event] = true
+		}
+
+		r.notifiers = append(r.notifiers, sub)
+
+		return nil
+	}
+}
+
+// WithLongHoldThreshold makes unlocking a lock that was held for longer than
+// threshold deliver an EventLongHold notification. Holds that never end are
+// reported as takeovers or reaps instead.
+func WithLongHoldThreshold(threshold time.Duration) Option {
+	return func(r *RLock) error {
+		if threshold <= 0 {
+			return fmt.Errorf("long hold threshold must be positive")
+		}
+
+		r.longHold = threshold
+
+		return nil
+	}
+}
+
+func isNotifyEvent(event EventType) bool {
+	for _, e := range NotifyEvents {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *RLock) notify(eventType EventType, name string, entry *LockEntry, details string) {
+	if len(r.notifiers) == 0 {
+		return
+	}
+
+	n := &Notification{
+		Type:    eventType,
+		Name:    name,
+		Time:    r.clock.Now(),
+		Owner:   r.owner,
+		Host:    r.host,
+		PID:     r.pid,
+		Details: details,
+		Lock:    entry,
+	}
+
+	for _, sub := range r.notifiers {
+		if !sub.events[eventType] {
+			continue
+		}
+
+		go func(notifier Notifier) {
+			if err := notifier.Notify(n); err != nil {
+				withError(r.logFor(name), err).WithFields(golog.Fields{"event": string(eventType)}).Warn("unable to deliver notification")
+			}
+		}(sub.notifier)
+	}
+}
+
+// LogNotifier is a Notifier logging notifications as warnings.
+type LogNotifier struct {
+	logger golog.Logger
+}
+
+// NewLogNotifier returns a Notifier logging to logger (rlock's own logger if
+// nil).
+func NewLogNotifier(logger golog.Logger) *LogNotifier {
+	if logger == nil {
+		logger = log
+	}
+
+	return &LogNotifier{logger: logger}
+}
+
+func (l *LogNotifier) Notify(n *Notification) error {
+	fields := golog.Fields{
+		"event":   n.Type,
+		"lock":    n.Name,
+		"owner":   n.Owner,
+		"host":    n.Host,
+		"pid":     n.PID,
+		"details": n.Details,
+	}
+
+	if n.Lock != nil {
+		fields["previous_owner"] = n.Lock.Owner
+		fields["previous_host"] = n.Lock.Host
+		fields["previous_pid"] = n.Lock.PID
+	}
+
+	l.logger.WithFields(fields).Warn("lock notification")
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package rlock
+
+import (
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type chanNotifier chan *Notification
+
+func (c chanNotifier) Notify(n *Notification) error {
+	c <- n
+	return nil
+}
+
+var _ = Describe("WithNotifier", func() {
+	var (
+		mock     sqlmock.Sqlmock
+		rl       *RLock
+		notified chanNotifier
+		clock    *FakeClock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		notified = make(chanNotifier, 10)
+		clock = NewFakeClock(time.Now())
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock),
+			WithNotifier(notified, EventTimeout, EventLongHold, EventReaped), WithLongHoldThreshold(time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates its options", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithNotifier(nil))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithNotifier(notified, EventAcquired))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithLongHoldThreshold(0))
+		Expect(err).To(HaveOccurred())
+
+		// All event classes by default
+		rl, err := New(db, WithNotifier(notified))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rl.notifiers[0].events).To(HaveLen(len(NotifyEvents)))
+	})
+
+	It("notifies about timeouts", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+
+		_, err := rl.Lock("foo", 0)
+		Expect(err).To(Equal(AcquireTimeoutErr))
+
+		var n *Notification
+		Eventually(notified).Should(Receive(&n))
+
+		Expect(n.Type).To(Equal(EventTimeout))
+		Expect(n.Name).To(Equal("foo"))
+		Expect(n.Owner).To(Equal(rl.owner))
+	})
+
+	It("notifies about long holds", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		clock.Advance(2 * time.Minute)
+
+		Expect(l.Unlock(nil)).To(Succeed())
+
+		var n *Notification
+		Eventually(notified).Should(Receive(&n))
+
+		Expect(n.Type).To(Equal(EventLongHold))
+		Expect(n.Details).To(Equal("held for 2m0s (threshold 1m0s)"))
+	})
+
+	It("does not notify about short holds", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Unlock(nil)).To(Succeed())
+
+		Consistently(notified, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("notifies about reaped locks", func() {
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "wedged-owner", []byte{1}, "", clock.Now().Add(-2*MaxAge), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.ReapStale(MaxAge)
+		Expect(err).ToNot(HaveOccurred())
+
+		var n *Notification
+		Eventually(notified).Should(Receive(&n))
+
+		Expect(n.Type).To(Equal(EventReaped))
+		Expect(n.Lock.Owner).To(Equal("wedged-owner"))
+	})
+
+	It("only delivers the configured event classes", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", clock.Now().Add(-2*MaxAge), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Consistently(notified, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("logs notifications with NewLogNotifier", func() {
+		var logged golog.Fields
+
+		logger := &recordingLogger{record: func(f golog.Fields) { logged = f }}
+
+		Expect(NewLogNotifier(logger).Notify(&Notification{Type: EventTakeover, Name: "foo"})).To(Succeed())
+		Expect(logged).To(HaveKeyWithValue("event", EventTakeover))
+		Expect(logged).To(HaveKeyWithValue("lock", "foo"))
+	})
+})
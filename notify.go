@@ -0,0 +1,84 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReleaseNotifier delivers lock release notifications between processes (ie.
+// via Redis pub/sub) so contended acquisitions wake up as soon as a lock is
+// released instead of waiting for the next poll. Lock state always stays in
+// the DB; notifications are only a hint and polling continues regardless.
+type ReleaseNotifier interface {
+	// NotifyRelease is called after a lock has been released.
+	NotifyRelease(name string) error
+
+	// Subscribe returns a channel that receives a value every time the lock
+	// is released, plus a func to cancel the subscription. The channel should
+	// be closed if the subscription breaks (rlock falls back to polling).
+	Subscribe(name string) (<-chan struct{}, func(), error)
+}
+
+// WithReleaseNotifier enables release notifications via n.
+func WithReleaseNotifier(n ReleaseNotifier) Option {
+	return func(r *RLock) error {
+		if n == nil {
+			return fmt.Errorf("notifier cannot be nil")
+		}
+
+		r.notifier = n
+
+		return nil
+	}
+}
+
+func (r *RLock) notifyRelease(name string) {
+	if r.notifier == nil {
+		return
+	}
+
+	if err := r.notifier.NotifyRelease(name); err != nil {
+		withError(r.logFor(name), err).Warn("unable to publish release notification")
+	}
+}
+
+// subscribeReleases returns a channel receiving release notifications for
+// name; the channel is nil (ie. never fires) if there is no notifier or the
+// subscription failed.
+func (r *RLock) subscribeReleases(name string) (<-chan struct{}, func()) {
+	if r.notifier == nil {
+		return nil, func() {}
+	}
+
+	released, cancel, err := r.notifier.Subscribe(name)
+	if err != nil {
+		withError(r.logFor(name), err).Warn("unable to subscribe to release notifications, falling back to polling")
+		return nil, func() {}
+	}
+
+	return released, cancel
+}
+
+// waitForRelease sleeps for wait, until a release notification is received or
+// until ctx is done (in which case ctx.Err() is returned). Returns the channel
+// to wait on next time (nil once the subscription broke).
+func (r *RLock) waitForRelease(ctx context.Context, released <-chan struct{}, wait time.Duration) (<-chan struct{}, error) {
+	defer annotateWait(ctx, "rlock.wait")()
+
+	poll := r.clock.NewTimer(wait)
+	defer poll.Stop()
+
+	select {
+	case <-poll.C():
+	case <-ctx.Done():
+		return released, ctx.Err()
+	case _, ok := <-released:
+		if !ok {
+			r.logger.Warn("release notification channel closed, falling back to polling")
+			return nil, nil
+		}
+	}
+
+	return released, nil
+}
@@ -0,0 +1,120 @@
+package rlock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type fakeNotifier struct {
+	mu           sync.Mutex
+	notified     []string
+	subscribeErr error
+	released     chan struct{}
+}
+
+func (f *fakeNotifier) NotifyRelease(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.notified = append(f.notified, name)
+
+	return nil
+}
+
+func (f *fakeNotifier) Subscribe(name string) (<-chan struct{}, func(), error) {
+	if f.subscribeErr != nil {
+		return nil, nil, f.subscribeErr
+	}
+
+	return f.released, func() {}, nil
+}
+
+var _ = Describe("ReleaseNotifier", func() {
+	var (
+		mock     sqlmock.Sqlmock
+		rl       *RLock
+		notifier *fakeNotifier
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		notifier = &fakeNotifier{released: make(chan struct{}, 1)}
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithReleaseNotifier(notifier))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	expectContended := func(name string) {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, name, "other-owner", []byte{1}, "", time.Now(), time.Now()))
+	}
+
+	It("rejects a nil notifier", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithReleaseNotifier(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("publishes a notification on unlock", func() {
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l := &Lock{rl: rl, name: "foo"}
+		Expect(l.Unlock(nil)).To(Succeed())
+
+		Expect(notifier.notified).To(Equal([]string{"foo"}))
+	})
+
+	It("attempts a takeover as soon as a release is announced", func() {
+		expectContended("foo")
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		notifier.released <- struct{}{}
+
+		start := time.Now()
+
+		l, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l).ToNot(BeNil())
+		Expect(time.Since(start)).To(BeNumerically("<", PollInterval))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("falls back to polling when the subscription breaks", func() {
+		expectContended("foo")
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		close(notifier.released)
+
+		l, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l).ToNot(BeNil())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("falls back to polling when subscribing fails", func() {
+		notifier.subscribeErr = fmt.Errorf("redis is down")
+
+		expectContended("foo")
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l).ToNot(BeNil())
+	})
+})
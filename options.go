@@ -0,0 +1,26 @@
+package rlock
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Option configures an RLock instance; see New().
+type Option func(*RLock) error
+
+var validTableName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// WithTableName overrides the name of the table locks are stored in (defaults
+// to TableName).
+func WithTableName(name string) Option {
+	return func(r *RLock) error {
+		// The table name is interpolated into queries; be strict
+		if !validTableName.MatchString(name) {
+			return fmt.Errorf("invalid table name '%v'", name)
+		}
+
+		r.table = name
+
+		return nil
+	}
+}
@@ -0,0 +1,35 @@
+package rlock
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Options", func() {
+	Describe("WithTableName", func() {
+		It("overrides the lock table", func() {
+			db, mock, _ := setupMocks()
+
+			rl, err := New(db, WithTableName("custom_locks"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rl.table).To(Equal("custom_locks"))
+
+			mock.ExpectExec(`INSERT INTO custom_locks`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			_, err = rl.Lock("foo", 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("rejects table names that are not plain identifiers", func() {
+			db, _, _ := setupMocks()
+
+			rl, err := New(db, WithTableName("locks; DROP TABLE users"))
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid table name"))
+			Expect(rl).To(BeNil())
+		})
+	})
+})
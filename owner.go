@@ -0,0 +1,141 @@
+package rlock
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Size of the owner column
+const maxOwnerLength = 255
+
+// WithOwnerID makes the RLock acquire locks as owner (ie.
+// "orders-service/pod-abc123") rather than as a generated ID (see
+// WithIDGenerator), so that its locks can be recognized across restarts and
+// by operators. Every process sharing the lock table must use a different
+// owner; as MySQL compares owners case-insensitively, owners differing only
+// in case are the same owner. Owners cannot be longer than 255 bytes or
+// contain whitespace or control characters.
+func WithOwnerID(owner string) Option {
+	return func(r *RLock) error {
+		if err := validateOwner(owner); err != nil {
+			return err
+		}
+
+		r.ownerID = owner
+
+		return nil
+	}
+}
+
+// validateOwner checks that owner fits the owner column and compares equal
+// to itself only (MySQL ignores trailing spaces when comparing).
+func validateOwner(owner string) error {
+	if owner == "" {
+		return fmt.Errorf("owner cannot be empty")
+	}
+
+	if len(owner) > maxOwnerLength {
+		return fmt.Errorf("owner cannot be longer than %d bytes", maxOwnerLength)
+	}
+
+	if !utf8.ValidString(owner) {
+		return fmt.Errorf("owner must be valid UTF-8")
+	}
+
+	if strings.IndexFunc(owner, func(c rune) bool { return unicode.IsSpace(c) || unicode.IsControl(c) }) >= 0 {
+		return fmt.Errorf("owner cannot contain whitespace or control characters")
+	}
+
+	return nil
+}
+
+// WithOwner returns an RLock sharing this one's DB handle and configuration
+// but acquiring locks as owner, ie. for processes hosting several logical
+// workers whose holds must be told apart (see GetLocksByOwner()). Locks held
+// by either are contended on like those of any other owner. The derived
+// RLock keeps its own held locks, event subscriptions (see Subscribe()),
+// in-process mutexes (see WithLocalMutex), stats and pinned connections;
+// closing it leaves the shared DB handle open.
+func (r *RLock) WithOwner(owner string) (*RLock, error) {
+	if err := validateOwner(owner); err != nil {
+		return nil, err
+	}
+
+	derived := &RLock{
+		db:     r.db,
+		owner:  owner,
+		table:  r.table,
+		ownsDB: false,
+
+		host: r.host,
+		pid:  r.pid,
+
+		failover: failover{
+			enabled:    r.failover.enabled,
+			flushConns: r.failover.flushConns,
+		},
+		notifier:    r.notifier,
+		clock:       r.clock,
+		polling:     r.polling,
+		statusCache: r.statusCache,
+		replica:     r.replica,
+		replicaLag:  r.replicaLag,
+		pollJitter:  r.pollJitter,
+		retry:       r.retry,
+		pool:        r.pool,
+		metrics:     r.metrics,
+		audit:       r.audit,
+		notifiers:   r.notifiers,
+
+		statementTimeout:  r.statementTimeout,
+		slowOpThreshold:   r.slowOpThreshold,
+		longHold:          r.longHold,
+		localMutex:        r.localMutex,
+		trackWaiters:      r.trackWaiters,
+		queuedHandoff:     r.queuedHandoff,
+		priorityAging:     r.priorityAging,
+		ownerQuota:        r.ownerQuota,
+		auditPartitioned:  r.auditPartitioned,
+		strictSchema:      r.strictSchema,
+		maxLastError:      r.maxLastError,
+		redactLastError:   r.redactLastError,
+		correlationIDs:    r.correlationIDs,
+		correlationID:     r.correlationID,
+		ownerLabels:       r.ownerLabels,
+		environment:       r.environment,
+		sessionLocks:      r.sessionLocks,
+		softDelete:        r.softDelete,
+		archive:           r.archive,
+		totals:            r.totals,
+		onLockLost:        r.onLockLost,
+		heartbeat:         r.heartbeat,
+		batchedHeartbeats: r.batchedHeartbeats,
+		stripes:           r.stripes,
+		maxPollers:        r.maxPollers,
+		leaderHeartbeat:   r.leaderHeartbeat,
+
+		lockAllParallelism: r.lockAllParallelism,
+		shards:             r.shards,
+		externalMigrations: r.externalMigrations,
+		nameNormalization:  r.nameNormalization,
+
+		takeoverPolicy:   r.takeoverPolicy,
+		noForcedTakeover: r.noForcedTakeover,
+		staleOverrides:   r.staleOverrides,
+		takeoverVeto:     r.takeoverVeto,
+		waitSLAs:         r.waitSLAs,
+
+		idGenerator: r.idGenerator,
+		logBase:     r.logBase,
+		logLevel:    r.logLevel,
+		logSampling: r.logSampling,
+
+		held: make(map[string]*Lock),
+	}
+
+	derived.configureLogger()
+
+	return derived, nil
+}
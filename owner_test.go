@@ -0,0 +1,101 @@
+package rlock
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithOwner", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithEnvironment("staging"), WithAuditLog())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the owner", func() {
+		_, err := rl.WithOwner("")
+		Expect(err).To(HaveOccurred())
+
+		_, err = rl.WithOwner(strings.Repeat("x", 256))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("shares the DB handle and configuration", func() {
+		worker, err := rl.WithOwner("worker-1")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(worker.Owner()).To(Equal("worker-1"))
+		Expect(worker.db).To(BeIdenticalTo(rl.db))
+		Expect(worker.Environment()).To(Equal("staging"))
+		Expect(worker.audit).To(BeTrue())
+		Expect(worker.ownsDB).To(BeFalse())
+		Expect(rl.Owner()).ToNot(Equal("worker-1"))
+	})
+
+	It("acquires locks as the derived owner", func() {
+		worker, err := rl.WithOwner("worker-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock ").
+			WithArgs("staging:foo", "worker-1", rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO rlock_audit").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := worker.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(worker.heldLocks()).To(ConsistOf(l))
+		Expect(rl.heldLocks()).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("WithOwnerID", func() {
+	It("acquires as the given owner", func() {
+		db, mock, _ := setupMocks()
+
+		rl, err := New(db, WithOwnerID("orders-service/pod-abc123"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rl.Owner()).To(Equal("orders-service/pod-abc123"))
+
+		mock.ExpectExec("INSERT INTO rlock ").
+			WithArgs("foo", "orders-service/pod-abc123", rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err = rl.Lock("foo", time.Second)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("takes precedence over the ID generator", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db, WithOwnerID("pod-a"), WithIDGenerator(UUIDGenerator))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rl.Owner()).To(Equal("pod-a"))
+	})
+
+	It("rejects owners that would not compare as given", func() {
+		db, _, _ := setupMocks()
+
+		for _, owner := range []string{"", strings.Repeat("x", 256), "pod-a ", "pod a", "pod-a\n", "pod-\xff"} {
+			_, err := New(db, WithOwnerID(owner))
+			Expect(err).To(HaveOccurred(), owner)
+		}
+	})
+})
@@ -0,0 +1,202 @@
+package rlock
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const auditPartitionedSchemaDDL = "CREATE TABLE IF NOT EXISTS `%v` (\n" +
+	"  `id` BIGINT NOT NULL AUTO_INCREMENT,\n" +
+	"  `name` VARCHAR(255) NOT NULL,\n" +
+	"  `owner` VARCHAR(255) NOT NULL,\n" +
+	"  `host` VARCHAR(255) NOT NULL DEFAULT '',\n" +
+	"  `pid` INT NOT NULL DEFAULT 0,\n" +
+	"  `acquired_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"  `released_at` TIMESTAMP NULL DEFAULT NULL,\n" +
+	"  `exit_status` VARCHAR(32) NOT NULL DEFAULT '',\n" +
+	"  `last_error` VARCHAR(2048) NOT NULL DEFAULT '',\n" +
+	"%v" +
+	"  PRIMARY KEY (`id`, `acquired_at`),\n" +
+	"  KEY `name_id` (`name`, `id`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4\n" +
+	"PARTITION BY RANGE (UNIX_TIMESTAMP(`acquired_at`)) (\n" +
+	"  PARTITION `" + auditCatchAllPartition + "` VALUES LESS THAN MAXVALUE\n" +
+	")"
+
+// Partition holding audit entries newer than the last daily partition
+const auditCatchAllPartition = "pmax"
+
+// Daily audit partitions are named after the (UTC) day they hold
+const auditPartitionLayout = "p20060102"
+
+// AuditPartition is a daily partition of a partitioned audit table (see
+// WithPartitionedAuditLog); it holds the holds acquired before Before.
+type AuditPartition struct {
+	Name   string
+	Before time.Time
+}
+
+// WithPartitionedAuditLog is WithAuditLog with the audit table partitioned by
+// day (of acquired_at; see PartitionedAuditSchema()), so that old history can
+// be dropped a partition at a time (see DropAuditPartitions()) instead of
+// deleting rows, keeping the audit log fast at scale. Daily partitions have to
+// be created ahead of time with AddAuditPartitions(), ie. from a periodic job;
+// until then, entries land in a catch-all partition.
+//
+// Existing (unpartitioned) audit tables are not converted by EnsureSchema().
+func WithPartitionedAuditLog() Option {
+	return func(r *RLock) error {
+		r.audit = true
+		r.auditPartitioned = true
+
+		return nil
+	}
+}
+
+// PartitionedAuditSchema returns the MySQL DDL creating a partitioned audit
+// table for a lock table called table (see WithPartitionedAuditLog).
+func PartitionedAuditSchema(table string) string {
+	return fmt.Sprintf(auditPartitionedSchemaDDL, table+"_audit", columnDDL(auditSchemaColumns))
+}
+
+// AuditPartitions returns the daily partitions of the audit table, oldest
+// first.
+func (r *RLock) AuditPartitions() ([]*AuditPartition, error) {
+	if !r.audit {
+		return nil, AuditDisabledErr
+	}
+
+	query := "SELECT partition_name AS partition_name, partition_description AS partition_description " +
+		"FROM information_schema.partitions WHERE table_schema=DATABASE() AND table_name=? AND partition_name IS NOT NULL"
+
+	ctx, cancel := r.statementContext()
+	defer cancel()
+
+	rows, err := r.selectStrings(ctx, 2, query, r.auditTable())
+	if err != nil {
+		return nil, fmt.Errorf("unable to list partitions of '%v': %v", r.auditTable(), err)
+	}
+
+	partitions := make([]*AuditPartition, 0, len(rows))
+	catchAll := false
+
+	for _, row := range rows {
+		name, description := row[0], row[1]
+
+		if name == auditCatchAllPartition {
+			catchAll = true
+			continue
+		}
+
+		before, err := strconv.ParseInt(description, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected bound '%v' of partition '%v'", description, name)
+		}
+
+		partitions = append(partitions, &AuditPartition{Name: name, Before: time.Unix(before, 0).UTC()})
+	}
+
+	if !catchAll {
+		return nil, fmt.Errorf("audit table '%v' is not partitioned (see WithPartitionedAuditLog)", r.auditTable())
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].Before.Before(partitions[j].Before)
+	})
+
+	return partitions, nil
+}
+
+// AddAuditPartitions creates the daily audit partitions needed to hold the
+// holds acquired within the next ahead (ie. a week), returning the names of
+// the partitions it created. Run it more often than ahead.
+func (r *RLock) AddAuditPartitions(ahead time.Duration) ([]string, error) {
+	if ahead < 0 {
+		return nil, fmt.Errorf("ahead cannot be negative")
+	}
+
+	partitions, err := r.AuditPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries acquired before the first partition go to the first partition
+	next := truncateDay(r.clock.Now())
+	if len(partitions) > 0 {
+		next = partitions[len(partitions)-1].Before
+	}
+
+	until := r.clock.Now().Add(ahead)
+
+	added := make([]string, 0)
+	defs := make([]string, 0)
+
+	for !next.After(until) {
+		name := next.Format(auditPartitionLayout)
+		next = next.AddDate(0, 0, 1)
+
+		added = append(added, name)
+		defs = append(defs, fmt.Sprintf("PARTITION `%v` VALUES LESS THAN (%d)", name, next.Unix()))
+	}
+
+	if len(added) == 0 {
+		return added, nil
+	}
+
+	defs = append(defs, fmt.Sprintf("PARTITION `%v` VALUES LESS THAN MAXVALUE", auditCatchAllPartition))
+
+	query := fmt.Sprintf("ALTER TABLE `%v` REORGANIZE PARTITION `%v` INTO (%v)",
+		r.auditTable(), auditCatchAllPartition, strings.Join(defs, ", "))
+
+	if _, err := r.exec(query); err != nil {
+		return nil, fmt.Errorf("unable to add partitions to '%v': %v", r.auditTable(), err)
+	}
+
+	return added, nil
+}
+
+// DropAuditPartitions drops the daily audit partitions holding only holds
+// acquired more than olderThan ago, returning the names of the dropped
+// partitions. Unlike deleting rows, dropping a partition is near instant.
+func (r *RLock) DropAuditPartitions(olderThan time.Duration) ([]string, error) {
+	if olderThan < 0 {
+		return nil, fmt.Errorf("olderThan cannot be negative")
+	}
+
+	partitions, err := r.AuditPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := r.clock.Now().Add(-olderThan)
+	dropped := make([]string, 0)
+
+	for _, p := range partitions {
+		if p.Before.After(cutoff) {
+			break
+		}
+
+		dropped = append(dropped, p.Name)
+	}
+
+	if len(dropped) == 0 {
+		return dropped, nil
+	}
+
+	query := fmt.Sprintf("ALTER TABLE `%v` DROP PARTITION `%v`", r.auditTable(), strings.Join(dropped, "`, `"))
+
+	if _, err := r.exec(query); err != nil {
+		return nil, fmt.Errorf("unable to drop partitions of '%v': %v", r.auditTable(), err)
+	}
+
+	return dropped, nil
+}
+
+// truncateDay returns the start of the (UTC) day t falls on.
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,133 @@
+package rlock
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Audit partitions", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		rl    *RLock
+		clock *FakeClock
+	)
+
+	day := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02", s)
+		Expect(err).ToNot(HaveOccurred())
+
+		return t
+	}
+
+	partitionRows := func(days ...string) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"PARTITION_NAME", "PARTITION_DESCRIPTION"}).
+			AddRow("pmax", "MAXVALUE")
+
+		for _, d := range days {
+			rows.AddRow(day(d).AddDate(0, 0, -1).Format(auditPartitionLayout), day(d).Unix())
+		}
+
+		return rows
+	}
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		clock = NewFakeClock(day("2026-10-16").Add(13 * time.Hour))
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock), WithPartitionedAuditLog())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("creates a partitioned audit table in EnsureSchema", func() {
+		Expect(rl.audit).To(BeTrue())
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock_audit`.*PARTITION BY RANGE").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT column_name").WithArgs("rlock").
+			WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow("acquired_at").AddRow("acquire_count").
+				AddRow("host").AddRow("pid").AddRow("takeover_count").AddRow("timeout_count").AddRow("metadata").AddRow("correlation_id").AddRow("deleted_at").AddRow("owner_labels").AddRow("checkpoint"))
+		mock.ExpectQuery("SELECT column_name").WithArgs("rlock_audit").
+			WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow("acquire_mode").AddRow("previous_owner").AddRow("evidence").AddRow("correlation_id"))
+		expectSchemaVersion(mock, SchemaVersion)
+
+		Expect(rl.EnsureSchema()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("lists the daily partitions oldest first", func() {
+		mock.ExpectQuery("SELECT partition_name AS partition_name, partition_description AS partition_description FROM information_schema.partitions").
+			WithArgs("rlock_audit").WillReturnRows(partitionRows("2026-10-16", "2026-10-15"))
+
+		partitions, err := rl.AuditPartitions()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(partitions).To(Equal([]*AuditPartition{
+			{Name: "p20261014", Before: day("2026-10-15")},
+			{Name: "p20261015", Before: day("2026-10-16")},
+		}))
+	})
+
+	It("refuses unpartitioned audit tables", func() {
+		mock.ExpectQuery("SELECT partition_name").WillReturnRows(
+			sqlmock.NewRows([]string{"PARTITION_NAME", "PARTITION_DESCRIPTION"}))
+
+		_, err := rl.AuditPartitions()
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("not partitioned"))
+	})
+
+	It("adds the partitions missing ahead of time", func() {
+		mock.ExpectQuery("SELECT partition_name").WillReturnRows(partitionRows("2026-10-16"))
+		mock.ExpectExec("ALTER TABLE `rlock_audit` REORGANIZE PARTITION `pmax` INTO \\(" +
+			"PARTITION `p20261016` VALUES LESS THAN \\(1792195200\\), " +
+			"PARTITION `p20261017` VALUES LESS THAN \\(1792281600\\), " +
+			"PARTITION `pmax` VALUES LESS THAN MAXVALUE\\)").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		added, err := rl.AddAuditPartitions(24 * time.Hour)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(added).To(Equal([]string{"p20261016", "p20261017"}))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("does nothing when the partitions exist already", func() {
+		mock.ExpectQuery("SELECT partition_name").WillReturnRows(partitionRows("2026-10-17", "2026-10-18"))
+
+		added, err := rl.AddAuditPartitions(time.Hour)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(added).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("drops partitions holding only old entries", func() {
+		mock.ExpectQuery("SELECT partition_name").WillReturnRows(partitionRows("2026-10-14", "2026-10-15", "2026-10-16"))
+		mock.ExpectExec("ALTER TABLE `rlock_audit` DROP PARTITION `p20261013`, `p20261014`$").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		dropped, err := rl.DropAuditPartitions(36 * time.Hour)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dropped).To(Equal([]string{"p20261013", "p20261014"}))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("requires the audit log", func() {
+		db, _, _ := setupMocks()
+
+		plain, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = plain.AddAuditPartitions(time.Hour)
+		Expect(err).To(Equal(AuditDisabledErr))
+	})
+})
@@ -0,0 +1,213 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pool hands out up to size permits named after the pool, ie. to allow at
+// most size concurrent runners of a job across all instances. Every permit
+// is backed by its own lock row ("<name>/permit-<n>"); see NewPool().
+type Pool struct {
+	rl     *RLock
+	name   string
+	prefix string
+	names  []string
+}
+
+// Permit is a permit checked out of a Pool; weighted permits (see
+// CheckoutN()) consist of several of the pool's permits.
+type Permit struct {
+	pool    *Pool
+	locks   []*Lock
+	indexes []int
+}
+
+// NewPool returns a pool of size permits called name. Pools with the same
+// name (and size) share their permits across instances.
+func (r *RLock) NewPool(name string, size int) (*Pool, error) {
+	return r.newPool(name, "/permit-", size)
+}
+
+// newPool returns a pool whose permits are named "<name><suffix><n>".
+func (r *RLock) newPool(name, suffix string, size int) (*Pool, error) {
+	name = r.normalizeName(name)
+
+	if name == "" {
+		return nil, fmt.Errorf("pool name cannot be empty")
+	}
+
+	if size <= 0 {
+		return nil, fmt.Errorf("pool size must be positive")
+	}
+
+	p := &Pool{
+		rl:     r,
+		name:   name,
+		prefix: name + suffix,
+		names:  make([]string, size),
+	}
+
+	for i := range p.names {
+		p.names[i] = fmt.Sprintf("%v%d", p.prefix, i)
+	}
+
+	return p, nil
+}
+
+// Name returns the name of the pool.
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Size returns how many permits the pool hands out.
+func (p *Pool) Size() int {
+	return len(p.names)
+}
+
+// Checkout waits up to timeout (see Lock()) or until ctx is done for a permit
+// to become available. Like any lock, a permit whose holder went away is
+// taken over once it goes stale (see MaxAge and Reclaim()); long running
+// holders should Refresh() theirs.
+func (p *Pool) Checkout(ctx context.Context, timeout time.Duration) (*Permit, error) {
+	l, err := p.rl.AcquireAny(ctx, p.names, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.permit([]*Lock{l})
+}
+
+// CheckoutN is Checkout() for a job weighing weight permits (ie. a heavy job
+// taking 4 of 10 slots). All of the permits are taken at once, in a single
+// transaction, so heavy jobs cannot starve each other (or deadlock) holding
+// part of the permits they need.
+func (p *Pool) CheckoutN(ctx context.Context, weight int, timeout time.Duration) (*Permit, error) {
+	if weight <= 0 || weight > len(p.names) {
+		return nil, fmt.Errorf("weight must be within [1, %d]", len(p.names))
+	}
+
+	if err := p.rl.CreateLocks(p.names...); err != nil {
+		return nil, err
+	}
+
+	// Free permits, and ones that went stale (which are taken over)
+	cond := fmt.Sprintf("name IN (%v) AND (in_use=0 OR last_used < ?)", strings.TrimSuffix(strings.Repeat("?, ", len(p.names)), ", "))
+	if p.rl.noForcedTakeover {
+		cond = fmt.Sprintf("name IN (%v) AND in_use=0", strings.TrimSuffix(strings.Repeat("?, ", len(p.names)), ", "))
+	}
+
+	start := p.rl.clock.Now()
+	deadline := start.Add(timeout)
+
+	for {
+		args := make([]interface{}, 0, len(p.names)+1)
+
+		for _, name := range p.names {
+			args = append(args, name)
+		}
+
+		if !p.rl.noForcedTakeover {
+			args = append(args, p.rl.clock.Now().Add(-MaxAge))
+		}
+
+		// Lock the rows in the order of the index to avoid deadlocks
+		locks, _, err := p.rl.claimN(ctx, weight, cond, "name", false, args...)
+		if err == nil {
+			return p.permit(locks)
+		}
+
+		if err != NothingToClaimErr {
+			return nil, err
+		}
+
+		wait := p.rl.jitter(p.rl.pollInterval(p.name, p.rl.clock.Now().Sub(start)), false)
+
+		if timeout >= 0 {
+			left := deadline.Sub(p.rl.clock.Now())
+			if left <= 0 {
+				return nil, AcquireTimeoutErr
+			}
+
+			if wait > left {
+				wait = left
+			}
+		}
+
+		if _, err := p.rl.waitForRelease(ctx, nil, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *Pool) permit(locks []*Lock) (*Permit, error) {
+	permit := &Permit{pool: p, locks: locks}
+
+	for _, l := range locks {
+		index := -1
+
+		for i, name := range p.names {
+			if name == l.name {
+				index = i
+				break
+			}
+		}
+
+		if index < 0 {
+			return nil, fmt.Errorf("acquired unexpected lock '%v'", l.name)
+		}
+
+		permit.indexes = append(permit.indexes, index)
+	}
+
+	return permit, nil
+}
+
+// Return hands the permit back to its pool, recording lastError (see
+// Lock.Unlock()).
+func (p *Pool) Return(permit *Permit, lastError error) error {
+	if permit.pool != p {
+		return fmt.Errorf("permit does not belong to pool '%v'", p.name)
+	}
+
+	if len(permit.locks) == 1 {
+		return permit.locks[0].Unlock(lastError)
+	}
+
+	return (&LockSet{locks: permit.locks}).Unlock(lastError)
+}
+
+// Reclaim releases permits whose holders have not used them for longer than
+// maxAge (see ReapStale()), returning the names of the reclaimed permits.
+func (p *Pool) Reclaim(maxAge time.Duration) ([]string, error) {
+	return p.rl.reapStale(maxAge, p.prefix)
+}
+
+// Index returns which of the pool's permits this is (0 to size - 1); for
+// weighted permits, the first of them (see Indexes()).
+func (p *Permit) Index() int {
+	return p.indexes[0]
+}
+
+// Indexes returns which of the pool's permits this permit consists of.
+func (p *Permit) Indexes() []int {
+	return p.indexes
+}
+
+// Weight returns how many of the pool's permits this permit consists of.
+func (p *Permit) Weight() int {
+	return len(p.locks)
+}
+
+// Refresh keeps the permit from going stale; see Lock.Refresh().
+func (p *Permit) Refresh() error {
+	for _, l := range p.locks {
+		if err := l.Refresh(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package rlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Pool", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+		pool *Pool
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+
+		var err error
+
+		pool, err = rl.NewPool("runners", 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.MatchExpectationsInOrder(false)
+	})
+
+	It("validates its arguments", func() {
+		_, err := rl.NewPool("", 2)
+		Expect(err).To(HaveOccurred())
+
+		_, err = rl.NewPool("runners", 0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("checks out a free permit and returns it", func() {
+		mock.ExpectExec("INSERT INTO").WithArgs("runners/permit-0", rl.owner, rl.host, rl.pid).
+			WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WithArgs("runners/permit-0").WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "runners/permit-0", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE").WithArgs(rl.owner, rl.host, rl.pid, "runners/permit-0", "other-owner").
+			WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("INSERT INTO").WithArgs("runners/permit-1", rl.owner, rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(2, 1))
+
+		permit, err := pool.Checkout(context.Background(), time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(permit.Index()).To(Equal(1))
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", "runners/permit-1", rl.owner).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(pool.Return(permit, nil)).To(Succeed())
+	})
+
+	It("refuses permits of other pools", func() {
+		other, err := rl.NewPool("other", 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(pool.Return(&Permit{pool: other}, nil)).ToNot(Succeed())
+	})
+
+	It("reclaims stale permits of the pool only", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE in_use=1 AND last_used < \? AND name LIKE \? ESCAPE '!'`).
+			WithArgs(sqlmock.AnyArg(), "runners/permit-%").
+			WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+				AddRow(1, "runners/permit-0", "wedged", []byte{1}, "", time.Now().Add(-2*time.Hour), time.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		reclaimed, err := pool.Reclaim(time.Hour)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reclaimed).To(Equal([]string{"runners/permit-0"}))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	Describe("CheckoutN", func() {
+		BeforeEach(func() {
+			mock.MatchExpectationsInOrder(true)
+		})
+
+		It("takes several permits at once", func() {
+			mock.ExpectExec("INSERT IGNORE INTO rlock").WithArgs("runners/permit-0", "runners/permit-1").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM rlock WHERE name IN \(\?, \?\) AND \(in_use=0 OR last_used < \?\) ORDER BY name LIMIT 2 FOR UPDATE`).
+				WithArgs("runners/permit-0", "runners/permit-1", sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+					AddRow(1, "runners/permit-0", "", []byte{0}, "", time.Now(), time.Now()).
+					AddRow(2, "runners/permit-1", "", []byte{0}, "", time.Now(), time.Now()))
+			mock.ExpectExec("UPDATE rlock SET owner").WithArgs(rl.owner, rl.host, rl.pid, "runners/permit-0").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE rlock SET owner").WithArgs(rl.owner, rl.host, rl.pid, "runners/permit-1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			permit, err := pool.CheckoutN(context.Background(), 2, time.Minute)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(permit.Weight()).To(Equal(2))
+			Expect(permit.Indexes()).To(Equal([]int{0, 1}))
+
+			mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", "runners/permit-0", rl.owner).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", "runners/permit-1", rl.owner).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Expect(pool.Return(permit, nil)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("takes none of the permits unless enough are free", func() {
+			mock.ExpectExec("INSERT IGNORE INTO rlock").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM rlock`).
+				WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+					AddRow(1, "runners/permit-0", "", []byte{0}, "", time.Now(), time.Now()))
+			mock.ExpectRollback()
+
+			_, err := pool.CheckoutN(context.Background(), 2, 0)
+
+			Expect(err).To(Equal(AcquireTimeoutErr))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("validates the weight", func() {
+			_, err := pool.CheckoutN(context.Background(), 3, time.Minute)
+			Expect(err).To(HaveOccurred())
+
+			_, err = pool.CheckoutN(context.Background(), 0, time.Minute)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,263 @@
+// Package pgnotify implements rlock.ReleaseNotifier on top of Postgres
+// LISTEN/NOTIFY, allowing waiters to wake up as soon as a lock is released
+// while lock state stays in MySQL.
+package pgnotify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultChannel is the Postgres notification channel releases are
+	// published on (with the lock name as payload).
+	DefaultChannel = "rlock_released"
+
+	// DefaultPublishTimeout is how long a release notification may take to
+	// publish unless WithPublishTimeout says otherwise.
+	DefaultPublishTimeout = time.Second
+
+	// MaxPendingPublishes is how many release notifications may be
+	// publishing at once; further ones are dropped until some are done.
+	MaxPendingPublishes = 1000
+
+	// How long the listener waits before reconnecting, doubling up to
+	// maxReconnectInterval
+	minReconnectInterval = 10 * time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// DisconnectedErr is returned by Subscribe while the listener is not
+// connected; rlock polls until it is again.
+var DisconnectedErr = errors.New("not connected to postgres")
+
+// listener is the part of *pq.Listener the notifier uses
+type listener interface {
+	Listen(channel string) error
+	NotificationChannel() <-chan *pq.Notification
+	Close() error
+}
+
+type Notifier struct {
+	db             *sql.DB
+	channel        string
+	publishTimeout time.Duration
+
+	// Holds a token per notification being published
+	pending chan struct{}
+
+	listener listener
+
+	mu        sync.Mutex
+	connected bool
+	closed    bool
+	subs      map[string]map[chan struct{}]bool
+}
+
+type Option func(*Notifier)
+
+// WithPublishTimeout sets how long a release notification may take to
+// publish (defaults to DefaultPublishTimeout).
+func WithPublishTimeout(timeout time.Duration) Option {
+	return func(n *Notifier) {
+		n.publishTimeout = timeout
+	}
+}
+
+// New returns a notifier publishing releases on channel via db and listening
+// for them on a dedicated connection to dsn (LISTEN needs a connection of its
+// own, which reconnects on its own). If channel is empty, DefaultChannel is
+// used. Subscriptions fail (and rlock polls) until the listener is connected.
+func New(db *sql.DB, dsn, channel string, opts ...Option) (*Notifier, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("dsn cannot be empty")
+	}
+
+	n, err := newNotifier(db, channel, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	n.listen(pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		n.event(event)
+	}))
+
+	return n, nil
+}
+
+func newNotifier(db *sql.DB, channel string, opts ...Option) (*Notifier, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db cannot be nil")
+	}
+
+	if channel == "" {
+		channel = DefaultChannel
+	}
+
+	n := &Notifier{
+		db:             db,
+		channel:        channel,
+		publishTimeout: DefaultPublishTimeout,
+		pending:        make(chan struct{}, MaxPendingPublishes),
+		subs:           make(map[string]map[chan struct{}]bool),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	if n.publishTimeout <= 0 {
+		return nil, fmt.Errorf("publish timeout must be positive")
+	}
+
+	return n, nil
+}
+
+// listen starts listening via l in the background (Listen() blocks until l is
+// connected).
+func (n *Notifier) listen(l listener) {
+	n.listener = l
+
+	go func() {
+		if err := l.Listen(n.channel); err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		n.connected = true
+		n.mu.Unlock()
+	}()
+
+	go n.dispatch()
+}
+
+// NotifyRelease publishes the release in the background, so that a slow or
+// unreachable Postgres does not hold up unlocking; notifications are only a
+// hint, so ones that fail to publish in time are dropped (waiters notice the
+// release on their next poll instead). An error is only returned when too
+// many notifications are publishing already.
+func (n *Notifier) NotifyRelease(name string) error {
+	select {
+	case n.pending <- struct{}{}:
+	default:
+		return fmt.Errorf("too many release notifications pending; dropping the one for '%v'", name)
+	}
+
+	go func() {
+		defer func() { <-n.pending }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), n.publishTimeout)
+		defer cancel()
+
+		n.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", n.channel, name)
+	}()
+
+	return nil
+}
+
+func (n *Notifier) Subscribe(name string) (<-chan struct{}, func(), error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return nil, nil, fmt.Errorf("notifier is closed")
+	}
+
+	if !n.connected {
+		return nil, nil, DisconnectedErr
+	}
+
+	released := make(chan struct{}, 1)
+
+	if n.subs[name] == nil {
+		n.subs[name] = make(map[chan struct{}]bool)
+	}
+
+	n.subs[name][released] = true
+
+	return released, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		// Dropped (and closed) already if the connection broke
+		if !n.subs[name][released] {
+			return
+		}
+
+		delete(n.subs[name], released)
+
+		if len(n.subs[name]) == 0 {
+			delete(n.subs, name)
+		}
+	}, nil
+}
+
+// Close stops listening; subscribers fall back to polling.
+func (n *Notifier) Close() error {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+
+	return n.listener.Close()
+}
+
+// dispatch wakes up the subscribers of every lock released until the listener
+// is closed.
+func (n *Notifier) dispatch() {
+	for notification := range n.listener.NotificationChannel() {
+		// Sent after reconnecting; notifications may have been lost, which
+		// subscribers already know about (see event)
+		if notification == nil {
+			continue
+		}
+
+		n.mu.Lock()
+
+		for released := range n.subs[notification.Extra] {
+			select {
+			case released <- struct{}{}:
+			default:
+			}
+		}
+
+		n.mu.Unlock()
+	}
+
+	n.drop()
+}
+
+// event tracks whether the listener is connected.
+func (n *Notifier) event(event pq.ListenerEventType) {
+	switch event {
+	case pq.ListenerEventReconnected:
+		// Channels are listened on again before reconnecting is reported
+		n.mu.Lock()
+		n.connected = true
+		n.mu.Unlock()
+	case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+		n.drop()
+	}
+}
+
+// drop closes the channels of every subscriber, making rlock fall back to
+// polling; releases would go unnoticed while the listener is not connected.
+func (n *Notifier) drop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.connected = false
+
+	for name, subs := range n.subs {
+		for released := range subs {
+			close(released)
+		}
+
+		delete(n.subs, name)
+	}
+}
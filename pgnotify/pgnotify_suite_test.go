@@ -0,0 +1,13 @@
+package pgnotify
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPGNotifySuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PGNotify Suite")
+}
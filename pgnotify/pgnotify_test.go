@@ -0,0 +1,145 @@
+package pgnotify
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// fakeListener hands out the notifications sent to it
+type fakeListener struct {
+	notifications chan *pq.Notification
+}
+
+func (l *fakeListener) Listen(channel string) error {
+	return nil
+}
+
+func (l *fakeListener) NotificationChannel() <-chan *pq.Notification {
+	return l.notifications
+}
+
+func (l *fakeListener) Close() error {
+	close(l.notifications)
+	return nil
+}
+
+var _ = Describe("Notifier", func() {
+	var (
+		mock sqlmock.Sqlmock
+		l    *fakeListener
+		n    *Notifier
+	)
+
+	BeforeEach(func() {
+		db, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		n, err = newNotifier(db, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		l = &fakeListener{notifications: make(chan *pq.Notification)}
+		n.listen(l)
+
+		Eventually(func() bool {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			return n.connected
+		}).Should(BeTrue())
+	})
+
+	It("validates its options", func() {
+		db, _, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = New(db, "", "")
+		Expect(err).To(HaveOccurred())
+
+		_, err = newNotifier(nil, "")
+		Expect(err).To(HaveOccurred())
+
+		_, err = newNotifier(db, "", WithPublishTimeout(0))
+		Expect(err).To(HaveOccurred())
+
+		Expect(n.channel).To(Equal(DefaultChannel))
+	})
+
+	It("publishes releases", func() {
+		mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).WithArgs(DefaultChannel, "foo").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(n.NotifyRelease("foo")).To(Succeed())
+
+		// Taking every token waits for the notification to be published
+		for i := 0; i < MaxPendingPublishes; i++ {
+			n.pending <- struct{}{}
+		}
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("wakes up the lock's subscribers when it is released", func() {
+		released, cancel, err := n.Subscribe("foo")
+		Expect(err).ToNot(HaveOccurred())
+		defer cancel()
+
+		other, cancelOther, err := n.Subscribe("bar")
+		Expect(err).ToNot(HaveOccurred())
+		defer cancelOther()
+
+		l.notifications <- &pq.Notification{Channel: DefaultChannel, Extra: "foo"}
+
+		Eventually(released).Should(Receive())
+		Consistently(other, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("forgets cancelled subscriptions", func() {
+		_, cancel, err := n.Subscribe("foo")
+		Expect(err).ToNot(HaveOccurred())
+
+		cancel()
+		cancel()
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		Expect(n.subs).To(BeEmpty())
+	})
+
+	It("falls back to polling while disconnected", func() {
+		released, cancel, err := n.Subscribe("foo")
+		Expect(err).ToNot(HaveOccurred())
+
+		n.event(pq.ListenerEventDisconnected)
+
+		Eventually(released).Should(BeClosed())
+		cancel()
+
+		_, _, err = n.Subscribe("foo")
+		Expect(err).To(Equal(DisconnectedErr))
+
+		n.event(pq.ListenerEventReconnected)
+
+		_, cancel, err = n.Subscribe("foo")
+		Expect(err).ToNot(HaveOccurred())
+		cancel()
+	})
+
+	It("closes subscriptions once closed", func() {
+		released, cancel, err := n.Subscribe("foo")
+		Expect(err).ToNot(HaveOccurred())
+		defer cancel()
+
+		Expect(n.Close()).To(Succeed())
+
+		Eventually(released).Should(BeClosed())
+
+		_, _, err = n.Subscribe("foo")
+		Expect(err).To(HaveOccurred())
+	})
+})
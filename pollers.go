@@ -0,0 +1,116 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// pollers bounds how many contended acquisitions of an RLock poll the DB at
+// the same time; see WithMaxPollers.
+type pollers struct {
+	once   sync.Once
+	slots  chan struct{}
+	mu     sync.Mutex
+	queued int
+}
+
+// WithMaxPollers caps how many contended acquisitions (ie. of locks held by
+// someone else) this RLock instance polls the DB for at the same time;
+// further ones queue in-process, without touching the DB, until a poller
+// finishes. Time spent queued counts towards the acquire timeout. This keeps
+// a single misbehaving service from flooding the shared DB with thousands
+// of pollers. Uncontended acquisitions are not limited.
+func WithMaxPollers(max int) Option {
+	return func(r *RLock) error {
+		if max <= 0 {
+			return fmt.Errorf("max pollers must be positive")
+		}
+
+		r.maxPollers = max
+
+		return nil
+	}
+}
+
+// Pollers returns how many contended acquisitions of this RLock are polling
+// the DB and how many are queued waiting to (see WithMaxPollers).
+func (r *RLock) Pollers() (active, queued int) {
+	if r.maxPollers == 0 {
+		return 0, 0
+	}
+
+	slots := r.pollerSlots()
+
+	r.pollers.mu.Lock()
+	defer r.pollers.mu.Unlock()
+
+	return len(slots), r.pollers.queued
+}
+
+func (r *RLock) pollerSlots() chan struct{} {
+	r.pollers.once.Do(func() {
+		r.pollers.slots = make(chan struct{}, r.maxPollers)
+	})
+
+	return r.pollers.slots
+}
+
+// enterPoller blocks until the contended acquisition of the lock called name
+// may poll the DB (see WithMaxPollers), deadline is reached (in which case
+// AcquireTimeoutErr is returned) or ctx is done; a negative remaining waits
+// forever. On success, it returns a func that must be called once the
+// acquisition stops polling.
+func (r *RLock) enterPoller(ctx context.Context, name string, deadline time.Time, remaining time.Duration) (func(), error) {
+	if r.maxPollers == 0 {
+		return func() {}, nil
+	}
+
+	slots := r.pollerSlots()
+
+	release := func() {
+		<-slots
+	}
+
+	// Fast path: there is room for another poller
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	r.pollers.mu.Lock()
+	r.pollers.queued++
+	queued := r.pollers.queued
+	r.pollers.mu.Unlock()
+
+	defer func() {
+		r.pollers.mu.Lock()
+		r.pollers.queued--
+		r.pollers.mu.Unlock()
+	}()
+
+	r.logFor(name).WithFields(golog.Fields{"max_pollers": r.maxPollers, "queued": queued}).
+		Debug("too many pollers; queueing")
+
+	var timeout <-chan time.Time
+
+	if remaining >= 0 {
+		timer := r.clock.NewTimer(deadline.Sub(r.clock.Now()))
+		defer timer.Stop()
+
+		timeout = timer.C()
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, AcquireTimeoutErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,114 @@
+package rlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithMaxPollers", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithMaxPollers(1))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the limit", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithMaxPollers(0))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not limit pollers by default", func() {
+		_, _, plain := setupMocks()
+
+		for i := 0; i < 10; i++ {
+			_, err := plain.enterPoller(context.Background(), "foo", time.Now(), 0)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		active, queued := plain.Pollers()
+		Expect(active).To(Equal(0))
+		Expect(queued).To(Equal(0))
+	})
+
+	It("queues pollers beyond the limit until one exits", func() {
+		exit, err := rl.enterPoller(context.Background(), "foo", time.Now(), WaitForever)
+		Expect(err).ToNot(HaveOccurred())
+
+		entered := make(chan struct{})
+
+		go func() {
+			defer GinkgoRecover()
+
+			exitSecond, err := rl.enterPoller(context.Background(), "bar", time.Now(), WaitForever)
+			Expect(err).ToNot(HaveOccurred())
+
+			close(entered)
+			exitSecond()
+		}()
+
+		Eventually(func() int {
+			_, queued := rl.Pollers()
+			return queued
+		}).Should(Equal(1))
+
+		Consistently(entered, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		exit()
+
+		Eventually(entered).Should(BeClosed())
+		Eventually(func() int {
+			active, _ := rl.Pollers()
+			return active
+		}).Should(Equal(0))
+	})
+
+	It("gives up queueing at the deadline or once the context is done", func() {
+		exit, err := rl.enterPoller(context.Background(), "foo", time.Now(), WaitForever)
+		Expect(err).ToNot(HaveOccurred())
+		defer exit()
+
+		_, err = rl.enterPoller(context.Background(), "bar", time.Now().Add(10*time.Millisecond), 10*time.Millisecond)
+		Expect(err).To(Equal(AcquireTimeoutErr))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err = rl.enterPoller(ctx, "bar", time.Now(), WaitForever)
+		Expect(err).To(Equal(context.Canceled))
+
+		_, queued := rl.Pollers()
+		Expect(queued).To(Equal(0))
+	})
+
+	It("does not poll the DB for contended locks while queued", func() {
+		exit, err := rl.enterPoller(context.Background(), "other", time.Now(), WaitForever)
+		Expect(err).ToNot(HaveOccurred())
+		defer exit()
+
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET timeout_count=timeout_count\+1`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err = rl.Lock("foo", 50*time.Millisecond)
+		Expect(err).To(Equal(AcquireTimeoutErr))
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
@@ -0,0 +1,146 @@
+package rlock
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// Waiters poll this many times per (estimated) hold time
+	adaptivePollsPerHold = 10
+
+	// Upper bound of lock names hold times are remembered for
+	adaptiveMaxTracked = 1024
+)
+
+type adaptivePolling struct {
+	min time.Duration
+	max time.Duration
+
+	mu    sync.Mutex
+	holds map[string]time.Duration
+}
+
+// WithAdaptivePolling replaces the fixed PollInterval with one derived from
+// how long each lock is typically held: waiters on long-held locks poll less
+// often (reducing DB load) and waiters on briefly held locks poll more often
+// (reducing latency). The interval always stays within [min, max].
+//
+// Hold times are learned from locks released by this instance and from how
+// long waiters in this instance had to wait.
+func WithAdaptivePolling(min, max time.Duration) Option {
+	return func(r *RLock) error {
+		if min <= 0 {
+			return fmt.Errorf("min poll interval must be positive")
+		}
+
+		if max < min {
+			return fmt.Errorf("max poll interval cannot be smaller than min")
+		}
+
+		r.polling = &adaptivePolling{
+			min:   min,
+			max:   max,
+			holds: make(map[string]time.Duration),
+		}
+
+		return nil
+	}
+}
+
+// WithPollJitter keeps waiters that started waiting at the same time (ie.
+// after a deploy) from polling in lockstep: every waiter sleeps for a random
+// part of the poll interval before its first retry, and every following
+// interval is randomized by up to +/- fraction of it.
+func WithPollJitter(fraction float64) Option {
+	return func(r *RLock) error {
+		if fraction <= 0 || fraction > 1 {
+			return fmt.Errorf("poll jitter must be within (0, 1]")
+		}
+
+		r.pollJitter = fraction
+
+		return nil
+	}
+}
+
+// jitter randomizes wait (see WithPollJitter()); first is whether it is the
+// waiter's first sleep.
+func (r *RLock) jitter(wait time.Duration, first bool) time.Duration {
+	if r.pollJitter == 0 || wait <= 0 {
+		return wait
+	}
+
+	if first {
+		return time.Duration(rand.Float64() * float64(wait))
+	}
+
+	return wait + time.Duration((rand.Float64()*2-1)*r.pollJitter*float64(wait))
+}
+
+// pollInterval returns how long a waiter for name that has already waited for
+// waited should sleep before trying again.
+func (r *RLock) pollInterval(name string, waited time.Duration) time.Duration {
+	if r.polling == nil {
+		return PollInterval
+	}
+
+	return r.polling.interval(name, waited)
+}
+
+// observeHold records that name was held for (at least) held.
+func (r *RLock) observeHold(name string, held time.Duration) {
+	if r.polling == nil {
+		return
+	}
+
+	r.polling.observe(name, held)
+}
+
+func (a *adaptivePolling) interval(name string, waited time.Duration) time.Duration {
+	a.mu.Lock()
+	hold := a.holds[name]
+	a.mu.Unlock()
+
+	// Having waited this long already means the lock is held for longer
+	if waited > hold {
+		hold = waited
+	}
+
+	interval := hold / adaptivePollsPerHold
+
+	if interval < a.min {
+		return a.min
+	}
+
+	if interval > a.max {
+		return a.max
+	}
+
+	return interval
+}
+
+func (a *adaptivePolling) observe(name string, held time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	prev, ok := a.holds[name]
+	if !ok {
+		// Forget an arbitrary lock rather than growing without bound
+		if len(a.holds) >= adaptiveMaxTracked {
+			for k := range a.holds {
+				delete(a.holds, k)
+				break
+			}
+		}
+
+		a.holds[name] = held
+
+		return
+	}
+
+	// Exponentially weighted moving average; recent holds count the most
+	a.holds[name] = (prev*3 + held) / 4
+}
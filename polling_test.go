@@ -0,0 +1,149 @@
+package rlock
+
+import (
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithAdaptivePolling", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		rl    *RLock
+		clock *FakeClock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		clock = NewFakeClock(time.Now())
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock), WithAdaptivePolling(100*time.Millisecond, 5*time.Second))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates bounds", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithAdaptivePolling(0, time.Second))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithAdaptivePolling(time.Second, time.Millisecond))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("uses PollInterval when disabled", func() {
+		db, _, _ := setupMocks()
+
+		r, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		r.observeHold("foo", time.Hour)
+		Expect(r.pollInterval("foo", time.Hour)).To(Equal(PollInterval))
+	})
+
+	It("derives the interval from observed hold times within bounds", func() {
+		Expect(rl.pollInterval("foo", 0)).To(Equal(100 * time.Millisecond))
+
+		rl.observeHold("foo", 20*time.Second)
+		Expect(rl.pollInterval("foo", 0)).To(Equal(2 * time.Second))
+
+		rl.observeHold("foo", 4*time.Second)
+		Expect(rl.pollInterval("foo", 0)).To(Equal(1600 * time.Millisecond))
+
+		rl.observeHold("bar", 10*time.Hour)
+		Expect(rl.pollInterval("bar", 0)).To(Equal(5 * time.Second))
+	})
+
+	It("lengthens the interval the longer a waiter has been waiting", func() {
+		Expect(rl.pollInterval("foo", 30*time.Second)).To(Equal(3 * time.Second))
+	})
+
+	It("learns hold times from released locks", func() {
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l := rl.newLock("foo", time.Second, 1)
+		clock.Advance(10 * time.Second)
+		Expect(l.Unlock(nil)).To(Succeed())
+
+		Expect(rl.pollInterval("foo", 0)).To(Equal(time.Second))
+	})
+
+	It("sleeps for the adapted interval while waiting", func() {
+		rl.observeHold("foo", 30*time.Second)
+
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		locks := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := rl.Lock("foo", WaitForever)
+			Expect(err).ToNot(HaveOccurred())
+
+			locks <- l
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		clock.Advance(2 * time.Second)
+		Consistently(locks, 50*time.Millisecond).ShouldNot(Receive())
+
+		clock.Advance(time.Second)
+		Eventually(locks).Should(Receive(Not(BeNil())))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("WithPollJitter", func() {
+	It("validates the fraction", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithPollJitter(0))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithPollJitter(1.5))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("leaves waits alone when disabled", func() {
+		_, _, rl := setupMocks()
+
+		Expect(rl.jitter(time.Second, true)).To(Equal(time.Second))
+		Expect(rl.jitter(time.Second, false)).To(Equal(time.Second))
+	})
+
+	It("spreads waits within bounds", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db, WithPollJitter(0.2))
+		Expect(err).ToNot(HaveOccurred())
+
+		firsts := make(map[time.Duration]bool)
+
+		for i := 0; i < 100; i++ {
+			first := rl.jitter(time.Second, true)
+			Expect(first).To(BeNumerically(">=", 0))
+			Expect(first).To(BeNumerically("<", time.Second))
+
+			firsts[first] = true
+
+			next := rl.jitter(time.Second, false)
+			Expect(next).To(BeNumerically(">=", 800*time.Millisecond))
+			Expect(next).To(BeNumerically("<=", 1200*time.Millisecond))
+		}
+
+		Expect(len(firsts)).To(BeNumerically(">", 1))
+	})
+})
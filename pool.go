@@ -0,0 +1,120 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// Connection pool defaults for DB handles owned by rlock (see NewFromDSN);
+	// lock traffic is light, a small dedicated pool is plenty
+	DefaultMaxOpenConns    = 4
+	DefaultMaxIdleConns    = 2
+	DefaultConnMaxLifetime = 5 * time.Minute
+)
+
+type pool struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+
+	// Set if any pool option was passed
+	configured bool
+}
+
+var defaultPool = pool{
+	maxOpenConns:    DefaultMaxOpenConns,
+	maxIdleConns:    DefaultMaxIdleConns,
+	connMaxLifetime: DefaultConnMaxLifetime,
+}
+
+// NewFromDSN opens a dedicated connection pool to the MySQL database
+// described by dsn and returns an RLock that owns it; call Close() to release
+// it. Keeping lock traffic in its own (small) pool keeps it from competing
+// with application queries; see WithMaxOpenConns, WithMaxIdleConns and
+// WithConnMaxLifetime to tune it.
+func NewFromDSN(dsn string, opts ...Option) (*RLock, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse dsn: %v", err)
+	}
+
+	// Required to scan timestamps into LockEntry
+	cfg.ParseTime = true
+
+	// Acquiring tells inserted rows from rows in use by the number of rows
+	// affected; see acquireQuery()
+	cfg.ClientFoundRows = false
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create connector: %v", err)
+	}
+
+	return NewFromConnector(connector, opts...)
+}
+
+// WithMaxOpenConns limits the number of open connections in a pool owned by
+// rlock (defaults to DefaultMaxOpenConns; 0 means unlimited).
+func WithMaxOpenConns(n int) Option {
+	return func(r *RLock) error {
+		if n < 0 {
+			return fmt.Errorf("max open conns cannot be negative")
+		}
+
+		r.pool.maxOpenConns = n
+		r.pool.configured = true
+
+		return nil
+	}
+}
+
+// WithMaxIdleConns limits the number of idle connections in a pool owned by
+// rlock (defaults to DefaultMaxIdleConns).
+func WithMaxIdleConns(n int) Option {
+	return func(r *RLock) error {
+		if n < 0 {
+			return fmt.Errorf("max idle conns cannot be negative")
+		}
+
+		r.pool.maxIdleConns = n
+		r.pool.configured = true
+
+		return nil
+	}
+}
+
+// WithConnMaxLifetime limits how long a connection in a pool owned by rlock
+// is reused (defaults to DefaultConnMaxLifetime; 0 means forever).
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(r *RLock) error {
+		if d < 0 {
+			return fmt.Errorf("conn max lifetime cannot be negative")
+		}
+
+		r.pool.connMaxLifetime = d
+		r.pool.configured = true
+
+		return nil
+	}
+}
+
+// configurePool applies the pool settings to DB handles owned by rlock;
+// handles passed to New() belong to the caller and are left alone.
+func (r *RLock) configurePool() error {
+	if !r.ownsDB {
+		if r.pool.configured {
+			return fmt.Errorf("connection pool options require rlock to own the DB handle (see NewFromDSN)")
+		}
+
+		return nil
+	}
+
+	r.db.SetMaxOpenConns(r.pool.maxOpenConns)
+	r.db.SetMaxIdleConns(r.pool.maxIdleConns)
+	r.db.SetConnMaxLifetime(r.pool.connMaxLifetime)
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package rlock
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connection pool", func() {
+	const dsn = "user:pass@tcp(127.0.0.1:3306)/rlock"
+
+	It("rejects invalid DSNs", func() {
+		_, err := NewFromDSN("not a dsn")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("opens a small dedicated pool by default", func() {
+		rl, err := NewFromDSN(dsn)
+		Expect(err).ToNot(HaveOccurred())
+		defer rl.Close()
+
+		Expect(rl.ownsDB).To(BeTrue())
+		Expect(rl.db.Stats().MaxOpenConnections).To(Equal(DefaultMaxOpenConns))
+	})
+
+	It("applies pool options", func() {
+		rl, err := NewFromDSN(dsn, WithMaxOpenConns(10), WithMaxIdleConns(5), WithConnMaxLifetime(time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		defer rl.Close()
+
+		Expect(rl.db.Stats().MaxOpenConnections).To(Equal(10))
+		Expect(rl.pool.maxIdleConns).To(Equal(5))
+		Expect(rl.pool.connMaxLifetime).To(Equal(time.Minute))
+	})
+
+	It("rejects negative values", func() {
+		for _, opt := range []Option{WithMaxOpenConns(-1), WithMaxIdleConns(-1), WithConnMaxLifetime(-time.Second)} {
+			_, err := NewFromDSN(dsn, opt)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+
+	It("refuses to tune a pool it does not own", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithMaxOpenConns(1))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,54 @@
+package rlock
+
+import (
+	"context"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// Profiler labels (see runtime/pprof) set on goroutines acquiring locks, ie.
+// for `go tool pprof -tagfocus rlock_lock=MyLock`. Acquisitions also show up
+// in execution traces (see runtime/trace) as "rlock.Lock" tasks, logging the
+// lock name and outcome, with "rlock.gate" and "rlock.wait" regions covering
+// the time spent waiting for other goroutines in this process (see
+// WithLocalMutex) and for other holders.
+const (
+	ProfileLabelLock  = "rlock_lock"
+	ProfileLabelPhase = "rlock_phase"
+)
+
+// annotateAcquire labels the calling goroutine and starts an execution trace
+// task for acquiring name; the returned function logs the outcome, ends the
+// task and restores the goroutine's labels.
+func annotateAcquire(ctx context.Context, name string) (context.Context, func(outcome string)) {
+	ctx, task := trace.NewTask(ctx, "rlock.Lock")
+	trace.Log(ctx, "lock", name)
+
+	parent := ctx
+
+	ctx = pprof.WithLabels(ctx, pprof.Labels(ProfileLabelLock, name, ProfileLabelPhase, "acquire"))
+	pprof.SetGoroutineLabels(ctx)
+
+	return ctx, func(outcome string) {
+		trace.Log(ctx, "outcome", outcome)
+		task.End()
+		pprof.SetGoroutineLabels(parent)
+	}
+}
+
+// annotateWait marks the calling goroutine as waiting for the lock it is
+// acquiring (see annotateAcquire) until the returned function is called.
+func annotateWait(ctx context.Context, region string) func() {
+	r := trace.StartRegion(ctx, region)
+
+	if _, ok := pprof.Label(ctx, ProfileLabelLock); !ok {
+		return r.End
+	}
+
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels(ProfileLabelPhase, "wait")))
+
+	return func() {
+		pprof.SetGoroutineLabels(ctx)
+		r.End()
+	}
+}
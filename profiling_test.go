@@ -0,0 +1,42 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"runtime/pprof"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiling", func() {
+	It("labels acquisitions with the lock name", func() {
+		ctx, end := annotateAcquire(context.Background(), "foo")
+		defer end("acquired")
+
+		name, ok := pprof.Label(ctx, ProfileLabelLock)
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("foo"))
+
+		phase, _ := pprof.Label(ctx, ProfileLabelPhase)
+		Expect(phase).To(Equal("acquire"))
+	})
+
+	It("keeps the caller's labels", func() {
+		parent := pprof.WithLabels(context.Background(), pprof.Labels("job", "report"))
+
+		ctx, end := annotateAcquire(parent, "foo")
+		defer end("acquired")
+
+		job, _ := pprof.Label(ctx, "job")
+		Expect(job).To(Equal("report"))
+	})
+
+	It("classifies outcomes", func() {
+		Expect(acquireResult(&Lock{}, nil)).To(Equal("acquired"))
+		Expect(acquireResult(&Lock{tookOver: true}, nil)).To(Equal("takeover"))
+		Expect(acquireResult(nil, AcquireTimeoutErr)).To(Equal("timeout"))
+		Expect(acquireResult(nil, context.Canceled)).To(Equal("cancelled"))
+		Expect(acquireResult(nil, errors.New("boom"))).To(Equal("error"))
+	})
+})
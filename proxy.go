@@ -0,0 +1,277 @@
+package rlock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+const (
+	// ProxyErrAcquireTimeout is the error code an rlockd server responds with
+	// when a lock could not be acquired within the requested timeout.
+	ProxyErrAcquireTimeout = "acquire_timeout"
+
+	// ProxyErrNotFound is the error code an rlockd server responds with when
+	// the referenced lock handle does not exist.
+	ProxyErrNotFound = "not_found"
+
+	// ProxyErrQuotaExceeded is the error code an rlockd server responds with
+	// when it already holds as many locks as its owner quota allows.
+	ProxyErrQuotaExceeded = "quota_exceeded"
+
+	// ProxyErrLockLost is the error code an rlockd server responds with when
+	// the lock of a handle being renewed is no longer held.
+	ProxyErrLockLost = "lock_lost"
+)
+
+// notFoundErr is returned for requests referencing a lock handle rlockd does
+// not hold (anymore)
+var notFoundErr = errors.New("no such lock handle")
+
+// ProxyAcquireRequest is the body of an acquire request sent to rlockd.
+type ProxyAcquireRequest struct {
+	Name    string `json:"name"`
+	Timeout string `json:"timeout"`
+}
+
+// ProxyLockResponse describes a lock held by rlockd on behalf of a client.
+// Lease is how long rlockd keeps the handle without it being renewed (see
+// ProxyRenewRequest); once it expires, the lock is released.
+type ProxyLockResponse struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Lease string `json:"lease,omitempty"`
+}
+
+// ProxyRenewRequest is the body of a request sent to rlockd to renew the
+// lease of a lock handle.
+type ProxyRenewRequest struct {
+	ID string `json:"id"`
+}
+
+// ProxyReleaseRequest is the body of a release request sent to rlockd.
+type ProxyReleaseRequest struct {
+	ID        string `json:"id"`
+	LastError string `json:"last_error"`
+}
+
+// ProxyLastErrorResponse is returned by rlockd for last error lookups.
+type ProxyLastErrorResponse struct {
+	LastError string `json:"last_error"`
+}
+
+// ProxyErrorResponse is returned by rlockd whenever a request fails.
+type ProxyErrorResponse struct {
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error"`
+}
+
+// Client talks to an rlockd server and implements IRLock, allowing services
+// to acquire locks without having direct access to the database.
+type Client struct {
+	addr   string
+	http   *http.Client
+	apiKey string
+}
+
+type ClientOption func(*Client)
+
+// WithAPIKey authenticates every request to rlockd with the given API key.
+// For mTLS, pass an *http.Client configured with a client certificate to
+// NewClient instead.
+func WithAPIKey(key string) ClientOption {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// NewClient returns a client for the rlockd server at addr (ie.
+// "http://rlockd:8080"). If httpClient is nil, a client without an overall
+// timeout is used since acquire requests block until the lock is acquired.
+func NewClient(addr string, httpClient *http.Client, opts ...ClientOption) (*Client, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("addr cannot be empty")
+	}
+
+	if _, err := url.Parse(addr); err != nil {
+		return nil, fmt.Errorf("unable to parse addr '%v': %v", addr, err)
+	}
+
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	c := &Client{
+		addr: strings.TrimRight(addr, "/"),
+		http: httpClient,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// Lock asks the rlockd server to acquire the lock; it blocks until the lock is
+// acquired or acquireTimeout is reached (in which case AcquireTimeoutErr is
+// returned).
+func (c *Client) Lock(name string, acquireTimeout time.Duration) (*Lock, error) {
+	req := &ProxyAcquireRequest{
+		Name:    name,
+		Timeout: acquireTimeout.String(),
+	}
+
+	resp := &ProxyLockResponse{}
+
+	if err := c.do(http.MethodPost, "/v1/locks/acquire", req, resp); err != nil {
+		if err == AcquireTimeoutErr || err == QuotaExceededErr {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("unable to acquire lock '%v' via proxy: %v", name, err)
+	}
+
+	l := &Lock{
+		name:    resp.Name,
+		timeout: acquireTimeout,
+		client:  c,
+		id:      resp.ID,
+	}
+
+	// Older servers do not lease handles
+	if lease, err := time.ParseDuration(resp.Lease); err == nil && lease > 0 {
+		done := make(chan struct{})
+		go c.renew(l, lease, done)
+
+		l.stopHeartbeats = append(l.stopHeartbeats, func() { close(done) })
+	}
+
+	return l, nil
+}
+
+// renew renews the lease of the lock's handle until done is closed (once the
+// lock is unlocked), a third of the lease at a time so that a failed renewal
+// or two do not lose the lock.
+func (c *Client) renew(l *Lock, lease time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		err := c.do(http.MethodPost, "/v1/locks/renew", &ProxyRenewRequest{ID: l.id}, nil)
+		if err == nil {
+			continue
+		}
+
+		log.WithFields(golog.Fields{"lock": l.name}).Warnf("unable to renew lock via proxy: %v", err)
+
+		// The server released (or lost) the lock already
+		if err == notFoundErr || err == LockLostErr {
+			return
+		}
+	}
+}
+
+func (c *Client) unlock(l *Lock, lastError error) error {
+	req := &ProxyReleaseRequest{
+		ID: l.id,
+	}
+
+	if lastError != nil {
+		req.LastError = lastError.Error()
+	}
+
+	if err := c.do(http.MethodPost, "/v1/locks/release", req, nil); err != nil {
+		fullErr := fmt.Errorf("unable to unlock '%v' via proxy: %v", l.name, err)
+		log.WithFields(golog.Fields{"lock": l.name}).Error(fullErr)
+		return fullErr
+	}
+
+	return nil
+}
+
+func (c *Client) lastError(l *Lock) error {
+	resp := &ProxyLastErrorResponse{}
+
+	if err := c.do(http.MethodGet, "/v1/locks/last-error?id="+url.QueryEscape(l.id), nil, resp); err != nil {
+		return &LastErrorFetchErr{err}
+	}
+
+	if resp.LastError == "" {
+		return nil
+	}
+
+	return errors.New(resp.LastError)
+}
+
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return fmt.Errorf("unable to encode request: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, c.addr+path, &body)
+	if err != nil {
+		return fmt.Errorf("unable to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errResp := &ProxyErrorResponse{}
+
+		if err := json.NewDecoder(resp.Body).Decode(errResp); err != nil {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+
+		switch errResp.Code {
+		case ProxyErrNotFound:
+			return notFoundErr
+		case ProxyErrAcquireTimeout:
+			return AcquireTimeoutErr
+		case ProxyErrQuotaExceeded:
+			return QuotaExceededErr
+		case ProxyErrLockLost:
+			return LockLostErr
+		}
+
+		return fmt.Errorf("server error (%d): %v", resp.StatusCode, errResp.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode response: %v", err)
+	}
+
+	return nil
+}
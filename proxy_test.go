@@ -0,0 +1,295 @@
+package rlock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		ts       *httptest.Server
+		handler  http.HandlerFunc
+		client   *Client
+		lockName = "proxy-test-lock"
+	)
+
+	BeforeEach(func() {
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+
+		var err error
+		client, err = NewClient(ts.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	Describe("NewClient", func() {
+		Context("with an empty addr", func() {
+			It("should error", func() {
+				c, err := NewClient("", nil)
+
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("cannot be empty"))
+				Expect(c).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Lock", func() {
+		Context("happy path", func() {
+			It("returns a lock backed by the proxy", func() {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					Expect(r.URL.Path).To(Equal("/v1/locks/acquire"))
+
+					req := &ProxyAcquireRequest{}
+					Expect(json.NewDecoder(r.Body).Decode(req)).To(Succeed())
+					Expect(req.Name).To(Equal(lockName))
+					Expect(req.Timeout).To(Equal("10s"))
+
+					json.NewEncoder(w).Encode(&ProxyLockResponse{ID: "handle-id", Name: lockName})
+				}
+
+				l, err := client.Lock(lockName, 10*time.Second)
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(l).ToNot(BeNil())
+				Expect(l.name).To(Equal(lockName))
+				Expect(l.id).To(Equal("handle-id"))
+				Expect(l.client).To(Equal(client))
+			})
+
+			It("leaves heartbeats to the lease", func() {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					Expect(r.URL.Path).To(Equal("/v1/locks/acquire"))
+
+					json.NewEncoder(w).Encode(&ProxyLockResponse{ID: "handle-id", Name: lockName})
+				}
+
+				l, err := client.Lock(lockName, 10*time.Second)
+				Expect(err).ToNot(HaveOccurred())
+
+				stop := l.Heartbeat(10 * time.Millisecond)
+				Expect(stop).ToNot(BeNil())
+
+				stop()
+				stop()
+			})
+		})
+
+		Context("when the server leases the handle", func() {
+			It("renews the lease until the lock is unlocked", func() {
+				var (
+					mu       sync.Mutex
+					renewals int
+				)
+
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/v1/locks/acquire":
+						json.NewEncoder(w).Encode(&ProxyLockResponse{ID: "handle-id", Name: lockName, Lease: "30ms"})
+					case "/v1/locks/renew":
+						req := &ProxyRenewRequest{}
+						Expect(json.NewDecoder(r.Body).Decode(req)).To(Succeed())
+						Expect(req.ID).To(Equal("handle-id"))
+
+						mu.Lock()
+						renewals++
+						mu.Unlock()
+
+						w.Write([]byte("{}"))
+					default:
+						w.Write([]byte("{}"))
+					}
+				}
+
+				renewed := func() int {
+					mu.Lock()
+					defer mu.Unlock()
+
+					return renewals
+				}
+
+				l, err := client.Lock(lockName, 10*time.Second)
+				Expect(err).ToNot(HaveOccurred())
+
+				Eventually(renewed).Should(BeNumerically(">=", 2))
+
+				Expect(l.Unlock(nil)).To(Succeed())
+
+				stopped := renewed()
+				Consistently(renewed, 100*time.Millisecond).Should(BeNumerically("<=", stopped+1))
+			})
+		})
+
+		Context("when the server lost the lock", func() {
+			It("stops renewing the lease", func() {
+				var (
+					mu       sync.Mutex
+					renewals int
+				)
+
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/v1/locks/acquire":
+						json.NewEncoder(w).Encode(&ProxyLockResponse{ID: "handle-id", Name: lockName, Lease: "30ms"})
+					case "/v1/locks/renew":
+						mu.Lock()
+						renewals++
+						mu.Unlock()
+
+						w.WriteHeader(http.StatusConflict)
+						json.NewEncoder(w).Encode(&ProxyErrorResponse{Code: ProxyErrLockLost, Error: "lock lost"})
+					}
+				}
+
+				renewed := func() int {
+					mu.Lock()
+					defer mu.Unlock()
+
+					return renewals
+				}
+
+				_, err := client.Lock(lockName, 10*time.Second)
+				Expect(err).ToNot(HaveOccurred())
+
+				Eventually(renewed).Should(Equal(1))
+				Consistently(renewed, 100*time.Millisecond).Should(Equal(1))
+			})
+		})
+
+		Context("when the server times out acquiring the lock", func() {
+			It("returns AcquireTimeoutErr", func() {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusConflict)
+					json.NewEncoder(w).Encode(&ProxyErrorResponse{Code: ProxyErrAcquireTimeout, Error: "timeout"})
+				}
+
+				l, err := client.Lock(lockName, 10*time.Second)
+
+				Expect(err).To(Equal(AcquireTimeoutErr))
+				Expect(l).To(BeNil())
+			})
+		})
+
+		Context("when the server holds too many locks", func() {
+			It("returns QuotaExceededErr", func() {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusTooManyRequests)
+					json.NewEncoder(w).Encode(&ProxyErrorResponse{Code: ProxyErrQuotaExceeded, Error: "quota"})
+				}
+
+				l, err := client.Lock(lockName, 10*time.Second)
+
+				Expect(err).To(Equal(QuotaExceededErr))
+				Expect(l).To(BeNil())
+			})
+		})
+
+		Context("when the server errors", func() {
+			It("returns an error", func() {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(w).Encode(&ProxyErrorResponse{Error: "db is down"})
+				}
+
+				l, err := client.Lock(lockName, 10*time.Second)
+
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("db is down"))
+				Expect(l).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Unlock", func() {
+		It("sends the handle id and last error to the proxy", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).To(Equal("/v1/locks/release"))
+
+				req := &ProxyReleaseRequest{}
+				Expect(json.NewDecoder(r.Body).Decode(req)).To(Succeed())
+				Expect(req.ID).To(Equal("handle-id"))
+				Expect(req.LastError).To(Equal("some error"))
+
+				w.Write([]byte("{}"))
+			}
+
+			l := &Lock{name: lockName, client: client, id: "handle-id"}
+
+			Expect(l.Unlock(fmt.Errorf("some error"))).To(Succeed())
+		})
+
+		Context("when the handle is unknown", func() {
+			It("returns an error", func() {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNotFound)
+					json.NewEncoder(w).Encode(&ProxyErrorResponse{Code: ProxyErrNotFound, Error: "no such lock handle"})
+				}
+
+				l := &Lock{name: lockName, client: client, id: "handle-id"}
+
+				err := l.Unlock(nil)
+
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("no such lock handle"))
+			})
+		})
+	})
+
+	Describe("LastError", func() {
+		It("returns the last error reported by the proxy", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Query().Get("id")).To(Equal("handle-id"))
+				json.NewEncoder(w).Encode(&ProxyLastErrorResponse{LastError: "foo"})
+			}
+
+			l := &Lock{name: lockName, client: client, id: "handle-id"}
+
+			err := l.LastError()
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("foo"))
+		})
+
+		It("returns nil when there is no last error", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(&ProxyLastErrorResponse{})
+			}
+
+			l := &Lock{name: lockName, client: client, id: "handle-id"}
+
+			Expect(l.LastError()).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Client with API key", func() {
+	It("sends the API key as a bearer token", func() {
+		var header string
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header.Get("Authorization")
+			json.NewEncoder(w).Encode(&ProxyLockResponse{ID: "handle-id", Name: "foo"})
+		}))
+		defer ts.Close()
+
+		client, err := NewClient(ts.URL, nil, WithAPIKey("secret"))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = client.Lock("foo", time.Second)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header).To(Equal("Bearer secret"))
+	})
+})
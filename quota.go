@@ -0,0 +1,50 @@
+package rlock
+
+import (
+	"errors"
+	"fmt"
+)
+
+// QuotaExceededErr is returned when acquiring a lock would make this RLock
+// instance hold more locks than allowed by WithOwnerQuota.
+var QuotaExceededErr = errors.New("owner already holds the maximum number of locks")
+
+// WithOwnerQuota caps how many locks this RLock instance (ie. its Owner())
+// may hold at the same time; acquisitions beyond that fail right away with
+// QuotaExceededErr. This protects the lock table from services that create
+// locks without bound (ie. one per entity) due to a bug.
+func WithOwnerQuota(max int) Option {
+	return func(r *RLock) error {
+		if max <= 0 {
+			return fmt.Errorf("owner quota must be positive")
+		}
+
+		r.ownerQuota = max
+
+		return nil
+	}
+}
+
+// reserveQuota reserves room for n locks about to be acquired (see
+// WithOwnerQuota); the returned func must be called once the acquisition is
+// over, whether or not it succeeded.
+func (r *RLock) reserveQuota(n int) (func(), error) {
+	if r.ownerQuota == 0 {
+		return func() {}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.held)+r.reserved+n > r.ownerQuota {
+		return nil, QuotaExceededErr
+	}
+
+	r.reserved += n
+
+	return func() {
+		r.mu.Lock()
+		r.reserved -= n
+		r.mu.Unlock()
+	}, nil
+}
@@ -0,0 +1,73 @@
+package rlock
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithOwnerQuota", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithOwnerQuota(2))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the quota", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithOwnerQuota(0))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to hold more locks than allowed", func() {
+		for _, name := range []string{"a", "b"} {
+			mock.ExpectExec("INSERT INTO").WithArgs(name, rl.owner, rl.host, rl.pid).WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+
+		a, err := rl.Lock("a", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = rl.Lock("b", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = rl.Lock("c", time.Second)
+		Expect(err).To(Equal(QuotaExceededErr))
+
+		// Unlocking makes room again
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO").WithArgs("c", rl.owner, rl.host, rl.pid).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(a.Unlock(nil)).To(Succeed())
+
+		_, err = rl.Lock("c", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("counts acquisitions in progress", func() {
+		done, err := rl.reserveQuota(1)
+		Expect(err).ToNot(HaveOccurred())
+
+		rl.newLock("a", time.Second, 1)
+
+		_, err = rl.reserveQuota(1)
+		Expect(err).To(Equal(QuotaExceededErr))
+
+		done()
+
+		_, err = rl.reserveQuota(1)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,175 @@
+package rlock
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ReapStale releases every in-use lock that has not been used for longer than
+// maxAge, recording the reason in last_error so the next holder knows the
+// previous holder did not finish cleanly. Returns the names of reaped locks.
+func (r *RLock) ReapStale(maxAge time.Duration) ([]string, error) {
+	reaped, err := r.reapStale(maxAge, "")
+	r.recordReap(reaped, err)
+
+	return reaped, err
+}
+
+// reapStale is ReapStale() limited to locks whose name starts with prefix.
+func (r *RLock) reapStale(maxAge time.Duration, prefix string) ([]string, error) {
+	cutoff := r.clock.Now().Add(-maxAge)
+
+	cond := "in_use=1 AND last_used < ?"
+	args := []interface{}{cutoff}
+
+	// Pool prefixes are scoped already
+	if prefix == "" {
+		prefix = r.environmentPrefix()
+	}
+
+	if prefix != "" {
+		cond += " AND name LIKE ? ESCAPE '!'"
+		args = append(args, escapeLike(prefix)+"%")
+	}
+
+	stale := make([]*LockEntry, 0)
+
+	for _, table := range r.tables() {
+		found := make([]*LockEntry, 0)
+
+		if err := r.selectAll(&found, fmt.Sprintf("SELECT * FROM %v WHERE %v", table, cond), args...); err != nil {
+			return nil, fmt.Errorf("unable to find stale locks: %v", err)
+		}
+
+		stale = append(stale, found...)
+	}
+
+	reaped := make([]string, 0, len(stale))
+
+	for _, entry := range stale {
+		reason := fmt.Sprintf("reaped: lock held by '%v' was stale (last used %v)", entry.Owner, entry.LastUsed)
+
+		// Only reap the lock if it has not changed hands since we looked at it
+		query := fmt.Sprintf("UPDATE %v SET in_use=0, last_error=? WHERE name=? AND owner=? AND in_use=1 AND last_used < ?", r.tableFor(entry.Name))
+
+		res, err := r.exec(query, reason, entry.Name, entry.Owner, cutoff)
+		if err != nil {
+			return reaped, fmt.Errorf("unable to reap '%v': %v", entry.Name, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return reaped, fmt.Errorf("unable to determine affected rows after reaping '%v': %v", entry.Name, err)
+		}
+
+		if affected == 0 {
+			continue
+		}
+
+		r.mutated(entry.Name)
+		r.markNextWaiter(entry.Name)
+		r.auditRelease(entry.Name, entry.Owner, ExitReaped, reason)
+		r.emit(EventReaped, entry.Name, entry.Owner, reason)
+		r.notify(EventReaped, entry.Name, entry, reason)
+		r.notifyRelease(entry.Name)
+
+		reaped = append(reaped, entry.Name)
+	}
+
+	return reaped, nil
+}
+
+// Purge deletes locks that are not in use and have not been used for longer
+// than olderThan (or, with WithSoftDelete, marks them as deleted; with
+// WithArchive, moves them to the archive). Returns the number of deleted
+// locks.
+func (r *RLock) Purge(olderThan time.Duration) (int64, error) {
+	return r.purge("in_use=0 AND last_used < ?", []interface{}{r.clock.Now().Add(-olderThan)})
+}
+
+// purge is Purge() for the locks matching cond (with args).
+func (r *RLock) purge(cond string, args []interface{}) (int64, error) {
+	var purged int64
+
+	cond, args = r.scoped(cond, args)
+
+	for _, table := range r.tables() {
+		var (
+			res sql.Result
+			err error
+		)
+
+		switch {
+		case r.softDelete:
+			// Setting last_used explicitly keeps it from being bumped
+			query := fmt.Sprintf("UPDATE %v SET deleted_at=NOW(), last_used=last_used WHERE %v AND deleted_at IS NULL", table, cond)
+			res, err = r.exec(query, args...)
+		case r.archive:
+			res, err = r.moveToArchive(table, cond, args, nil)
+		default:
+			res, err = r.exec(fmt.Sprintf("DELETE FROM %v WHERE %v", table, cond), args...)
+		}
+
+		if err != nil {
+			return purged, fmt.Errorf("unable to purge locks: %v", err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return purged, fmt.Errorf("unable to determine affected rows after purge: %v", err)
+		}
+
+		purged += affected
+	}
+
+	return purged, nil
+}
+
+// WithSoftDelete makes Purge() mark locks as deleted (see LockEntry.DeletedAt)
+// instead of deleting them, so that questions like "did lock X ever exist?"
+// can still be answered after cleanup; PurgeDeleted() deletes them for good.
+// Soft-deleted locks are still listed and come back when acquired again.
+func WithSoftDelete() Option {
+	return func(r *RLock) error {
+		r.softDelete = true
+		return nil
+	}
+}
+
+// PurgeDeleted deletes locks that were soft-deleted (see WithSoftDelete) more
+// than olderThan ago (with WithArchive, moves them to the archive). Returns the
+// number of deleted locks.
+func (r *RLock) PurgeDeleted(olderThan time.Duration) (int64, error) {
+	cutoff := r.clock.Now().Add(-olderThan)
+
+	var purged int64
+
+	cond, args := r.scoped("in_use=0 AND deleted_at < ?", []interface{}{cutoff})
+
+	for _, table := range r.tables() {
+		var (
+			res sql.Result
+			err error
+		)
+
+		if r.archive {
+			res, err = r.moveToArchive(table, cond, args, nil)
+		} else {
+			res, err = r.exec(fmt.Sprintf("DELETE FROM %v WHERE %v", table, cond), args...)
+		}
+
+		if err != nil {
+			return purged, fmt.Errorf("unable to purge deleted locks: %v", err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return purged, fmt.Errorf("unable to determine affected rows after purge: %v", err)
+		}
+
+		purged += affected
+	}
+
+	return purged, nil
+}
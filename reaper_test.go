@@ -0,0 +1,106 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Reaper", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	Describe("ReapStale", func() {
+		It("releases stale locks that have not changed hands", func() {
+			rows := sqlmock.NewRows(lockEntryColumns).
+				AddRow(1, "a", "owner-a", []byte{1}, "", time.Now().Add(-2*time.Hour), time.Now()).
+				AddRow(2, "b", "owner-b", []byte{1}, "", time.Now().Add(-2*time.Hour), time.Now())
+
+			mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM %v WHERE in_use=1 AND last_used < \?`, TableName)).
+				WillReturnRows(rows)
+			mock.ExpectExec(`UPDATE .+ WHERE name=\? AND owner=\? AND in_use=1 AND last_used < \?`).
+				WithArgs(sqlmock.AnyArg(), "a", "owner-a", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(`UPDATE`).
+				WithArgs(sqlmock.AnyArg(), "b", "owner-b", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 0))
+
+			reaped, err := rl.ReapStale(time.Hour)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reaped).To(Equal([]string{"a"}))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("returns an error when the lookup fails", func() {
+			mock.ExpectQuery(`SELECT`).WillReturnError(fmt.Errorf("boom"))
+
+			_, err := rl.ReapStale(time.Hour)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("boom"))
+		})
+	})
+
+	Describe("Purge", func() {
+		It("deletes old unused locks", func() {
+			mock.ExpectExec(fmt.Sprintf(`DELETE FROM %v WHERE in_use=0 AND last_used < \?`, TableName)).
+				WillReturnResult(sqlmock.NewResult(0, 3))
+
+			purged, err := rl.Purge(24 * time.Hour)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(purged).To(Equal(int64(3)))
+		})
+	})
+
+	Describe("WithSoftDelete", func() {
+		BeforeEach(func() {
+			var err error
+
+			rl, err = New(rl.db, WithSoftDelete())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("marks old unused locks as deleted", func() {
+			mock.ExpectExec(`UPDATE rlock SET deleted_at=NOW\(\), last_used=last_used WHERE in_use=0 AND last_used < \? AND deleted_at IS NULL`).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+
+			purged, err := rl.Purge(24 * time.Hour)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(purged).To(Equal(int64(2)))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("deletes locks that were soft-deleted long enough ago", func() {
+			mock.ExpectExec(`DELETE FROM rlock WHERE in_use=0 AND deleted_at < \?`).
+				WillReturnResult(sqlmock.NewResult(0, 5))
+
+			purged, err := rl.PurgeDeleted(30 * 24 * time.Hour)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(purged).To(Equal(int64(5)))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("brings purged locks back when acquiring them", func() {
+			mock.ExpectExec(`UPDATE rlock SET owner=\?, .*, deleted_at=NULL, in_use=1 WHERE name=\? AND in_use=0 AND owner=\?`).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			_, err := rl.takeover(context.Background(), "foo", "", false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,142 @@
+// Package redisnotify implements rlock.ReleaseNotifier on top of Redis
+// pub/sub, allowing waiters to wake up as soon as a lock is released while
+// lock state stays in MySQL.
+//
+// go-redis v9 is only importable in module mode, so unlike the rest of rlock
+// this package cannot be built in GOPATH mode.
+package redisnotify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultChannelPrefix is prepended to lock names to form pub/sub
+	// channels.
+	DefaultChannelPrefix = "rlock:released:"
+
+	// DefaultPublishTimeout is how long a release notification may take to
+	// publish unless WithPublishTimeout says otherwise.
+	DefaultPublishTimeout = time.Second
+
+	// MaxPendingPublishes is how many release notifications may be
+	// publishing at once; further ones are dropped until some are done.
+	MaxPendingPublishes = 1000
+)
+
+type Notifier struct {
+	client         *redis.Client
+	prefix         string
+	publishTimeout time.Duration
+
+	// Holds a token per notification being published
+	pending chan struct{}
+}
+
+type Option func(*Notifier)
+
+// WithPublishTimeout sets how long a release notification may take to
+// publish (defaults to DefaultPublishTimeout). go-redis only gives up on
+// reading a reply in time if the client has ContextTimeoutEnabled set;
+// otherwise its ReadTimeout bounds publishing instead.
+func WithPublishTimeout(timeout time.Duration) Option {
+	return func(n *Notifier) {
+		n.publishTimeout = timeout
+	}
+}
+
+// New returns a notifier publishing to channels named prefix + lock name. If
+// prefix is empty, DefaultChannelPrefix is used.
+func New(client *redis.Client, prefix string, opts ...Option) (*Notifier, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client cannot be nil")
+	}
+
+	if prefix == "" {
+		prefix = DefaultChannelPrefix
+	}
+
+	n := &Notifier{
+		client:         client,
+		prefix:         prefix,
+		publishTimeout: DefaultPublishTimeout,
+		pending:        make(chan struct{}, MaxPendingPublishes),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	if n.publishTimeout <= 0 {
+		return nil, fmt.Errorf("publish timeout must be positive")
+	}
+
+	return n, nil
+}
+
+// NotifyRelease publishes the release in the background, so that a slow or
+// unreachable Redis does not hold up unlocking; notifications are only a
+// hint, so ones that fail to publish in time are dropped (waiters notice the
+// release on their next poll instead). An error is only returned when too
+// many notifications are publishing already.
+func (n *Notifier) NotifyRelease(name string) error {
+	select {
+	case n.pending <- struct{}{}:
+	default:
+		return fmt.Errorf("too many release notifications pending; dropping the one for '%v'", name)
+	}
+
+	go func() {
+		defer func() { <-n.pending }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), n.publishTimeout)
+		defer cancel()
+
+		n.client.Publish(ctx, n.prefix+name, "")
+	}()
+
+	return nil
+}
+
+func (n *Notifier) Subscribe(name string) (<-chan struct{}, func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pubsub := n.client.Subscribe(ctx, n.prefix+name)
+
+	// Wait for the subscription to be confirmed so we don't miss a release
+	// that happens right after we return
+	if _, err := pubsub.Receive(ctx); err != nil {
+		cancel()
+		pubsub.Close()
+
+		return nil, nil, fmt.Errorf("unable to subscribe: %v", err)
+	}
+
+	released := make(chan struct{}, 1)
+
+	go func() {
+		defer close(released)
+
+		for {
+			if _, err := pubsub.ReceiveMessage(ctx); err != nil {
+				// Either cancelled or redis went away; closing released makes
+				// rlock fall back to polling
+				return
+			}
+
+			select {
+			case released <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return released, func() {
+		cancel()
+		pubsub.Close()
+	}, nil
+}
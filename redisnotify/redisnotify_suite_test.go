@@ -0,0 +1,13 @@
+package redisnotify
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestRedisNotifySuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RedisNotify Suite")
+}
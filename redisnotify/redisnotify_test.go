@@ -0,0 +1,146 @@
+package redisnotify
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ = Describe("Notifier", func() {
+	var (
+		server *miniredis.Miniredis
+		client *redis.Client
+		n      *Notifier
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		server, err = miniredis.Run()
+		Expect(err).ToNot(HaveOccurred())
+
+		client = redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+		n, err = New(client, "")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+	})
+
+	It("validates its options", func() {
+		_, err := New(nil, "")
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(client, "", WithPublishTimeout(0))
+		Expect(err).To(HaveOccurred())
+
+		Expect(n.prefix).To(Equal(DefaultChannelPrefix))
+	})
+
+	It("wakes up the lock's subscribers when it is released", func() {
+		released, cancel, err := n.Subscribe("foo")
+		Expect(err).ToNot(HaveOccurred())
+		defer cancel()
+
+		other, cancelOther, err := n.Subscribe("bar")
+		Expect(err).ToNot(HaveOccurred())
+		defer cancelOther()
+
+		Expect(n.NotifyRelease("foo")).To(Succeed())
+
+		Eventually(released).Should(Receive())
+		Consistently(other, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("publishes to channels named after the prefix", func() {
+		n, err := New(client, "custom:")
+		Expect(err).ToNot(HaveOccurred())
+
+		sub := client.Subscribe(context.Background(), "custom:foo")
+		defer sub.Close()
+
+		_, err = sub.Receive(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(n.NotifyRelease("foo")).To(Succeed())
+
+		Eventually(sub.Channel()).Should(Receive())
+	})
+
+	It("closes the channel when Redis goes away", func() {
+		released, cancel, err := n.Subscribe("foo")
+		Expect(err).ToNot(HaveOccurred())
+		defer cancel()
+
+		server.Close()
+
+		Eventually(released).Should(BeClosed())
+	})
+
+	It("fails to subscribe when Redis is unreachable", func() {
+		server.Close()
+
+		_, _, err := n.Subscribe("foo")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("when Redis does not respond", func() {
+		var listener net.Listener
+
+		BeforeEach(func() {
+			var err error
+
+			// Accepts connections but never replies
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+
+					defer conn.Close()
+				}
+			}()
+
+			client = redis.NewClient(&redis.Options{Addr: listener.Addr().String(), ContextTimeoutEnabled: true})
+
+			n, err = New(client, "", WithPublishTimeout(50*time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		It("does not hold up releasing", func() {
+			start := time.Now()
+
+			Expect(n.NotifyRelease("foo")).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+
+			// Given up on once the publish timeout is reached
+			Eventually(func() int { return len(n.pending) }).Should(Equal(0))
+		})
+
+		It("drops notifications once too many are pending", func() {
+			n.pending = make(chan struct{}, 1)
+
+			Expect(n.NotifyRelease("foo")).To(Succeed())
+			Expect(n.NotifyRelease("bar")).ToNot(Succeed())
+
+			Eventually(func() int { return len(n.pending) }).Should(Equal(0))
+			Expect(n.NotifyRelease("bar")).To(Succeed())
+		})
+	})
+})
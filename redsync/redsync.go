@@ -0,0 +1,215 @@
+// Package redsync exposes a mutex API modelled after
+// github.com/go-redsync/redsync, backed by rlock. Code moving off Redis-based
+// locking onto the MySQL lock table mostly only has to change how the
+// Redsync instance is created:
+//
+//	rs := redsync.New(rl)
+//	mutex := rs.NewMutex("my-global-mutex")
+//
+//	if err := mutex.Lock(); err != nil { ... }
+//	defer mutex.Unlock()
+//
+// Unlike redsync, locks do not expire after a per-mutex TTL; they become
+// stale (and may be taken over) once they have not been used for
+// rlock.MaxAge. Extend() refreshes the lock to push that back.
+package redsync
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dselans/rlock"
+)
+
+// Errors are named after their redsync counterparts to ease migration.
+var (
+	// ErrFailed is returned when the lock could not be acquired in time
+	ErrFailed = errors.New("redsync: failed to acquire lock")
+
+	// ErrExtendFailed is returned when the lock could not be extended
+	ErrExtendFailed = errors.New("redsync: failed to extend lock")
+
+	// ErrLockAlreadyExpired is returned when unlocking or extending a mutex
+	// that is not held (anymore)
+	ErrLockAlreadyExpired = errors.New("redsync: failed to unlock, lock was already expired")
+)
+
+const (
+	// DefaultTries is how many times Lock() tries to acquire the lock by
+	// default (like redsync)
+	DefaultTries = 32
+
+	// DefaultRetryDelay is how long Lock() waits between tries by default
+	DefaultRetryDelay = 250 * time.Millisecond
+)
+
+// Redsync creates mutexes backed by an rlock.RLock.
+type Redsync struct {
+	rl *rlock.RLock
+}
+
+// New returns a Redsync creating mutexes backed by rl.
+func New(rl *rlock.RLock) *Redsync {
+	return &Redsync{rl: rl}
+}
+
+// Option configures a Mutex.
+type Option interface {
+	Apply(*Mutex)
+}
+
+// OptionFunc is a function that configures a mutex.
+type OptionFunc func(*Mutex)
+
+// Apply calls f(mutex)
+func (f OptionFunc) Apply(mutex *Mutex) {
+	f(mutex)
+}
+
+// WithTries sets how many times Lock() tries to acquire the lock; together
+// with the retry delay it determines how long Lock() waits.
+func WithTries(tries int) Option {
+	return OptionFunc(func(m *Mutex) {
+		if tries > 0 {
+			m.tries = tries
+		}
+	})
+}
+
+// WithRetryDelay sets how long Lock() waits between tries.
+func WithRetryDelay(delay time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		if delay >= 0 {
+			m.delay = delay
+		}
+	})
+}
+
+// Mutex is a distributed mutual exclusion lock. It is safe for concurrent
+// use by multiple goroutines.
+type Mutex struct {
+	rl    *rlock.RLock
+	name  string
+	tries int
+	delay time.Duration
+
+	mu    sync.Mutex
+	lock  *rlock.Lock
+	until time.Time
+}
+
+// NewMutex returns a new distributed mutex with the given name.
+func (r *Redsync) NewMutex(name string, options ...Option) *Mutex {
+	m := &Mutex{
+		rl:    r.rl,
+		name:  name,
+		tries: DefaultTries,
+		delay: DefaultRetryDelay,
+	}
+
+	for _, o := range options {
+		o.Apply(m)
+	}
+
+	return m
+}
+
+// Name returns the mutex name.
+func (m *Mutex) Name() string {
+	return m.name
+}
+
+// Value returns the ID identifying this process as the owner of the lock.
+func (m *Mutex) Value() string {
+	return m.rl.Owner()
+}
+
+// Until returns the time after which the lock becomes stale unless it is
+// extended (zero if the mutex is not held).
+func (m *Mutex) Until() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.until
+}
+
+// Lock locks m, trying up to the configured number of times. ErrFailed is
+// returned if the lock could not be acquired.
+func (m *Mutex) Lock() error {
+	return m.acquire(time.Duration(m.tries-1) * m.delay)
+}
+
+// TryLock makes a single attempt to lock m; ErrFailed is returned if the lock
+// is held elsewhere.
+func (m *Mutex) TryLock() error {
+	return m.acquire(0)
+}
+
+func (m *Mutex) acquire(timeout time.Duration) error {
+	l, err := m.rl.Lock(m.name, timeout)
+	if err == rlock.AcquireTimeoutErr || err == rlock.MaxAttemptsErr {
+		return ErrFailed
+	}
+
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lock = l
+	m.until = time.Now().Add(rlock.MaxAge)
+
+	return nil
+}
+
+// Unlock unlocks m and returns whether the lock was released.
+func (m *Mutex) Unlock() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lock == nil {
+		return false, ErrLockAlreadyExpired
+	}
+
+	if err := m.lock.Unlock(nil); err != nil {
+		if err == rlock.AlreadyUnlockedErr {
+			err = ErrLockAlreadyExpired
+		}
+
+		return false, err
+	}
+
+	m.lock = nil
+	m.until = time.Time{}
+
+	return true, nil
+}
+
+// Extend refreshes the lock so it does not become stale, returning whether
+// it is still held.
+func (m *Mutex) Extend() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lock == nil {
+		return false, ErrLockAlreadyExpired
+	}
+
+	if err := m.lock.Refresh(); err != nil {
+		if err == rlock.LockLostErr || err == rlock.AlreadyUnlockedErr {
+			m.lock = nil
+			m.until = time.Time{}
+
+			return false, ErrExtendFailed
+		}
+
+		return false, err
+	}
+
+	m.until = time.Now().Add(rlock.MaxAge)
+
+	return true, nil
+}
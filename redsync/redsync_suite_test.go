@@ -0,0 +1,13 @@
+package redsync
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestRedsyncSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Redsync Suite")
+}
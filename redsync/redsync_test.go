@@ -0,0 +1,84 @@
+package redsync
+
+import (
+	"time"
+
+	"github.com/dselans/rlock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Mutex", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rs   *Redsync
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err := rlock.New(sqlx.NewDb(mockDB, "sqlmock"))
+		Expect(err).ToNot(HaveOccurred())
+
+		rs = New(rl)
+	})
+
+	It("locks, extends and unlocks", func() {
+		mutex := rs.NewMutex("foo")
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET last_used=NOW()").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(mutex.Lock()).To(Succeed())
+		Expect(mutex.Until()).To(BeTemporally("~", time.Now().Add(rlock.MaxAge), time.Second))
+
+		ok, err := mutex.Extend()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		ok, err = mutex.Unlock()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(mutex.Until()).To(BeZero())
+
+		ok, err = mutex.Unlock()
+		Expect(err).To(Equal(ErrLockAlreadyExpired))
+		Expect(ok).To(BeFalse())
+
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("returns ErrFailed when the lock is held elsewhere", func() {
+		mutex := rs.NewMutex("foo", WithTries(1), WithRetryDelay(time.Millisecond))
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at"}).
+				AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(mutex.Lock()).To(Equal(ErrFailed))
+	})
+
+	It("returns ErrExtendFailed when the lock was lost", func() {
+		mutex := rs.NewMutex("foo")
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET last_used").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at"}).
+				AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+
+		Expect(mutex.Lock()).To(Succeed())
+
+		ok, err := mutex.Extend()
+		Expect(err).To(Equal(ErrExtendFailed))
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,134 @@
+package rlock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultReadPinWindow is how long reads of a lock go to the primary after
+// this instance mutated it, by default; see WithReadReplica().
+const DefaultReadPinWindow = 5 * time.Second
+
+// Upper bound of pinned lock names before expired pins are pruned
+const replicaMaxPins = 1024
+
+type replica struct {
+	db     *sqlx.DB
+	window time.Duration
+
+	mu   sync.Mutex
+	pins map[string]time.Time
+	all  time.Time
+}
+
+// WithReadReplica sends reads made on behalf of callers (Status(),
+// ListLocks(), FindLocks(), GetLocksByOwner(), History() and
+// Lock.LastError()) to db, ie. a read replica, taking load off the primary.
+// So that replica lag cannot hide this instance's own writes, reads of a lock
+// go to the primary for window (0 means DefaultReadPinWindow) after this
+// instance acquired, unlocked, refreshed or otherwise mutated it; list reads
+// go to the primary while any lock is pinned. Reads rlock relies on to
+// acquire and verify locks always go to the primary.
+func WithReadReplica(db *sqlx.DB, window time.Duration) Option {
+	return func(r *RLock) error {
+		if db == nil {
+			return fmt.Errorf("replica db cannot be nil")
+		}
+
+		if window < 0 {
+			return fmt.Errorf("read pin window cannot be negative")
+		}
+
+		if window == 0 {
+			window = DefaultReadPinWindow
+		}
+
+		r.replica = &replica{
+			db:     db,
+			window: window,
+			pins:   make(map[string]time.Time),
+		}
+
+		return nil
+	}
+}
+
+// reader returns the handle to read the locks called names from (any lock,
+// with no names); ReplicaLagErr if that is a replica lagging too far behind
+// (see WithMaxReplicaLag).
+func (r *RLock) reader(names ...string) (*sqlx.DB, error) {
+	if r.replica == nil || r.replica.pinned(r.clock.Now(), names...) {
+		return r.db, nil
+	}
+
+	if err := r.checkReplicaLag(); err != nil {
+		return nil, err
+	}
+
+	return r.replica.db, nil
+}
+
+// mutated records that this instance changed the locks called names (every
+// lock, with no names): their cached status is evicted and their reads are
+// pinned to the primary.
+func (r *RLock) mutated(names ...string) {
+	r.statusCache.invalidate(names...)
+
+	if r.replica != nil {
+		r.replica.pin(r.clock.Now(), names...)
+	}
+}
+
+func (p *replica) pin(now time.Time, names ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	until := now.Add(p.window)
+
+	if len(names) == 0 {
+		p.all = until
+		return
+	}
+
+	if len(p.pins) >= replicaMaxPins {
+		for name, expires := range p.pins {
+			if !now.Before(expires) {
+				delete(p.pins, name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		p.pins[name] = until
+	}
+}
+
+func (p *replica) pinned(now time.Time, names ...string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now.Before(p.all) {
+		return true
+	}
+
+	if len(names) == 0 {
+		for _, until := range p.pins {
+			if now.Before(until) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, name := range names {
+		if now.Before(p.pins[name]) {
+			return true
+		}
+	}
+
+	return false
+}
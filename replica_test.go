@@ -0,0 +1,101 @@
+package rlock
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithReadReplica", func() {
+	var (
+		primary sqlmock.Sqlmock
+		replica sqlmock.Sqlmock
+		rl      *RLock
+		clock   *FakeClock
+	)
+
+	BeforeEach(func() {
+		primaryDB, p, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		primary = p
+
+		replicaDB, r, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		replica = r
+
+		clock = NewFakeClock(time.Now())
+
+		rl, err = New(sqlx.NewDb(primaryDB, "sqlmock"), WithClock(clock),
+			WithReadReplica(sqlx.NewDb(replicaDB, "sqlmock"), time.Second))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	status := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WithArgs("foo").WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", rl.owner, []byte{1}, "", clock.Now(), clock.Now()))
+	}
+
+	It("validates its arguments", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithReadReplica(nil, time.Second))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithReadReplica(db, -time.Second))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reads from the replica", func() {
+		status(replica)
+
+		_, err := rl.Status("foo")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replica.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("pins reads of locks mutated locally to the primary for a while", func() {
+		primary.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		status(primary)
+
+		_, err = rl.Status("foo")
+		Expect(err).ToNot(HaveOccurred())
+
+		// Lists go to the primary while anything is pinned
+		primary.ExpectQuery(`SELECT \* FROM rlock ORDER BY name`).WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+
+		_, err = rl.ListLocks()
+		Expect(err).ToNot(HaveOccurred())
+
+		clock.Advance(2 * time.Second)
+		status(replica)
+
+		_, err = rl.Status("foo")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(primary.ExpectationsWereMet()).ToNot(HaveOccurred())
+		Expect(replica.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("verifies locks against the primary", func() {
+		primary.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		clock.Advance(2 * time.Second)
+
+		primary.ExpectExec("UPDATE rlock SET last_used").WillReturnResult(sqlmock.NewResult(0, 0))
+		status(primary)
+
+		Expect(l.Refresh()).To(Succeed())
+		Expect(primary.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,188 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ReplicaLagErr is returned by reads that would go to the read replica (see
+// WithReadReplica) while it lags further behind the primary than allowed; see
+// WithMaxReplicaLag.
+var ReplicaLagErr = errors.New("read replica lags too far behind the primary")
+
+// How long a replica lag measurement is reused for
+const replicaLagCheckInterval = time.Second
+
+// ReplicaLagFunc returns how far the read replica lags behind the primary.
+type ReplicaLagFunc func(ctx context.Context) (time.Duration, error)
+
+type replicaLag struct {
+	max     time.Duration
+	measure ReplicaLagFunc
+	onLag   func(lag time.Duration, err error)
+
+	mu         sync.Mutex
+	measuredAt time.Time
+	lag        time.Duration
+	err        error
+}
+
+// WithMaxReplicaLag guards reads going to the read replica (see
+// WithReadReplica) against a replica whose view is more than maxLag old, so
+// that decisions made on it (ie. forcibly unlocking a lock that merely looks
+// stale) are not driven by outdated data. Lag is measured using measure (see
+// SecondsBehindSource() and HeartbeatLag()) at most once a second. Without
+// onLag, reads are refused with ReplicaLagErr while the replica lags (or its
+// lag cannot be measured); with it, onLag is called (with the measurement
+// error, if any) and the read goes ahead. Reads rlock relies on to acquire
+// and verify locks go to the primary regardless.
+func WithMaxReplicaLag(maxLag time.Duration, measure ReplicaLagFunc, onLag func(lag time.Duration, err error)) Option {
+	return func(r *RLock) error {
+		if maxLag <= 0 {
+			return fmt.Errorf("max replica lag must be positive")
+		}
+
+		if measure == nil {
+			return fmt.Errorf("replica lag measure cannot be nil")
+		}
+
+		r.replicaLag = &replicaLag{max: maxLag, measure: measure, onLag: onLag}
+
+		return nil
+	}
+}
+
+// SecondsBehindSource measures the lag of the replica db using SHOW REPLICA
+// STATUS (SHOW SLAVE STATUS before MySQL 8.0.22), which needs the
+// REPLICATION CLIENT privilege and only has a resolution of seconds.
+func SecondsBehindSource(db *sqlx.DB) ReplicaLagFunc {
+	return func(ctx context.Context) (time.Duration, error) {
+		status, err := replicaStatus(ctx, db, "SHOW REPLICA STATUS")
+		if err != nil {
+			status, err = replicaStatus(ctx, db, "SHOW SLAVE STATUS")
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("unable to fetch replica status: %v", err)
+		}
+
+		behind, ok := status["Seconds_Behind_Source"]
+		if !ok {
+			behind = status["Seconds_Behind_Master"]
+		}
+
+		if behind == nil {
+			return 0, fmt.Errorf("replication is not running")
+		}
+
+		var seconds int64
+
+		switch v := behind.(type) {
+		case int64:
+			seconds = v
+		case []byte:
+			seconds, err = strconv.ParseInt(string(v), 10, 64)
+		case string:
+			seconds, err = strconv.ParseInt(v, 10, 64)
+		default:
+			err = fmt.Errorf("unexpected type %T", behind)
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("unable to parse seconds behind source: %v", err)
+		}
+
+		return time.Duration(seconds) * time.Second, nil
+	}
+}
+
+func replicaStatus(ctx context.Context, db *sqlx.DB, query string) (map[string]interface{}, error) {
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("db is not a replica")
+	}
+
+	status := make(map[string]interface{})
+
+	if err := rows.MapScan(status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// HeartbeatLag measures the lag of the replica db by comparing its clock to
+// the newest timestamp in column of table, which something (ie.
+// pt-heartbeat) keeps updating on the primary. Unlike SecondsBehindSource(),
+// this reflects the lag of the data the replica actually serves and needs no
+// special privileges.
+func HeartbeatLag(db *sqlx.DB, table, column string) ReplicaLagFunc {
+	query := fmt.Sprintf("SELECT TIMESTAMPDIFF(MICROSECOND, MAX(%v), NOW(6)) FROM %v", column, table)
+
+	return func(ctx context.Context) (time.Duration, error) {
+		var micros *int64
+
+		if err := db.GetContext(ctx, &micros, query); err != nil {
+			return 0, fmt.Errorf("unable to fetch replica heartbeat: %v", err)
+		}
+
+		if micros == nil {
+			return 0, fmt.Errorf("no replica heartbeat recorded")
+		}
+
+		return time.Duration(*micros) * time.Microsecond, nil
+	}
+}
+
+// checkReplicaLag returns ReplicaLagErr if reads should not go to the replica
+// because it lags too far behind (see WithMaxReplicaLag).
+func (r *RLock) checkReplicaLag() error {
+	g := r.replicaLag
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := r.clock.Now()
+
+	if g.measuredAt.IsZero() || now.Sub(g.measuredAt) >= replicaLagCheckInterval {
+		ctx, cancel := r.statementContext()
+		g.lag, g.err = g.measure(ctx)
+		cancel()
+
+		g.measuredAt = now
+	}
+
+	if g.err == nil && g.lag <= g.max {
+		return nil
+	}
+
+	if g.onLag != nil {
+		g.onLag(g.lag, g.err)
+		return nil
+	}
+
+	if g.err != nil {
+		withError(r.logger, g.err).Warn("unable to measure replica lag, refusing replica reads")
+	}
+
+	return ReplicaLagErr
+}
@@ -0,0 +1,168 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithMaxReplicaLag", func() {
+	var (
+		replica    sqlmock.Sqlmock
+		replicaDB  *sqlx.DB
+		rl         *RLock
+		clock      *FakeClock
+		lag        time.Duration
+		lagErr     error
+		measured   int
+		lagWarning []time.Duration
+	)
+
+	measure := func(ctx context.Context) (time.Duration, error) {
+		measured++
+		return lag, lagErr
+	}
+
+	newRLock := func(onLag func(time.Duration, error)) {
+		primaryDB, _, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+
+		rl, err = New(sqlx.NewDb(primaryDB, "sqlmock"), WithClock(clock),
+			WithReadReplica(replicaDB, time.Second), WithMaxReplicaLag(5*time.Second, measure, onLag))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		db, r, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		replica = r
+		replicaDB = sqlx.NewDb(db, "sqlmock")
+
+		clock = NewFakeClock(time.Now())
+		lag, lagErr, measured, lagWarning = 0, nil, 0, nil
+
+		newRLock(nil)
+	})
+
+	status := func() {
+		replica.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WithArgs("foo").WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", clock.Now(), clock.Now()))
+	}
+
+	It("validates its arguments", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithMaxReplicaLag(0, measure, nil))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithMaxReplicaLag(time.Second, nil, nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reads from a replica that keeps up", func() {
+		lag = time.Second
+		status()
+
+		_, err := rl.Status("foo")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replica.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("refuses reads from a lagging replica", func() {
+		lag = time.Minute
+
+		_, err := rl.Status("foo")
+		Expect(err).To(Equal(ReplicaLagErr))
+
+		_, err = rl.ListLocks()
+		Expect(err).To(Equal(ReplicaLagErr))
+	})
+
+	It("refuses reads when the lag cannot be measured", func() {
+		lagErr = errors.New("access denied")
+
+		_, err := rl.Status("foo")
+		Expect(err).To(Equal(ReplicaLagErr))
+	})
+
+	It("reuses measurements for a while", func() {
+		lag = time.Minute
+
+		rl.Status("foo")
+		rl.Status("foo")
+		Expect(measured).To(Equal(1))
+
+		lag = time.Second
+		clock.Advance(time.Second)
+		status()
+
+		_, err := rl.Status("foo")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(measured).To(Equal(2))
+	})
+
+	It("only warns when given a callback", func() {
+		newRLock(func(lag time.Duration, err error) {
+			lagWarning = append(lagWarning, lag)
+		})
+
+		lag = time.Minute
+		status()
+
+		_, err := rl.Status("foo")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lagWarning).To(Equal([]time.Duration{time.Minute}))
+	})
+
+	Describe("SecondsBehindSource", func() {
+		It("reports the replica's lag", func() {
+			replica.ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(
+				sqlmock.NewRows([]string{"Replica_IO_Running", "Seconds_Behind_Source"}).AddRow("Yes", "3"))
+
+			lag, err := SecondsBehindSource(replicaDB)(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lag).To(Equal(3 * time.Second))
+		})
+
+		It("falls back to SHOW SLAVE STATUS", func() {
+			replica.ExpectQuery("SHOW REPLICA STATUS").WillReturnError(errors.New("syntax error"))
+			replica.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
+				sqlmock.NewRows([]string{"Seconds_Behind_Master"}).AddRow("7"))
+
+			lag, err := SecondsBehindSource(replicaDB)(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lag).To(Equal(7 * time.Second))
+		})
+
+		It("fails when replication is not running", func() {
+			replica.ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(
+				sqlmock.NewRows([]string{"Seconds_Behind_Source"}).AddRow(nil))
+
+			_, err := SecondsBehindSource(replicaDB)(context.Background())
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("HeartbeatLag", func() {
+		It("compares the newest heartbeat to the replica's clock", func() {
+			replica.ExpectQuery(`SELECT TIMESTAMPDIFF\(MICROSECOND, MAX\(ts\), NOW\(6\)\) FROM heartbeat`).
+				WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(1500000))
+
+			lag, err := HeartbeatLag(replicaDB, "heartbeat", "ts")(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lag).To(Equal(1500 * time.Millisecond))
+		})
+	})
+})
@@ -0,0 +1,196 @@
+package rlock
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RetryAbortedErr is returned by Lock() when a retry hook aborts the
+// acquisition without an error of its own; see RetryInfo.Abort().
+var RetryAbortedErr = errors.New("acquisition aborted by retry hook")
+
+// RetryInfo describes a retry of a contended acquisition; see WithOnRetry().
+type RetryInfo struct {
+	// Name of the lock being acquired
+	Name string
+
+	// Attempt is the number of the attempt about to be made (the initial
+	// attempt is 1, so the first retry is 2)
+	Attempt int
+
+	// Waited is how long the acquisition has been waiting on the lock
+	Waited time.Duration
+
+	// LastErr is the DB error the previous attempt failed with, or nil if it
+	// failed because the lock was still held
+	LastErr error
+
+	abort error
+}
+
+// Abort makes Lock() give up right after the retry hook returns, failing with
+// err (or RetryAbortedErr if err is nil) instead of making the attempt.
+func (i *RetryInfo) Abort(err error) {
+	if err == nil {
+		err = RetryAbortedErr
+	}
+
+	i.abort = err
+}
+
+type retryPolicy struct {
+	maxAttempts int
+	maxBackoff  time.Duration
+	budget      *retryBudget
+	onRetry     func(*RetryInfo)
+}
+
+// WithMaxAttempts limits how many times a contended acquisition tries to take
+// over the lock before giving up with MaxAttemptsErr (0 means no limit).
+func WithMaxAttempts(attempts int) Option {
+	return func(r *RLock) error {
+		if attempts < 0 {
+			return fmt.Errorf("max attempts cannot be negative")
+		}
+
+		r.retry.maxAttempts = attempts
+
+		return nil
+	}
+}
+
+// WithMaxBackoff caps how long a waiter sleeps between two attempts (0 means
+// no cap beyond the poll interval).
+func WithMaxBackoff(backoff time.Duration) Option {
+	return func(r *RLock) error {
+		if backoff < 0 {
+			return fmt.Errorf("max backoff cannot be negative")
+		}
+
+		r.retry.maxBackoff = backoff
+
+		return nil
+	}
+}
+
+// WithRetryBudget limits the retries made by all waiters of this RLock
+// combined to retries per period (with bursts of up to retries), bounding
+// the DB load a process can generate no matter how many goroutines are
+// waiting. Waiters that run out of budget wait for it to refill.
+func WithRetryBudget(retries int, period time.Duration) Option {
+	return func(r *RLock) error {
+		if retries <= 0 {
+			return fmt.Errorf("retries must be positive")
+		}
+
+		if period <= 0 {
+			return fmt.Errorf("period must be positive")
+		}
+
+		r.retry.budget = &retryBudget{
+			capacity: float64(retries),
+			tokens:   float64(retries),
+			rate:     float64(retries) / float64(period),
+		}
+
+		return nil
+	}
+}
+
+// WithOnRetry registers a func that is called before every retry of a
+// contended acquisition (ie. after every failed attempt that is followed by
+// another), with how long it has been waiting and why the previous attempt
+// failed, so that applications can report progress or give up partway
+// through a long wait (see RetryInfo.Abort()). Retries held back by the retry
+// budget (see WithRetryBudget) are not made, so it is only called once the
+// budget allows the retry. It is called synchronously from Lock() and should
+// return quickly.
+func WithOnRetry(fn func(*RetryInfo)) Option {
+	return func(r *RLock) error {
+		if fn == nil {
+			return fmt.Errorf("retry hook cannot be nil")
+		}
+
+		r.retry.onRetry = fn
+
+		return nil
+	}
+}
+
+// backoff caps wait at the configured max backoff and extends it until the
+// retry budget allows another attempt.
+func (p *retryPolicy) backoff(wait time.Duration, now time.Time) time.Duration {
+	if p.maxBackoff > 0 && wait > p.maxBackoff {
+		wait = p.maxBackoff
+	}
+
+	if d := p.budget.delay(now); d > wait {
+		wait = d
+	}
+
+	return wait
+}
+
+// retryBudget is a token bucket shared by all waiters of an RLock.
+type retryBudget struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per nanosecond
+	last     time.Time
+}
+
+func (b *retryBudget) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += float64(now.Sub(b.last)) * b.rate
+
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+
+	b.last = now
+}
+
+// take consumes a retry from the budget; returns false if there is none left.
+// A nil budget is unlimited.
+func (b *retryBudget) take(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// delay returns how long until the budget allows another retry.
+func (b *retryBudget) delay(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	// Round up so waiters never wake up just before the retry is available
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate))
+}
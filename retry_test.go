@@ -0,0 +1,262 @@
+package rlock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Retry policy", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		clock *FakeClock
+	)
+
+	newRLock := func(opts ...Option) *RLock {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		clock = NewFakeClock(time.Now())
+
+		rl, err := New(sqlx.NewDb(mockDB, "sqlmock"), append([]Option{WithClock(clock)}, opts...)...)
+		Expect(err).ToNot(HaveOccurred())
+
+		return rl
+	}
+
+	expectContended := func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", clock.Now(), clock.Now()))
+	}
+
+	It("validates options", func() {
+		db, _, _ := setupMocks()
+
+		for _, opt := range []Option{
+			WithMaxAttempts(-1),
+			WithMaxBackoff(-time.Second),
+			WithRetryBudget(0, time.Second),
+			WithRetryBudget(1, 0),
+			WithOnRetry(nil),
+		} {
+			_, err := New(db, opt)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+
+	It("gives up after max attempts", func() {
+		rl := newRLock(WithMaxAttempts(2))
+
+		expectContended()
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+
+		errs := make(chan error, 1)
+
+		go func() {
+			_, err := rl.Lock("foo", WaitForever)
+			errs <- err
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(PollInterval)
+
+		Eventually(errs).Should(Receive(Equal(MaxAttemptsErr)))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("caps a single backoff and calls the retry hook", func() {
+		var (
+			mu      sync.Mutex
+			retries []RetryInfo
+		)
+
+		rl := newRLock(WithMaxBackoff(100*time.Millisecond), WithOnRetry(func(info *RetryInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			retries = append(retries, *info)
+		}))
+
+		expectContended()
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		locks := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := rl.Lock("foo", WaitForever)
+			Expect(err).ToNot(HaveOccurred())
+
+			locks <- l
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(100 * time.Millisecond)
+
+		Eventually(locks).Should(Receive(Not(BeNil())))
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		Expect(retries).To(Equal([]RetryInfo{{Name: "foo", Attempt: 2, Waited: 100 * time.Millisecond}}))
+	})
+
+	It("tells the retry hook why the last attempt failed and lets it abort", func() {
+		var (
+			mu      sync.Mutex
+			retries []RetryInfo
+		)
+
+		rl := newRLock(WithMaxBackoff(100*time.Millisecond), WithOnRetry(func(info *RetryInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			retries = append(retries, *info)
+
+			if info.Attempt == 3 {
+				info.Abort(nil)
+			}
+		}))
+
+		expectContended()
+		mock.ExpectExec("UPDATE").WillReturnError(fmt.Errorf("boom"))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+
+		errs := make(chan error, 1)
+
+		go func() {
+			_, err := rl.Lock("foo", WaitForever)
+			errs <- err
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(100 * time.Millisecond)
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(100 * time.Millisecond)
+
+		Eventually(errs).Should(Receive(Equal(RetryAbortedErr)))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		Expect(retries).To(HaveLen(2))
+		Expect(retries[0].LastErr).To(MatchError(ContainSubstring("boom")))
+		Expect(retries[1].LastErr).ToNot(HaveOccurred())
+	})
+
+	It("tells the retry hook why the last attempt at a striped lock failed", func() {
+		var (
+			mu      sync.Mutex
+			retries []RetryInfo
+		)
+
+		rl := newRLock(WithStripes("hot-*", 2), WithMaxBackoff(100*time.Millisecond), WithOnRetry(func(info *RetryInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			retries = append(retries, *info)
+		}))
+
+		stripes := stripeNames("hot-a", 2)
+		ours := stripes[rl.firstStripe(2)]
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(fmt.Errorf("boom"))
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT name, owner").WillReturnRows(sqlmock.NewRows(claimColumns).
+			AddRow(ours, rl.owner, []byte{1}, 1, rl.host, rl.pid))
+		mock.ExpectQuery(`SELECT \* FROM rlock`).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(1, ours, rl.owner, []byte{1}, "", clock.Now(), clock.Now()))
+
+		locks := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := rl.Lock("hot-a", WaitForever)
+			Expect(err).ToNot(HaveOccurred())
+
+			locks <- l
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(100 * time.Millisecond)
+
+		Eventually(locks).Should(Receive(Not(BeNil())))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		Expect(retries).To(HaveLen(1))
+		Expect(retries[0].Attempt).To(Equal(2))
+		Expect(retries[0].Waited).To(Equal(100 * time.Millisecond))
+		Expect(retries[0].LastErr).To(MatchError(ContainSubstring("boom")))
+	})
+
+	It("waits for the retry budget to refill", func() {
+		rl := newRLock(WithRetryBudget(1, 10*time.Second))
+
+		// Use up the budget
+		Expect(rl.retry.budget.take(clock.Now())).To(BeTrue())
+
+		expectContended()
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		locks := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := rl.Lock("foo", WaitForever)
+			Expect(err).ToNot(HaveOccurred())
+
+			locks <- l
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(9 * time.Second)
+		Consistently(locks, 50*time.Millisecond).ShouldNot(Receive())
+
+		clock.Advance(time.Second)
+		Eventually(locks).Should(Receive(Not(BeNil())))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	Describe("retryBudget", func() {
+		It("refills at the configured rate up to capacity", func() {
+			now := time.Now()
+			b := &retryBudget{capacity: 2, tokens: 2, rate: 2 / float64(time.Second)}
+
+			Expect(b.take(now)).To(BeTrue())
+			Expect(b.take(now)).To(BeTrue())
+			Expect(b.take(now)).To(BeFalse())
+			Expect(b.delay(now)).To(Equal(500 * time.Millisecond))
+
+			Expect(b.take(now.Add(500 * time.Millisecond))).To(BeTrue())
+
+			b.refill(now.Add(time.Hour))
+			Expect(b.tokens).To(Equal(2.0))
+		})
+
+		It("is unlimited when nil", func() {
+			var b *retryBudget
+
+			Expect(b.take(time.Now())).To(BeTrue())
+			Expect(b.delay(time.Now())).To(BeZero())
+		})
+	})
+})
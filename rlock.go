@@ -1,9 +1,12 @@
 package rlock
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	golog "github.com/InVisionApp/go-logger"
@@ -11,12 +14,14 @@ import (
 	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
-	"github.com/satori/go.uuid"
 )
 
 var (
-	AcquireTimeoutErr = errors.New("reached timeout while waiting on lock")
-	KeyNotFoundErr    = errors.New("no such lock")
+	AcquireTimeoutErr  = errors.New("reached timeout while waiting on lock")
+	KeyNotFoundErr     = errors.New("no such lock")
+	AlreadyUnlockedErr = errors.New("lock has already been unlocked")
+	MaxAttemptsErr     = errors.New("reached max attempts while waiting on lock")
+	LockLostErr        = errors.New("lock is no longer held")
 
 	log golog.Logger
 )
@@ -29,75 +34,447 @@ const (
 	TableName    = "rlock"
 	PollInterval = 1 * time.Second
 	MaxAge       = 1 * time.Hour
+
+	// StatementTimeout is how long a single statement may take by default; see
+	// WithStatementTimeout()
+	StatementTimeout = 30 * time.Second
+
+	// WaitForever can be passed as acquireTimeout to Lock() to wait for as
+	// long as it takes to acquire the lock (any negative timeout does the same)
+	WaitForever time.Duration = -1
 )
 
 type IRLock interface {
 	Lock(name string, acquireTimeout time.Duration) (*Lock, error)
 }
 
+// RLock is safe for concurrent use by multiple goroutines.
 type RLock struct {
-	db    *sqlx.DB
-	owner string
+	db     *sqlx.DB
+	owner  string
+	table  string
+	ownsDB bool
+
+	// Recorded along with every lock we acquire
+	host string
+	pid  int
+
+	subscribers subscribers
+	failover    failover
+	notifier    ReleaseNotifier
+	gates       gates
+	clock       Clock
+	polling     *adaptivePolling
+	statusCache *statusCache
+	replica     *replica
+	replicaLag  *replicaLag
+	pollJitter  float64
+	retry       retryPolicy
+	pool        pool
+	metrics     MetricsSink
+	stats       stats
+	audit       bool
+	notifiers   []*notifierSub
+	leases      leases
+
+	statementTimeout  time.Duration
+	slowOpThreshold   time.Duration
+	longHold          time.Duration
+	localMutex        bool
+	trackWaiters      bool
+	queuedHandoff     bool
+	priorityAging     time.Duration
+	ownerQuota        int
+	auditPartitioned  bool
+	strictSchema      bool
+	maxLastError      int
+	redactLastError   func(string) string
+	correlationIDs    bool
+	correlationID     string
+	ownerLabels       Labels
+	environment       string
+	sessionLocks      bool
+	softDelete        bool
+	archive           bool
+	totals            bool
+	onLockLost        LockLostHandler
+	heartbeat         HeartbeatPolicy
+	stripes           []stripeRule
+	batchedHeartbeats bool
+	maxPollers        int
+	leaderHeartbeat   time.Duration
+
+	lockAllParallelism int
+	shards             int
+	externalMigrations bool
+	nameNormalization  NameNormalization
+
+	takeoverPolicy   TakeoverPolicy
+	noForcedTakeover bool
+	staleOverrides   []staleOverride
+	takeoverVeto     func(*LockEntry) error
+	waitSLAs         []waitSLA
+
+	mu   sync.Mutex
+	held map[string]*Lock
+
+	// Acquisitions in progress counting towards ownerQuota
+	reserved int
+
+	// State reported by Dump()
+	debug debugState
+
+	// See WithBatchedHeartbeats
+	beats heartbeatBatches
+
+	// See statementContext() and statementCache
+	deadlines  statementDeadlines
+	statements statementCache
+
+	// Contended acquisitions polling the DB; see WithMaxPollers
+	pollers pollers
+
+	// Our owner ID is ownerID if given or generated by idGenerator; see
+	// WithOwnerID and WithIDGenerator
+	ownerID     string
+	idGenerator IDGenerator
+
+	// See WithLogger, WithLogLevel and WithLogSampling; logger is set up by
+	// configureLogger()
+	logBase     golog.Logger
+	logLevel    LogLevel
+	logSampling int
+	logger      golog.Logger
 }
 
+// Lock is a handle to an acquired lock. It is safe for concurrent use by
+// multiple goroutines; only the first successful Unlock() releases the lock,
+// subsequent calls return AlreadyUnlockedErr.
 type Lock struct {
-	rl      *RLock
-	name    string
-	timeout time.Duration
+	rl         *RLock
+	name       string
+	timeout    time.Duration
+	acquiredAt time.Time
+	tookOver   bool
+
+	// The lock's acquire count as of our acquisition; see Token()
+	token int64
+
+	// Set when the lock is held on our behalf by an rlockd server
+	client *Client
+	id     string
+
+	// Guards the fields below
+	mu sync.Mutex
+
+	// Set in hybrid mode (see WithLocalMutex); frees the in-process mutex
+	releaseGate func()
+
+	// Set when using session locks (see WithSessionLocks); the connection
+	// holding the lock's session lock
+	session *connLease
+
+	// Stop the lock's heartbeats (see Heartbeat()) and its expiry (see
+	// LockFor())
+	stopHeartbeats []func()
+	heartbeats     heartbeatStatus
+
+	// End of the lock's hold window, if any; see LockFor()
+	expiresAt time.Time
+	expired   bool
+
+	// Closed once the lock turns out to be lost; see Lost()
+	lost chan struct{}
+
+	unlocked bool
 }
 
 type LockEntry struct {
-	ID        int           `db:"id"`
-	Name      string        `db:"name"`
-	Owner     string        `db:"owner"`
-	InUse     types.BitBool `db:"in_use"`
-	LastError string        `db:"last_error"`
-	LastUsed  time.Time     `db:"last_used"`
-	CreatedAt time.Time     `db:"created_at"`
+	ID        int           `db:"id" json:"id"`
+	Name      string        `db:"name" json:"name"`
+	Owner     string        `db:"owner" json:"owner"`
+	InUse     types.BitBool `db:"in_use" json:"in_use"`
+	LastError string        `db:"last_error" json:"last_error"`
+	LastUsed  time.Time     `db:"last_used" json:"last_used"`
+	CreatedAt time.Time     `db:"created_at" json:"created_at"`
+
+	// When the current (or last) hold started and how many times the lock
+	// has been acquired in total
+	AcquiredAt   time.Time `db:"acquired_at" json:"acquired_at"`
+	AcquireCount int64     `db:"acquire_count" json:"acquire_count"`
+
+	// Host and PID of the process holding (or that last held) the lock
+	Host string `db:"host" json:"host"`
+	PID  int    `db:"pid" json:"pid"`
+
+	// How many times the lock was forcibly taken over (because it was stale)
+	// and how many acquisitions gave up waiting for it
+	TakeoverCount int64 `db:"takeover_count" json:"takeover_count"`
+	TimeoutCount  int64 `db:"timeout_count" json:"timeout_count"`
+
+	// How many contenders are waiting for the lock (only tracked when using
+	// WithWaiterTracking)
+	Waiters int64 `db:"waiters" json:"waiters"`
+
+	// Set by the holder via SetMetadata(); see DecodeMetadata()
+	Metadata []byte `db:"metadata" json:"metadata,omitempty"`
+
+	// Correlation ID of the current (or last) hold; see WithCorrelationID
+	CorrelationID string `db:"correlation_id" json:"correlation_id,omitempty"`
+
+	// When the lock was purged, if it was soft-deleted; see WithSoftDelete
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+
+	// Labels of the current (or last) holder; see WithOwnerLabels
+	OwnerLabels Labels `db:"owner_labels" json:"owner_labels,omitempty"`
+
+	// When the row was archived (only set on archived rows); see WithArchive
+	ArchivedAt *time.Time `db:"archived_at" json:"archived_at,omitempty"`
+
+	// Progress saved by the current (or a previous) holder; see
+	// Lock.SaveCheckpoint()
+	Checkpoint []byte `db:"checkpoint" json:"checkpoint,omitempty"`
 }
 
-func New(db *sqlx.DB) (*RLock, error) {
+func New(db *sqlx.DB, opts ...Option) (*RLock, error) {
+	return newRLock(db, false, opts)
+}
+
+func newRLock(db *sqlx.DB, ownsDB bool, opts []Option) (*RLock, error) {
 	if db == nil {
 		return nil, fmt.Errorf("db cannot be nil")
 	}
 
-	return &RLock{
-		db:    db,
-		owner: generateUUID().String(),
-	}, nil
+	r := &RLock{
+		db:     db,
+		table:  TableName,
+		ownsDB: ownsDB,
+		host:   hostname(),
+		pid:    os.Getpid(),
+		held:   make(map[string]*Lock),
+		clock:  realClock{},
+		pool:   defaultPool,
+
+		idGenerator: UUIDGenerator,
+
+		statementTimeout: StatementTimeout,
+		takeoverPolicy:   MaxAgePolicy(MaxAge),
+		heartbeat:        DefaultHeartbeatPolicy,
+		leaderHeartbeat:  DefaultLeaderHeartbeat,
+	}
+
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, fmt.Errorf("unable to apply option: %v", err)
+		}
+	}
+
+	if err := r.generateOwner(); err != nil {
+		return nil, err
+	}
+
+	r.configureLogger()
+
+	if err := r.configurePool(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
 }
 
+// Lock acquires the lock called name, waiting up to acquireTimeout for it to
+// become available; AcquireTimeoutErr is returned if it does not. An
+// acquireTimeout of 0 makes a single attempt without waiting (ie. try-lock);
+// WaitForever (or any negative timeout) waits until the lock is acquired.
 func (r *RLock) Lock(name string, acquireTimeout time.Duration) (*Lock, error) {
-	// try to insert a lock
+	return r.lockContext(context.Background(), name, acquireTimeout)
+}
+
+// LockContext is Lock() giving up waiting (with ctx.Err()) once ctx is done,
+// ie. when the caller it acquires the lock for went away.
+func (r *RLock) LockContext(ctx context.Context, name string, acquireTimeout time.Duration) (*Lock, error) {
+	return r.lockContext(ctx, name, acquireTimeout)
+}
+
+// LockPriority is Lock() waiting with priority; see ContextWithPriority().
+func (r *RLock) LockPriority(name string, priority int, acquireTimeout time.Duration) (*Lock, error) {
+	return r.lockContext(ContextWithPriority(context.Background(), priority), name, acquireTimeout)
+}
+
+// LockUntil is Lock() waiting until deadline (a wall-clock time, ie. the end
+// of a maintenance window) rather than for a duration; the acquisition gives
+// up at deadline however long its statements take. A deadline that has
+// passed makes a single attempt without waiting; a zero deadline waits until
+// the lock is acquired.
+func (r *RLock) LockUntil(name string, deadline time.Time) (*Lock, error) {
+	if deadline.IsZero() {
+		return r.Lock(name, WaitForever)
+	}
+
+	timeout := deadline.Sub(r.clock.Now())
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	return r.Lock(name, timeout)
+}
+
+// lockContext is Lock() giving up waiting (with ctx.Err()) once ctx is done.
+func (r *RLock) lockContext(ctx context.Context, name string, acquireTimeout time.Duration) (l *Lock, err error) {
+	name = r.normalizeName(name)
+	start := r.clock.Now()
+
+	ctx, end := annotateAcquire(ctx, name)
+
+	defer func() {
+		outcome := acquireResult(l, err)
+		end(outcome)
+
+		// Spare building the line when it would be dropped anyway
+		if r.logLevel > LogDebug {
+			return
+		}
+
+		r.logFor(name).WithFields(golog.Fields{
+			"wait_ms": int64(r.clock.Now().Sub(start) / time.Millisecond),
+			"outcome": outcome,
+		}).Debug("acquire finished")
+	}()
+
+	defer r.trackPending(name, priorityFrom(ctx))()
+
+	unreserve, err := r.reserveQuota(1)
+	if err != nil {
+		r.recordAcquire(name, nil, err, 0)
+		return nil, err
+	}
+
+	defer unreserve()
+
+	// Goroutines in this process acquiring the same lock queue up locally so
+	// that only one of them talks to the DB at a time
+	endGate := annotateWait(ctx, "rlock.gate")
+	release, remaining, err := r.gates.enter(ctx, name, acquireTimeout, r.clock)
+	endGate()
+
+	if err != nil {
+		r.recordAcquire(name, nil, err, r.clock.Now().Sub(start))
+		return nil, err
+	}
+
+	if r.sessionLocks {
+		l, err = r.lockSession(ctx, name, acquireTimeout, remaining)
+	} else if stripes := r.stripesFor(name); stripes > 1 {
+		l, err = r.lockStriped(ctx, name, stripes, acquireTimeout, remaining)
+	} else {
+		l, err = r.lock(ctx, name, acquireTimeout, remaining)
+	}
+
+	r.recordAcquire(name, l, err, r.clock.Now().Sub(start))
+
+	if err != nil || !r.localMutex {
+		release()
+		return l, err
+	}
+
+	// Hybrid mode; keep the local mutex until the lock is unlocked
+	l.releaseGate = release
+
+	return l, nil
+}
+
+// lock acquires the lock in the DB, waiting up to remaining (or until ctx is
+// done) for it to become available.
+func (r *RLock) lock(ctx context.Context, name string, acquireTimeout, remaining time.Duration) (*Lock, error) {
+	// try to insert a lock (or take over a released one)
 	// if success -> return lock
 	//
 	// if failure -> check the existing lock
 	//	is existing lock valid (ie. is the existing lock NOT stale?)
 	//	poll until it gets released OR disappears OR we timeout
-	query := fmt.Sprintf("INSERT INTO %v (name, owner, in_use) VALUES(?, ?, 1)", TableName)
+	var (
+		query string
+		args  []interface{}
+	)
+
+	// The deadline is fixed before the first statement so that the time
+	// statements take counts against remaining
+	deadline := r.clock.Now().Add(remaining)
+
+	// Archived locks have no row once released; see WithArchive
+	if r.archive {
+		query, args = r.insertQuery(ctx, name)
+	} else {
+		query, args = r.acquireQuery(ctx, name)
+	}
 
 	dupe := false
 
-	if _, err := r.db.Exec(query, name, r.owner); err != nil {
+	op := r.startOp("acquire", name)
+	defer op.done()
+
+	res, err := r.exec(query, args...)
+	op.step("insert")
+
+	if err != nil {
 		// Is this a dupe? MySQL error codes documented here: https://dev.mysql.com/doc/refman/5.7/en/error-messages-server.html
 		if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1062 {
 			dupe = true
 		} else {
+			r.observeError(err)
 			return nil, fmt.Errorf("unable to insert lock for '%s': %v", name, err)
 		}
 	}
 
 	// No error, no dupe
 	if !dupe {
-		return &Lock{
-			rl:      r,
-			name:    name,
-			timeout: acquireTimeout,
-		}, nil
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine affected rows after acquiring '%v': %v", name, err)
+		}
+
+		switch affected {
+		case 0:
+			// In use; see below
+			dupe = true
+		case 2:
+			// Released by its previous owner and taken over; see
+			// acquireQuery()
+			token, err := acquireToken(res)
+			if err != nil {
+				return nil, err
+			}
+
+			r.auditAcquire(ctx, name, AcquireHandoff, "", "in_use=false")
+			r.emit(EventAcquired, name, "", "")
+
+			return r.newLock(name, acquireTimeout, token), nil
+		default:
+			// Connections reporting found rather than changed rows (ie.
+			// clientFoundRows=true in the DSN) count rows in use (which the
+			// statement leaves alone) as affected too; unlike inserted rows,
+			// those come without an insert id
+			if !r.archive && !r.inserted(res) {
+				dupe = true
+				break
+			}
+
+			token, err := r.insertedToken(res)
+			if err != nil {
+				return nil, err
+			}
+
+			r.auditAcquire(ctx, name, AcquireFresh, "", "")
+			r.emit(EventAcquired, name, "", "")
+
+			return r.newLock(name, acquireTimeout, token), nil
+		}
 	}
 
 	// Got an error, but it was a dupe, let's inspect the lock
 	existingLock, err := r.getExistingByName(name)
+	op.step("select")
+
 	if err != nil {
 		if err == KeyNotFoundErr {
 			return nil, fmt.Errorf("lock no longer exists")
@@ -107,95 +484,462 @@ func (r *RLock) Lock(name string, acquireTimeout time.Duration) (*Lock, error) {
 	}
 
 	// If the existing lock is invalid, take it over
-	if err := isValid(existingLock, name, acquireTimeout); err != nil {
-		// Existing lock is not valid
-		if err := r.takeover(name, existingLock.Owner, true); err != nil {
+	if err := isValid(r.policyFor(name), existingLock, name, acquireTimeout, r.clock.Now()); err != nil {
+		// Existing lock is not valid; either it was released (a clean handoff)
+		// or it is stale, in which case we forcibly take it over
+		stale := bool(existingLock.InUse)
+
+		if stale && r.noForcedTakeover {
+			r.logFor(name).WithFields(golog.Fields{"holder": existingLock.Owner, "last_used": existingLock.LastUsed}).
+				Warn("not taking over stale lock")
+			return nil, &StaleLockErr{Entry: existingLock}
+		}
+
+		if stale && r.takeoverVeto != nil {
+			if err := r.takeoverVeto(existingLock); err != nil {
+				withError(r.logFor(name), err).WithFields(golog.Fields{"holder": existingLock.Owner}).Warn("takeover of stale lock vetoed")
+				return nil, err
+			}
+		}
+
+		token, err := r.takeover(ctx, name, existingLock.Owner, stale)
+		op.step("takeover")
+
+		if err != nil {
 			return nil, fmt.Errorf("unable to take over lock '%v': %v", name, err)
 		}
 
-		return &Lock{
-			rl:      r,
-			name:    name,
-			timeout: acquireTimeout,
-		}, nil
+		if !stale {
+			r.auditAcquire(ctx, name, AcquireHandoff, existingLock.Owner, "in_use=false")
+			r.emit(EventAcquired, name, existingLock.Owner, "")
+
+			return r.newLock(name, acquireTimeout, token), nil
+		}
+
+		evidence := r.staleEvidence(existingLock)
+
+		r.auditRelease(name, existingLock.Owner, ExitTakenOver, evidence)
+		r.auditAcquire(ctx, name, AcquireStaleTakeover, existingLock.Owner, evidence)
+		r.emit(EventTakeover, name, existingLock.Owner, "")
+		r.notify(EventTakeover, name, existingLock, evidence)
+
+		l := r.newLock(name, acquireTimeout, token)
+		l.tookOver = true
+
+		return l, nil
 	}
 
 	// Existing lock is valid, poll and block until it becomes available OR
-	// we hit acquireTimeout
-	timer := time.NewTimer(acquireTimeout)
+	// we hit acquireTimeout; waiting does not count towards the acquire op
+	op.done()
+
+	start := r.clock.Now()
+
+	exitPoller, err := r.enterPoller(ctx, name, deadline, remaining)
+	if err != nil {
+		if err == AcquireTimeoutErr {
+			r.countTimeout(name)
+		}
+
+		return nil, err
+	}
+
+	defer exitPoller()
+
+	released, cancel := r.subscribeReleases(name)
+	defer cancel()
+
+	priority := priorityFrom(ctx)
+	sla := r.waitSLAFor(name)
+
+	r.registerWaiter(name, priority)
+	defer r.deregisterWaiter(name)
+
+	attempts := 0
+	attempt := true
+	slept := false
+
+	var lastErr error
 
 	for {
-		select {
-		case <-timer.C:
-			return nil, AcquireTimeoutErr
-		default:
-			time.Sleep(PollInterval)
-			if err := r.takeover(name, existingLock.Owner, false); err != nil {
-				continue
+		// Try right away (the lock may have been released since we looked at
+		// it) and then again every time we wake up (if the retry budget allows)
+		if attempt {
+			attempts++
+
+			if attempts > 1 {
+				r.logFor(name).WithFields(golog.Fields{
+					"attempt": attempts,
+					"wait_ms": int64(r.clock.Now().Sub(start) / time.Millisecond),
+				}).Debug("retrying acquire")
+
+				if r.retry.onRetry != nil {
+					info := &RetryInfo{Name: name, Attempt: attempts, Waited: r.clock.Now().Sub(start), LastErr: lastErr}
+					r.retry.onRetry(info)
+
+					if info.abort != nil {
+						return nil, info.abort
+					}
+				}
+
+				r.registerWaiter(name, priority)
 			}
 
-			// We acquired a lock!
-			return &Lock{
-				rl:      r,
-				name:    name,
-				timeout: acquireTimeout,
-			}, nil
+			attemptOp := r.startOp("takeover", name)
+
+			var token int64
+
+			if r.archive {
+				token, err = r.insertReleased(ctx, name)
+				attemptOp.step("insert")
+			} else {
+				token, err = r.takeover(ctx, name, existingLock.Owner, false)
+				attemptOp.step("update")
+			}
+
+			attemptOp.done()
+
+			if err == nil {
+				// How long we waited is a lower bound of how long it was held
+				if waited := r.clock.Now().Sub(start); waited > 0 {
+					r.observeHold(name, waited)
+				}
+
+				// We acquired a lock!
+				r.auditAcquire(ctx, name, AcquireHandoff, existingLock.Owner, fmt.Sprintf("released after waiting %v", r.clock.Now().Sub(start)))
+				r.emit(EventAcquired, name, existingLock.Owner, "")
+
+				return r.newLock(name, acquireTimeout, token), nil
+			}
+
+			lastErr = nil
+			if err != lockInUseErr {
+				lastErr = err
+			}
+
+			if r.retry.maxAttempts > 0 && attempts >= r.retry.maxAttempts {
+				r.countTimeout(name)
+				return nil, MaxAttemptsErr
+			}
+		}
+
+		wait := r.retry.backoff(r.jitter(r.pollInterval(name, r.clock.Now().Sub(start)), !slept), r.clock.Now())
+		slept = true
+
+		if remaining >= 0 {
+			// Never sleep past the deadline; the last attempt happens right at it
+			left := deadline.Sub(r.clock.Now())
+			if left <= 0 {
+				r.countTimeout(name)
+				return nil, AcquireTimeoutErr
+			}
+
+			if wait > left {
+				wait = left
+			}
+		}
+
+		released, err = r.waitForRelease(ctx, released, wait)
+		if err != nil {
+			return nil, err
+		}
+
+		// Breaches are reported once; we keep waiting either way
+		if sla != nil && r.checkWaitSLA(sla, name, start, existingLock) {
+			sla = nil
 		}
+
+		attempt = r.retry.budget.take(r.clock.Now())
 	}
 }
 
+func (r *RLock) newLock(name string, acquireTimeout time.Duration, token int64) *Lock {
+	l := &Lock{
+		rl:         r,
+		name:       name,
+		timeout:    acquireTimeout,
+		acquiredAt: r.clock.Now(),
+		token:      token,
+	}
+
+	r.mu.Lock()
+	r.held[name] = l
+	r.mu.Unlock()
+
+	r.mutated(name)
+
+	return l
+}
+
+func (r *RLock) forget(l *Lock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.held[l.name] == l {
+		delete(r.held, l.name)
+	}
+
+	r.mutated(l.name)
+}
+
+// lockInUseErr is returned by takeover() when the lock is (still) in use.
+var lockInUseErr = errors.New("unable to takeover lock, still in use")
+
 // Try to take over an existing lock; if force is false, we will only take over
 // the lock when in_use is false; if force is true, we will take over
-// the lock, regardless of state of in_use.
-func (r *RLock) takeover(origName, origOwner string, force bool) error {
-	query := fmt.Sprintf("UPDATE %v SET owner=?, in_use=1 WHERE name=? AND in_use=0 AND owner=?", TableName)
+// the lock, regardless of state of in_use. Returns the lock's new acquire
+// count (our fencing token, see Lock.Token()).
+func (r *RLock) takeover(ctx context.Context, origName, origOwner string, force bool) (int64, error) {
+	set, args := r.holderColumns(ctx)
+	args = append(args, origName, origOwner)
+
+	table := r.tableFor(origName)
+
+	query := fmt.Sprintf("UPDATE %v SET %v, in_use=1 WHERE name=? AND in_use=0 AND owner=?", table, set)
 
 	if force {
-		query = fmt.Sprintf("UPDATE %v SET owner=?, in_use=1 WHERE name=? AND owner=?", TableName)
+		query = fmt.Sprintf("UPDATE %v SET %v, takeover_count=takeover_count+1, in_use=1 WHERE name=? AND owner=?", table, set)
+	} else if r.queuedHandoff {
+		// Leave the lock to the waiter it was handed to, unless that is us
+		query += fmt.Sprintf(" AND NOT EXISTS (SELECT 1 FROM %v WHERE name=? AND owner<>? AND eligible=1 AND seen_at >= ?)", r.waitersTable())
+		args = append(args, origName, r.owner, r.clock.Now().Add(-r.waiterTTL()))
 	}
 
-	res, err := r.db.Exec(query, r.owner, origName, origOwner)
+	res, err := r.exec(query, args...)
 	if err != nil {
-		return fmt.Errorf("unable to take over '%v': %v", origName, err)
+		r.observeError(err)
+		return 0, fmt.Errorf("unable to take over '%v': %v", origName, err)
 	}
 
 	affected, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("unable to determine rows affected during takeover for '%v': %v", origName, err)
+		return 0, fmt.Errorf("unable to determine rows affected during takeover for '%v': %v", origName, err)
 	}
 
 	if affected > 1 {
-		return fmt.Errorf("lock takeover affected more than 1 row, possible bug")
+		return 0, fmt.Errorf("lock takeover affected more than 1 row, possible bug")
 	}
 
 	if affected == 0 {
-		return fmt.Errorf("unable to takeover lock, still in use")
+		return 0, lockInUseErr
 	}
 
 	// Lock takeover succeeded
-	return nil
+	return acquireToken(res)
+}
+
+// acquireToken returns the acquire count set by a statement using
+// holderColumns().
+func acquireToken(res sql.Result) (int64, error) {
+	token, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to determine acquire count: %v", err)
+	}
+
+	return token, nil
+}
+
+// inserted returns whether the statement acquiring a lock (see acquireQuery())
+// inserted its row, judging by the row's auto-increment id.
+func (r *RLock) inserted(res sql.Result) bool {
+	id, err := res.LastInsertId()
+	return err != nil || id != 0
+}
+
+// insertedToken returns the acquire count of a row inserted using
+// insertQuery().
+func (r *RLock) insertedToken(res sql.Result) (int64, error) {
+	// Inserted rows start out with an acquire count of 1, unless archived
+	// rows are continued
+	if !r.archive {
+		return 1, nil
+	}
+
+	return acquireToken(res)
+}
+
+// insertQuery returns the statement (and its args) inserting the lock called
+// name as held by us, acquired with ctx.
+func (r *RLock) insertQuery(ctx context.Context, name string) (string, []interface{}) {
+	table := r.tableFor(name)
+
+	query, ok := r.statements.lookup("insert", table)
+	if !ok {
+		columns, values := r.insertColumns()
+		query = r.statements.put("insert", table, fmt.Sprintf("INSERT INTO %v (%v) VALUES(%v)", table, columns, values))
+	}
+
+	return query, r.insertArgs(ctx, name)
+}
+
+// acquireQuery returns the statement (and its args) acquiring the lock called
+// name with ctx in one go: its row is inserted or, if it exists but is not in
+// use, taken over (see claimUpdate()); rows in use are left alone. It affects
+// 1 row when inserting, 2 when taking over and 0 otherwise; connections
+// reporting found rather than changed rows (clientFoundRows=true) affect 1
+// row otherwise as well, which inserted() tells apart.
+func (r *RLock) acquireQuery(ctx context.Context, name string) (string, []interface{}) {
+	table := r.tableFor(name)
+
+	// Taking over released locks hides their previous owner, which the
+	// audit log and event subscribers are told about; leave those to
+	// takeover()
+	kind := "acquire"
+	if r.needsPreviousOwner() {
+		kind = "acquire_insert"
+	}
+
+	query, ok := r.statements.lookup(kind, table)
+	if !ok {
+		columns, values := r.insertColumns()
+
+		update := "name=name"
+		if kind == "acquire" {
+			update = r.claimUpdate(columns)
+		}
+
+		query = r.statements.put(kind, table, fmt.Sprintf("INSERT INTO %v (%v) VALUES(%v) ON DUPLICATE KEY UPDATE %v",
+			table, columns, values, update))
+	}
+
+	return query, r.insertArgs(ctx, name)
+}
+
+// needsPreviousOwner returns whether acquisitions must find out who held the
+// lock before us; see acquireQuery().
+func (r *RLock) needsPreviousOwner() bool {
+	if r.audit {
+		return true
+	}
+
+	r.subscribers.mu.Lock()
+	defer r.subscribers.mu.Unlock()
+
+	return len(r.subscribers.subs) > 0
+}
+
+// insertColumns returns the columns and values of a row inserting a lock as
+// held by us; see insertArgs().
+func (r *RLock) insertColumns() (string, string) {
+	columns := "name, owner, in_use, acquired_at, acquire_count, host, pid"
+	values := "?, ?, 1, NOW(), 1, ?, ?"
+
+	// Continue where the newest archived row left off; LAST_INSERT_ID(expr)
+	// makes the acquire count the statement's last insert id (see
+	// acquireToken())
+	if r.archive {
+		columns += ", last_error"
+		values = fmt.Sprintf("?, ?, 1, NOW(), LAST_INSERT_ID(COALESCE(%v, 0)+1), ?, ?, COALESCE(%v, '')",
+			r.archived("acquire_count"), r.archived("last_error"))
+	}
+
+	if r.correlationIDs {
+		columns += ", correlation_id"
+		values += ", ?"
+	}
+
+	if r.ownerLabels != nil {
+		columns += ", owner_labels"
+		values += ", ?"
+	}
+
+	return columns, values
+}
+
+// insertArgs returns the args of insertColumns() inserting the lock called
+// name, acquired with ctx.
+func (r *RLock) insertArgs(ctx context.Context, name string) []interface{} {
+	args := make([]interface{}, 0, 8)
+
+	if r.archive {
+		args = append(args, name, r.owner, name, r.host, r.pid, name)
+	} else {
+		args = append(args, name, r.owner, r.host, r.pid)
+	}
+
+	if r.correlationIDs {
+		args = append(args, r.correlationIDFrom(ctx))
+	}
+
+	if r.ownerLabels != nil {
+		args = append(args, r.ownerLabels)
+	}
+
+	return args
+}
+
+// holderColumns returns the assignments (and their args) making us the holder
+// of a lock acquired with ctx.
+func (r *RLock) holderColumns(ctx context.Context) (string, []interface{}) {
+	// LAST_INSERT_ID(expr) makes the new acquire count the statement's last
+	// insert id; see acquireToken()
+	set := "owner=?, host=?, pid=?, acquired_at=NOW(), acquire_count=LAST_INSERT_ID(acquire_count+1)"
+	args := []interface{}{r.owner, r.host, r.pid}
+
+	if r.correlationIDs {
+		set += ", correlation_id=?"
+		args = append(args, r.correlationIDFrom(ctx))
+	}
+
+	if r.ownerLabels != nil {
+		set += ", owner_labels=?"
+		args = append(args, r.ownerLabels)
+	}
+
+	// Acquiring a purged lock brings it back
+	if r.softDelete {
+		set += ", deleted_at=NULL"
+	}
+
+	return set, args
+}
+
+// countTimeout records that an acquisition of name gave up waiting; failing
+// to do so is not worth failing the acquisition over.
+func (r *RLock) countTimeout(name string) {
+	// Setting last_used explicitly keeps it from being bumped, which would
+	// make a stale lock look fresh
+	query := fmt.Sprintf("UPDATE %v SET timeout_count=timeout_count+1, last_used=last_used WHERE name=?", r.tableFor(name))
+
+	if _, err := r.exec(query, name); err != nil {
+		withError(r.logFor(name), err).Warn("unable to record timeout")
+	}
+
+	r.totalTimeout(name)
 }
 
 func (r *RLock) getExistingByName(name string) (*LockEntry, error) {
-	query := fmt.Sprintf("SELECT * FROM %v WHERE name=?", TableName)
+	return r.getLockEntry(r.db, name)
+}
+
+// getLockEntry is getExistingByName() reading from db (see reader()).
+func (r *RLock) getLockEntry(db *sqlx.DB, name string) (*LockEntry, error) {
+	query := fmt.Sprintf("SELECT * FROM %v WHERE name=?", r.tableFor(name))
 
 	entry := &LockEntry{}
 
-	if err := r.db.Get(entry, query, name); err != nil {
+	if err := r.getFrom(db, entry, query, name); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, KeyNotFoundErr
 		}
 
+		r.observeError(err)
+
 		return nil, err
 	}
 
+	// Waiter counts are only read by Status()
+	if !r.trackWaiters {
+		r.statusCache.put(entry, r.clock.Now())
+	}
+
 	return entry, nil
 }
 
 // Verify that the existing lock is in good condition (and should be trusted).
 //
-// ie. is it stale?
-func isValid(existingLock *LockEntry, newLockName string, newLockTimeout time.Duration) error {
+// ie. is it stale (as of now, according to policy)?
+func isValid(policy TakeoverPolicy, existingLock *LockEntry, newLockName string, newLockTimeout time.Duration, now time.Time) error {
 	if existingLock == nil {
 		return fmt.Errorf("existing lock cannot be nil")
 	}
@@ -204,7 +948,7 @@ func isValid(existingLock *LockEntry, newLockName string, newLockTimeout time.Du
 		return fmt.Errorf("existing lock is not in use")
 	}
 
-	if time.Since(existingLock.LastUsed) > MaxAge {
+	if policy.Stale(existingLock, now) {
 		return fmt.Errorf("existing lock is stale")
 	}
 
@@ -216,53 +960,170 @@ func isValid(existingLock *LockEntry, newLockName string, newLockTimeout time.Du
 // holders can call on LastError() and see what (if any) error previous
 // lock holder(s) ran into.
 func (l *Lock) Unlock(lastError error) error {
-	query := fmt.Sprintf("UPDATE %v SET in_use=0, last_error=? WHERE name=? AND owner=?", TableName)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlocked {
+		return AlreadyUnlockedErr
+	}
+
+	if err := l.unlock(lastError, false); err != nil {
+		return err
+	}
+
+	l.unlocked = true
+	l.stopHeartbeat()
+
+	return nil
+}
+
+// unlock releases the lock; when fenced, only if it was not acquired again
+// since we acquired it (see Token()).
+func (l *Lock) unlock(lastError error, fenced bool) error {
+	if l.client != nil {
+		return l.client.unlock(l, lastError)
+	}
+
+	cond := "name=? AND owner=?"
+	args := []interface{}{l.name, l.rl.owner}
+
+	if fenced {
+		cond += " AND acquire_count=?"
+		args = append(args, l.token)
+	}
 
 	var lastErrorStr string
 
 	if lastError == nil {
 		lastErrorStr = ""
 	} else {
-		lastErrorStr = lastError.Error()
+		lastErrorStr = l.rl.lastErrorText(lastError.Error())
+	}
+
+	// Whether or not the DB unlock succeeds, the local mutex must not outlive
+	// the handle; otherwise siblings could never take over a stale lock
+	if l.releaseGate != nil {
+		defer l.releaseGate()
+		l.releaseGate = nil
+	}
+
+	if l.session != nil {
+		defer l.rl.unpin(l.session, l.rl.releaseSession(l.name))
+		l.session = nil
 	}
 
-	result, err := l.rl.db.Exec(query, lastErrorStr, l.name, l.rl.owner)
+	op := l.rl.startOp("unlock", l.name)
+	defer op.done()
+
+	var (
+		result sql.Result
+		err    error
+	)
+
+	if l.rl.archive {
+		set := map[string]string{"in_use": "0", "last_error": "?", "last_used": "NOW()"}
+		result, err = l.rl.moveToArchive(l.rl.tableFor(l.name), cond, args, set, lastErrorStr)
+	} else {
+		table := l.rl.tableFor(l.name)
+
+		query, ok := l.rl.statements.lookup(cond, table)
+		if !ok {
+			query = l.rl.statements.put(cond, table, fmt.Sprintf("UPDATE %v SET in_use=0, last_error=? WHERE %v", table, cond))
+		}
+
+		result, err = l.rl.exec(query, append([]interface{}{lastErrorStr}, args...)...)
+	}
+
+	op.step("update")
+
 	if err != nil {
+		l.rl.observeError(err)
 		fullErr := fmt.Errorf("unable to unlock '%v': %v", l.name, err)
-		log.Error(fullErr)
+		withError(l.rl.logFor(l.name), err).Error("unable to unlock")
 		return fullErr
 	}
 
 	affected, err := result.RowsAffected()
 	if err != nil {
 		fullErr := fmt.Errorf("unable to determine affected rows after unlock for '%v': %v", l.name, err)
-		log.Error(fullErr)
+		withError(l.rl.logFor(l.name), err).Error("unable to determine affected rows after unlock")
 		return fullErr
 	}
 
+	if affected == 0 && fenced {
+		l.rl.logFor(l.name).Warn("not unlocking: it was acquired again since")
+		return LockLostErr
+	}
+
 	if affected != 1 {
 		fullErr := fmt.Errorf("unexpected number of affected rows after unlock (%d)", affected)
-		log.Error(fullErr)
+		l.rl.logFor(l.name).WithFields(golog.Fields{"affected": affected}).Error("unexpected number of affected rows after unlock")
 		return fullErr
 	}
 
+	l.released(lastError, lastErrorStr)
+
 	// Unlocked successfully
 	return nil
 }
 
+// released does the bookkeeping once the lock's row was released, recording
+// lastError (stored as lastErrorStr).
+func (l *Lock) released(lastError error, lastErrorStr string) {
+	l.rl.forget(l)
+	held := l.rl.clock.Now().Sub(l.acquiredAt)
+
+	l.rl.observeHold(l.name, held)
+	l.rl.recordHold(l.name, held)
+
+	if l.rl.longHold > 0 && held > l.rl.longHold {
+		l.rl.notify(EventLongHold, l.name, nil, fmt.Sprintf("held for %v (threshold %v)", held, l.rl.longHold))
+	}
+
+	status := ExitUnlocked
+	if lastError != nil {
+		status = ExitError
+	}
+
+	l.rl.markNextWaiter(l.name)
+	l.rl.auditRelease(l.name, l.rl.owner, status, lastErrorStr)
+	l.rl.emit(EventReleased, l.name, "", lastErrorStr)
+	l.rl.notifyRelease(l.name)
+}
+
+// LastErrorFetchErr is returned by LastError() when the last error could not
+// be read, as opposed to the last error recorded by a previous holder.
+type LastErrorFetchErr struct {
+	Err error
+}
+
+func (e *LastErrorFetchErr) Error() string {
+	return fmt.Sprintf("unexpected error while fetching last error state: %v", e.Err)
+}
+
 // LastError returns nil if `last_used` is empty or an error if `last_used` is
 // not empty.
 //
 // `last_error` (in the db) is used by *current* lock holders to convey state
 // such as whether they ran into an error. A subsequent lock holder can then
 // determine whether the previous lock user ran into issues AND potentially
-// perform additional steps based on the answer.
+// perform additional steps based on the answer. Failures to read it are
+// returned as a *LastErrorFetchErr.
 func (l *Lock) LastError() error {
-	query := fmt.Sprintf("SELECT last_error FROM %v WHERE name=? AND owner=?", TableName)
+	if l.client != nil {
+		return l.client.lastError(l)
+	}
+
+	query := fmt.Sprintf("SELECT last_error FROM %v WHERE name=? AND owner=?", l.rl.tableFor(l.name))
+
+	db, err := l.rl.reader(l.name)
+	if err != nil {
+		return &LastErrorFetchErr{err}
+	}
 
 	var lastError string
-	if err := l.rl.db.Get(&lastError, query, l.name, l.rl.owner); err != nil {
-		return fmt.Errorf("unexpected error while fetching last error state: %v", err)
+	if err := l.rl.getFrom(db, &lastError, query, l.name, l.rl.owner); err != nil {
+		return &LastErrorFetchErr{err}
 	}
 
 	if lastError == "" {
@@ -272,18 +1133,95 @@ func (l *Lock) LastError() error {
 	return errors.New(lastError)
 }
 
-// Namespace uuid was generated via `uuidgen`
-var nsUUID = uuid.Must(uuid.FromString("3cd4853f-ad8f-40f9-8558-014dd707b7b4"))
+// Refresh bumps the lock's `last_used` so that a long running holder's lock is
+// not considered stale (and taken over or reaped) after MaxAge. Returns
+// LockLostErr if the lock is no longer ours.
+func (l *Lock) Refresh() error {
+	err := l.refresh(false)
+	if err == LockLostErr {
+		l.rl.lockLost(l, err)
+	}
+
+	return err
+}
+
+// refresh refreshes the lock; when fenced, only if it was not acquired again
+// since we acquired it (see Token()).
+func (l *Lock) refresh(fenced bool) error {
+	if l.client != nil {
+		return fmt.Errorf("refreshing locks is not supported via proxy")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlocked {
+		return AlreadyUnlockedErr
+	}
+
+	if l.expired {
+		return LockExpiredErr
+	}
+
+	// Our session lock may have dropped along with its connection
+	if l.session != nil {
+		if err := l.session.check(context.Background()); err != nil {
+			withError(l.rl.logFor(l.name), err).Warn("unable to refresh")
+			return LockLostErr
+		}
+	}
+
+	query := fmt.Sprintf("UPDATE %v SET last_used=NOW() WHERE name=? AND owner=? AND in_use=1", l.rl.tableFor(l.name))
+	args := []interface{}{l.name, l.rl.owner}
 
-// GenerateUUID by iterating over the strategies, without throwing an error
-func generateUUID() uuid.UUID {
-	id, err := uuid.NewV1()
+	if fenced {
+		query += " AND acquire_count=?"
+		args = append(args, l.token)
+	}
+
+	result, err := l.rl.exec(query, args...)
+	if err != nil {
+		l.rl.observeError(err)
+		return fmt.Errorf("unable to refresh '%v': %v", l.name, err)
+	}
+
+	affected, err := result.RowsAffected()
 	if err != nil {
-		id, err = uuid.NewV4()
+		return fmt.Errorf("unable to determine affected rows after refresh for '%v': %v", l.name, err)
+	}
+
+	// MySQL does not count rows whose values did not change (ie. when
+	// refreshing twice within a second); make sure the lock is really gone
+	if affected == 0 {
+		err := l.rl.revalidate(l, fenced)
+		if _, mismatch := err.(*lockMismatchErr); mismatch {
+			withError(l.rl.logFor(l.name), err).Warn("unable to refresh")
+			return LockLostErr
+		}
+
+		// Unable to tell whether the lock is still ours
 		if err != nil {
-			return uuid.NewV5(nsUUID, time.Now().String())
+			return fmt.Errorf("unable to refresh '%v': %v", l.name, err)
 		}
 	}
 
-	return id
+	l.rl.mutated(l.name)
+
+	return nil
+}
+
+// Name returns the name of the lock
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// hostname returns the name of the host we are running on or an empty string
+// if it cannot be determined
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	return host
 }
@@ -1,6 +1,7 @@
 package rlock
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/jmoiron/sqlx"
@@ -76,7 +77,7 @@ var _ = Describe("RLock", func() {
 			It("inserts a lock and returns lock instance", func() {
 				mock.ExpectExec(
 					fmt.Sprintf(`INSERT INTO %v`, TableName)).
-					WithArgs(newLockName, rl.owner).
+					WithArgs(newLockName, rl.owner, rl.host, rl.pid).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 
 				l, err := rl.Lock(newLockName, acquireTimeout)
@@ -121,11 +122,32 @@ var _ = Describe("RLock", func() {
 			})
 		})
 
+		Context("when the connection reports found rather than changed rows", func() {
+			It("does not mistake a lock in use for an inserted one", func() {
+				// With clientFoundRows=true, leaving a row in use alone
+				// affects 1 row, but inserts none
+				mock.ExpectExec(fmt.Sprintf(`INSERT INTO %v .* ON DUPLICATE KEY UPDATE`, TableName)).
+					WithArgs(newLockName, rl.owner, rl.host, rl.pid).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM %v WHERE name=\?`, TableName)).
+					WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+						AddRow(1, newLockName, "other-owner", []byte{1}, "", time.Now(), time.Now()))
+				mock.ExpectExec(`UPDATE .* in_use=1 WHERE name=\? AND in_use=0`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("UPDATE .* SET timeout_count").WillReturnResult(sqlmock.NewResult(0, 1))
+
+				l, err := rl.Lock(newLockName, 0)
+
+				Expect(err).To(Equal(AcquireTimeoutErr))
+				Expect(l).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+			})
+		})
+
 		Context("when inserting a lock but get mysql error", func() {
 			It("should return error", func() {
 				mock.ExpectExec(
 					fmt.Sprintf(`INSERT INTO %v`, TableName)).
-					WithArgs(newLockName, rl.owner).
+					WithArgs(newLockName, rl.owner, rl.host, rl.pid).
 					WillReturnError(fmt.Errorf("some error"))
 
 				l, err := rl.Lock(newLockName, acquireTimeout)
@@ -138,6 +160,60 @@ var _ = Describe("RLock", func() {
 		})
 	})
 
+	Describe("LockUntil", func() {
+		var (
+			mock  sqlmock.Sqlmock
+			rl    *RLock
+			clock *FakeClock
+		)
+
+		BeforeEach(func() {
+			db, m, _ := setupMocks()
+			mock = m
+
+			clock = NewFakeClock(time.Now())
+
+			var err error
+
+			rl, err = New(db, WithClock(clock))
+			Expect(err).ToNot(HaveOccurred())
+
+			mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+				AddRow(1, existingLockName, existingLockOwner, []byte{1}, "", clock.Now(), clock.Now()))
+			mock.ExpectExec(`UPDATE rlock SET .*in_use=1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		})
+
+		It("waits until the deadline", func() {
+			mock.ExpectExec(`UPDATE rlock SET .*in_use=1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("UPDATE rlock SET timeout_count").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			errs := make(chan error, 1)
+
+			go func() {
+				_, err := rl.LockUntil(existingLockName, clock.Now().Add(PollInterval))
+				errs <- err
+			}()
+
+			Eventually(clock.Waiters).Should(Equal(1))
+			Consistently(errs).ShouldNot(Receive())
+
+			clock.Advance(PollInterval)
+
+			Eventually(errs).Should(Receive(Equal(AcquireTimeoutErr)))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("makes a single attempt once the deadline has passed", func() {
+			mock.ExpectExec("UPDATE rlock SET timeout_count").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			_, err := rl.LockUntil(existingLockName, clock.Now().Add(-time.Minute))
+
+			Expect(err).To(Equal(AcquireTimeoutErr))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
 	Describe("takeover", func() {
 		var (
 			mock sqlmock.Sqlmock
@@ -154,10 +230,10 @@ var _ = Describe("RLock", func() {
 					// ensure our query contains "WHERE in_use = 0"
 					mock.ExpectExec(
 						fmt.Sprintf(`^UPDATE %v SET owner=.+,\s+in_use=1 WHERE name=.+\s+AND\s+in_use=0 AND owner=.+$`, TableName)).
-						WithArgs(rl.owner, existingLockName, existingLockOwner).
+						WithArgs(rl.owner, rl.host, rl.pid, existingLockName, existingLockOwner).
 						WillReturnResult(sqlmock.NewResult(1, 1))
 
-					err := rl.takeover(existingLockName, existingLockOwner, false)
+					_, err := rl.takeover(context.Background(), existingLockName, existingLockOwner, false)
 
 					Expect(err).ToNot(HaveOccurred())
 					Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
@@ -169,10 +245,10 @@ var _ = Describe("RLock", func() {
 					// ensure our query does NOT contain "WHERE in_use = 0"
 					mock.ExpectExec(
 						fmt.Sprintf(`^UPDATE %v SET owner=.+, in_use=1 WHERE name=.+\s+AND owner=.+$`, TableName)).
-						WithArgs(rl.owner, existingLockName, existingLockOwner).
+						WithArgs(rl.owner, rl.host, rl.pid, existingLockName, existingLockOwner).
 						WillReturnResult(sqlmock.NewResult(1, 1))
 
-					err := rl.takeover(existingLockName, existingLockOwner, true)
+					_, err := rl.takeover(context.Background(), existingLockName, existingLockOwner, true)
 
 					Expect(err).ToNot(HaveOccurred())
 					Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
@@ -187,7 +263,7 @@ var _ = Describe("RLock", func() {
 					fmt.Sprintf(`^UPDATE %v SET owner=.+,\s+in_use=1 WHERE name=.+\s+AND\s+in_use=0 AND owner=.+$`, TableName)).
 					WillReturnError(fmt.Errorf("something broke"))
 
-				err := rl.takeover(existingLockName, existingLockOwner, false)
+				_, err := rl.takeover(context.Background(), existingLockName, existingLockOwner, false)
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("something broke"))
@@ -201,7 +277,7 @@ var _ = Describe("RLock", func() {
 					fmt.Sprintf(`^UPDATE %v SET owner=.+,\s+in_use=1 WHERE name=.+\s+AND\s+in_use=0 AND owner=.+$`, TableName)).
 					WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("affected broke")))
 
-				err := rl.takeover(existingLockName, existingLockOwner, false)
+				_, err := rl.takeover(context.Background(), existingLockName, existingLockOwner, false)
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("unable to determine rows affected during takeover"))
@@ -215,7 +291,7 @@ var _ = Describe("RLock", func() {
 					fmt.Sprintf(`^UPDATE %v SET owner=.+,\s+in_use=1 WHERE name=.+\s+AND\s+in_use=0 AND owner=.+$`, TableName)).
 					WillReturnResult(sqlmock.NewResult(1, 2))
 
-				err := rl.takeover(existingLockName, existingLockOwner, false)
+				_, err := rl.takeover(context.Background(), existingLockName, existingLockOwner, false)
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("lock takeover affected more than 1 row, possible bug"))
@@ -228,7 +304,7 @@ var _ = Describe("RLock", func() {
 					fmt.Sprintf(`^UPDATE %v SET owner=.+,\s+in_use=1 WHERE name=.+\s+AND\s+in_use=0 AND owner=.+$`, TableName)).
 					WillReturnResult(sqlmock.NewResult(1, 0))
 
-				err := rl.takeover(existingLockName, existingLockOwner, false)
+				_, err := rl.takeover(context.Background(), existingLockName, existingLockOwner, false)
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("unable to takeover lock, still in use"))
@@ -316,7 +392,7 @@ var _ = Describe("RLock", func() {
 
 		Context("when given an existing lock that is NOT expired and still in use", func() {
 			It("should return nil", func() {
-				err := isValid(existingLock, existingLockName, 15*time.Minute)
+				err := isValid(MaxAgePolicy(MaxAge), existingLock, existingLockName, 15*time.Minute, time.Now())
 
 				Expect(err).To(BeNil())
 			})
@@ -326,7 +402,7 @@ var _ = Describe("RLock", func() {
 			It("should return error saying that the lock is stale", func() {
 				existingLock.LastUsed = existingLock.LastUsed.AddDate(-1, 0, 0)
 
-				err := isValid(existingLock, existingLockName, 15*time.Minute)
+				err := isValid(MaxAgePolicy(MaxAge), existingLock, existingLockName, 15*time.Minute, time.Now())
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("lock is stale"))
@@ -337,7 +413,7 @@ var _ = Describe("RLock", func() {
 			It("should return an error saying that the lock is no longer in use", func() {
 				existingLock.InUse = false
 
-				err := isValid(existingLock, existingLockName, 15*time.Minute)
+				err := isValid(MaxAgePolicy(MaxAge), existingLock, existingLockName, 15*time.Minute, time.Now())
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("lock is not in use"))
@@ -347,7 +423,7 @@ var _ = Describe("RLock", func() {
 
 		Context("when existing lock is nil", func() {
 			It("should return an error", func() {
-				err := isValid(nil, "", 15*time.Minute)
+				err := isValid(MaxAgePolicy(MaxAge), nil, "", 15*time.Minute, time.Now())
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("cannot be nil"))
@@ -502,10 +578,68 @@ var _ = Describe("RLock", func() {
 				err := l.LastError()
 
 				Expect(err).To(HaveOccurred())
+				Expect(err).To(BeAssignableToTypeOf(&LastErrorFetchErr{}))
 				Expect(err.Error()).To(ContainSubstring("unexpected error"))
 				Expect(err.Error()).To(ContainSubstring("something broke"))
 				Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
 			})
 		})
 	})
+
+	Describe("Refresh", func() {
+		var (
+			l    *Lock
+			mock sqlmock.Sqlmock
+			rl   *RLock
+		)
+
+		BeforeEach(func() {
+			_, mock, rl = setupMocks()
+
+			l = &Lock{
+				rl:      rl,
+				name:    newLockName,
+				timeout: acquireTimeout,
+			}
+		})
+
+		Context("when we still hold the lock", func() {
+			It("bumps last_used", func() {
+				mock.ExpectExec(`UPDATE rlock SET last_used=NOW\(\) WHERE name=\? AND owner=\? AND in_use=1`).
+					WithArgs(newLockName, rl.owner).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+
+				Expect(l.Refresh()).To(Succeed())
+				Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+			})
+
+			It("succeeds when last_used did not change", func() {
+				mock.ExpectExec("UPDATE rlock SET last_used").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+					sqlmock.NewRows(lockEntryColumns).AddRow(1, newLockName, rl.owner, []byte{1}, "", time.Now(), time.Now()))
+
+				Expect(l.Refresh()).To(Succeed())
+				Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("when the lock changed hands", func() {
+			It("returns LockLostErr", func() {
+				mock.ExpectExec("UPDATE rlock SET last_used").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+					sqlmock.NewRows(lockEntryColumns).AddRow(1, newLockName, "someone-else", []byte{1}, "", time.Now(), time.Now()))
+
+				Expect(l.Refresh()).To(Equal(LockLostErr))
+				Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("when the lock was unlocked", func() {
+			It("returns AlreadyUnlockedErr", func() {
+				l.unlocked = true
+
+				Expect(l.Refresh()).To(Equal(AlreadyUnlockedErr))
+			})
+		})
+	})
 })
@@ -0,0 +1,48 @@
+//go:build integration
+
+package rlocktest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/testcontainers/testcontainers-go/modules/mysql"
+)
+
+// Images the suite is run against with -tags integration
+var images = []string{
+	"mysql:8.0",
+	"mysql:8.4",
+}
+
+func TestContainers(t *testing.T) {
+	for _, image := range images {
+		image := image
+
+		t.Run(image, func(t *testing.T) {
+			ctx := context.Background()
+
+			container, err := mysql.Run(ctx, image, mysql.WithDatabase("rlock"))
+			if err != nil {
+				t.Fatalf("unable to start %v: %v", image, err)
+			}
+
+			defer container.Terminate(ctx)
+
+			dsn, err := container.ConnectionString(ctx, "parseTime=true")
+			if err != nil {
+				t.Fatalf("unable to get DSN of %v: %v", image, err)
+			}
+
+			db, err := sqlx.Open("mysql", dsn)
+			if err != nil {
+				t.Fatalf("unable to open db: %v", err)
+			}
+
+			defer db.Close()
+
+			Run(t, db)
+		})
+	}
+}
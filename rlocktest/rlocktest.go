@@ -0,0 +1,289 @@
+// Package rlocktest is a conformance suite running rlock's contention,
+// takeover and staleness matrix against a real database, ie. to check that
+// a MySQL flavor, version or proxy in front of it behaves as rlock expects:
+//
+//	func TestRLock(t *testing.T) {
+//		db := sqlx.MustOpen("mysql", os.Getenv("DSN"))
+//		rlocktest.Run(t, db)
+//	}
+//
+// The suite works on its own table ("rlocktest", created if needed; pass
+// rlock.WithTableName to override) and names its locks after the run. Do not
+// point it at a table holding real locks: it reaps any stale lock it finds.
+// The DSN must enable parseTime. This package's own tests run the suite
+// against MySQL containers (go test -tags integration) or against the
+// database in RLOCK_TEST_DSN.
+package rlocktest
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dselans/rlock"
+	"github.com/jmoiron/sqlx"
+)
+
+// TableName is the table the suite uses unless overridden
+const TableName = "rlocktest"
+
+// Contenders and the acquisitions each of them makes in the contention test
+const (
+	contenders   = 8
+	acquisitions = 5
+)
+
+type suite struct {
+	db     *sqlx.DB
+	opts   []rlock.Option
+	prefix string
+}
+
+// Run runs the suite against db, creating every rlock instance with opts.
+func Run(t *testing.T, db *sqlx.DB, opts ...rlock.Option) {
+	s := &suite{
+		db:     db,
+		opts:   append([]rlock.Option{rlock.WithTableName(TableName)}, opts...),
+		prefix: fmt.Sprintf("rlocktest-%d/", time.Now().UnixNano()),
+	}
+
+	if err := s.new(t).EnsureSchema(); err != nil {
+		t.Fatalf("unable to create schema: %v", err)
+	}
+
+	t.Run("MutualExclusion", s.testMutualExclusion)
+	t.Run("TryLock", s.testTryLock)
+	t.Run("Handoff", s.testHandoff)
+	t.Run("StaleTakeover", s.testStaleTakeover)
+	t.Run("FreshLocksAreNotTakenOver", s.testFreshLocksAreNotTakenOver)
+	t.Run("ReapStale", s.testReapStale)
+	t.Run("LastError", s.testLastError)
+}
+
+// new returns an rlock instance (with an owner of its own) using clock, if
+// given.
+func (s *suite) new(t *testing.T, clock ...rlock.Clock) *rlock.RLock {
+	opts := append([]rlock.Option{rlock.WithAdaptivePolling(5*time.Millisecond, 50*time.Millisecond)}, s.opts...)
+
+	if len(clock) > 0 {
+		opts = append(opts, rlock.WithClock(clock[0]))
+	}
+
+	rl, err := rlock.New(s.db, opts...)
+	if err != nil {
+		t.Fatalf("unable to create rlock: %v", err)
+	}
+
+	return rl
+}
+
+func (s *suite) name(t *testing.T) string {
+	return s.prefix + t.Name()
+}
+
+// stale returns an rlock instance whose clock is far enough ahead for every
+// existing lock to look stale.
+func (s *suite) stale(t *testing.T) *rlock.RLock {
+	return s.new(t, rlock.NewFakeClock(time.Now().Add(rlock.MaxAge+time.Minute)))
+}
+
+func (s *suite) lock(t *testing.T, rl *rlock.RLock, name string) *rlock.Lock {
+	l, err := rl.Lock(name, time.Minute)
+	if err != nil {
+		t.Fatalf("unable to lock '%v': %v", name, err)
+	}
+
+	return l
+}
+
+func (s *suite) testMutualExclusion(t *testing.T) {
+	name := s.name(t)
+
+	var (
+		inside   int32
+		overlaps int32
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < contenders; i++ {
+		rl := s.new(t)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < acquisitions; j++ {
+				l, err := rl.Lock(name, time.Minute)
+				if err != nil {
+					t.Errorf("unable to lock '%v': %v", name, err)
+					return
+				}
+
+				if atomic.AddInt32(&inside, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&inside, -1)
+
+				if err := l.Unlock(nil); err != nil {
+					t.Errorf("unable to unlock '%v': %v", name, err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if overlaps > 0 {
+		t.Errorf("lock was held by more than one owner at once %d times", overlaps)
+	}
+}
+
+func (s *suite) testTryLock(t *testing.T) {
+	name := s.name(t)
+	holder, contender := s.new(t), s.new(t)
+
+	l := s.lock(t, holder, name)
+	defer l.Unlock(nil)
+
+	if _, err := contender.Lock(name, 0); err != rlock.AcquireTimeoutErr {
+		t.Errorf("expected AcquireTimeoutErr locking a held lock, got %v", err)
+	}
+
+	start := time.Now()
+
+	if _, err := contender.Lock(name, 100*time.Millisecond); err != rlock.AcquireTimeoutErr {
+		t.Errorf("expected AcquireTimeoutErr waiting for a held lock, got %v", err)
+	}
+
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Errorf("expected to wait for the timeout, gave up after %v", waited)
+	}
+}
+
+func (s *suite) testHandoff(t *testing.T) {
+	name := s.name(t)
+	first, second := s.new(t), s.new(t)
+
+	l := s.lock(t, first, name)
+
+	acquired := make(chan *rlock.Lock)
+
+	go func() {
+		l, err := second.Lock(name, time.Minute)
+		if err != nil {
+			t.Errorf("unable to lock '%v' after it was released: %v", name, err)
+		}
+
+		acquired <- l
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := l.Unlock(nil); err != nil {
+		t.Fatalf("unable to unlock '%v': %v", name, err)
+	}
+
+	next := <-acquired
+	if next == nil {
+		return
+	}
+
+	defer next.Unlock(nil)
+
+	entry, err := second.Status(name)
+	if err != nil {
+		t.Fatalf("unable to get status of '%v': %v", name, err)
+	}
+
+	if entry.Owner != second.Owner() || !bool(entry.InUse) {
+		t.Errorf("expected '%v' to be held by %v, got owner %v (in use: %v)", name, second.Owner(), entry.Owner, entry.InUse)
+	}
+}
+
+func (s *suite) testStaleTakeover(t *testing.T) {
+	name := s.name(t)
+	holder, taker := s.new(t), s.stale(t)
+
+	l := s.lock(t, holder, name)
+
+	taken, err := taker.Lock(name, 0)
+	if err != nil {
+		t.Fatalf("expected to take over stale lock '%v', got %v", name, err)
+	}
+
+	defer taken.Unlock(nil)
+
+	if err := l.Refresh(); err != rlock.LockLostErr {
+		t.Errorf("expected the displaced holder's Refresh() to return LockLostErr, got %v", err)
+	}
+
+	if err := l.Unlock(nil); err == nil {
+		t.Errorf("expected the displaced holder's Unlock() to fail")
+	}
+}
+
+func (s *suite) testFreshLocksAreNotTakenOver(t *testing.T) {
+	name := s.name(t)
+	holder := s.new(t)
+	contender := s.new(t, rlock.NewFakeClock(time.Now().Add(rlock.MaxAge-time.Minute)))
+
+	l := s.lock(t, holder, name)
+	defer l.Unlock(nil)
+
+	if _, err := contender.Lock(name, 0); err != rlock.AcquireTimeoutErr {
+		t.Errorf("expected AcquireTimeoutErr locking a lock that is not stale yet, got %v", err)
+	}
+}
+
+func (s *suite) testReapStale(t *testing.T) {
+	name := s.name(t)
+	holder, reaper := s.new(t), s.stale(t)
+
+	s.lock(t, holder, name)
+
+	reaped, err := reaper.ReapStale(rlock.MaxAge)
+	if err != nil {
+		t.Fatalf("unable to reap stale locks: %v", err)
+	}
+
+	found := false
+
+	for _, r := range reaped {
+		found = found || r == name
+	}
+
+	if !found {
+		t.Errorf("expected '%v' to be reaped, reaped %v", name, reaped)
+	}
+
+	l, err := holder.Lock(name, 0)
+	if err != nil {
+		t.Fatalf("expected reaped lock '%v' to be free, got %v", name, err)
+	}
+
+	l.Unlock(nil)
+}
+
+func (s *suite) testLastError(t *testing.T) {
+	name := s.name(t)
+	first, second := s.new(t), s.new(t)
+
+	l := s.lock(t, first, name)
+
+	if err := l.Unlock(fmt.Errorf("job failed")); err != nil {
+		t.Fatalf("unable to unlock '%v': %v", name, err)
+	}
+
+	l = s.lock(t, second, name)
+	defer l.Unlock(nil)
+
+	if err := l.LastError(); err == nil || err.Error() != "job failed" {
+		t.Errorf("expected the previous holder's error, got %v", err)
+	}
+}
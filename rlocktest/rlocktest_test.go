@@ -0,0 +1,26 @@
+package rlocktest
+
+import (
+	"os"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// TestDSN runs the suite against the database in RLOCK_TEST_DSN, if set.
+func TestDSN(t *testing.T) {
+	dsn := os.Getenv("RLOCK_TEST_DSN")
+	if dsn == "" {
+		t.Skip("RLOCK_TEST_DSN is not set")
+	}
+
+	db, err := sqlx.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+
+	defer db.Close()
+
+	Run(t, db)
+}
@@ -0,0 +1,399 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// DefaultTickRetention is how long the rows of a job's ticks are kept unless
+// WithTickRetention says otherwise.
+const DefaultTickRetention = time.Hour
+
+// OverlappingRunErr is recorded as the last error of ticks skipped because
+// the job's previous run was still going; see SkipIfRunning.
+var OverlappingRunErr = errors.New("skipped: previous run is still running")
+
+// OverlapPolicy decides what happens when a job's tick comes up while its
+// previous run (on any instance) is still going.
+type OverlapPolicy int
+
+const (
+	// SkipIfRunning skips the tick (the default). Runs hold the job's
+	// "<scheduler>/<job>/running" lock to tell.
+	SkipIfRunning OverlapPolicy = iota
+
+	// AllowOverlap runs the tick regardless.
+	AllowOverlap
+)
+
+// MissedTickPolicy decides which ticks run when the scheduler falls behind,
+// ie. when the process was paused (or the job's previous ticks were slow to
+// dispatch) across several of a job's ticks.
+type MissedTickPolicy int
+
+const (
+	// RunLatestTick runs only the most recent of the missed ticks (the
+	// default).
+	RunLatestTick MissedTickPolicy = iota
+
+	// RunMissedTicks runs every missed tick, oldest first, unless it already
+	// ran elsewhere.
+	RunMissedTicks
+)
+
+// JobOption configures a job registered with a Scheduler.
+type JobOption func(*scheduledJob) error
+
+// WithOverlapPolicy sets what happens when a tick comes up while the job's
+// previous run is still going (defaults to SkipIfRunning).
+func WithOverlapPolicy(policy OverlapPolicy) JobOption {
+	return func(j *scheduledJob) error {
+		if policy != SkipIfRunning && policy != AllowOverlap {
+			return fmt.Errorf("unknown overlap policy %d", policy)
+		}
+
+		j.overlap = policy
+
+		return nil
+	}
+}
+
+// WithTickRetention sets how long the rows of the job's ticks are kept
+// (defaults to DefaultTickRetention) before the scheduler purges them, which
+// it does every quarter of retention. A tick whose row was purged runs again
+// if an instance gets to it late, so retention must exceed how late any
+// instance may be (or its clock skewed) by.
+func WithTickRetention(retention time.Duration) JobOption {
+	return func(j *scheduledJob) error {
+		if retention <= 0 {
+			return fmt.Errorf("tick retention must be positive")
+		}
+
+		j.retention = retention
+
+		return nil
+	}
+}
+
+// WithMissedTickPolicy sets which ticks run when the scheduler falls behind
+// (defaults to RunLatestTick).
+func WithMissedTickPolicy(policy MissedTickPolicy) JobOption {
+	return func(j *scheduledJob) error {
+		if policy != RunLatestTick && policy != RunMissedTicks {
+			return fmt.Errorf("unknown missed tick policy %d", policy)
+		}
+
+		j.missed = policy
+
+		return nil
+	}
+}
+
+// Scheduler runs jobs on cron schedules such that each tick of a job runs on
+// exactly one of the instances running the scheduler; see NewScheduler().
+type Scheduler struct {
+	rl   *RLock
+	name string
+
+	// Guards the fields below
+	mu      sync.Mutex
+	jobs    []*scheduledJob
+	running bool
+}
+
+type scheduledJob struct {
+	name      string
+	schedule  *cronSchedule
+	fn        func(ctx context.Context, tick time.Time) error
+	overlap   OverlapPolicy
+	missed    MissedTickPolicy
+	retention time.Duration
+
+	// The last tick considered and when ticks were last purged; only used
+	// by Run()
+	last   time.Time
+	purged time.Time
+}
+
+// NewScheduler returns the scheduler called name. Every instance should
+// register the same jobs (see Register()) and Run() it.
+//
+// Each tick of a job is a lock ("<scheduler>/<job>/<tick>", the tick being
+// formatted as RFC 3339 in UTC) that every instance tries to acquire without
+// waiting; the one that acquires it first runs the tick, and the row left
+// behind keeps the others (including latecomers) from running it again until
+// the scheduler purges it; see WithTickRetention().
+func (r *RLock) NewScheduler(name string) (*Scheduler, error) {
+	name = r.normalizeName(name)
+
+	if name == "" {
+		return nil, fmt.Errorf("scheduler name cannot be empty")
+	}
+
+	return &Scheduler{
+		rl:   r,
+		name: name,
+	}, nil
+}
+
+// Name returns the name of the scheduler.
+func (s *Scheduler) Name() string {
+	return s.name
+}
+
+// Register adds the job called job, running fn on the cron schedule spec:
+// five fields (minute, hour, day of month, month and day of week), a macro
+// such as "@hourly" or "@daily", or "@every <duration>". Ticks are computed
+// in UTC. fn is passed the tick it runs for; its context is cancelled when
+// the scheduler stops or the tick's lock is lost, and its error is recorded
+// as the tick's last error. Jobs cannot be registered once Run() was called.
+func (s *Scheduler) Register(job, spec string, fn func(ctx context.Context, tick time.Time) error, opts ...JobOption) error {
+	if job == "" {
+		return fmt.Errorf("job name cannot be empty")
+	}
+
+	// Would make the job's tick rows overlap with another job's
+	if strings.Contains(job, "/") {
+		return fmt.Errorf("job name '%v' cannot contain '/'", job)
+	}
+
+	if fn == nil {
+		return fmt.Errorf("job '%v' has no func", job)
+	}
+
+	schedule, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+
+	j := &scheduledJob{
+		name:      job,
+		schedule:  schedule,
+		fn:        fn,
+		retention: DefaultTickRetention,
+	}
+
+	for _, opt := range opts {
+		if err := opt(j); err != nil {
+			return fmt.Errorf("unable to register job '%v': %v", job, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return fmt.Errorf("scheduler '%v' is already running", s.name)
+	}
+
+	for _, other := range s.jobs {
+		if other.name == job {
+			return fmt.Errorf("job '%v' is already registered", job)
+		}
+	}
+
+	s.jobs = append(s.jobs, j)
+
+	return nil
+}
+
+// Run runs the registered jobs' ticks as they come up (starting with the
+// first tick after Run was called) until ctx is done, then waits for running
+// ticks to return and returns ctx.Err(). The scheduler may be run again
+// once Run returned.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+
+	if s.running {
+		s.mu.Unlock()
+		return fmt.Errorf("scheduler '%v' is already running", s.name)
+	}
+
+	if len(s.jobs) == 0 {
+		s.mu.Unlock()
+		return fmt.Errorf("scheduler '%v' has no jobs", s.name)
+	}
+
+	s.running = true
+	jobs := s.jobs
+
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	now := s.rl.clock.Now()
+
+	for _, j := range jobs {
+		j.last = now
+		j.purged = now
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		var next time.Time
+
+		for _, j := range jobs {
+			if t := j.schedule.next(j.last); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+
+		// None of the jobs has a tick coming up
+		if next.IsZero() {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		if _, err := s.rl.waitForRelease(ctx, nil, next.Sub(s.rl.clock.Now())); err != nil {
+			return err
+		}
+
+		now = s.rl.clock.Now()
+
+		for _, j := range jobs {
+			if now.Sub(j.purged) >= j.retention/4 {
+				j.purged = now
+				wg.Add(1)
+
+				go func(j *scheduledJob, cutoff time.Time) {
+					defer wg.Done()
+					s.purgeTicks(j, cutoff)
+				}(j, now.Add(-j.retention))
+			}
+
+			ticks := j.due(now)
+			if len(ticks) == 0 {
+				continue
+			}
+
+			wg.Add(1)
+
+			go func(j *scheduledJob, ticks []time.Time) {
+				defer wg.Done()
+
+				for _, tick := range ticks {
+					if ctx.Err() != nil {
+						return
+					}
+
+					s.runTick(ctx, j, tick)
+				}
+			}(j, ticks)
+		}
+	}
+}
+
+// due returns the job's ticks up to now that were not considered yet, as
+// per its missed tick policy.
+func (j *scheduledJob) due(now time.Time) []time.Time {
+	var ticks []time.Time
+
+	for t := j.schedule.next(j.last); !t.IsZero() && !t.After(now); t = j.schedule.next(t) {
+		ticks = append(ticks, t)
+		j.last = t
+	}
+
+	if j.missed == RunLatestTick && len(ticks) > 1 {
+		ticks = ticks[len(ticks)-1:]
+	}
+
+	return ticks
+}
+
+// tickName returns the name of the lock of the job's tick.
+func (s *Scheduler) tickName(j *scheduledJob, tick time.Time) string {
+	return fmt.Sprintf("%v/%v/%v", s.name, j.name, tick.UTC().Format(time.RFC3339))
+}
+
+// purgeTicks purges the rows of the job's ticks before cutoff. Tick names
+// only differ by their (fixed width) timestamp, so those are the names
+// between the job's prefix and the name of the tick at cutoff; the job's
+// running lock sorts after any tick.
+func (s *Scheduler) purgeTicks(j *scheduledJob, cutoff time.Time) {
+	from := s.rl.normalizeName(fmt.Sprintf("%v/%v/", s.name, j.name))
+	to := s.rl.normalizeName(s.tickName(j, cutoff))
+
+	purged, err := s.rl.purge("in_use=0 AND name >= ? AND name < ?", []interface{}{from, to})
+	if err != nil {
+		withError(s.rl.logFor(from), err).Warn("unable to purge ticks")
+		return
+	}
+
+	if purged > 0 {
+		s.rl.logFor(from).WithFields(golog.Fields{"purged": purged}).Debug("purged ticks")
+	}
+}
+
+// runTick runs the job's tick unless another instance got to it first.
+func (s *Scheduler) runTick(ctx context.Context, j *scheduledJob, tick time.Time) {
+	name := s.tickName(j, tick)
+
+	l, err := s.rl.lockContext(ctx, name, 0)
+	if err != nil {
+		if err != AcquireTimeoutErr && ctx.Err() == nil {
+			withError(s.rl.logFor(name), err).Warn("unable to acquire tick")
+		}
+
+		return
+	}
+
+	// The tick's row was released (or went stale) before we got to it, so
+	// the tick already ran elsewhere; keep its last error as it was
+	if l.Token() != 1 {
+		if err := l.UnlockFenced(l.LastError()); err != nil && err != LockLostErr {
+			withError(s.rl.logFor(name), err).Warn("unable to release tick")
+		}
+
+		return
+	}
+
+	if j.overlap == SkipIfRunning {
+		running, err := s.rl.lockContext(ctx, fmt.Sprintf("%v/%v/running", s.name, j.name), 0)
+		if err != nil {
+			if err != AcquireTimeoutErr {
+				withError(s.rl.logFor(name), err).Warn("unable to tell whether the previous run is still running")
+			} else {
+				s.rl.logFor(name).Info("skipping tick; previous run is still running")
+				err = OverlappingRunErr
+			}
+
+			if err := l.UnlockFenced(err); err != nil && err != LockLostErr {
+				withError(s.rl.logFor(name), err).Warn("unable to release tick")
+			}
+
+			return
+		}
+
+		stop := running.Heartbeat(s.rl.leaderHeartbeat)
+
+		defer func() {
+			stop()
+
+			if err := running.UnlockFenced(nil); err != nil && err != LockLostErr {
+				withError(running.rl.logFor(running.name), err).Warn("unable to release running lock")
+			}
+		}()
+	}
+
+	s.rl.logFor(name).Info("running tick")
+
+	err = s.rl.lead(ctx, l, func(ctx context.Context) error {
+		return j.fn(ctx, tick)
+	})
+
+	if err != nil && err != LockLostErr && ctx.Err() == nil {
+		withError(s.rl.logFor(name), err).Warn("tick failed")
+	}
+}
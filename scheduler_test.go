@@ -0,0 +1,260 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Scheduler", func() {
+	var (
+		mock   sqlmock.Sqlmock
+		clock  *FakeClock
+		rl     *RLock
+		cancel context.CancelFunc
+		errs   chan error
+		ticks  chan time.Time
+		events <-chan *Event
+	)
+
+	const firstTick = "sched/report/2020-01-01T10:01:00Z"
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		start, err := time.Parse(time.RFC3339, "2020-01-01T10:00:30Z")
+		Expect(err).ToNot(HaveOccurred())
+
+		clock = NewFakeClock(start)
+
+		rl, err = New(db, WithClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+
+		errs = make(chan error, 1)
+		ticks = make(chan time.Time, 10)
+		events, _ = rl.Subscribe(100)
+	})
+
+	// run runs a scheduler with a job ticking every minute in the background
+	run := func(fnErr error, opts ...JobOption) {
+		s, err := rl.NewScheduler("sched")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(s.Register("report", "* * * * *", func(ctx context.Context, tick time.Time) error {
+			ticks <- tick
+			return fnErr
+		}, opts...)).To(Succeed())
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+
+		go func() {
+			errs <- s.Run(ctx)
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+	}
+
+	// stop stops the scheduler once the lock called last, released last, is
+	// released
+	stop := func(last string) {
+		Eventually(func() string {
+			select {
+			case e := <-events:
+				if e.Type == EventReleased {
+					return e.Name
+				}
+			default:
+			}
+
+			return ""
+		}).Should(Equal(last))
+
+		cancel()
+		Eventually(errs).Should(Receive(Equal(context.Canceled)))
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	}
+
+	expectContended := func(name string) {
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \* FROM rlock`).WithArgs(name).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(1, name, "other-owner", []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec(`UPDATE rlock SET .*in_use=1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE rlock SET timeout_count").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	It("validates jobs", func() {
+		s, err := rl.NewScheduler("sched")
+		Expect(err).ToNot(HaveOccurred())
+
+		noop := func(ctx context.Context, tick time.Time) error { return nil }
+
+		Expect(s.Run(context.Background())).ToNot(Succeed())
+
+		Expect(s.Register("", "@hourly", noop)).ToNot(Succeed())
+		Expect(s.Register("report", "@hourly", nil)).ToNot(Succeed())
+		Expect(s.Register("report", "61 * * * *", noop)).ToNot(Succeed())
+		Expect(s.Register("report", "@hourly", noop, WithOverlapPolicy(OverlapPolicy(7)))).ToNot(Succeed())
+		Expect(s.Register("report", "@hourly", noop, WithTickRetention(0))).ToNot(Succeed())
+		Expect(s.Register("report/daily", "@hourly", noop)).ToNot(Succeed())
+
+		Expect(s.Register("report", "@hourly", noop)).To(Succeed())
+		Expect(s.Register("report", "@daily", noop)).ToNot(Succeed())
+
+		_, err = rl.NewScheduler("")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("runs the tick once acquired, holding the job's running lock", func() {
+		run(fmt.Errorf("boom"))
+
+		mock.ExpectExec("INSERT INTO rlock").WithArgs(firstTick, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE rlock SET in_use=0, last_error=\? WHERE name=\? AND owner=\? AND acquire_count=\?`).
+			WithArgs("boom", firstTick, rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").
+			WithArgs("", "sched/report/running", rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		clock.Advance(30 * time.Second)
+
+		Eventually(ticks).Should(Receive(Equal(clock.Now())))
+		stop("sched/report/running")
+	})
+
+	It("skips ticks running on another instance", func() {
+		run(nil, WithOverlapPolicy(AllowOverlap), WithMissedTickPolicy(RunMissedTicks))
+
+		next := "sched/report/2020-01-01T10:02:00Z"
+
+		expectContended(firstTick)
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", next, rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		clock.Advance(90 * time.Second)
+
+		stop(next)
+		Expect(ticks).To(Receive(Equal(clock.Now().Truncate(time.Minute))))
+		Expect(ticks).ToNot(Receive())
+	})
+
+	It("skips ticks that already ran, keeping their last error", func() {
+		run(nil)
+
+		// Released by the instance that ran it, and acquired again
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(2, 2))
+		mock.ExpectQuery("SELECT last_error FROM rlock").WithArgs(firstTick, rl.owner).
+			WillReturnRows(sqlmock.NewRows([]string{"last_error"}).AddRow("boom"))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("boom", firstTick, rl.owner, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		clock.Advance(30 * time.Second)
+
+		stop(firstTick)
+		Expect(ticks).ToNot(Receive())
+	})
+
+	It("skips ticks while the previous run is still running", func() {
+		run(nil)
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		expectContended("sched/report/running")
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs(OverlappingRunErr.Error(), firstTick, rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		clock.Advance(30 * time.Second)
+
+		stop(firstTick)
+		Expect(ticks).ToNot(Receive())
+	})
+
+	It("runs overlapping ticks when allowed", func() {
+		run(nil, WithOverlapPolicy(AllowOverlap))
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", firstTick, rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		clock.Advance(30 * time.Second)
+
+		Eventually(ticks).Should(Receive())
+		stop(firstTick)
+	})
+
+	It("runs only the latest of missed ticks by default", func() {
+		run(nil, WithOverlapPolicy(AllowOverlap))
+
+		latest := "sched/report/2020-01-01T10:03:00Z"
+
+		mock.ExpectExec("INSERT INTO rlock").WithArgs(latest, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", latest, rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		clock.Advance(150 * time.Second)
+
+		Eventually(ticks).Should(Receive(Equal(clock.Now().Truncate(time.Minute))))
+		stop(latest)
+		Expect(ticks).ToNot(Receive())
+	})
+
+	It("can be run again once stopped", func() {
+		s, err := rl.NewScheduler("sched")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(s.Register("report", "@hourly", func(ctx context.Context, tick time.Time) error {
+			return nil
+		})).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(s.Run(ctx)).To(Equal(context.Canceled))
+		Expect(s.Run(ctx)).To(Equal(context.Canceled))
+	})
+
+	It("purges ticks older than the retention", func() {
+		mock.MatchExpectationsInOrder(false)
+
+		// Purged every 15s
+		run(nil, WithOverlapPolicy(AllowOverlap), WithTickRetention(time.Minute))
+
+		mock.ExpectExec(`DELETE FROM rlock WHERE in_use=0 AND name >= \? AND name < \?`).
+			WithArgs("sched/report/", "sched/report/2020-01-01T10:00:00Z").
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", firstTick, rl.owner, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		clock.Advance(30 * time.Second)
+
+		stop(firstTick)
+	})
+
+	It("runs every missed tick when asked to", func() {
+		run(nil, WithOverlapPolicy(AllowOverlap), WithMissedTickPolicy(RunMissedTicks))
+
+		for _, tick := range []string{"10:01", "10:02", "10:03"} {
+			name := fmt.Sprintf("sched/report/2020-01-01T%v:00Z", tick)
+
+			mock.ExpectExec("INSERT INTO rlock").WithArgs(name, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE rlock SET in_use=0").WithArgs("", name, rl.owner, 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		clock.Advance(150 * time.Second)
+
+		stop("sched/report/2020-01-01T10:03:00Z")
+		Expect(ticks).To(HaveLen(3))
+	})
+})
@@ -0,0 +1,431 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaMismatchErr is returned by ValidateSchema (and, with
+// WithStrictSchema, EnsureSchema) when the tables differ from the schema
+// rlock expects.
+var SchemaMismatchErr = errors.New("tables do not match the expected schema")
+
+const schemaDDL = "CREATE TABLE IF NOT EXISTS `%v` (\n" +
+	"  `id` INT NOT NULL AUTO_INCREMENT,\n" +
+	"  `name` VARCHAR(255) NOT NULL,\n" +
+	"  `owner` VARCHAR(255) NOT NULL,\n" +
+	"  `in_use` BIT(1) NOT NULL DEFAULT b'0',\n" +
+	"  `last_error` VARCHAR(2048) NOT NULL DEFAULT '',\n" +
+	"  `last_used` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n" +
+	"  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"%v" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  UNIQUE KEY `name` (`name`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+const auditSchemaDDL = "CREATE TABLE IF NOT EXISTS `%v` (\n" +
+	"  `id` BIGINT NOT NULL AUTO_INCREMENT,\n" +
+	"  `name` VARCHAR(255) NOT NULL,\n" +
+	"  `owner` VARCHAR(255) NOT NULL,\n" +
+	"  `host` VARCHAR(255) NOT NULL DEFAULT '',\n" +
+	"  `pid` INT NOT NULL DEFAULT 0,\n" +
+	"  `acquired_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"  `released_at` TIMESTAMP NULL DEFAULT NULL,\n" +
+	"  `exit_status` VARCHAR(32) NOT NULL DEFAULT '',\n" +
+	"  `last_error` VARCHAR(2048) NOT NULL DEFAULT '',\n" +
+	"%v" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  KEY `name_id` (`name`, `id`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+type column struct {
+	name       string
+	definition string
+}
+
+// Columns of the initial schemas, as checked by ValidateSchema()
+var (
+	initialSchemaColumns = []column{
+		{"id", "INT"},
+		{"name", "VARCHAR(255)"},
+		{"owner", "VARCHAR(255)"},
+		{"in_use", "BIT(1)"},
+		{"last_error", "VARCHAR(2048)"},
+		{"last_used", "TIMESTAMP"},
+		{"created_at", "TIMESTAMP"},
+	}
+
+	initialAuditSchemaColumns = []column{
+		{"id", "BIGINT"},
+		{"name", "VARCHAR(255)"},
+		{"owner", "VARCHAR(255)"},
+		{"host", "VARCHAR(255)"},
+		{"pid", "INT"},
+		{"acquired_at", "TIMESTAMP"},
+		{"released_at", "TIMESTAMP"},
+		{"exit_status", "VARCHAR(32)"},
+		{"last_error", "VARCHAR(2048)"},
+	}
+
+	initialWaitersSchemaColumns = []column{
+		{"id", "BIGINT"},
+		{"name", "VARCHAR(255)"},
+		{"owner", "VARCHAR(255)"},
+		{"host", "VARCHAR(255)"},
+		{"pid", "INT"},
+		{"since", "TIMESTAMP"},
+		{"seen_at", "TIMESTAMP"},
+	}
+)
+
+// Column types that are interchangeable as far as rlock is concerned
+var columnTypeFamilies = map[string]string{
+	"tinyint":   "integer",
+	"smallint":  "integer",
+	"mediumint": "integer",
+	"int":       "integer",
+	"bigint":    "integer",
+	"char":      "string",
+	"varchar":   "string",
+	"text":      "string",
+	"timestamp": "time",
+	"datetime":  "time",
+	"bit":       "bit",
+}
+
+// Columns added after the initial schema; EnsureSchema() adds them to tables
+// created by earlier versions.
+var schemaColumns = []column{
+	{"acquired_at", "TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+	{"acquire_count", "BIGINT NOT NULL DEFAULT 0"},
+	{"host", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"pid", "INT NOT NULL DEFAULT 0"},
+	{"takeover_count", "BIGINT NOT NULL DEFAULT 0"},
+	{"timeout_count", "BIGINT NOT NULL DEFAULT 0"},
+	{"metadata", "BLOB NULL"},
+	{"correlation_id", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"deleted_at", "TIMESTAMP NULL DEFAULT NULL"},
+	{"owner_labels", "TEXT NULL"},
+	{"checkpoint", "BLOB NULL"},
+}
+
+// Columns added to the audit table after its initial schema
+var auditSchemaColumns = []column{
+	{"acquire_mode", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"previous_owner", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"evidence", "VARCHAR(1024) NOT NULL DEFAULT ''"},
+	{"correlation_id", "VARCHAR(255) NOT NULL DEFAULT ''"},
+}
+
+// Schema returns the MySQL DDL creating a lock table called table.
+func Schema(table string) string {
+	return fmt.Sprintf(schemaDDL, table, columnDDL(schemaColumns))
+}
+
+// AuditSchema returns the MySQL DDL creating the audit table for a lock table
+// called table (see WithAuditLog).
+func AuditSchema(table string) string {
+	return fmt.Sprintf(auditSchemaDDL, table+"_audit", columnDDL(auditSchemaColumns))
+}
+
+func columnDDL(columns []column) string {
+	var ddl string
+
+	for _, c := range columns {
+		ddl += fmt.Sprintf("  `%v` %v,\n", c.name, c.definition)
+	}
+
+	return ddl
+}
+
+// EnsureSchema creates the lock table (and the audit and waiter tables, if
+// enabled) if it does not exist yet, adds any columns missing from tables
+// created by earlier versions of rlock and runs the migrations newer than the
+// recorded schema version (see SchemaVersion).
+func (r *RLock) EnsureSchema() error {
+	if r.externalMigrations {
+		if err := r.checkSchemaVersion(context.Background()); err != nil {
+			return err
+		}
+
+		return r.validateStrict()
+	}
+
+	for _, table := range r.tables() {
+		if _, err := r.exec(Schema(table)); err != nil {
+			return fmt.Errorf("unable to create table '%v': %v", table, err)
+		}
+	}
+
+	if r.audit {
+		ddl := AuditSchema(r.table)
+		if r.auditPartitioned {
+			ddl = PartitionedAuditSchema(r.table)
+		}
+
+		if _, err := r.exec(ddl); err != nil {
+			return fmt.Errorf("unable to create table '%v': %v", r.auditTable(), err)
+		}
+	}
+
+	if r.trackWaiters {
+		if _, err := r.exec(WaitersSchema(r.table)); err != nil {
+			return fmt.Errorf("unable to create table '%v': %v", r.waitersTable(), err)
+		}
+	}
+
+	if r.archive {
+		if _, err := r.exec(ArchiveSchema(r.table)); err != nil {
+			return fmt.Errorf("unable to create table '%v': %v", r.archiveTable(), err)
+		}
+	}
+
+	if r.totals {
+		if _, err := r.exec(TotalsSchema(r.table)); err != nil {
+			return fmt.Errorf("unable to create table '%v': %v", r.totalsTable(), err)
+		}
+	}
+
+	for _, table := range r.tables() {
+		if err := r.addMissingColumns(table, schemaColumns); err != nil {
+			return err
+		}
+	}
+
+	if r.audit {
+		if err := r.addMissingColumns(r.auditTable(), auditSchemaColumns); err != nil {
+			return err
+		}
+	}
+
+	if r.trackWaiters {
+		if err := r.addMissingColumns(r.waitersTable(), waitersSchemaColumns); err != nil {
+			return err
+		}
+	}
+
+	if r.archive {
+		if err := r.addMissingColumns(r.archiveTable(), schemaColumns); err != nil {
+			return err
+		}
+	}
+
+	if err := r.migrate(context.Background()); err != nil {
+		return err
+	}
+
+	return r.validateStrict()
+}
+
+// validateStrict validates the schema with WithStrictSchema.
+func (r *RLock) validateStrict() error {
+	if !r.strictSchema {
+		return nil
+	}
+
+	problems, err := r.ValidateSchema(context.Background())
+	if err != nil && err != SchemaMismatchErr {
+		return err
+	}
+
+	if err == SchemaMismatchErr {
+		return fmt.Errorf("%v:\n%v", err, strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
+
+// WithStrictSchema makes EnsureSchema() validate the tables once it is done
+// (see ValidateSchema()), failing on drift it cannot fix itself (ie. changed
+// column types or a missing unique index) rather than leaving it to surface
+// as confusing errors at runtime.
+func WithStrictSchema() Option {
+	return func(r *RLock) error {
+		r.strictSchema = true
+		return nil
+	}
+}
+
+// ValidateSchema checks that the lock table (and the audit and waiter tables,
+// if enabled) have every column rlock uses, with a compatible type, and the
+// unique indexes it relies on (ie. on the lock name). Any differences are
+// returned along with SchemaMismatchErr; run it at startup to fail fast when
+// the tables drifted.
+func (r *RLock) ValidateSchema(ctx context.Context) ([]string, error) {
+	problems := make([]string, 0)
+
+	for _, table := range r.tables() {
+		found, err := r.validateTable(ctx, table, append(initialSchemaColumns, schemaColumns...), "name")
+		if err != nil {
+			return nil, err
+		}
+
+		problems = append(problems, found...)
+	}
+
+	if r.audit {
+		found, err := r.validateTable(ctx, r.auditTable(), append(initialAuditSchemaColumns, auditSchemaColumns...))
+		if err != nil {
+			return nil, err
+		}
+
+		problems = append(problems, found...)
+	}
+
+	if r.trackWaiters {
+		found, err := r.validateTable(ctx, r.waitersTable(), append(initialWaitersSchemaColumns, waitersSchemaColumns...), "name", "owner")
+		if err != nil {
+			return nil, err
+		}
+
+		problems = append(problems, found...)
+	}
+
+	if r.archive {
+		columns := append(append(initialSchemaColumns, schemaColumns...), column{"archived_at", "TIMESTAMP"})
+
+		found, err := r.validateTable(ctx, r.archiveTable(), columns)
+		if err != nil {
+			return nil, err
+		}
+
+		problems = append(problems, found...)
+	}
+
+	if r.totals {
+		found, err := r.validateTable(ctx, r.totalsTable(), totalsSchemaColumns, "name")
+		if err != nil {
+			return nil, err
+		}
+
+		problems = append(problems, found...)
+	}
+
+	if len(problems) > 0 {
+		return problems, SchemaMismatchErr
+	}
+
+	return problems, nil
+}
+
+// validateTable returns how table differs from columns and, if given, a unique
+// index on exactly the unique columns.
+func (r *RLock) validateTable(ctx context.Context, table string, columns []column, unique ...string) ([]string, error) {
+	query := "SELECT column_name AS column_name, data_type AS data_type FROM information_schema.columns " +
+		"WHERE table_schema=DATABASE() AND table_name=?"
+
+	existing, err := r.selectStrings(ctx, 2, query, table)
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect table '%v': %v", table, err)
+	}
+
+	if len(existing) == 0 {
+		return []string{fmt.Sprintf("table '%v' does not exist", table)}, nil
+	}
+
+	types := make(map[string]string, len(existing))
+
+	for _, c := range existing {
+		types[strings.ToLower(c[0])] = strings.ToLower(c[1])
+	}
+
+	problems := make([]string, 0)
+
+	for _, c := range columns {
+		have, ok := types[c.name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("table '%v' is missing column '%v' (%v)", table, c.name, c.definition))
+			continue
+		}
+
+		want := columnType(c.definition)
+
+		if columnTypeFamilies[have] != columnTypeFamilies[want] {
+			problems = append(problems, fmt.Sprintf("column '%v' of table '%v' is %v, expected %v", c.name, table, have, want))
+		}
+	}
+
+	if len(unique) == 0 {
+		return problems, nil
+	}
+
+	query = "SELECT index_name AS index_name, column_name AS column_name FROM information_schema.statistics " +
+		"WHERE table_schema=DATABASE() AND table_name=? AND non_unique=0 ORDER BY index_name, seq_in_index"
+
+	indexed, err := r.selectStrings(ctx, 2, query, table)
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect indexes of '%v': %v", table, err)
+	}
+
+	indexes := make(map[string][]string)
+
+	for _, i := range indexed {
+		indexes[i[0]] = append(indexes[i[0]], strings.ToLower(i[1]))
+	}
+
+	want := strings.Join(unique, ", ")
+
+	for _, columns := range indexes {
+		if strings.Join(columns, ", ") == want {
+			return problems, nil
+		}
+	}
+
+	names := make([]string, 0, len(indexes))
+
+	for name := range indexes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return append(problems, fmt.Sprintf("table '%v' is missing a unique index on (%v); unique indexes: [%v]",
+		table, want, strings.Join(names, ", "))), nil
+}
+
+// columnType returns the MySQL data type of a column definition (ie.
+// "varchar" for "VARCHAR(255) NOT NULL").
+func columnType(definition string) string {
+	t := strings.Fields(definition)[0]
+
+	if i := strings.Index(t, "("); i >= 0 {
+		t = t[:i]
+	}
+
+	return strings.ToLower(t)
+}
+
+// addMissingColumns adds any of columns that table (created by an older
+// version of rlock) is missing.
+func (r *RLock) addMissingColumns(table string, columns []column) error {
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name=?"
+
+	existing := make([]string, 0)
+
+	if err := r.selectAll(&existing, query, table); err != nil {
+		return fmt.Errorf("unable to inspect table '%v': %v", table, err)
+	}
+
+	have := make(map[string]bool, len(existing))
+
+	for _, name := range existing {
+		have[name] = true
+	}
+
+	for _, c := range columns {
+		if have[c.name] {
+			continue
+		}
+
+		alter := fmt.Sprintf("ALTER TABLE `%v` ADD COLUMN `%v` %v", table, c.name, c.definition)
+
+		if _, err := r.exec(alter); err != nil {
+			return fmt.Errorf("unable to add column '%v' to '%v': %v", c.name, table, err)
+		}
+	}
+
+	return nil
+}
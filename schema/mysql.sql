@@ -0,0 +1,67 @@
+-- Canonical rlock schema (see rlock.SchemaSQL("mysql")) for the default table
+-- name. The audit and waiter tables are only needed with WithAuditLog and
+-- WithWaiterTracking respectively.
+
+CREATE TABLE IF NOT EXISTS `rlock` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `name` VARCHAR(255) NOT NULL,
+  `owner` VARCHAR(255) NOT NULL,
+  `in_use` BIT(1) NOT NULL DEFAULT b'0',
+  `last_error` VARCHAR(2048) NOT NULL DEFAULT '',
+  `last_used` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `acquired_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `acquire_count` BIGINT NOT NULL DEFAULT 0,
+  `host` VARCHAR(255) NOT NULL DEFAULT '',
+  `pid` INT NOT NULL DEFAULT 0,
+  `takeover_count` BIGINT NOT NULL DEFAULT 0,
+  `timeout_count` BIGINT NOT NULL DEFAULT 0,
+  `metadata` BLOB NULL,
+  `correlation_id` VARCHAR(255) NOT NULL DEFAULT '',
+  `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+  `owner_labels` TEXT NULL,
+  `checkpoint` BLOB NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `rlock_schema_version` (
+  `id` TINYINT NOT NULL,
+  `version` INT NOT NULL,
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO rlock_schema_version (id, version) VALUES (1, 8) ON DUPLICATE KEY UPDATE version=GREATEST(version, VALUES(version));
+
+CREATE TABLE IF NOT EXISTS `rlock_audit` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `name` VARCHAR(255) NOT NULL,
+  `owner` VARCHAR(255) NOT NULL,
+  `host` VARCHAR(255) NOT NULL DEFAULT '',
+  `pid` INT NOT NULL DEFAULT 0,
+  `acquired_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `released_at` TIMESTAMP NULL DEFAULT NULL,
+  `exit_status` VARCHAR(32) NOT NULL DEFAULT '',
+  `last_error` VARCHAR(2048) NOT NULL DEFAULT '',
+  `acquire_mode` VARCHAR(32) NOT NULL DEFAULT '',
+  `previous_owner` VARCHAR(255) NOT NULL DEFAULT '',
+  `evidence` VARCHAR(1024) NOT NULL DEFAULT '',
+  `correlation_id` VARCHAR(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `name_id` (`name`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `rlock_waiters` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `name` VARCHAR(255) NOT NULL,
+  `owner` VARCHAR(255) NOT NULL,
+  `host` VARCHAR(255) NOT NULL DEFAULT '',
+  `pid` INT NOT NULL DEFAULT 0,
+  `since` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `seen_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `eligible` BIT(1) NOT NULL DEFAULT b'0',
+  `priority` INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `name_owner` (`name`, `owner`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,168 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Schema", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	It("includes every column of LockEntry", func() {
+		ddl := Schema("locks")
+
+		Expect(ddl).To(HavePrefix("CREATE TABLE IF NOT EXISTS `locks`"))
+
+		for _, column := range append(lockEntryColumns, "acquired_at", "acquire_count", "host", "pid", "takeover_count", "timeout_count") {
+			Expect(ddl).To(ContainSubstring("`" + column + "`"))
+		}
+	})
+
+	Describe("EnsureSchema", func() {
+		It("adds columns missing from older tables", func() {
+			columns := sqlmock.NewRows([]string{"column_name"})
+			for _, c := range append(lockEntryColumns, "acquired_at", "acquire_count") {
+				columns.AddRow(c)
+			}
+
+			mock.ExpectExec("CREATE TABLE IF NOT EXISTS").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT column_name FROM information_schema.columns").
+				WithArgs(TableName).
+				WillReturnRows(columns)
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `host`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `pid`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `takeover_count`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `timeout_count`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `metadata`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `correlation_id`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `deleted_at`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `owner_labels`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE `rlock` ADD COLUMN `checkpoint`").WillReturnResult(sqlmock.NewResult(0, 0))
+			expectSchemaVersion(mock, SchemaVersion)
+
+			Expect(rl.EnsureSchema()).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("returns an error when the table cannot be created", func() {
+			mock.ExpectExec("CREATE TABLE").WillReturnError(fmt.Errorf("access denied"))
+
+			err := rl.EnsureSchema()
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("access denied"))
+		})
+	})
+})
+
+var _ = Describe("ValidateSchema", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+	})
+
+	columnRows := func(skip string, override map[string]string) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"COLUMN_NAME", "DATA_TYPE"})
+
+		for _, c := range append(initialSchemaColumns, schemaColumns...) {
+			if c.name == skip {
+				continue
+			}
+
+			t := columnType(c.definition)
+			if override[c.name] != "" {
+				t = override[c.name]
+			}
+
+			rows.AddRow(c.name, t)
+		}
+
+		return rows
+	}
+
+	indexRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME"}).AddRow("PRIMARY", "id").AddRow("name", "name")
+	}
+
+	It("accepts the schema created by EnsureSchema", func() {
+		mock.ExpectQuery("SELECT column_name AS column_name, data_type AS data_type FROM information_schema.columns").WithArgs("rlock").
+			WillReturnRows(columnRows("", map[string]string{"id": "bigint", "last_used": "datetime"}))
+		mock.ExpectQuery("SELECT index_name AS index_name, column_name AS column_name FROM information_schema.statistics").WithArgs("rlock").
+			WillReturnRows(indexRows())
+
+		problems, err := rl.ValidateSchema(context.Background())
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(problems).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("reports missing columns, incompatible types and a missing unique index", func() {
+		mock.ExpectQuery("SELECT column_name AS column_name, data_type AS data_type").
+			WillReturnRows(columnRows("pid", map[string]string{"in_use": "tinyint"}))
+		mock.ExpectQuery("SELECT index_name AS index_name, column_name AS column_name").
+			WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME"}).AddRow("PRIMARY", "id"))
+
+		problems, err := rl.ValidateSchema(context.Background())
+
+		Expect(err).To(Equal(SchemaMismatchErr))
+		Expect(problems).To(Equal([]string{
+			"column 'in_use' of table 'rlock' is tinyint, expected bit",
+			"table 'rlock' is missing column 'pid' (INT NOT NULL DEFAULT 0)",
+			"table 'rlock' is missing a unique index on (name); unique indexes: [PRIMARY]",
+		}))
+	})
+
+	It("reports missing tables", func() {
+		mock.ExpectQuery("SELECT column_name AS column_name, data_type AS data_type").
+			WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME", "DATA_TYPE"}))
+
+		problems, err := rl.ValidateSchema(context.Background())
+
+		Expect(err).To(Equal(SchemaMismatchErr))
+		Expect(problems).To(Equal([]string{"table 'rlock' does not exist"}))
+	})
+
+	It("validates the tables after EnsureSchema in strict mode", func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithStrictSchema())
+		Expect(err).ToNot(HaveOccurred())
+
+		existing := sqlmock.NewRows([]string{"column_name"})
+		for _, c := range schemaColumns {
+			existing.AddRow(c.name)
+		}
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT column_name FROM information_schema.columns").WillReturnRows(existing)
+		expectSchemaVersion(mock, SchemaVersion)
+		mock.ExpectQuery("SELECT column_name AS column_name, data_type AS data_type").WillReturnRows(columnRows("", nil))
+		mock.ExpectQuery("SELECT index_name AS index_name, column_name AS column_name").
+			WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME"}).AddRow("name", "name").AddRow("name", "owner"))
+
+		err = rl.EnsureSchema()
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing a unique index on (name)"))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
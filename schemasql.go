@@ -0,0 +1,22 @@
+package rlock
+
+import (
+	"embed"
+	"fmt"
+)
+
+//go:embed schema/*.sql
+var schemaSQL embed.FS
+
+// SchemaSQL returns the canonical DDL (for the default table name, see
+// TableName) of the given SQL dialect, ie. for provisioning the tables from
+// infrastructure-as-code pipelines instead of EnsureSchema(). The only
+// supported dialect is "mysql".
+func SchemaSQL(dialect string) (string, error) {
+	ddl, err := schemaSQL.ReadFile("schema/" + dialect + ".sql")
+	if err != nil {
+		return "", fmt.Errorf("unsupported dialect '%v'", dialect)
+	}
+
+	return string(ddl), nil
+}
@@ -0,0 +1,33 @@
+package rlock
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SchemaSQL", func() {
+	It("matches the schema created by EnsureSchema", func() {
+		ddl, err := SchemaSQL("mysql")
+		Expect(err).ToNot(HaveOccurred())
+
+		migrations := Migrations(TableName)
+		last := migrations[len(migrations)-1].Statements
+
+		for _, statement := range []string{
+			Schema(TableName),
+			fmt.Sprintf(schemaVersionDDL, TableName+"_schema_version"),
+			last[len(last)-1],
+			AuditSchema(TableName),
+			WaitersSchema(TableName),
+		} {
+			Expect(ddl).To(ContainSubstring(statement + ";\n"))
+		}
+	})
+
+	It("refuses unsupported dialects", func() {
+		_, err := SchemaSQL("oracle")
+		Expect(err).To(HaveOccurred())
+	})
+})
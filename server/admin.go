@@ -0,0 +1,171 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/dselans/rlock"
+)
+
+//go:embed ui
+var uiFS embed.FS
+
+// admin is implemented by *rlock.RLock
+type admin interface {
+	ListLocks(labels ...rlock.Label) ([]*rlock.LockEntry, error)
+	GetLocksByOwner(owner string, labels ...rlock.Label) ([]*rlock.LockEntry, error)
+	FindLocks(pattern string) ([]*rlock.LockEntry, error)
+	ForceUnlock(name, reason string) error
+}
+
+// ForceUnlockRequest is the body of a force unlock request.
+type ForceUnlockRequest struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func uiHandler() http.Handler {
+	sub, err := fs.Sub(uiFS, "ui")
+	if err != nil {
+		panic(fmt.Sprintf("unable to load embedded ui: %v", err))
+	}
+
+	return http.FileServer(http.FS(sub))
+}
+
+func (s *Server) listHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	a, ok := s.rl.(admin)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "", "listing locks is not supported by this backend")
+		return
+	}
+
+	var principal *Principal
+
+	if s.auth != nil {
+		if principal = s.auth.authenticate(r); principal == nil {
+			writeError(w, http.StatusUnauthorized, "", "unauthenticated")
+			return
+		}
+	}
+
+	var (
+		entries []*rlock.LockEntry
+		err     error
+	)
+
+	owner := r.URL.Query().Get("owner")
+	pattern := r.URL.Query().Get("name")
+
+	labels, err := parseLabels(r.URL.Query()["label"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+
+	switch {
+	case pattern != "":
+		entries, err = a.FindLocks(pattern)
+	case owner != "":
+		entries, err = a.GetLocksByOwner(owner, labels...)
+	default:
+		entries, err = a.ListLocks(labels...)
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	visible := make([]*rlock.LockEntry, 0, len(entries))
+
+	for _, e := range entries {
+		if principal != nil && !principal.Allowed(s.unscoped(e.Name), RoleReadOnly) {
+			continue
+		}
+
+		if owner != "" && e.Owner != owner {
+			continue
+		}
+
+		if !hasLabels(e, labels) {
+			continue
+		}
+
+		visible = append(visible, e)
+	}
+
+	writeJSON(w, http.StatusOK, visible)
+}
+
+// parseLabels parses label filters given as key=value.
+func parseLabels(values []string) ([]rlock.Label, error) {
+	labels := make([]rlock.Label, 0, len(values))
+
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("label filter '%v' is not of the form key=value", v)
+		}
+
+		labels = append(labels, rlock.Label{Key: parts[0], Value: parts[1]})
+	}
+
+	return labels, nil
+}
+
+// hasLabels returns whether e's holder was labeled with every label.
+func hasLabels(e *rlock.LockEntry, labels []rlock.Label) bool {
+	for _, label := range labels {
+		if v, ok := e.OwnerLabels[label.Key]; !ok || v != label.Value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Server) forceUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	a, ok := s.rl.(admin)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "", "force unlock is not supported by this backend")
+		return
+	}
+
+	req := &ForceUnlockRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, "", fmt.Sprintf("unable to decode request: %v", err))
+		return
+	}
+
+	if !s.authorize(w, r, req.Name, RoleForceTakeover) {
+		return
+	}
+
+	if err := a.ForceUnlock(req.Name, req.Reason); err != nil {
+		if err == rlock.KeyNotFoundErr {
+			writeError(w, http.StatusNotFound, rlock.ProxyErrNotFound, err.Error())
+			return
+		}
+
+		writeError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct{}{})
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/dselans/rlock"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("admin", func() {
+	var (
+		mock sqlmock.Sqlmock
+		s    *Server
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err := rlock.New(sqlx.NewDb(mockDB, "sqlmock"))
+		Expect(err).ToNot(HaveOccurred())
+
+		s, err = New(rl, WithAuth(&Auth{
+			APIKeys: map[string]*Principal{
+				"billing-key": {Name: "billing", Grants: []Grant{{Namespace: "billing/", Role: RoleUnlock}}},
+				"ops-key":     {Name: "ops", Grants: []Grant{{Namespace: "*", Role: RoleForceTakeover}}},
+			},
+		}))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	request := func(key, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		return w
+	}
+
+	Describe("list", func() {
+		It("only returns locks the caller is allowed to see", func() {
+			rows := sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at"}).
+				AddRow(1, "billing/invoice-1", "a", []byte{1}, "", time.Now(), time.Now()).
+				AddRow(2, "shipping/order-1", "b", []byte{1}, "", time.Now(), time.Now())
+
+			mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+			w := request("billing-key", http.MethodGet, "/v1/locks", "")
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var entries []*rlock.LockEntry
+			Expect(json.NewDecoder(w.Body).Decode(&entries)).To(Succeed())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Name).To(Equal("billing/invoice-1"))
+		})
+	})
+
+	It("filters by owner", func() {
+		rows := sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at"}).
+			AddRow(1, "billing/invoice-1", "a", []byte{1}, "", time.Now(), time.Now())
+
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE owner=\?`).WithArgs("a").WillReturnRows(rows)
+
+		w := request("ops-key", http.MethodGet, "/v1/locks?owner=a", "")
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("filters by name pattern", func() {
+		rows := sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at"}).
+			AddRow(1, "billing/invoice-1", "a", []byte{1}, "", time.Now(), time.Now()).
+			AddRow(2, "billing/invoice-2", "b", []byte{1}, "", time.Now(), time.Now())
+
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name LIKE \?`).WithArgs("billing/invoice-%").WillReturnRows(rows)
+
+		w := request("ops-key", http.MethodGet, "/v1/locks?name=billing/invoice-*&owner=b", "")
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var entries []*rlock.LockEntry
+		Expect(json.NewDecoder(w.Body).Decode(&entries)).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name).To(Equal("billing/invoice-2"))
+	})
+
+	It("filters by label", func() {
+		rows := sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at", "owner_labels"}).
+			AddRow(1, "billing/invoice-1", "a", []byte{1}, "", time.Now(), time.Now(), `{"team":"billing"}`)
+
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE JSON_UNQUOTE\(JSON_EXTRACT\(owner_labels, \?\)\)=\? ORDER BY name`).
+			WithArgs(`$."team"`, "billing").WillReturnRows(rows)
+
+		w := request("ops-key", http.MethodGet, "/v1/locks?label=team=billing", "")
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+		var entries []*rlock.LockEntry
+		Expect(json.NewDecoder(w.Body).Decode(&entries)).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].OwnerLabels).To(Equal(rlock.Labels{"team": "billing"}))
+	})
+
+	It("applies grants to names without their environment", func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err := rlock.New(sqlx.NewDb(mockDB, "sqlmock"), rlock.WithEnvironment("staging"))
+		Expect(err).ToNot(HaveOccurred())
+
+		s, err = New(rl, WithAuth(&Auth{
+			APIKeys: map[string]*Principal{
+				"billing-key": {Name: "billing", Grants: []Grant{{Namespace: "billing/", Role: RoleUnlock}}},
+			},
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		rows := sqlmock.NewRows([]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at"}).
+			AddRow(1, "staging:billing/invoice-1", "a", []byte{1}, "", time.Now(), time.Now()).
+			AddRow(2, "staging:shipping/order-1", "b", []byte{1}, "", time.Now(), time.Now())
+
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name LIKE \?`).WithArgs("staging:%").WillReturnRows(rows)
+
+		w := request("billing-key", http.MethodGet, "/v1/locks", "")
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var entries []*rlock.LockEntry
+		Expect(json.NewDecoder(w.Body).Decode(&entries)).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name).To(Equal("staging:billing/invoice-1"))
+	})
+
+	It("rejects malformed label filters", func() {
+		w := request("ops-key", http.MethodGet, "/v1/locks?label=team", "")
+
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	Describe("force unlock", func() {
+		It("requires the force-takeover role", func() {
+			w := request("billing-key", http.MethodPost, "/v1/locks/force-unlock", `{"name": "billing/invoice-1"}`)
+
+			Expect(w.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("force unlocks the lock", func() {
+			mock.ExpectExec("UPDATE").
+				WithArgs("stuck", "billing/invoice-1").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			w := request("ops-key", http.MethodPost, "/v1/locks/force-unlock", `{"name": "billing/invoice-1", "reason": "stuck"}`)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("returns not found when the lock is not in use", func() {
+			mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+
+			w := request("ops-key", http.MethodPost, "/v1/locks/force-unlock", `{"name": "billing/invoice-1"}`)
+
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("ui", func() {
+		It("serves the dashboard", func() {
+			w := request("", http.MethodGet, "/", "")
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(ContainSubstring("<title>rlock</title>"))
+		})
+	})
+})
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role determines what a principal is allowed to do within a namespace. Roles
+// are ordered; a higher role implies all of the permissions of the lower ones.
+type Role int
+
+const (
+	// RoleReadOnly allows inspecting locks
+	RoleReadOnly Role = iota + 1
+
+	// RoleUnlock allows acquiring and releasing locks
+	RoleUnlock
+
+	// RoleForceTakeover allows releasing or taking over locks held by others
+	RoleForceTakeover
+)
+
+var roleNames = map[Role]string{
+	RoleReadOnly:      "read-only",
+	RoleUnlock:        "unlock",
+	RoleForceTakeover: "force-takeover",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+func (r Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Role) UnmarshalJSON(data []byte) error {
+	var name string
+
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+
+	for role, roleName := range roleNames {
+		if roleName == name {
+			*r = role
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown role '%v'", name)
+}
+
+// NamespaceSeparator separates a namespace from the rest of the lock names
+// within it.
+const NamespaceSeparator = "/"
+
+// Grant gives a role within a namespace. A namespace is a lock name prefix
+// ending at a separator: "billing" (or "billing/") matches "billing" and
+// "billing/invoice-1", but not "billing-archive". "*" matches every lock.
+type Grant struct {
+	Namespace string `json:"namespace"`
+	Role      Role   `json:"role"`
+}
+
+// Principal is an authenticated caller along with its grants.
+type Principal struct {
+	Name   string  `json:"name"`
+	Grants []Grant `json:"grants"`
+}
+
+// Allowed returns true if the principal holds at least the given role for the
+// namespace the lock belongs to.
+func (p *Principal) Allowed(lockName string, role Role) bool {
+	for _, g := range p.Grants {
+		if g.Role < role {
+			continue
+		}
+
+		if g.Namespace == "*" || inNamespace(lockName, g.Namespace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inNamespace returns true if the lock called lockName belongs to namespace.
+func inNamespace(lockName, namespace string) bool {
+	namespace = strings.TrimSuffix(namespace, NamespaceSeparator)
+
+	return lockName == namespace || strings.HasPrefix(lockName, namespace+NamespaceSeparator)
+}
+
+// Auth configures how callers are authenticated. API keys are passed via the
+// "Authorization: Bearer <key>" header; client certificates (mTLS) are matched
+// by their subject common name.
+type Auth struct {
+	APIKeys     map[string]*Principal `json:"api_keys"`
+	ClientCerts map[string]*Principal `json:"client_certs"`
+}
+
+// authenticate returns the principal for the request or nil if the request
+// could not be authenticated.
+func (a *Auth) authenticate(r *http.Request) *Principal {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		key := strings.TrimPrefix(header, "Bearer ")
+
+		for k, p := range a.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				return p
+			}
+		}
+	}
+
+	// The TLS handshake has already verified the chain against the client CA
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if p, ok := a.ClientCerts[r.TLS.VerifiedChains[0][0].Subject.CommonName]; ok {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// authorize writes an error response and returns false if the caller is not
+// allowed to perform an action requiring role on the given lock.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, lockName string, role Role) bool {
+	if s.auth == nil {
+		return true
+	}
+
+	p := s.auth.authenticate(r)
+	if p == nil {
+		writeError(w, http.StatusUnauthorized, "", "unauthenticated")
+		return false
+	}
+
+	if !p.Allowed(s.unscoped(lockName), role) {
+		writeError(w, http.StatusForbidden, "", fmt.Sprintf("'%v' is not allowed %v access to '%v'", p.Name, role, lockName))
+		return false
+	}
+
+	return true
+}
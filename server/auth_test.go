@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/dselans/rlock"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Auth", func() {
+	var (
+		reader = &Principal{
+			Name:   "reader",
+			Grants: []Grant{{Namespace: "*", Role: RoleReadOnly}},
+		}
+		billing = &Principal{
+			Name:   "billing",
+			Grants: []Grant{{Namespace: "billing/", Role: RoleUnlock}},
+		}
+	)
+
+	Describe("Principal.Allowed", func() {
+		It("allows lower roles when a higher role is granted", func() {
+			Expect(billing.Allowed("billing/invoice-1", RoleReadOnly)).To(BeTrue())
+			Expect(billing.Allowed("billing/invoice-1", RoleUnlock)).To(BeTrue())
+		})
+
+		It("denies higher roles than granted", func() {
+			Expect(billing.Allowed("billing/invoice-1", RoleForceTakeover)).To(BeFalse())
+			Expect(reader.Allowed("anything", RoleUnlock)).To(BeFalse())
+		})
+
+		It("denies locks outside of the namespace", func() {
+			Expect(billing.Allowed("shipping/order-1", RoleReadOnly)).To(BeFalse())
+		})
+
+		It("only matches namespaces at a separator", func() {
+			p := &Principal{Name: "billing", Grants: []Grant{{Namespace: "billing", Role: RoleUnlock}}}
+
+			Expect(p.Allowed("billing", RoleUnlock)).To(BeTrue())
+			Expect(p.Allowed("billing/invoice-1", RoleUnlock)).To(BeTrue())
+			Expect(p.Allowed("billing-archive", RoleUnlock)).To(BeFalse())
+			Expect(p.Allowed("billingX", RoleUnlock)).To(BeFalse())
+			Expect(billing.Allowed("billing-archive/invoice-1", RoleUnlock)).To(BeFalse())
+		})
+
+		It("treats '*' as every namespace", func() {
+			Expect(reader.Allowed("shipping/order-1", RoleReadOnly)).To(BeTrue())
+		})
+	})
+
+	Describe("Role JSON", func() {
+		It("round trips role names", func() {
+			g := &Grant{}
+
+			Expect(json.Unmarshal([]byte(`{"namespace": "a/", "role": "force-takeover"}`), g)).To(Succeed())
+			Expect(g.Role).To(Equal(RoleForceTakeover))
+
+			data, err := json.Marshal(g)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`"force-takeover"`))
+		})
+
+		It("rejects unknown roles", func() {
+			g := &Grant{}
+
+			Expect(json.Unmarshal([]byte(`{"role": "root"}`), g)).ToNot(Succeed())
+		})
+	})
+
+	Describe("server with auth enabled", func() {
+		var (
+			mock sqlmock.Sqlmock
+			s    *Server
+		)
+
+		BeforeEach(func() {
+			mockDB, m, err := sqlmock.New()
+			Expect(err).ToNot(HaveOccurred())
+			mock = m
+
+			rl, err := rlock.New(sqlx.NewDb(mockDB, "sqlmock"))
+			Expect(err).ToNot(HaveOccurred())
+
+			s, err = New(rl, WithAuth(&Auth{
+				APIKeys: map[string]*Principal{
+					"reader-key":  reader,
+					"billing-key": billing,
+				},
+			}))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		acquire := func(key, name string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/v1/locks/acquire",
+				strings.NewReader(`{"name": "`+name+`", "timeout": "1s"}`))
+
+			if key != "" {
+				req.Header.Set("Authorization", "Bearer "+key)
+			}
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+
+			return w
+		}
+
+		It("rejects unauthenticated requests", func() {
+			Expect(acquire("", "billing/invoice-1").Code).To(Equal(http.StatusUnauthorized))
+			Expect(acquire("bogus", "billing/invoice-1").Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects principals without the required role", func() {
+			Expect(acquire("reader-key", "billing/invoice-1").Code).To(Equal(http.StatusForbidden))
+			Expect(acquire("billing-key", "shipping/order-1").Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("allows principals with the required role", func() {
+			mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Expect(acquire("billing-key", "billing/invoice-1").Code).To(Equal(http.StatusOK))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dselans/rlock"
+)
+
+// eventSource is implemented by *rlock.RLock
+type eventSource interface {
+	Subscribe(buffer int) (<-chan *rlock.Event, func())
+}
+
+const eventBuffer = 100
+
+// eventsHandler streams lock events to the client using server-sent events.
+// Clients may narrow the stream down to a namespace via ?prefix=.
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	source, ok := s.rl.(eventSource)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "", "event streaming is not supported by this backend")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "", "streaming is not supported")
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+
+	var principal *Principal
+
+	if s.auth != nil {
+		if principal = s.auth.authenticate(r); principal == nil {
+			writeError(w, http.StatusUnauthorized, "", "unauthenticated")
+			return
+		}
+	}
+
+	events, cancel := source.Subscribe(eventBuffer)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			name := s.unscoped(event.Name)
+
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+
+			// Only stream events for locks the caller is allowed to see
+			if principal != nil && !principal.Allowed(name, RoleReadOnly) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/dselans/rlock"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("events", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *rlock.RLock
+		ts   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = rlock.New(sqlx.NewDb(mockDB, "sqlmock"))
+		Expect(err).ToNot(HaveOccurred())
+
+		s, err := New(rl)
+		Expect(err).ToNot(HaveOccurred())
+
+		ts = httptest.NewServer(s)
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("streams lock events as server-sent events", func() {
+		resp, err := http.Get(ts.URL + "/v1/events?prefix=billing/")
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err = rl.Lock("shipping/order-1", 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = rl.Lock("billing/invoice-1", 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		reader := bufio.NewReader(resp.Body)
+
+		line, err := reader.ReadString('\n')
+		Expect(err).ToNot(HaveOccurred())
+		Expect(line).To(Equal("event: acquired\n"))
+
+		line, err = reader.ReadString('\n')
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.HasPrefix(line, "data: ")).To(BeTrue())
+		Expect(line).To(ContainSubstring(`"name":"billing/invoice-1"`))
+	})
+})
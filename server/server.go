@@ -0,0 +1,430 @@
+// Package server implements rlockd's HTTP API, which acquires and releases
+// locks on behalf of clients that do not have access to the database.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dselans/rlock"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultHandleLease is how long lock handles are kept without being renewed
+// unless WithHandleLease says otherwise.
+const DefaultHandleLease = time.Minute
+
+// LeaseExpiredErr is recorded as the last error of locks released because
+// their handle's lease expired.
+var LeaseExpiredErr = errors.New("released by rlockd: handle lease expired")
+
+type Server struct {
+	rl    rlock.IRLock
+	mux   *http.ServeMux
+	auth  *Auth
+	lease time.Duration
+	now   func() time.Time
+
+	mu    sync.Mutex
+	locks map[string]*handle
+
+	stopReaper chan struct{}
+	closeOnce  sync.Once
+}
+
+// handle is a lock held on behalf of a client, until it releases it or stops
+// renewing it.
+type handle struct {
+	l       *rlock.Lock
+	expires time.Time
+}
+
+type Option func(*Server)
+
+// environment is implemented by *rlock.RLock
+type environment interface {
+	Environment() string
+}
+
+// contextLocker is implemented by *rlock.RLock
+type contextLocker interface {
+	LockContext(ctx context.Context, name string, acquireTimeout time.Duration) (*rlock.Lock, error)
+}
+
+// WithAuth enables authentication and authorization of every request. Without
+// it, the server trusts anyone that can reach it.
+func WithAuth(a *Auth) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+// WithHandleLease sets how long the server keeps a lock handle without the
+// client renewing it (defaults to DefaultHandleLease). Handles that are not
+// renewed in time are released, so that a crashed client does not orphan
+// its locks. rlock.Client renews the handles it holds on its own.
+func WithHandleLease(lease time.Duration) Option {
+	return func(s *Server) {
+		s.lease = lease
+	}
+}
+
+func New(rl rlock.IRLock, opts ...Option) (*Server, error) {
+	if rl == nil {
+		return nil, fmt.Errorf("rlock cannot be nil")
+	}
+
+	s := &Server{
+		rl:         rl,
+		mux:        http.NewServeMux(),
+		lease:      DefaultHandleLease,
+		now:        time.Now,
+		locks:      make(map[string]*handle),
+		stopReaper: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.lease <= 0 {
+		return nil, fmt.Errorf("handle lease must be positive")
+	}
+
+	s.mux.HandleFunc("/v1/locks/acquire", s.acquireHandler)
+	s.mux.HandleFunc("/v1/locks/release", s.releaseHandler)
+	s.mux.HandleFunc("/v1/locks/renew", s.renewHandler)
+	s.mux.HandleFunc("/v1/locks/last-error", s.lastErrorHandler)
+	s.mux.HandleFunc("/v1/events", s.eventsHandler)
+	s.mux.HandleFunc("/v1/locks", s.listHandler)
+	s.mux.HandleFunc("/v1/locks/force-unlock", s.forceUnlockHandler)
+	s.mux.Handle("/", uiHandler())
+
+	go s.runReaper()
+
+	return s, nil
+}
+
+// Close stops releasing handles whose lease expired. Handles still held are
+// left as they are.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stopReaper)
+	})
+
+	return nil
+}
+
+func (s *Server) runReaper() {
+	ticker := time.NewTicker(s.lease / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopReaper:
+			return
+		case <-ticker.C:
+			s.reapExpired()
+		}
+	}
+}
+
+// reapExpired releases the locks whose handle's lease expired.
+func (s *Server) reapExpired() {
+	now := s.now()
+
+	var expired []*handle
+
+	s.mu.Lock()
+
+	for id, h := range s.locks {
+		if now.After(h.expires) {
+			expired = append(expired, h)
+			delete(s.locks, id)
+		}
+	}
+
+	s.mu.Unlock()
+
+	for _, h := range expired {
+		if err := h.l.Unlock(LeaseExpiredErr); err != nil {
+			logrus.WithField("lock", h.l.Name()).Warnf("unable to release lock whose handle lease expired: %v", err)
+		}
+	}
+}
+
+// lookup returns the handle called id, if any.
+func (s *Server) lookup(id string) (*handle, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.locks[id]
+
+	return h, ok
+}
+
+// unscoped returns name without the prefix of the environment the backend
+// scopes lock names to (see rlock.WithEnvironment), which neither grants nor
+// clients' filters include.
+func (s *Server) unscoped(name string) string {
+	e, ok := s.rl.(environment)
+	if !ok || e.Environment() == "" {
+		return name
+	}
+
+	return strings.TrimPrefix(name, e.Environment()+rlock.EnvironmentSeparator)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) acquireHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	req := &rlock.ProxyAcquireRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, "", fmt.Sprintf("unable to decode request: %v", err))
+		return
+	}
+
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "", "name cannot be empty")
+		return
+	}
+
+	if !s.authorize(w, r, req.Name, RoleUnlock) {
+		return
+	}
+
+	timeout, err := time.ParseDuration(req.Timeout)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "", fmt.Sprintf("unable to parse timeout: %v", err))
+		return
+	}
+
+	var l *rlock.Lock
+
+	// Stop waiting once the client goes away
+	if locker, ok := s.rl.(contextLocker); ok {
+		l, err = locker.LockContext(r.Context(), req.Name, timeout)
+	} else {
+		l, err = s.rl.Lock(req.Name, timeout)
+	}
+
+	if err == nil && r.Context().Err() != nil {
+		// Acquired just as the client went away; nobody would release it
+		if err := l.Unlock(nil); err != nil {
+			logrus.WithField("lock", l.Name()).Warnf("unable to release lock acquired for a client that went away: %v", err)
+		}
+
+		return
+	}
+
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+
+		if err == rlock.AcquireTimeoutErr {
+			writeError(w, http.StatusConflict, rlock.ProxyErrAcquireTimeout, err.Error())
+			return
+		}
+
+		if err == rlock.QuotaExceededErr {
+			writeError(w, http.StatusTooManyRequests, rlock.ProxyErrQuotaExceeded, err.Error())
+			return
+		}
+
+		writeError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	id := uuid.New().String()
+
+	s.mu.Lock()
+	s.locks[id] = &handle{l: l, expires: s.now().Add(s.lease)}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, &rlock.ProxyLockResponse{
+		ID:    id,
+		Name:  req.Name,
+		Lease: s.lease.String(),
+	})
+}
+
+func (s *Server) renewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	req := &rlock.ProxyRenewRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, "", fmt.Sprintf("unable to decode request: %v", err))
+		return
+	}
+
+	h, ok := s.lookup(req.ID)
+	if !ok {
+		writeError(w, http.StatusNotFound, rlock.ProxyErrNotFound, "no such lock handle")
+		return
+	}
+
+	if !s.authorize(w, r, h.l.Name(), RoleUnlock) {
+		return
+	}
+
+	// Keep the row from going stale for as long as the client holds on to it
+	if err := h.l.Refresh(); err != nil {
+		if err == rlock.LockLostErr || err == rlock.LockExpiredErr {
+			s.mu.Lock()
+			if s.locks[req.ID] == h {
+				delete(s.locks, req.ID)
+			}
+			s.mu.Unlock()
+
+			writeError(w, http.StatusConflict, rlock.ProxyErrLockLost, err.Error())
+
+			return
+		}
+
+		writeError(w, http.StatusInternalServerError, "", err.Error())
+
+		return
+	}
+
+	s.mu.Lock()
+	_, ok = s.locks[req.ID]
+
+	if ok {
+		h.expires = s.now().Add(s.lease)
+	}
+
+	s.mu.Unlock()
+
+	// Released (or reaped) in the meantime
+	if !ok {
+		writeError(w, http.StatusNotFound, rlock.ProxyErrNotFound, "no such lock handle")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &rlock.ProxyLockResponse{
+		ID:    req.ID,
+		Name:  h.l.Name(),
+		Lease: s.lease.String(),
+	})
+}
+
+func (s *Server) releaseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	req := &rlock.ProxyReleaseRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, "", fmt.Sprintf("unable to decode request: %v", err))
+		return
+	}
+
+	h, ok := s.lookup(req.ID)
+	if !ok {
+		writeError(w, http.StatusNotFound, rlock.ProxyErrNotFound, "no such lock handle")
+		return
+	}
+
+	l := h.l
+
+	if !s.authorize(w, r, l.Name(), RoleUnlock) {
+		return
+	}
+
+	s.mu.Lock()
+	_, ok = s.locks[req.ID]
+	delete(s.locks, req.ID)
+	s.mu.Unlock()
+
+	// Lost a race against a concurrent release of the same handle
+	if !ok {
+		writeError(w, http.StatusNotFound, rlock.ProxyErrNotFound, "no such lock handle")
+		return
+	}
+
+	var lastError error
+
+	if req.LastError != "" {
+		lastError = fmt.Errorf("%s", req.LastError)
+	}
+
+	if err := l.Unlock(lastError); err != nil {
+		// Still held; let the client retry rather than orphan the lock
+		s.mu.Lock()
+		s.locks[req.ID] = h
+		s.mu.Unlock()
+
+		writeError(w, http.StatusInternalServerError, "", err.Error())
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) lastErrorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	h, ok := s.lookup(r.URL.Query().Get("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, rlock.ProxyErrNotFound, "no such lock handle")
+		return
+	}
+
+	l := h.l
+
+	if !s.authorize(w, r, l.Name(), RoleReadOnly) {
+		return
+	}
+
+	resp := &rlock.ProxyLastErrorResponse{}
+
+	if err := l.LastError(); err != nil {
+		if _, ok := err.(*rlock.LastErrorFetchErr); ok {
+			writeError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+
+		resp.LastError = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, &rlock.ProxyErrorResponse{
+		Code:  code,
+		Error: msg,
+	})
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/dselans/rlock"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func setupServer() (sqlmock.Sqlmock, *Server) {
+	mockDB, mock, err := sqlmock.New()
+	Expect(err).ToNot(HaveOccurred())
+
+	rl, err := rlock.New(sqlx.NewDb(mockDB, "sqlmock"))
+	Expect(err).ToNot(HaveOccurred())
+
+	s, err := New(rl)
+	Expect(err).ToNot(HaveOccurred())
+
+	return mock, s
+}
+
+func doRequest(s *Server, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+
+	if body != nil {
+		Expect(json.NewEncoder(&buf).Encode(body)).To(Succeed())
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, path, &buf))
+
+	return w
+}
+
+var _ = Describe("Server", func() {
+	var (
+		mock     sqlmock.Sqlmock
+		s        *Server
+		lockName = "server-test-lock"
+	)
+
+	BeforeEach(func() {
+		mock, s = setupServer()
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	acquire := func() *rlock.ProxyLockResponse {
+		mock.ExpectExec(fmt.Sprintf("INSERT INTO %v", rlock.TableName)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		w := doRequest(s, http.MethodPost, "/v1/locks/acquire", &rlock.ProxyAcquireRequest{
+			Name:    lockName,
+			Timeout: "1s",
+		})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		resp := &rlock.ProxyLockResponse{}
+		Expect(json.NewDecoder(w.Body).Decode(resp)).To(Succeed())
+
+		return resp
+	}
+
+	Describe("New", func() {
+		It("should error with a nil rlock", func() {
+			s, err := New(nil)
+
+			Expect(err).To(HaveOccurred())
+			Expect(s).To(BeNil())
+		})
+	})
+
+	Describe("acquire", func() {
+		It("acquires the lock and returns a handle", func() {
+			mock.ExpectExec(fmt.Sprintf("INSERT INTO %v", rlock.TableName)).
+				WithArgs(lockName, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			w := doRequest(s, http.MethodPost, "/v1/locks/acquire", &rlock.ProxyAcquireRequest{
+				Name:    lockName,
+				Timeout: "1s",
+			})
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			resp := &rlock.ProxyLockResponse{}
+			Expect(json.NewDecoder(w.Body).Decode(resp)).To(Succeed())
+			Expect(resp.ID).ToNot(BeEmpty())
+			Expect(resp.Name).To(Equal(lockName))
+			Expect(s.locks).To(HaveKey(resp.ID))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("stops waiting once the client goes away", func() {
+			mockDB, mock, err := sqlmock.New()
+			Expect(err).ToNot(HaveOccurred())
+
+			// Waits would never end without the client going away
+			rl, err := rlock.New(sqlx.NewDb(mockDB, "sqlmock"), rlock.WithClock(rlock.NewFakeClock(time.Now())))
+			Expect(err).ToNot(HaveOccurred())
+
+			s, err := New(rl)
+			Expect(err).ToNot(HaveOccurred())
+			defer s.Close()
+
+			mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(`SELECT \* FROM rlock`).WillReturnRows(sqlmock.NewRows(
+				[]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at"}).
+				AddRow(1, lockName, "someone-else", []byte{1}, "", time.Now(), time.Now()))
+			mock.ExpectExec(`UPDATE rlock SET .*in_use=1`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			var buf bytes.Buffer
+			Expect(json.NewEncoder(&buf).Encode(&rlock.ProxyAcquireRequest{Name: lockName, Timeout: "-1s"})).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+				s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/locks/acquire", &buf).WithContext(ctx))
+			}()
+
+			Eventually(done).Should(BeClosed())
+			Expect(s.locks).To(BeEmpty())
+		})
+
+		It("leases the handle", func() {
+			Expect(acquire().Lease).To(Equal(DefaultHandleLease.String()))
+		})
+
+		It("rejects an invalid timeout", func() {
+			w := doRequest(s, http.MethodPost, "/v1/locks/acquire", &rlock.ProxyAcquireRequest{
+				Name:    lockName,
+				Timeout: "soon",
+			})
+
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("rejects non-POST requests", func() {
+			w := doRequest(s, http.MethodGet, "/v1/locks/acquire", nil)
+
+			Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Describe("release", func() {
+		It("unlocks a held lock", func() {
+			mock.ExpectExec(fmt.Sprintf("INSERT INTO %v", rlock.TableName)).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(fmt.Sprintf("UPDATE %v SET in_use=0", rlock.TableName)).
+				WithArgs("some error", lockName, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			w := doRequest(s, http.MethodPost, "/v1/locks/acquire", &rlock.ProxyAcquireRequest{
+				Name:    lockName,
+				Timeout: "1s",
+			})
+
+			resp := &rlock.ProxyLockResponse{}
+			Expect(json.NewDecoder(w.Body).Decode(resp)).To(Succeed())
+
+			w = doRequest(s, http.MethodPost, "/v1/locks/release", &rlock.ProxyReleaseRequest{
+				ID:        resp.ID,
+				LastError: "some error",
+			})
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(s.locks).ToNot(HaveKey(resp.ID))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("keeps the handle when unlocking fails so the client can retry", func() {
+			resp := acquire()
+
+			mock.ExpectExec(fmt.Sprintf("UPDATE %v SET in_use=0", rlock.TableName)).WillReturnError(fmt.Errorf("boom"))
+
+			w := doRequest(s, http.MethodPost, "/v1/locks/release", &rlock.ProxyReleaseRequest{ID: resp.ID})
+
+			Expect(w.Code).To(Equal(http.StatusInternalServerError))
+			Expect(s.locks).To(HaveKey(resp.ID))
+
+			mock.ExpectExec(fmt.Sprintf("UPDATE %v SET in_use=0", rlock.TableName)).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			w = doRequest(s, http.MethodPost, "/v1/locks/release", &rlock.ProxyReleaseRequest{ID: resp.ID})
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(s.locks).ToNot(HaveKey(resp.ID))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("returns not found for unknown handles", func() {
+			w := doRequest(s, http.MethodPost, "/v1/locks/release", &rlock.ProxyReleaseRequest{
+				ID: "unknown",
+			})
+
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("last error", func() {
+		It("returns the last error of the lock", func() {
+			resp := acquire()
+
+			mock.ExpectQuery("SELECT last_error").WillReturnRows(sqlmock.NewRows([]string{"last_error"}).AddRow("boom"))
+
+			w := doRequest(s, http.MethodGet, "/v1/locks/last-error?id="+resp.ID, nil)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			lastError := &rlock.ProxyLastErrorResponse{}
+			Expect(json.NewDecoder(w.Body).Decode(lastError)).To(Succeed())
+			Expect(lastError.LastError).To(Equal("boom"))
+		})
+
+		It("fails when the last error cannot be read", func() {
+			resp := acquire()
+
+			mock.ExpectQuery("SELECT last_error").WillReturnError(fmt.Errorf("db down"))
+
+			w := doRequest(s, http.MethodGet, "/v1/locks/last-error?id="+resp.ID, nil)
+
+			Expect(w.Code).To(Equal(http.StatusInternalServerError))
+			Expect(w.Body.String()).To(ContainSubstring("db down"))
+		})
+	})
+
+	Describe("leases", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Now()
+			s.now = func() time.Time { return now }
+		})
+
+		It("releases locks whose handle was not renewed in time", func() {
+			resp := acquire()
+
+			now = now.Add(50 * time.Second)
+
+			mock.ExpectExec(`UPDATE rlock SET last_used=NOW\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			w := doRequest(s, http.MethodPost, "/v1/locks/renew", &rlock.ProxyRenewRequest{ID: resp.ID})
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			now = now.Add(50 * time.Second)
+			s.reapExpired()
+
+			Expect(s.locks).To(HaveKey(resp.ID))
+
+			mock.ExpectExec(fmt.Sprintf("UPDATE %v SET in_use=0", rlock.TableName)).
+				WithArgs(LeaseExpiredErr.Error(), lockName, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			now = now.Add(20 * time.Second)
+			s.reapExpired()
+
+			Expect(s.locks).ToNot(HaveKey(resp.ID))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+			w = doRequest(s, http.MethodPost, "/v1/locks/renew", &rlock.ProxyRenewRequest{ID: resp.ID})
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("refreshes the lock on renewal", func() {
+			resp := acquire()
+
+			mock.ExpectExec(`UPDATE rlock SET last_used=NOW\(\) WHERE name=\? AND owner=\? AND in_use=1`).
+				WithArgs(lockName, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			w := doRequest(s, http.MethodPost, "/v1/locks/renew", &rlock.ProxyRenewRequest{ID: resp.ID})
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("drops handles whose lock was lost", func() {
+			resp := acquire()
+
+			mock.ExpectExec(`UPDATE rlock SET last_used=NOW\(\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(`SELECT \* FROM rlock`).WillReturnRows(sqlmock.NewRows(
+				[]string{"id", "name", "owner", "in_use", "last_error", "last_used", "created_at"}).
+				AddRow(1, lockName, "someone-else", []byte{1}, "", time.Now(), time.Now()))
+
+			w := doRequest(s, http.MethodPost, "/v1/locks/renew", &rlock.ProxyRenewRequest{ID: resp.ID})
+
+			Expect(w.Code).To(Equal(http.StatusConflict))
+			Expect(w.Body.String()).To(ContainSubstring(rlock.ProxyErrLockLost))
+			Expect(s.locks).ToNot(HaveKey(resp.ID))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("keeps handles whose lock could not be refreshed", func() {
+			resp := acquire()
+
+			mock.ExpectExec(`UPDATE rlock SET last_used=NOW\(\)`).WillReturnError(fmt.Errorf("boom"))
+
+			w := doRequest(s, http.MethodPost, "/v1/locks/renew", &rlock.ProxyRenewRequest{ID: resp.ID})
+
+			Expect(w.Code).To(Equal(http.StatusInternalServerError))
+			Expect(s.locks).To(HaveKey(resp.ID))
+		})
+
+		It("returns not found for unknown handles", func() {
+			w := doRequest(s, http.MethodPost, "/v1/locks/renew", &rlock.ProxyRenewRequest{ID: "unknown"})
+
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,99 @@
+(function () {
+  var keyInput = document.getElementById("key");
+  var prefixInput = document.getElementById("prefix");
+  var status = document.getElementById("status");
+  var tbody = document.getElementById("locks");
+
+  keyInput.value = localStorage.getItem("rlock-api-key") || "";
+
+  function headers() {
+    var h = { "Content-Type": "application/json" };
+    if (keyInput.value) {
+      h["Authorization"] = "Bearer " + keyInput.value;
+    }
+    return h;
+  }
+
+  function age(since) {
+    var secs = Math.floor((Date.now() - new Date(since).getTime()) / 1000);
+    if (secs < 60) return secs + "s";
+    if (secs < 3600) return Math.floor(secs / 60) + "m";
+    return Math.floor(secs / 3600) + "h" + Math.floor((secs % 3600) / 60) + "m";
+  }
+
+  function cell(row, text, cls) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    if (cls) td.className = cls;
+    row.appendChild(td);
+    return td;
+  }
+
+  function forceUnlock(name) {
+    var reason = prompt("Force unlock '" + name + "'? Reason:");
+    if (reason === null) return;
+
+    fetch("v1/locks/force-unlock", {
+      method: "POST",
+      headers: headers(),
+      body: JSON.stringify({ name: name, reason: reason })
+    }).then(function (resp) {
+      return resp.json().then(function (body) {
+        if (!resp.ok) throw new Error(body.error);
+      });
+    }).then(refresh).catch(function (err) {
+      status.textContent = "force unlock failed: " + err.message;
+    });
+  }
+
+  function refresh() {
+    localStorage.setItem("rlock-api-key", keyInput.value);
+    status.textContent = "loading...";
+
+    fetch("v1/locks", { headers: headers() }).then(function (resp) {
+      return resp.json().then(function (body) {
+        if (!resp.ok) throw new Error(body.error);
+        return body;
+      });
+    }).then(function (locks) {
+      tbody.innerHTML = "";
+
+      locks.filter(function (l) {
+        return l.name.indexOf(prefixInput.value) === 0;
+      }).forEach(function (l) {
+        var row = document.createElement("tr");
+        if (l.in_use) row.className = "in-use";
+
+        cell(row, l.name);
+        cell(row, l.in_use ? "in use" : "free", "state");
+        cell(row, l.owner);
+        cell(row, l.host ? l.host + ":" + l.pid : "");
+        cell(row, l.in_use ? age(l.acquired_at) : "");
+        cell(row, l.acquire_count);
+        cell(row, l.takeover_count);
+        cell(row, l.timeout_count);
+        cell(row, age(l.created_at));
+        cell(row, age(l.last_used) + " ago");
+        cell(row, l.last_error, "error");
+
+        var actions = cell(row, "");
+        if (l.in_use) {
+          var btn = document.createElement("button");
+          btn.textContent = "Force unlock";
+          btn.onclick = function () { forceUnlock(l.name); };
+          actions.appendChild(btn);
+        }
+
+        tbody.appendChild(row);
+      });
+
+      status.textContent = "updated " + new Date().toLocaleTimeString();
+    }).catch(function (err) {
+      status.textContent = "unable to load locks: " + err.message;
+    });
+  }
+
+  document.getElementById("refresh").onclick = refresh;
+  refresh();
+  setInterval(refresh, 5000);
+})();
@@ -0,0 +1,208 @@
+package rlock
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// MySQL limits user-level lock names to 64 characters
+const maxSessionKeyLength = 64
+
+// WithSessionLocks ties every held lock to a MySQL user-level lock
+// (GET_LOCK()) held by a dedicated connection, which MySQL releases as soon as
+// that connection dies: a crashed holder's lock is taken over by the next
+// contender right away rather than after it goes stale. This costs a
+// connection per held lock (see WithMaxOpenConns), and all instances sharing
+// the locks must use session locks. Stale locks are then only those whose
+// holder's session ended; the takeover policy (see WithTakeoverPolicy) is not
+// consulted. LockTx(), Claim() and the primitives built on it do not use
+// session locks.
+func WithSessionLocks() Option {
+	return func(r *RLock) error {
+		r.sessionLocks = true
+		return nil
+	}
+}
+
+// lockSession is lock() using session locks: contenders wait for the session
+// lock in MySQL, and whoever gets it owns the lock row.
+func (r *RLock) lockSession(ctx context.Context, name string, acquireTimeout, remaining time.Duration) (*Lock, error) {
+	lease, err := r.pinConn(ctx, fmt.Sprintf("session lock '%v'", name))
+	if err != nil {
+		return nil, err
+	}
+
+	// GET_LOCK() waits whole seconds; negative waits forever
+	wait := int64(-1)
+	if remaining >= 0 {
+		wait = int64((remaining + time.Second - 1) / time.Second)
+	}
+
+	var got sql.NullInt64
+
+	err = lease.use(func(conn *sqlx.Conn) error {
+		return conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", r.sessionKey(name), wait).Scan(&got)
+	})
+
+	if err != nil || !got.Valid {
+		// The session lock may or may not have been granted
+		r.discardPin(lease)
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if err == nil {
+			return nil, fmt.Errorf("unable to get session lock for '%v'", name)
+		}
+
+		r.observeError(err)
+
+		return nil, fmt.Errorf("unable to get session lock for '%v': %v", name, err)
+	}
+
+	if got.Int64 == 0 {
+		r.unpin(lease, nil)
+		r.countTimeout(name)
+
+		return nil, AcquireTimeoutErr
+	}
+
+	l, err := r.takeSessionRow(ctx, name, acquireTimeout)
+	if err != nil {
+		r.unpin(lease, r.releaseSession(name))
+		return nil, err
+	}
+
+	lease.setRestore(r.restoreSession(name))
+	l.session = lease
+
+	return l, nil
+}
+
+// takeSessionRow makes us the holder of the lock row of name while holding
+// its session lock; whoever is still on record as its holder lost their
+// session.
+func (r *RLock) takeSessionRow(ctx context.Context, name string, acquireTimeout time.Duration) (*Lock, error) {
+	query, args := r.insertQuery(ctx, name)
+
+	res, err := r.exec(query, args...)
+	if err == nil {
+		token, err := r.insertedToken(res)
+		if err != nil {
+			return nil, err
+		}
+
+		r.auditAcquire(ctx, name, AcquireFresh, "", "")
+		r.emit(EventAcquired, name, "", "")
+
+		return r.newLock(name, acquireTimeout, token), nil
+	}
+
+	if me, ok := err.(*mysql.MySQLError); !ok || me.Number != 1062 {
+		r.observeError(err)
+		return nil, fmt.Errorf("unable to insert lock for '%s': %v", name, err)
+	}
+
+	existing, err := r.getExistingByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch existing lock: %v", err)
+	}
+
+	stale := bool(existing.InUse)
+
+	if stale && r.noForcedTakeover {
+		return nil, &StaleLockErr{Entry: existing}
+	}
+
+	if stale && r.takeoverVeto != nil {
+		if err := r.takeoverVeto(existing); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := r.takeover(ctx, name, existing.Owner, stale)
+	if err != nil {
+		return nil, fmt.Errorf("unable to take over lock '%v': %v", name, err)
+	}
+
+	l := r.newLock(name, acquireTimeout, token)
+
+	if !stale {
+		r.auditAcquire(ctx, name, AcquireHandoff, existing.Owner, "in_use=false")
+		r.emit(EventAcquired, name, existing.Owner, "")
+
+		return l, nil
+	}
+
+	evidence := "in_use=true, holder's session ended"
+
+	r.auditRelease(name, existing.Owner, ExitTakenOver, evidence)
+	r.auditAcquire(ctx, name, AcquireStaleTakeover, existing.Owner, evidence)
+	r.emit(EventTakeover, name, existing.Owner, "")
+	r.notify(EventTakeover, name, existing, evidence)
+
+	l.tookOver = true
+
+	return l, nil
+}
+
+// releaseSession returns how to release the session lock of name (see
+// unpin()).
+func (r *RLock) releaseSession(name string) func(ctx context.Context, conn *sqlx.Conn) error {
+	return func(ctx context.Context, conn *sqlx.Conn) error {
+		_, err := conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", r.sessionKey(name))
+		return err
+	}
+}
+
+// restoreSession returns how to get the session lock of name back on a new
+// connection after ours dropped; unless someone else got hold of it (and,
+// with it, the lock) meanwhile, the lock row is still ours.
+func (r *RLock) restoreSession(name string) func(ctx context.Context, conn *sqlx.Conn) error {
+	return func(ctx context.Context, conn *sqlx.Conn) error {
+		var got sql.NullInt64
+
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", r.sessionKey(name)).Scan(&got); err != nil {
+			return err
+		}
+
+		if got.Int64 != 1 {
+			return fmt.Errorf("session lock was taken by someone else")
+		}
+
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %v WHERE name=? AND owner=? AND in_use=1", r.tableFor(name))
+
+		var count int
+
+		if err := conn.GetContext(ctx, &count, query, name, r.owner); err != nil {
+			return err
+		}
+
+		if count != 1 {
+			conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", r.sessionKey(name))
+			return LockLostErr
+		}
+
+		return nil
+	}
+}
+
+// sessionKey returns the name of the session lock of the lock called name.
+func (r *RLock) sessionKey(name string) string {
+	key := r.tableFor(name) + ":" + name
+
+	if len(key) > maxSessionKeyLength {
+		sum := sha1.Sum([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+
+	return key
+}
@@ -0,0 +1,96 @@
+package rlock
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithSessionLocks", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithSessionLocks())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("holds the session lock until the lock is unlocked", func() {
+		mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\)`).WithArgs("rlock:foo", 60).
+			WillReturnRows(sqlmock.NewRows([]string{"got"}).AddRow(1))
+		mock.ExpectExec("INSERT INTO rlock").WithArgs("foo", rl.owner, rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		pinned := rl.PinnedConns()
+		Expect(pinned).To(HaveLen(1))
+		Expect(pinned[0].Purpose).To(Equal("session lock 'foo'"))
+		Expect(rl.CheckPinnedConns(context.Background())).To(Succeed())
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DO RELEASE_LOCK\(\?\)`).WithArgs("rlock:foo").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(l.Unlock(nil)).To(Succeed())
+		Expect(rl.PinnedConns()).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("times out when the session lock is held", func() {
+		mock.ExpectQuery(`SELECT GET_LOCK`).WithArgs("rlock:foo", 0).
+			WillReturnRows(sqlmock.NewRows([]string{"got"}).AddRow(0))
+		mock.ExpectExec("UPDATE rlock SET timeout_count").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := rl.Lock("foo", 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("takes over locks whose holder's session ended right away", func() {
+		mock.ExpectQuery(`SELECT GET_LOCK`).WillReturnRows(sqlmock.NewRows([]string{"got"}).AddRow(1))
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "crashed", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET .*takeover_count=takeover_count\+1`).WithArgs(rl.owner, rl.host, rl.pid, "foo", "crashed").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.tookOver).To(BeTrue())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("releases the session lock when taking the row fails", func() {
+		mock.ExpectQuery(`SELECT GET_LOCK`).WillReturnRows(sqlmock.NewRows([]string{"got"}).AddRow(1))
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1146})
+		mock.ExpectExec(`DO RELEASE_LOCK`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).To(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("hashes keys that are too long", func() {
+		key := rl.sessionKey(strings.Repeat("x", 100))
+
+		Expect(len(key)).To(BeNumerically("<=", maxSessionKeyLength))
+		Expect(key).To(Equal(rl.sessionKey(strings.Repeat("x", 100))))
+	})
+})
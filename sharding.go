@@ -0,0 +1,72 @@
+package rlock
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// WithShards spreads lock rows across n tables ("<table>_0" to
+// "<table>_<n-1>", see WithTableName), picking the table by a hash of the
+// lock name, to relieve hot-row and index contention for deployments with
+// very high lock cardinality and churn. EnsureSchema() creates every shard;
+// the audit and waiter tables are not sharded. Every instance sharing the
+// locks must use the same number of shards, and changing it orphans the
+// existing rows (see Snapshot() and Restore() to migrate them).
+func WithShards(n int) Option {
+	return func(r *RLock) error {
+		if n <= 0 {
+			return fmt.Errorf("number of shards must be positive")
+		}
+
+		r.shards = n
+
+		return nil
+	}
+}
+
+// tableFor returns the table the lock called name is stored in.
+func (r *RLock) tableFor(name string) string {
+	if r.shards <= 1 {
+		return r.table
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+
+	return fmt.Sprintf("%v_%d", r.table, h.Sum32()%uint32(r.shards))
+}
+
+// tables returns every table locks are stored in.
+func (r *RLock) tables() []string {
+	if r.shards <= 1 {
+		return []string{r.table}
+	}
+
+	tables := make([]string, r.shards)
+
+	for i := range tables {
+		tables[i] = fmt.Sprintf("%v_%d", r.table, i)
+	}
+
+	return tables
+}
+
+// byTable groups names by the table they are stored in.
+func (r *RLock) byTable(names []string) map[string][]string {
+	grouped := make(map[string][]string)
+
+	for _, name := range names {
+		table := r.tableFor(name)
+		grouped[table] = append(grouped[table], name)
+	}
+
+	return grouped
+}
+
+// sortByName orders entries read from several shards by name.
+func sortByName(entries []*LockEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+}
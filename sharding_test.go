@@ -0,0 +1,123 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithShards", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithShards(4))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the number of shards", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithShards(0))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("picks the same shard for a name every time", func() {
+		seen := make(map[string]bool)
+
+		for i := 0; i < 100; i++ {
+			table := rl.tableFor(fmt.Sprintf("lock-%d", i))
+
+			Expect(table).To(Equal(rl.tableFor(fmt.Sprintf("lock-%d", i))))
+			Expect(rl.tables()).To(ContainElement(table))
+
+			seen[table] = true
+		}
+
+		Expect(seen).To(HaveLen(4))
+	})
+
+	It("stores every lock in its shard", func() {
+		table := rl.tableFor("foo")
+
+		mock.ExpectExec("INSERT INTO "+table).WithArgs("foo", rl.owner, rl.host, rl.pid).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		l, err := rl.Lock("foo", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("UPDATE "+table+" SET in_use=0").WithArgs("", "foo", rl.owner).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(l.Unlock(nil)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("lists the locks of every shard ordered by name", func() {
+		for i, name := range []string{"d", "b", "c", "a"} {
+			mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM rlock_%d ORDER BY name`, i)).WillReturnRows(
+				sqlmock.NewRows(lockEntryColumns).AddRow(i, name, "owner", []byte{1}, "", time.Now(), time.Now()))
+		}
+
+		entries, err := rl.ListLocks()
+		Expect(err).ToNot(HaveOccurred())
+
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name
+		}
+
+		Expect(names).To(Equal([]string{"a", "b", "c", "d"}))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("creates every shard", func() {
+		for i := 0; i < 4; i++ {
+			mock.ExpectExec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `rlock_%d`", i)).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+
+		for i := 0; i < 4; i++ {
+			columns := sqlmock.NewRows([]string{"column_name"})
+			for _, c := range lockEntryColumns {
+				columns.AddRow(c)
+			}
+
+			for _, c := range schemaColumns {
+				columns.AddRow(c.name)
+			}
+
+			mock.ExpectQuery("SELECT column_name FROM information_schema.columns").
+				WithArgs(fmt.Sprintf("rlock_%d", i)).
+				WillReturnRows(columns)
+		}
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock_schema_version`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+
+		for i := 0; i < 4; i++ {
+			mock.ExpectExec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `rlock_%d`", i)).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 1\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 2\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 3\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 4\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 5\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 6\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 7\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_schema_version \(id, version\) VALUES \(1, 8\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(rl.EnsureSchema()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,93 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// WithSlowOpThreshold makes rlock log a "slow lock op" warning (with a timing
+// breakdown of the statements involved) for every lock operation (acquire
+// attempt, takeover attempt, unlock) that takes longer than threshold. Useful
+// to catch DB degradation affecting the lock path.
+func WithSlowOpThreshold(threshold time.Duration) Option {
+	return func(r *RLock) error {
+		if threshold <= 0 {
+			return fmt.Errorf("slow op threshold must be positive")
+		}
+
+		r.slowOpThreshold = threshold
+
+		return nil
+	}
+}
+
+// opTimer times the steps of a single lock operation; a nil *opTimer (slow op
+// logging disabled) is a no-op.
+type opTimer struct {
+	r     *RLock
+	op    string
+	name  string
+	start time.Time
+	last  time.Time
+	steps golog.Fields
+	ended bool
+}
+
+func (r *RLock) startOp(op, name string) *opTimer {
+	if r.slowOpThreshold == 0 {
+		return nil
+	}
+
+	now := r.clock.Now()
+
+	return &opTimer{
+		r:     r,
+		op:    op,
+		name:  name,
+		start: now,
+		last:  now,
+		steps: golog.Fields{},
+	}
+}
+
+// step records how long it has been since the previous step (or the start of
+// the operation).
+func (t *opTimer) step(step string) {
+	if t == nil {
+		return
+	}
+
+	now := t.r.clock.Now()
+
+	t.steps[step] = now.Sub(t.last).String()
+	t.last = now
+}
+
+// done logs the operation if it took longer than the threshold; only the
+// first call has any effect.
+func (t *opTimer) done() {
+	if t == nil || t.ended {
+		return
+	}
+
+	t.ended = true
+
+	total := t.r.clock.Now().Sub(t.start)
+	if total <= t.r.slowOpThreshold {
+		return
+	}
+
+	fields := golog.Fields{
+		"op":        t.op,
+		"total":     total.String(),
+		"threshold": t.r.slowOpThreshold.String(),
+	}
+
+	for step, took := range t.steps {
+		fields["step_"+step] = took
+	}
+
+	t.r.logFor(t.name).WithFields(fields).Warn("slow lock op")
+}
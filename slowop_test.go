@@ -0,0 +1,123 @@
+package rlock
+
+import (
+	"sync"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// recordingLogger passes the fields of every warning to record
+type recordingLogger struct {
+	fields golog.Fields
+	record func(golog.Fields)
+}
+
+func (l *recordingLogger) Debug(msg ...interface{})                  {}
+func (l *recordingLogger) Info(msg ...interface{})                   {}
+func (l *recordingLogger) Error(msg ...interface{})                  {}
+func (l *recordingLogger) Debugln(msg ...interface{})                {}
+func (l *recordingLogger) Infoln(msg ...interface{})                 {}
+func (l *recordingLogger) Warnln(msg ...interface{})                 {}
+func (l *recordingLogger) Errorln(msg ...interface{})                {}
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {}
+func (l *recordingLogger) Infof(format string, args ...interface{})  {}
+func (l *recordingLogger) Warnf(format string, args ...interface{})  {}
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {}
+
+func (l *recordingLogger) Warn(msg ...interface{}) {
+	l.record(l.fields)
+}
+
+func (l *recordingLogger) WithFields(fields golog.Fields) golog.Logger {
+	merged := golog.Fields{}
+
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	return &recordingLogger{fields: merged, record: l.record}
+}
+
+var _ = Describe("WithSlowOpThreshold", func() {
+	var (
+		mock     sqlmock.Sqlmock
+		rl       *RLock
+		recorded func() []golog.Fields
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		var (
+			mu       sync.Mutex
+			warnings []golog.Fields
+		)
+
+		logger := &recordingLogger{record: func(f golog.Fields) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			warnings = append(warnings, f)
+		}}
+
+		recorded = func() []golog.Fields {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return warnings
+		}
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithSlowOpThreshold(20*time.Millisecond), WithLogger(logger))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("rejects a non-positive threshold", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithSlowOpThreshold(0))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("logs slow acquire attempts with a timing breakdown", func() {
+		mock.ExpectExec("INSERT INTO").WillDelayFor(50 * time.Millisecond).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(recorded()).To(HaveLen(1))
+		Expect(recorded()[0]).To(HaveKeyWithValue("op", "acquire"))
+		Expect(recorded()[0]).To(HaveKeyWithValue("lock", "foo"))
+		Expect(recorded()[0]).To(HaveKey("step_insert"))
+		Expect(recorded()[0]).To(HaveKey("total"))
+	})
+
+	It("logs slow unlocks", func() {
+		mock.ExpectExec("UPDATE").WillDelayFor(50 * time.Millisecond).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(rl.newLock("foo", time.Second, 1).Unlock(nil)).To(Succeed())
+
+		Expect(recorded()).To(HaveLen(1))
+		Expect(recorded()[0]).To(HaveKeyWithValue("op", "unlock"))
+		Expect(recorded()[0]).To(HaveKey("step_update"))
+	})
+
+	It("does not log fast operations", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(recorded()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,194 @@
+package rlock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RestoreConflictErr is returned by Restore when the snapshot would overwrite
+// locks that are in use.
+var RestoreConflictErr = errors.New("snapshot conflicts with locks that are in use")
+
+// Columns of the lock table Restore() writes (every one but the id)
+var restoreColumns = []string{
+	"name", "owner", "in_use", "last_error", "last_used", "created_at", "acquired_at", "acquire_count", "host", "pid",
+	"takeover_count", "timeout_count", "metadata", "correlation_id", "deleted_at", "owner_labels", "checkpoint",
+}
+
+// Snapshot is a serializable dump of the lock table (and optionally the tail
+// of its audit log), ie. to attach to an incident ticket or for offline
+// analysis.
+type Snapshot struct {
+	Table   string    `json:"table"`
+	TakenAt time.Time `json:"taken_at"`
+	Host    string    `json:"host"`
+
+	Locks []*LockEntry `json:"locks"`
+
+	// Most recent audit entries first
+	Audit []*HistoryEntry `json:"audit,omitempty"`
+}
+
+// Snapshot dumps every lock row, ordered by name, along with the auditTail
+// most recent audit log entries (0 skips the audit log, which otherwise must
+// be enabled via WithAuditLog). Rows are read in a single read-only
+// transaction so the snapshot is consistent.
+func (r *RLock) Snapshot(ctx context.Context, auditTail int) (*Snapshot, error) {
+	if auditTail < 0 {
+		return nil, fmt.Errorf("audit tail cannot be negative")
+	}
+
+	if auditTail > 0 && !r.audit {
+		return nil, AuditDisabledErr
+	}
+
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("unable to start snapshot transaction: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	snapshot := &Snapshot{
+		Table:   r.table,
+		TakenAt: r.clock.Now(),
+		Host:    r.host,
+		Locks:   make([]*LockEntry, 0),
+	}
+
+	where, args := r.environmentWhere()
+
+	for _, table := range r.tables() {
+		locks := make([]*LockEntry, 0)
+
+		if err := tx.SelectContext(ctx, &locks, fmt.Sprintf("SELECT * FROM %v %vORDER BY name", table, where), args...); err != nil {
+			return nil, fmt.Errorf("unable to snapshot locks: %v", err)
+		}
+
+		snapshot.Locks = append(snapshot.Locks, locks...)
+	}
+
+	sortByName(snapshot.Locks)
+
+	if auditTail > 0 {
+		query := fmt.Sprintf("SELECT * FROM %v %vORDER BY id DESC LIMIT ?", r.auditTable(), where)
+
+		if err := tx.SelectContext(ctx, &snapshot.Audit, query, append(args, auditTail)...); err != nil {
+			return nil, fmt.Errorf("unable to snapshot audit log: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("unable to commit snapshot transaction: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// Restore loads the lock rows of snapshot (see Snapshot) into the lock table,
+// ie. when migrating between DB clusters. The table is expected to be empty;
+// rows with the same name as a snapshot row are replaced, unless they are in
+// use, in which case nothing is restored and the conflicting names are
+// returned along with RestoreConflictErr. With force, in-use rows are
+// replaced as well (their names are still returned). Rows are restored in a
+// single transaction; IDs are not preserved.
+func (r *RLock) Restore(ctx context.Context, snapshot *Snapshot, force bool) ([]string, error) {
+	if snapshot == nil {
+		return nil, fmt.Errorf("snapshot cannot be nil")
+	}
+
+	names := make(map[string]bool, len(snapshot.Locks))
+
+	for _, entry := range snapshot.Locks {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("snapshot contains a lock without a name")
+		}
+
+		if !r.inEnvironment(entry.Name) {
+			return nil, fmt.Errorf("snapshot lock '%v' is not in environment '%v'", entry.Name, r.environment)
+		}
+
+		if names[entry.Name] {
+			return nil, fmt.Errorf("snapshot contains lock '%v' more than once", entry.Name)
+		}
+
+		names[entry.Name] = true
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start restore transaction: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	existing := make([]*LockEntry, 0)
+
+	where, args := r.environmentWhere()
+
+	for _, table := range r.tables() {
+		locks := make([]*LockEntry, 0)
+
+		if err := tx.SelectContext(ctx, &locks, fmt.Sprintf("SELECT * FROM %v %vFOR UPDATE", table, where), args...); err != nil {
+			return nil, fmt.Errorf("unable to inspect lock table: %v", err)
+		}
+
+		existing = append(existing, locks...)
+	}
+
+	replaced := make([]string, 0)
+	conflicts := make([]string, 0)
+
+	for _, entry := range existing {
+		if !names[entry.Name] {
+			continue
+		}
+
+		replaced = append(replaced, entry.Name)
+
+		if entry.InUse {
+			conflicts = append(conflicts, entry.Name)
+		}
+	}
+
+	if len(conflicts) > 0 && !force {
+		return conflicts, RestoreConflictErr
+	}
+
+	for _, name := range replaced {
+		query := fmt.Sprintf("DELETE FROM %v WHERE name=?", r.tableFor(name))
+
+		if _, err := tx.ExecContext(ctx, query, name); err != nil {
+			return nil, fmt.Errorf("unable to replace '%v': %v", name, err)
+		}
+	}
+
+	for _, e := range snapshot.Locks {
+		query := fmt.Sprintf("INSERT INTO %v (%v) VALUES(%v)", r.tableFor(e.Name), strings.Join(restoreColumns, ", "),
+			strings.TrimSuffix(strings.Repeat("?, ", len(restoreColumns)), ", "))
+
+		// Rows created before acquired_at was tracked
+		acquiredAt := e.AcquiredAt
+		if acquiredAt.IsZero() {
+			acquiredAt = e.CreatedAt
+		}
+
+		if _, err := tx.ExecContext(ctx, query, e.Name, e.Owner, e.InUse, e.LastError, e.LastUsed, e.CreatedAt,
+			acquiredAt, e.AcquireCount, e.Host, e.PID, e.TakeoverCount, e.TimeoutCount, e.Metadata, e.CorrelationID,
+			e.DeletedAt, e.OwnerLabels, e.Checkpoint); err != nil {
+			return nil, fmt.Errorf("unable to restore '%v': %v", e.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("unable to commit restore transaction: %v", err)
+	}
+
+	r.mutated()
+
+	return conflicts, nil
+}
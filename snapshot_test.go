@@ -0,0 +1,237 @@
+package rlock
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// recordArg matches any argument, recording it in value.
+type recordArg struct {
+	value *driver.Value
+}
+
+func (a recordArg) Match(v driver.Value) bool {
+	*a.value = v
+	return true
+}
+
+var _ = Describe("Snapshot", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithAuditLog())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("dumps every lock row and the audit tail in one transaction", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM rlock ORDER BY name`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).
+				AddRow(1, "bar", "a", []byte{1}, "", time.Now(), time.Now()).
+				AddRow(2, "foo", "b", []byte{0}, "boom", time.Now(), time.Now()))
+		mock.ExpectQuery(`SELECT \* FROM rlock_audit ORDER BY id DESC LIMIT \?`).
+			WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner", "exit_status"}).AddRow(7, "foo", "b", "error"))
+		mock.ExpectCommit()
+
+		snapshot, err := rl.Snapshot(context.Background(), 5)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Table).To(Equal("rlock"))
+		Expect(snapshot.Locks).To(HaveLen(2))
+		Expect(snapshot.Locks[1].LastError).To(Equal("boom"))
+		Expect(snapshot.Audit).To(HaveLen(1))
+		Expect(snapshot.Audit[0].ExitStatus).To(Equal(ExitError))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+		// Round trips through JSON
+		data, err := json.Marshal(snapshot)
+		Expect(err).ToNot(HaveOccurred())
+
+		decoded := &Snapshot{}
+		Expect(json.Unmarshal(data, decoded)).To(Succeed())
+		Expect(decoded.Locks[0].Name).To(Equal("bar"))
+	})
+
+	It("skips the audit log when no tail is requested", func() {
+		_, _, plain := setupMocks()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM rlock ORDER BY name`).WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+		mock.ExpectCommit()
+
+		snapshot, err := rl.Snapshot(context.Background(), 0)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Locks).To(BeEmpty())
+		Expect(snapshot.Audit).To(BeNil())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+		_, err = plain.Snapshot(context.Background(), 10)
+		Expect(err).To(Equal(AuditDisabledErr))
+	})
+
+	It("rolls back on errors", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM rlock`).WillReturnError(fmt.Errorf("boom"))
+		mock.ExpectRollback()
+
+		_, err := rl.Snapshot(context.Background(), 0)
+
+		Expect(err).To(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	Describe("Restore", func() {
+		var snapshot *Snapshot
+
+		BeforeEach(func() {
+			snapshot = &Snapshot{Locks: []*LockEntry{
+				{Name: "bar", Owner: "a", InUse: true, CreatedAt: time.Now(), LastUsed: time.Now()},
+				{Name: "foo", Owner: "b", CreatedAt: time.Now(), LastUsed: time.Now(), AcquiredAt: time.Now(), AcquireCount: 3},
+			}}
+		})
+
+		It("loads every lock row in one transaction", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM rlock FOR UPDATE`).WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+			mock.ExpectExec("INSERT INTO rlock").
+				WithArgs("bar", "a", []byte{1}, "", snapshot.Locks[0].LastUsed, snapshot.Locks[0].CreatedAt, snapshot.Locks[0].CreatedAt,
+					0, "", 0, 0, 0, []byte(nil), "", nil, nil, []byte(nil)).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO rlock").
+				WithArgs("foo", "b", []byte{0}, "", snapshot.Locks[1].LastUsed, snapshot.Locks[1].CreatedAt, snapshot.Locks[1].AcquiredAt,
+					3, "", 0, 0, 0, []byte(nil), "", nil, nil, []byte(nil)).
+				WillReturnResult(sqlmock.NewResult(2, 1))
+			mock.ExpectCommit()
+
+			conflicts, err := rl.Restore(context.Background(), snapshot, false)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conflicts).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("replaces idle rows with the same name", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM rlock FOR UPDATE`).WillReturnRows(
+				sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "c", []byte{0}, "", time.Now(), time.Now()))
+			mock.ExpectExec(`DELETE FROM rlock WHERE name=\?`).WithArgs("foo").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(2, 1))
+			mock.ExpectCommit()
+
+			_, err := rl.Restore(context.Background(), snapshot, false)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("refuses to clobber in-use rows unless forced", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM rlock FOR UPDATE`).WillReturnRows(
+				sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "c", []byte{1}, "", time.Now(), time.Now()))
+			mock.ExpectRollback()
+
+			conflicts, err := rl.Restore(context.Background(), snapshot, false)
+
+			Expect(err).To(Equal(RestoreConflictErr))
+			Expect(conflicts).To(Equal([]string{"foo"}))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM rlock FOR UPDATE`).WillReturnRows(
+				sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "c", []byte{1}, "", time.Now(), time.Now()))
+			mock.ExpectExec(`DELETE FROM rlock WHERE name=\?`).WithArgs("foo").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO rlock").WillReturnResult(sqlmock.NewResult(2, 1))
+			mock.ExpectCommit()
+
+			conflicts, err = rl.Restore(context.Background(), snapshot, true)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conflicts).To(Equal([]string{"foo"}))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("restores every column of a snapshot", func() {
+			at := func(minute int) time.Time {
+				return time.Date(2020, 1, 1, 10, minute, 0, 0, time.UTC)
+			}
+
+			deletedAt := at(5)
+
+			entry := &LockEntry{
+				Name:          "foo",
+				Owner:         "a",
+				InUse:         true,
+				LastError:     "boom",
+				LastUsed:      at(4),
+				CreatedAt:     at(1),
+				AcquiredAt:    at(3),
+				AcquireCount:  3,
+				Host:          "host-a",
+				PID:           42,
+				TakeoverCount: 2,
+				TimeoutCount:  1,
+				Metadata:      []byte("meta"),
+				CorrelationID: "req-1",
+				DeletedAt:     &deletedAt,
+				OwnerLabels:   Labels{"team": "billing"},
+				Checkpoint:    []byte("progress"),
+			}
+
+			values := make([]driver.Value, len(restoreColumns))
+			args := make([]driver.Value, len(restoreColumns))
+
+			for i := range args {
+				args[i] = recordArg{&values[i]}
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM rlock FOR UPDATE`).WillReturnRows(sqlmock.NewRows(lockEntryColumns))
+			mock.ExpectExec("INSERT INTO rlock").WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			_, err := rl.Restore(context.Background(), &Snapshot{Locks: []*LockEntry{entry}}, false)
+			Expect(err).ToNot(HaveOccurred())
+
+			// Read back what was written
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM rlock ORDER BY name`).WillReturnRows(sqlmock.NewRows(restoreColumns).AddRow(values...))
+			mock.ExpectCommit()
+
+			restored, err := rl.Snapshot(context.Background(), 0)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(restored.Locks).To(Equal([]*LockEntry{entry}))
+			Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+		})
+
+		It("validates the snapshot", func() {
+			_, err := rl.Restore(context.Background(), nil, false)
+			Expect(err).To(HaveOccurred())
+
+			snapshot.Locks = append(snapshot.Locks, &LockEntry{Name: "foo"})
+
+			_, err = rl.Restore(context.Background(), snapshot, false)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("more than once"))
+		})
+	})
+})
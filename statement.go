@@ -0,0 +1,165 @@
+package rlock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// WithStatementTimeout overrides how long a single statement may take before
+// it is cancelled (defaults to StatementTimeout; 0 disables the timeout). This
+// keeps a hung query (ie. during a DB stall) from blocking an acquire or
+// unlock indefinitely.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(r *RLock) error {
+		if timeout < 0 {
+			return fmt.Errorf("statement timeout cannot be negative")
+		}
+
+		r.statementTimeout = timeout
+
+		return nil
+	}
+}
+
+func (r *RLock) statementContext() (context.Context, context.CancelFunc) {
+	if r.statementTimeout == 0 {
+		return context.Background(), func() {}
+	}
+
+	return r.deadlines.get(r.statementTimeout), func() {}
+}
+
+// Statement contexts are shared by statements started within the same
+// 1/deadlineSlack of the statement timeout
+const deadlineSlack = 16
+
+// statementDeadlines hands out the contexts bounding statements (see
+// WithStatementTimeout). Statements started at about the same time share a
+// context (and thereby its timer) rather than each allocating one; each of
+// them still gets at least the full timeout, and at most 1/deadlineSlack
+// more.
+type statementDeadlines struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	deadline time.Time
+
+	// Never called; shared contexts are only ever done once they expire
+	cancel context.CancelFunc
+}
+
+func (d *statementDeadlines) get(timeout time.Duration) context.Context {
+	earliest := time.Now().Add(timeout)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.ctx == nil || d.deadline.Before(earliest) {
+		d.deadline = earliest.Add(timeout / deadlineSlack)
+		d.ctx, d.cancel = context.WithDeadline(context.Background(), d.deadline)
+	}
+
+	return d.ctx
+}
+
+type statementKey struct {
+	kind, table string
+}
+
+// statementCache keeps the text of statements run on every acquisition and
+// unlock, which only depends on the table and on options, so that it is not
+// formatted over and over.
+type statementCache struct {
+	mu sync.RWMutex
+	m  map[statementKey]string
+}
+
+// lookup returns the statement of kind for table, if it is cached.
+func (c *statementCache) lookup(kind, table string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	query, ok := c.m[statementKey{kind, table}]
+
+	return query, ok
+}
+
+// put caches query as the statement of kind for table, returning it.
+func (c *statementCache) put(kind, table, query string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.m == nil {
+		c.m = make(map[statementKey]string)
+	}
+
+	c.m[statementKey{kind, table}] = query
+
+	return query
+}
+
+func (r *RLock) exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := r.statementContext()
+	defer cancel()
+
+	return r.db.ExecContext(ctx, query, args...)
+}
+
+func (r *RLock) get(dest interface{}, query string, args ...interface{}) error {
+	return r.getFrom(r.db, dest, query, args...)
+}
+
+func (r *RLock) selectAll(dest interface{}, query string, args ...interface{}) error {
+	return r.selectFrom(r.db, dest, query, args...)
+}
+
+// selectStrings returns the first n columns of the rows of query as strings.
+// Columns are read by position since their labels vary: MySQL 8 labels
+// information_schema columns in uppercase (unless they are aliased).
+func (r *RLock) selectStrings(ctx context.Context, n int, query string, args ...interface{}) ([][]string, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := make([][]string, 0)
+
+	for rows.Next() {
+		row := make([]string, n)
+		dest := make([]interface{}, n)
+
+		for i := range row {
+			dest[i] = &row[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+// getFrom is get() reading from db (see reader()).
+func (r *RLock) getFrom(db *sqlx.DB, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := r.statementContext()
+	defer cancel()
+
+	return db.GetContext(ctx, dest, query, args...)
+}
+
+// selectFrom is selectAll() reading from db (see reader()).
+func (r *RLock) selectFrom(db *sqlx.DB, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := r.statementContext()
+	defer cancel()
+
+	return db.SelectContext(ctx, dest, query, args...)
+}
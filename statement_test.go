@@ -0,0 +1,62 @@
+package rlock
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithStatementTimeout", func() {
+	var mock sqlmock.Sqlmock
+
+	newRLock := func(opts ...Option) *RLock {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err := New(sqlx.NewDb(mockDB, "sqlmock"), opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		return rl
+	}
+
+	It("rejects a negative timeout", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithStatementTimeout(-time.Second))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("defaults to StatementTimeout", func() {
+		rl := newRLock()
+
+		Expect(rl.statementTimeout).To(Equal(StatementTimeout))
+	})
+
+	It("cancels hung statements", func() {
+		rl := newRLock(WithStatementTimeout(50 * time.Millisecond))
+
+		mock.ExpectExec("INSERT INTO").WillDelayFor(time.Minute).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		start := time.Now()
+
+		_, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).To(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("cancels hung unlocks", func() {
+		rl := newRLock(WithStatementTimeout(50 * time.Millisecond))
+
+		mock.ExpectExec("UPDATE").WillDelayFor(time.Minute).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		start := time.Now()
+
+		Expect(rl.newLock("foo", time.Minute, 1).Unlock(nil)).ToNot(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+})
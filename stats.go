@@ -0,0 +1,157 @@
+package rlock
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Upper bound of lock names stats are kept for
+	statsMaxTracked = 1024
+
+	// Histogram buckets are powers of two, starting at histogramBase
+	histogramBase    = time.Millisecond
+	histogramBuckets = 22 // up to ~35 minutes, plus an overflow bucket
+)
+
+// Histogram is a distribution of durations using exponential (power of two)
+// buckets.
+type Histogram struct {
+	Count int64
+	Sum   time.Duration
+	Min   time.Duration
+	Max   time.Duration
+
+	// Buckets[i] counts observations <= histogramBase * 2^i; the last bucket
+	// counts everything above that
+	Buckets [histogramBuckets + 1]int64
+}
+
+// LockStats describes the wait and hold durations observed by this RLock for
+// a single lock.
+type LockStats struct {
+	Name string
+
+	// Wait is how long acquisitions waited (whether or not they succeeded)
+	Wait Histogram
+
+	// Hold is how long the lock was held before it was unlocked
+	Hold Histogram
+}
+
+type stats struct {
+	mu    sync.Mutex
+	locks map[string]*LockStats
+}
+
+// Stats returns the wait and hold durations observed by this RLock for the
+// lock called name (empty histograms if there were none). Stats are kept
+// in-process for up to 1024 locks.
+func (r *RLock) Stats(name string) LockStats {
+	name = r.normalizeName(name)
+
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+
+	if s, ok := r.stats.locks[name]; ok {
+		return *s
+	}
+
+	return LockStats{Name: name}
+}
+
+func (s *stats) observe(name string, wait, hold *time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locks == nil {
+		s.locks = make(map[string]*LockStats)
+	}
+
+	entry, ok := s.locks[name]
+	if !ok {
+		// Forget an arbitrary lock rather than growing without bound
+		if len(s.locks) >= statsMaxTracked {
+			for k := range s.locks {
+				delete(s.locks, k)
+				break
+			}
+		}
+
+		entry = &LockStats{Name: name}
+		s.locks[name] = entry
+	}
+
+	if wait != nil {
+		entry.Wait.observe(*wait)
+	}
+
+	if hold != nil {
+		entry.Hold.observe(*hold)
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.Count == 0 || d < h.Min {
+		h.Min = d
+	}
+
+	if d > h.Max {
+		h.Max = d
+	}
+
+	h.Count++
+	h.Sum += d
+
+	bucket := 0
+	for bound := histogramBase; bucket < histogramBuckets && d > bound; bound *= 2 {
+		bucket++
+	}
+
+	h.Buckets[bucket]++
+}
+
+// Mean returns the average observed duration.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an estimate (the upper bound of the bucket it falls into,
+// capped at Max) of the q-th quantile (0 <= q <= 1) of observed durations.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := int64(q * float64(h.Count))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+
+	for i, count := range h.Buckets {
+		seen += count
+
+		if seen < rank {
+			continue
+		}
+
+		// The overflow bucket has no upper bound
+		if i == histogramBuckets {
+			return h.Max
+		}
+
+		if bound := histogramBase << uint(i); bound < h.Max {
+			return bound
+		}
+
+		return h.Max
+	}
+
+	return h.Max
+}
@@ -0,0 +1,84 @@
+package rlock
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Stats", func() {
+	It("is empty for locks that were never used", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		s := rl.Stats("foo")
+
+		Expect(s.Name).To(Equal("foo"))
+		Expect(s.Wait.Count).To(BeZero())
+		Expect(s.Hold.Quantile(0.99)).To(BeZero())
+	})
+
+	It("tracks wait and hold durations per lock", func() {
+		mockDB, mock, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+
+		clock := NewFakeClock(time.Now())
+
+		rl, err := New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, hold := range []time.Duration{time.Second, 2 * time.Second} {
+			mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+			l, err := rl.Lock("foo", time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			clock.Advance(hold)
+
+			Expect(l.Unlock(nil)).To(Succeed())
+		}
+
+		s := rl.Stats("foo")
+
+		Expect(s.Wait.Count).To(Equal(int64(2)))
+		Expect(s.Hold.Count).To(Equal(int64(2)))
+		Expect(s.Hold.Min).To(Equal(time.Second))
+		Expect(s.Hold.Max).To(Equal(2 * time.Second))
+		Expect(s.Hold.Mean()).To(Equal(1500 * time.Millisecond))
+
+		Expect(rl.Stats("bar").Hold.Count).To(BeZero())
+	})
+
+	Describe("Histogram", func() {
+		It("estimates quantiles using bucket bounds", func() {
+			h := &Histogram{}
+
+			for i := 0; i < 90; i++ {
+				h.observe(3 * time.Millisecond)
+			}
+
+			for i := 0; i < 10; i++ {
+				h.observe(100 * time.Millisecond)
+			}
+
+			Expect(h.Quantile(0.5)).To(Equal(4 * time.Millisecond))
+			Expect(h.Quantile(0.9)).To(Equal(4 * time.Millisecond))
+			Expect(h.Quantile(0.99)).To(Equal(100 * time.Millisecond))
+			Expect(h.Quantile(0)).To(Equal(4 * time.Millisecond))
+		})
+
+		It("puts very long durations into the overflow bucket", func() {
+			h := &Histogram{}
+			h.observe(24 * time.Hour)
+
+			Expect(h.Buckets[histogramBuckets]).To(Equal(int64(1)))
+			Expect(h.Quantile(0.5)).To(Equal(24 * time.Hour))
+		})
+	})
+})
@@ -0,0 +1,97 @@
+// Package statsd implements rlock.MetricsSink on top of the StatsD protocol
+// (optionally with DogStatsD tags), for teams shipping metrics to StatsD or
+// Datadog rather than scraping Prometheus.
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPrefix is prepended to metric names.
+const DefaultPrefix = "rlock."
+
+// Flavor selects how tags are sent.
+type Flavor int
+
+const (
+	// StatsD drops tags; plain StatsD has no notion of them
+	StatsD Flavor = iota
+
+	// DogStatsD sends tags using the Datadog extension (|#key:value,...)
+	DogStatsD
+)
+
+type Sink struct {
+	conn   net.Conn
+	prefix string
+	flavor Flavor
+}
+
+// New returns a sink sending metrics over UDP to the StatsD agent at addr
+// (ie. "127.0.0.1:8125"), prefixing their names with prefix. If prefix is
+// empty, DefaultPrefix is used.
+func New(addr, prefix string, flavor Flavor) (*Sink, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("addr cannot be empty")
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial '%v': %v", addr, err)
+	}
+
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	return &Sink{
+		conn:   conn,
+		prefix: prefix,
+		flavor: flavor,
+	}, nil
+}
+
+func (s *Sink) Count(name string, value int64, tags map[string]string) {
+	s.send(fmt.Sprintf("%v%v:%d|c", s.prefix, name, value), tags)
+}
+
+func (s *Sink) Timing(name string, d time.Duration, tags map[string]string) {
+	ms := float64(d) / float64(time.Millisecond)
+
+	s.send(fmt.Sprintf("%v%v:%g|ms", s.prefix, name, ms), tags)
+}
+
+// Close closes the underlying connection.
+func (s *Sink) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Sink) send(line string, tags map[string]string) {
+	if s.flavor == DogStatsD && len(tags) > 0 {
+		line += "|#" + formatTags(tags)
+	}
+
+	// Metrics are best effort; never hold up lock operations
+	s.conn.Write([]byte(line))
+}
+
+func formatTags(tags map[string]string) string {
+	formatted := make([]string, 0, len(tags))
+
+	for k, v := range tags {
+		formatted = append(formatted, k+":"+sanitize(v))
+	}
+
+	sort.Strings(formatted)
+
+	return strings.Join(formatted, ",")
+}
+
+// sanitize replaces characters that have special meaning in the protocol
+func sanitize(v string) string {
+	return strings.NewReplacer("|", "_", ",", "_", "#", "_", ":", "_").Replace(v)
+}
@@ -0,0 +1,13 @@
+package statsd
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestStatsDSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "StatsD Suite")
+}
@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sink", func() {
+	var agent *net.UDPConn
+
+	BeforeEach(func() {
+		var err error
+
+		agent, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		agent.Close()
+	})
+
+	receive := func() string {
+		buf := make([]byte, 1024)
+
+		agent.SetReadDeadline(time.Now().Add(time.Second))
+
+		n, err := agent.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(buf[:n])
+	}
+
+	It("requires an addr", func() {
+		_, err := New("", "", StatsD)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("sends counters and timings without tags", func() {
+		s, err := New(agent.LocalAddr().String(), "", StatsD)
+		Expect(err).ToNot(HaveOccurred())
+		defer s.Close()
+
+		s.Count("acquire", 1, map[string]string{"lock": "foo"})
+		Expect(receive()).To(Equal("rlock.acquire:1|c"))
+
+		s.Timing("wait", 1500*time.Microsecond, nil)
+		Expect(receive()).To(Equal("rlock.wait:1.5|ms"))
+	})
+
+	It("sends sorted, sanitized DogStatsD tags", func() {
+		s, err := New(agent.LocalAddr().String(), "app.", DogStatsD)
+		Expect(err).ToNot(HaveOccurred())
+		defer s.Close()
+
+		s.Count("acquire", 1, map[string]string{"result": "acquired", "lock": "invoice|1"})
+		Expect(receive()).To(Equal("app.acquire:1|c|#lock:invoice_1,result:acquired"))
+	})
+})
@@ -0,0 +1,116 @@
+package rlock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Upper bound of lock names whose status is cached
+const statusCacheMaxEntries = 1024
+
+type statusCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cachedStatus
+}
+
+type cachedStatus struct {
+	entry *LockEntry
+	at    time.Time
+}
+
+// WithStatusCache serves Status() from a local cache for up to ttl (ie. a few
+// hundred milliseconds) after a lock's state was last read, absorbing read
+// bursts from dashboards and watchers. Lock state read while acquiring is
+// cached as well; locks mutated by this instance are evicted right away, but
+// changes made by other instances may take up to ttl to show.
+func WithStatusCache(ttl time.Duration) Option {
+	return func(r *RLock) error {
+		if ttl <= 0 {
+			return fmt.Errorf("status cache ttl must be positive")
+		}
+
+		r.statusCache = &statusCache{
+			ttl:     ttl,
+			entries: make(map[string]*cachedStatus),
+		}
+
+		return nil
+	}
+}
+
+// get returns a copy of the cached status of name, or nil if there is none
+// (or it expired).
+func (c *statusCache) get(name string, now time.Time) *LockEntry {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[name]
+	if !ok {
+		return nil
+	}
+
+	if now.Sub(cached.at) >= c.ttl {
+		delete(c.entries, name)
+		return nil
+	}
+
+	entry := *cached.entry
+
+	return &entry
+}
+
+func (c *statusCache) put(entry *LockEntry, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[entry.Name]; !ok && len(c.entries) >= statusCacheMaxEntries {
+		// Drop expired entries, or an arbitrary one if there are none
+		for name, cached := range c.entries {
+			if now.Sub(cached.at) >= c.ttl {
+				delete(c.entries, name)
+			}
+		}
+
+		for name := range c.entries {
+			if len(c.entries) < statusCacheMaxEntries {
+				break
+			}
+
+			delete(c.entries, name)
+		}
+	}
+
+	copied := *entry
+
+	c.entries[entry.Name] = &cachedStatus{entry: &copied, at: now}
+}
+
+// invalidate evicts name; with no name, it evicts everything.
+func (c *statusCache) invalidate(names ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(names) == 0 {
+		c.entries = make(map[string]*cachedStatus)
+		return
+	}
+
+	for _, name := range names {
+		delete(c.entries, name)
+	}
+}
@@ -0,0 +1,90 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithStatusCache", func() {
+	var (
+		mock  sqlmock.Sqlmock
+		rl    *RLock
+		clock *FakeClock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		clock = NewFakeClock(time.Now())
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock), WithStatusCache(500*time.Millisecond))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	expectStatus := func(owner string) {
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WithArgs("foo").WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", owner, []byte{1}, "", clock.Now(), clock.Now()))
+	}
+
+	It("validates the ttl", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithStatusCache(0))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("serves repeated reads from the cache until they expire", func() {
+		expectStatus("owner-1")
+
+		entry, err := rl.Status("foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.Owner).To(Equal("owner-1"))
+
+		// Callers cannot corrupt the cache
+		entry.Owner = "changed"
+
+		entry, err = rl.Status("foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.Owner).To(Equal("owner-1"))
+
+		clock.Advance(time.Second)
+		expectStatus("owner-2")
+
+		entry, err = rl.Status("foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.Owner).To(Equal("owner-2"))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("evicts locks mutated locally", func() {
+		expectStatus("owner-1")
+
+		_, err := rl.Status("foo")
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(1, 1))
+		Expect(rl.ForceUnlock("foo", "wedged")).To(Succeed())
+
+		expectStatus("owner-2")
+
+		entry, err := rl.Status("foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.Owner).To(Equal("owner-2"))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("bounds the number of cached locks", func() {
+		for i := 0; i < statusCacheMaxEntries+10; i++ {
+			rl.statusCache.put(&LockEntry{Name: fmt.Sprintf("lock-%d", i)}, clock.Now())
+		}
+
+		Expect(rl.statusCache.entries).To(HaveLen(statusCacheMaxEntries))
+	})
+})
@@ -0,0 +1,276 @@
+package rlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// StripeSeparator separates the name of a striped lock from the number of
+// the stripe in the names of its rows; see WithStripes.
+const StripeSeparator = "#"
+
+// stripeBusyErr is returned by claimStripe() when the lock is held (through
+// another stripe, or the one we tried to claim).
+var stripeBusyErr = errors.New("striped lock is held")
+
+// stripeClaimErr is returned by claimStripe() when the DB failed us while
+// claiming a stripe; like any other failed attempt, it is retried.
+type stripeClaimErr struct {
+	err error
+}
+
+func (e *stripeClaimErr) Error() string {
+	return e.err.Error()
+}
+
+type stripeRule struct {
+	pattern *regexp.Regexp
+	stripes int
+}
+
+// WithStripes spreads locks whose name matches pattern (a glob, see
+// FindLocks(); ie. "hot-*") over stripes rows ("<name>#0" to
+// "<name>#<stripes-1>", see StripeSeparator) rather than one, for extremely
+// hot locks acquired thousands of times a second: contenders claim any free
+// stripe, so that they do not all queue up on InnoDB's lock of a single row,
+// and hold the lock once no other stripe is held. A contender finding
+// another stripe held releases its own and tries again after a (jittered,
+// see WithPollJitter) poll interval. Stripes that went stale (see
+// WithTakeoverPolicy) do not count as held, unless forced takeovers are
+// disabled (see WithoutForcedTakeover), in which case StaleLockErr is
+// returned. The returned handle is a regular lock on the stripe's row, so
+// Lock.Name(), events, the audit log and admin functions (ie. GetLock())
+// see stripes rather than the lock. It can be passed several times; the
+// first matching pattern wins. Session locks (see WithSessionLocks) are not
+// striped.
+func WithStripes(pattern string, stripes int) Option {
+	return func(r *RLock) error {
+		if pattern == "" {
+			return fmt.Errorf("stripe pattern cannot be empty")
+		}
+
+		if stripes < 2 {
+			return fmt.Errorf("striped locks need at least 2 stripes")
+		}
+
+		r.stripes = append(r.stripes, stripeRule{globToRegexp(pattern), stripes})
+
+		return nil
+	}
+}
+
+// stripesFor returns how many rows the lock called name is spread over; 1
+// unless it is striped (see WithStripes).
+func (r *RLock) stripesFor(name string) int {
+	for _, rule := range r.stripes {
+		if rule.pattern.MatchString(name) {
+			return rule.stripes
+		}
+	}
+
+	return 1
+}
+
+// stripeNames returns the names of the rows of a lock called name spread
+// over stripes rows.
+func stripeNames(name string, stripes int) []string {
+	names := make([]string, stripes)
+
+	for i := range names {
+		names[i] = name + StripeSeparator + strconv.Itoa(i)
+	}
+
+	return names
+}
+
+// lockStriped acquires the lock called name, spread over stripes rows (see
+// WithStripes), waiting up to remaining (or until ctx is done).
+func (r *RLock) lockStriped(ctx context.Context, name string, stripes int, acquireTimeout, remaining time.Duration) (*Lock, error) {
+	start := r.clock.Now()
+	deadline := start.Add(remaining)
+	rows := stripeNames(name, stripes)
+
+	next := r.firstStripe(stripes)
+
+	attempts := 0
+	attempt := true
+
+	var lastErr error
+
+	for {
+		if attempt {
+			attempts++
+
+			if attempts > 1 && r.retry.onRetry != nil {
+				info := &RetryInfo{Name: name, Attempt: attempts, Waited: r.clock.Now().Sub(start), LastErr: lastErr}
+				r.retry.onRetry(info)
+
+				if info.abort != nil {
+					return nil, info.abort
+				}
+			}
+
+			l, err := r.claimStripe(ctx, name, rows, rows[next], acquireTimeout)
+
+			lastErr = nil
+
+			switch err := err.(type) {
+			case nil:
+				return l, nil
+			case *stripeClaimErr:
+				lastErr = err.err
+			default:
+				if err != stripeBusyErr {
+					return nil, err
+				}
+
+				next = (next + 1) % stripes
+			}
+
+			if r.retry.maxAttempts > 0 && attempts >= r.retry.maxAttempts {
+				return nil, MaxAttemptsErr
+			}
+
+			// The lock is contended; see WithMaxPollers
+			if attempts == 1 {
+				exitPoller, err := r.enterPoller(ctx, name, deadline, remaining)
+				if err != nil {
+					return nil, err
+				}
+
+				defer exitPoller()
+			}
+		}
+
+		wait := r.retry.backoff(r.jitter(r.pollInterval(name, r.clock.Now().Sub(start)), attempts == 1), r.clock.Now())
+
+		if remaining >= 0 {
+			left := deadline.Sub(r.clock.Now())
+			if left <= 0 {
+				return nil, AcquireTimeoutErr
+			}
+
+			if wait > left {
+				wait = left
+			}
+		}
+
+		if _, err := r.waitForRelease(ctx, nil, wait); err != nil {
+			return nil, err
+		}
+
+		attempt = r.retry.budget.take(r.clock.Now())
+	}
+}
+
+// firstStripe returns the stripe we try first out of stripes; owners start at
+// different stripes so that they do not convoy on the same row.
+func (r *RLock) firstStripe(stripes int) int {
+	h := fnv.New32a()
+	h.Write([]byte(r.owner))
+
+	return int(h.Sum32() % uint32(stripes))
+}
+
+// claimStripe claims the stripe row of the lock called name (whose stripes
+// are rows) and holds the lock if no other stripe is held; otherwise it
+// releases the stripe and returns stripeBusyErr.
+func (r *RLock) claimStripe(ctx context.Context, name string, rows []string, row string, acquireTimeout time.Duration) (*Lock, error) {
+	tokens, previous, err := r.claimBatch(ctx, r.tableFor(row), []string{row})
+	if err != nil {
+		return nil, &stripeClaimErr{err}
+	}
+
+	token, ok := tokens[row]
+	if !ok {
+		return nil, stripeBusyErr
+	}
+
+	// Our stripe is committed before the other stripes are read; of two
+	// contenders claiming different stripes at once, at least one sees the
+	// other's
+	if err := r.checkStripes(name, rows, row, acquireTimeout); err != nil {
+		query := fmt.Sprintf("UPDATE %v SET in_use=0 WHERE name=? AND owner=?", r.tableFor(row))
+
+		if _, releaseErr := r.exec(query, row, r.owner); releaseErr != nil {
+			r.observeError(releaseErr)
+			withError(r.logFor(row), releaseErr).Error("unable to release stripe")
+
+			return nil, fmt.Errorf("unable to release stripe '%v': %v", row, releaseErr)
+		}
+
+		return nil, err
+	}
+
+	if previousOwner, handoff := previous[row]; handoff {
+		r.auditAcquire(ctx, row, AcquireHandoff, previousOwner, "in_use=false")
+		r.emit(EventAcquired, row, previousOwner, "")
+	} else {
+		r.auditAcquire(ctx, row, AcquireFresh, "", "")
+		r.emit(EventAcquired, row, "", "")
+	}
+
+	return r.newLock(row, acquireTimeout, token), nil
+}
+
+// checkStripes returns stripeBusyErr if a stripe of the lock called name
+// other than ours is validly held.
+func (r *RLock) checkStripes(name string, rows []string, ours string, acquireTimeout time.Duration) error {
+	entries, err := r.entriesByName(rows)
+	if err != nil {
+		return &stripeClaimErr{fmt.Errorf("unable to fetch stripes of '%v': %v", name, err)}
+	}
+
+	for _, entry := range entries {
+		if entry.Name == ours || !entry.InUse {
+			continue
+		}
+
+		if isValid(r.policyFor(name), entry, name, acquireTimeout, r.clock.Now()) == nil {
+			return stripeBusyErr
+		}
+
+		if r.noForcedTakeover {
+			return &StaleLockErr{Entry: entry}
+		}
+
+		r.logFor(name).WithFields(golog.Fields{"stripe": entry.Name, "holder": entry.Owner}).
+			Warn("ignoring stale stripe")
+	}
+
+	return nil
+}
+
+// entriesByName returns the rows of the locks called names (that exist).
+func (r *RLock) entriesByName(names []string) ([]*LockEntry, error) {
+	entries := make([]*LockEntry, 0, len(names))
+
+	for table, names := range r.byTable(names) {
+		args := make([]interface{}, len(names))
+		for i, name := range names {
+			args[i] = name
+		}
+
+		query := fmt.Sprintf("SELECT * FROM %v WHERE name IN (%v)", table,
+			strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+
+		found := make([]*LockEntry, 0, len(names))
+
+		if err := r.selectAll(&found, query, args...); err != nil {
+			r.observeError(err)
+			return nil, err
+		}
+
+		entries = append(entries, found...)
+	}
+
+	return entries, nil
+}
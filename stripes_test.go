@@ -0,0 +1,124 @@
+package rlock
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Stripes", func() {
+	var (
+		mock         sqlmock.Sqlmock
+		rl           *RLock
+		ours, theirs string
+	)
+
+	claimRead := `SELECT name, owner, in_use, acquire_count, host, pid FROM rlock WHERE name IN \(\?\)`
+	stripesRead := `SELECT \* FROM rlock WHERE name IN \(\?, \?\)`
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithStripes("hot-*", 2))
+		Expect(err).ToNot(HaveOccurred())
+
+		stripes := stripeNames("hot-a", 2)
+		ours, theirs = stripes[rl.firstStripe(2)], stripes[1-rl.firstStripe(2)]
+	})
+
+	// expectStripeClaim expects a stripe to be claimed
+	expectStripeClaim := func() {
+		mock.ExpectExec(`INSERT INTO rlock .* ON DUPLICATE KEY UPDATE .*, in_use=1$`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(claimRead).WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(ours, rl.owner, []byte{1}, 1, rl.host, rl.pid))
+	}
+
+	It("validates its options", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithStripes("", 2))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithStripes("hot-*", 1))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("only stripes matching locks", func() {
+		Expect(rl.stripesFor("hot-a")).To(Equal(2))
+		Expect(rl.stripesFor("cold-a")).To(Equal(1))
+		Expect(stripeNames("hot-a", 2)).To(Equal([]string{"hot-a#0", "hot-a#1"}))
+	})
+
+	It("holds the lock once no other stripe is held", func() {
+		expectStripeClaim()
+		mock.ExpectQuery(stripesRead).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(1, ours, rl.owner, []byte{1}, "", time.Now(), time.Now()).
+			AddRow(2, theirs, "other-owner", []byte{0}, "", time.Now(), time.Now()))
+
+		l, err := rl.Lock("hot-a", 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.HasPrefix(l.Name(), "hot-a"+StripeSeparator)).To(BeTrue())
+		Expect(l.Token()).To(Equal(int64(1)))
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("releases its stripe when another stripe is held", func() {
+		expectStripeClaim()
+		mock.ExpectQuery(stripesRead).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(1, ours, rl.owner, []byte{1}, "", time.Now(), time.Now()).
+			AddRow(2, theirs, "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET in_use=0 WHERE name=\? AND owner=\?`).
+			WithArgs(ours, rl.owner).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := rl.Lock("hot-a", 0)
+		Expect(err).To(Equal(AcquireTimeoutErr))
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("does not wait for stripes in use by someone else", func() {
+		mock.ExpectExec(`INSERT INTO rlock .* ON DUPLICATE KEY UPDATE`).WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectQuery(claimRead).WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(ours, "other-owner", []byte{1}, 3, "other-host", 1))
+
+		_, err := rl.Lock("hot-a", 0)
+		Expect(err).To(Equal(AcquireTimeoutErr))
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("ignores stale stripes unless forced takeovers are disabled", func() {
+		stale := time.Now().Add(-2 * MaxAge)
+
+		expectStripeClaim()
+		mock.ExpectQuery(stripesRead).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(2, theirs, "dead-owner", []byte{1}, "", stale, stale))
+
+		_, err := rl.Lock("hot-a", 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		db, m, _ := setupMocks()
+		mock = m
+
+		rl, err = New(db, WithStripes("hot-*", 2), WithoutForcedTakeover())
+		Expect(err).ToNot(HaveOccurred())
+
+		stripes := stripeNames("hot-a", 2)
+		ours, theirs = stripes[rl.firstStripe(2)], stripes[1-rl.firstStripe(2)]
+
+		expectStripeClaim()
+		mock.ExpectQuery(stripesRead).WillReturnRows(sqlmock.NewRows(lockEntryColumns).
+			AddRow(2, theirs, "dead-owner", []byte{1}, "", stale, stale))
+		mock.ExpectExec(`UPDATE rlock SET in_use=0 WHERE name=\? AND owner=\?`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err = rl.Lock("hot-a", 0)
+		Expect(err).To(BeAssignableToTypeOf(&StaleLockErr{}))
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
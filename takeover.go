@@ -0,0 +1,141 @@
+package rlock
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// TakeoverPolicy decides whether a lock that is still marked as in use has
+// gone stale, in which case contenders take it over (see Lock()). The default
+// policy, MaxAgePolicy(MaxAge), considers locks stale once they have not been
+// used (ie. refreshed) for longer than MaxAge.
+type TakeoverPolicy interface {
+	Stale(existing *LockEntry, now time.Time) bool
+}
+
+// TakeoverPolicyFunc adapts a function to a TakeoverPolicy, ie. to require
+// both expiry and a missing heartbeat of the owner:
+//
+//	rlock.TakeoverPolicyFunc(func(e *rlock.LockEntry, now time.Time) bool {
+//	    return rlock.MaxAgePolicy(time.Hour).Stale(e, now) && !registry.Alive(e.Owner)
+//	})
+type TakeoverPolicyFunc func(existing *LockEntry, now time.Time) bool
+
+func (f TakeoverPolicyFunc) Stale(existing *LockEntry, now time.Time) bool {
+	return f(existing, now)
+}
+
+// MaxAgePolicy considers locks stale once they have not been used for longer
+// than the given duration.
+type MaxAgePolicy time.Duration
+
+func (p MaxAgePolicy) Stale(existing *LockEntry, now time.Time) bool {
+	return now.Sub(existing.LastUsed) > time.Duration(p)
+}
+
+func (p MaxAgePolicy) String() string {
+	return fmt.Sprintf("max age %v", time.Duration(p))
+}
+
+// WithTakeoverPolicy overrides what counts as a stale lock when acquiring
+// locks (defaults to MaxAgePolicy(MaxAge)). All instances sharing the locks
+// should use the same policy. ReapStale() and permit pools use their own age
+// cutoffs.
+func WithTakeoverPolicy(policy TakeoverPolicy) Option {
+	return func(r *RLock) error {
+		if policy == nil {
+			return fmt.Errorf("takeover policy cannot be nil")
+		}
+
+		r.takeoverPolicy = policy
+
+		return nil
+	}
+}
+
+type staleOverride struct {
+	pattern *regexp.Regexp
+	policy  MaxAgePolicy
+}
+
+// WithStaleAfter makes locks whose name matches pattern (a glob, see
+// FindLocks(); ie. "deploy-*") go stale after maxAge rather than as decided
+// by the takeover policy (see WithTakeoverPolicy). It can be passed several
+// times; the first matching pattern wins. Patterns are matched against
+// normalized names (see WithNameNormalization).
+func WithStaleAfter(pattern string, maxAge time.Duration) Option {
+	return func(r *RLock) error {
+		if pattern == "" {
+			return fmt.Errorf("stale pattern cannot be empty")
+		}
+
+		if maxAge <= 0 {
+			return fmt.Errorf("max age must be positive")
+		}
+
+		r.staleOverrides = append(r.staleOverrides, staleOverride{globToRegexp(pattern), MaxAgePolicy(maxAge)})
+
+		return nil
+	}
+}
+
+// WithTakeoverVeto calls veto with a stale lock's entry before taking it over
+// (see Lock()); returning an error aborts the takeover, failing the
+// acquisition with that error. Use it for checks the lock table cannot answer,
+// ie. whether the displaced owner is still heartbeating in a service registry.
+func WithTakeoverVeto(veto func(existing *LockEntry) error) Option {
+	return func(r *RLock) error {
+		if veto == nil {
+			return fmt.Errorf("takeover veto cannot be nil")
+		}
+
+		r.takeoverVeto = veto
+
+		return nil
+	}
+}
+
+// policyFor returns the takeover policy applying to the lock called name.
+func (r *RLock) policyFor(name string) TakeoverPolicy {
+	for _, o := range r.staleOverrides {
+		if o.pattern.MatchString(name) {
+			return o.policy
+		}
+	}
+
+	return r.takeoverPolicy
+}
+
+// StaleLockErr is returned when acquiring a stale lock with forced takeovers
+// disabled (see WithoutForcedTakeover); Entry is the stale lock as found.
+type StaleLockErr struct {
+	Entry *LockEntry
+}
+
+func (e *StaleLockErr) Error() string {
+	return fmt.Sprintf("lock '%v' held by '%v' is stale (last used %v)", e.Entry.Name, e.Entry.Owner, e.Entry.LastUsed)
+}
+
+// WithoutForcedTakeover never takes stale locks over: acquiring one returns a
+// *StaleLockErr instead, leaving it to a human (or ForceUnlock()) to decide
+// whether the holder is really gone. A wedged but alive process may still
+// believe it holds the lock. Permit pools stop reclaiming stale permits as
+// well; job queues still re-deliver jobs past their visibility timeout.
+func WithoutForcedTakeover() Option {
+	return func(r *RLock) error {
+		r.noForcedTakeover = true
+		return nil
+	}
+}
+
+// staleEvidence describes why existing was deemed stale, for the audit log.
+func (r *RLock) staleEvidence(existing *LockEntry) string {
+	evidence := fmt.Sprintf("in_use=true, last used %v ago", r.clock.Now().Sub(existing.LastUsed).Round(time.Second))
+
+	if s, ok := r.policyFor(existing.Name).(fmt.Stringer); ok {
+		evidence += fmt.Sprintf(" (%v)", s)
+	}
+
+	return evidence
+}
@@ -0,0 +1,209 @@
+package rlock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithTakeoverPolicy", func() {
+	var (
+		mock     sqlmock.Sqlmock
+		rl       *RLock
+		stale    bool
+		lastUsed time.Time
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+		stale = false
+		lastUsed = time.Now().Add(-2 * MaxAge)
+
+		var err error
+
+		rl, err = New(db, WithTakeoverPolicy(TakeoverPolicyFunc(func(existing *LockEntry, now time.Time) bool {
+			return stale
+		})))
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", lastUsed, time.Now()))
+	})
+
+	It("rejects nil policies", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithTakeoverPolicy(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("leaves locks the policy does not consider stale alone", func() {
+		_, err := rl.Lock("foo", 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("takes over locks the policy considers stale", func() {
+		stale = true
+
+		mock.ExpectExec(`UPDATE rlock SET .*takeover_count=takeover_count\+1`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", 0)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("MaxAgePolicy", func() {
+	It("considers locks unused for longer than its age stale", func() {
+		now := time.Now()
+		policy := MaxAgePolicy(time.Minute)
+
+		Expect(policy.Stale(&LockEntry{LastUsed: now.Add(-2 * time.Minute)}, now)).To(BeTrue())
+		Expect(policy.Stale(&LockEntry{LastUsed: now.Add(-30 * time.Second)}, now)).To(BeFalse())
+		Expect(policy.String()).To(Equal("max age 1m0s"))
+	})
+})
+
+var _ = Describe("WithoutForcedTakeover", func() {
+	It("reports stale locks instead of taking them over", func() {
+		db, mock, _ := setupMocks()
+
+		rl, err := New(db, WithoutForcedTakeover())
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now().Add(-2*MaxAge), time.Now()))
+
+		_, err = rl.Lock("foo", time.Minute)
+
+		staleErr, ok := err.(*StaleLockErr)
+		Expect(ok).To(BeTrue())
+		Expect(staleErr.Entry.Owner).To(Equal("other-owner"))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("still hands over released locks", func() {
+		db, mock, _ := setupMocks()
+
+		rl, err := New(db, WithoutForcedTakeover())
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{0}, "", time.Now().Add(-2*MaxAge), time.Now()))
+		mock.ExpectExec("UPDATE rlock SET").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err = rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("WithStaleAfter", func() {
+	It("validates its arguments", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithStaleAfter("", time.Hour))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithStaleAfter("deploy-*", 0))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("overrides the policy for matching names", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db,
+			WithStaleAfter("deploy-*", 2*time.Hour),
+			WithStaleAfter("cache-refresh", 2*time.Minute),
+			WithStaleAfter("deploy-?", time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(rl.policyFor("deploy-api")).To(Equal(MaxAgePolicy(2 * time.Hour)))
+		Expect(rl.policyFor("deploy-a")).To(Equal(MaxAgePolicy(2 * time.Hour)))
+		Expect(rl.policyFor("cache-refresh")).To(Equal(MaxAgePolicy(2 * time.Minute)))
+		Expect(rl.policyFor("cache-refresh-2")).To(Equal(MaxAgePolicy(MaxAge)))
+		Expect(rl.policyFor("other")).To(Equal(MaxAgePolicy(MaxAge)))
+	})
+
+	It("takes over matching locks once they exceed their max age", func() {
+		db, mock, _ := setupMocks()
+
+		rl, err := New(db, WithStaleAfter("cache-*", time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "cache-refresh", "other-owner", []byte{1}, "", time.Now().Add(-2*time.Minute), time.Now()))
+		mock.ExpectExec(`UPDATE rlock SET .*takeover_count=takeover_count\+1`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err = rl.Lock("cache-refresh", 0)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("WithTakeoverVeto", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+		veto error
+		seen *LockEntry
+	)
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+		veto = nil
+		seen = nil
+
+		var err error
+
+		rl, err = New(db, WithTakeoverVeto(func(existing *LockEntry) error {
+			seen = existing
+			return veto
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		mock.ExpectExec("INSERT INTO rlock").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now().Add(-2*MaxAge), time.Now()))
+	})
+
+	It("rejects nil vetoes", func() {
+		_, err := New(rl.db, WithTakeoverVeto(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("aborts the takeover when vetoed", func() {
+		veto = fmt.Errorf("owner still heartbeating")
+
+		_, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).To(Equal(veto))
+		Expect(seen.Owner).To(Equal("other-owner"))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("takes the lock over otherwise", func() {
+		mock.ExpectExec(`UPDATE rlock SET .*takeover_count=takeover_count\+1`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(seen.Name).To(Equal("foo"))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
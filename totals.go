@@ -0,0 +1,187 @@
+package rlock
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TotalsDisabledErr is returned by Totals() and ListTotals() unless
+// WithTotals is used.
+var TotalsDisabledErr = errors.New("totals are not enabled (see WithTotals)")
+
+const totalsSchemaDDL = "CREATE TABLE IF NOT EXISTS `%v` (\n" +
+	"  `name` VARCHAR(255) NOT NULL,\n" +
+	"  `acquire_count` BIGINT NOT NULL DEFAULT 0,\n" +
+	"  `takeover_count` BIGINT NOT NULL DEFAULT 0,\n" +
+	"  `timeout_count` BIGINT NOT NULL DEFAULT 0,\n" +
+	"  `release_count` BIGINT NOT NULL DEFAULT 0,\n" +
+	"  `total_hold_ms` BIGINT NOT NULL DEFAULT 0,\n" +
+	"  `last_holder` VARCHAR(255) NOT NULL DEFAULT '',\n" +
+	"  `last_acquired_at` TIMESTAMP NULL DEFAULT NULL,\n" +
+	"  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n" +
+	"  PRIMARY KEY (`name`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+var totalsSchemaColumns = []column{
+	{"name", "VARCHAR(255)"},
+	{"acquire_count", "BIGINT"},
+	{"takeover_count", "BIGINT"},
+	{"timeout_count", "BIGINT"},
+	{"release_count", "BIGINT"},
+	{"total_hold_ms", "BIGINT"},
+	{"last_holder", "VARCHAR(255)"},
+	{"last_acquired_at", "TIMESTAMP"},
+	{"updated_at", "TIMESTAMP"},
+}
+
+// LockTotals is the running totals of a lock, across every RLock using
+// WithTotals.
+type LockTotals struct {
+	Name           string     `db:"name"`
+	AcquireCount   int64      `db:"acquire_count"`
+	TakeoverCount  int64      `db:"takeover_count"`
+	TimeoutCount   int64      `db:"timeout_count"`
+	ReleaseCount   int64      `db:"release_count"`
+	TotalHoldMS    int64      `db:"total_hold_ms"`
+	LastHolder     string     `db:"last_holder"`
+	LastAcquiredAt *time.Time `db:"last_acquired_at"`
+	UpdatedAt      time.Time  `db:"updated_at"`
+}
+
+// TotalHold returns how long the lock was held for in total, counting
+// released holds only.
+func (t *LockTotals) TotalHold() time.Duration {
+	return time.Duration(t.TotalHoldMS) * time.Millisecond
+}
+
+// MeanHold returns how long the lock was held for on average, counting
+// released holds only.
+func (t *LockTotals) MeanHold() time.Duration {
+	if t.ReleaseCount == 0 {
+		return 0
+	}
+
+	return t.TotalHold() / time.Duration(t.ReleaseCount)
+}
+
+// WithTotals keeps running totals per lock (acquisitions, takeovers,
+// timeouts, total hold time and the last holder) in the totals table
+// ("<table>_stats", see TotalsSchema()), updated as locks are acquired,
+// released and time out, so that reports (see Totals() and ListTotals()) do
+// not have to scan the audit log (see WithAuditLog). Unlike Stats(), which only
+// covers this RLock, the totals cover every RLock using this option. Failing
+// to update them is logged, not returned. Holds are counted once released,
+// so locks that are never unlocked (ie. reaped) add to acquire_count only.
+func WithTotals() Option {
+	return func(r *RLock) error {
+		r.totals = true
+		return nil
+	}
+}
+
+// TotalsSchema returns the MySQL DDL creating the totals table for a lock
+// table called table (see WithTotals).
+func TotalsSchema(table string) string {
+	return fmt.Sprintf(totalsSchemaDDL, table+"_stats")
+}
+
+func (r *RLock) totalsTable() string {
+	return r.table + "_stats"
+}
+
+// Totals returns the running totals of the lock called name (see
+// WithTotals). Returns KeyNotFoundErr if it was never acquired or timed out
+// on.
+func (r *RLock) Totals(name string) (*LockTotals, error) {
+	name = r.normalizeName(name)
+
+	if !r.totals {
+		return nil, TotalsDisabledErr
+	}
+
+	db, err := r.reader(name)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %v WHERE name=?", r.totalsTable())
+
+	totals := &LockTotals{}
+
+	if err := r.getFrom(db, totals, query, name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, KeyNotFoundErr
+		}
+
+		return nil, fmt.Errorf("unable to fetch totals of '%v': %v", name, err)
+	}
+
+	return totals, nil
+}
+
+// ListTotals returns the running totals of every lock (see WithTotals),
+// ordered by name.
+func (r *RLock) ListTotals() ([]*LockTotals, error) {
+	if !r.totals {
+		return nil, TotalsDisabledErr
+	}
+
+	db, err := r.reader()
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := r.environmentWhere()
+	query := fmt.Sprintf("SELECT * FROM %v %vORDER BY name", r.totalsTable(), where)
+
+	totals := make([]*LockTotals, 0)
+
+	if err := r.selectFrom(db, &totals, query, args...); err != nil {
+		return nil, fmt.Errorf("unable to list totals: %v", err)
+	}
+
+	return totals, nil
+}
+
+// addTotals adds to the totals of name (see WithTotals); failing to do so is
+// not worth failing the operation over.
+func (r *RLock) addTotals(name, insert, update string, args ...interface{}) {
+	if !r.totals {
+		return
+	}
+
+	query := fmt.Sprintf("INSERT INTO %v %v ON DUPLICATE KEY UPDATE %v", r.totalsTable(), insert, update)
+
+	if _, err := r.exec(query, args...); err != nil {
+		withError(r.logFor(name), err).Warn("unable to update totals")
+	}
+}
+
+func (r *RLock) totalAcquire(name string, tookOver bool) {
+	takeovers := 0
+	if tookOver {
+		takeovers = 1
+	}
+
+	r.addTotals(name,
+		"(name, acquire_count, takeover_count, last_holder, last_acquired_at) VALUES (?, 1, ?, ?, NOW())",
+		"acquire_count=acquire_count+1, takeover_count=takeover_count+VALUES(takeover_count), "+
+			"last_holder=VALUES(last_holder), last_acquired_at=VALUES(last_acquired_at)",
+		name, takeovers, r.owner)
+}
+
+func (r *RLock) totalHold(name string, held time.Duration) {
+	r.addTotals(name,
+		"(name, release_count, total_hold_ms) VALUES (?, 1, ?)",
+		"release_count=release_count+1, total_hold_ms=total_hold_ms+VALUES(total_hold_ms)",
+		name, int64(held/time.Millisecond))
+}
+
+func (r *RLock) totalTimeout(name string) {
+	r.addTotals(name,
+		"(name, timeout_count) VALUES (?, 1)",
+		"timeout_count=timeout_count+1",
+		name)
+}
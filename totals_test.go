@@ -0,0 +1,135 @@
+package rlock
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithTotals", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	totalsColumns := []string{"name", "acquire_count", "takeover_count", "timeout_count", "release_count",
+		"total_hold_ms", "last_holder", "last_acquired_at", "updated_at"}
+
+	BeforeEach(func() {
+		db, m, _ := setupMocks()
+		mock = m
+
+		var err error
+
+		rl, err = New(db, WithTotals())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("counts acquisitions and their holder", func() {
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO rlock_stats \(name, acquire_count, takeover_count, last_holder, last_acquired_at\) `+
+			`VALUES \(\?, 1, \?, \?, NOW\(\)\) ON DUPLICATE KEY UPDATE acquire_count=acquire_count\+1, .*last_holder=VALUES\(last_holder\)`).
+			WithArgs("foo", 0, rl.owner).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("adds released holds", func() {
+		l := rl.newLock("foo", time.Minute, 1)
+		l.acquiredAt = rl.clock.Now().Add(-1500 * time.Millisecond)
+
+		mock.ExpectExec("UPDATE rlock SET").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO rlock_stats \(name, release_count, total_hold_ms\) VALUES \(\?, 1, \?\) `+
+			`ON DUPLICATE KEY UPDATE release_count=release_count\+1, total_hold_ms=total_hold_ms\+VALUES\(total_hold_ms\)`).
+			WithArgs("foo", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		Expect(l.Unlock(nil)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("counts timeouts", func() {
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE rlock SET timeout_count").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO rlock_stats \(name, timeout_count\) VALUES \(\?, 1\) ON DUPLICATE KEY UPDATE timeout_count=timeout_count\+1`).
+			WithArgs("foo").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("still acquires when updating the totals fails", func() {
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO rlock_stats").WillReturnError(errors.New("table is full"))
+
+		_, err := rl.Lock("foo", time.Minute)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("returns the totals of a lock", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock_stats WHERE name=\?`).
+			WithArgs("foo").
+			WillReturnRows(sqlmock.NewRows(totalsColumns).
+				AddRow("foo", 5, 1, 2, 4, 10000, "owner-a", time.Now(), time.Now()))
+
+		totals, err := rl.Totals("foo")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(totals.AcquireCount).To(Equal(int64(5)))
+		Expect(totals.LastHolder).To(Equal("owner-a"))
+		Expect(totals.TotalHold()).To(Equal(10 * time.Second))
+		Expect(totals.MeanHold()).To(Equal(2500 * time.Millisecond))
+	})
+
+	It("returns KeyNotFoundErr for locks without totals", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock_stats WHERE name=\?`).
+			WillReturnRows(sqlmock.NewRows(totalsColumns))
+
+		_, err := rl.Totals("foo")
+
+		Expect(err).To(Equal(KeyNotFoundErr))
+	})
+
+	It("lists the totals of every lock", func() {
+		mock.ExpectQuery(`SELECT \* FROM rlock_stats ORDER BY name`).
+			WillReturnRows(sqlmock.NewRows(totalsColumns).
+				AddRow("bar", 1, 0, 0, 0, 0, "owner-a", nil, time.Now()).
+				AddRow("foo", 5, 1, 2, 4, 10000, "owner-b", time.Now(), time.Now()))
+
+		totals, err := rl.ListTotals()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(totals).To(HaveLen(2))
+		Expect(totals[0].LastAcquiredAt).To(BeNil())
+		Expect(totals[0].MeanHold()).To(BeZero())
+	})
+
+	It("requires the totals to be enabled", func() {
+		db, _, _ := setupMocks()
+
+		rl, err := New(db)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = rl.Totals("foo")
+		Expect(err).To(Equal(TotalsDisabledErr))
+
+		_, err = rl.ListTotals()
+		Expect(err).To(Equal(TotalsDisabledErr))
+	})
+})
@@ -0,0 +1,199 @@
+package rlock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// UnlockAll unlocks every lock held by this instance (see Lock.Unlock()),
+// recording lastErrors[name] (if any) on the lock called name, with a single
+// statement per lock table rather than one per lock, so that workers holding
+// many locks shut down quickly. Locks that turn out to have been lost are
+// forgotten and reported in the returned error. Session locks (see
+// WithSessionLocks), locks held through an rlockd server and, when archiving
+// (see WithArchive), every lock are unlocked one by one.
+func (r *RLock) UnlockAll(lastErrors map[string]error) error {
+	errs := make(map[string]error, len(lastErrors))
+
+	for name, err := range lastErrors {
+		errs[r.normalizeName(name)] = err
+	}
+
+	locks := r.heldLocks()
+
+	// Always lock handles in the same order
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].name < locks[j].name
+	})
+
+	var (
+		total    int
+		failed   int
+		firstErr error
+	)
+
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		failed++
+	}
+
+	batch := make(map[string]*Lock, len(locks))
+	names := make([]string, 0, len(locks))
+
+	for _, l := range locks {
+		if l.client != nil || l.session != nil || r.archive {
+			if err := l.Unlock(errs[l.name]); err != AlreadyUnlockedErr {
+				total++
+
+				if err != nil {
+					fail(err)
+				}
+			}
+
+			continue
+		}
+
+		l.mu.Lock()
+
+		if l.unlocked {
+			l.mu.Unlock()
+			continue
+		}
+
+		defer l.mu.Unlock()
+
+		batch[l.name] = l
+		names = append(names, l.name)
+		total++
+	}
+
+	for table, names := range r.byTable(names) {
+		released, err := r.unlockBatch(table, names, errs)
+		if err != nil {
+			for range names {
+				fail(err)
+			}
+		}
+
+		for _, name := range names {
+			l := batch[name]
+
+			// Whether or not the DB unlock succeeds, the local mutex must not
+			// outlive the handle (see Lock.unlock())
+			if l.releaseGate != nil {
+				l.releaseGate()
+				l.releaseGate = nil
+			}
+
+			if err != nil {
+				continue
+			}
+
+			l.unlocked = true
+			l.stopHeartbeat()
+
+			if !released[name] {
+				r.forget(l)
+				r.logFor(name).Warn("lock was lost before unlocking it")
+				fail(LockLostErr)
+
+				continue
+			}
+
+			lastError := errs[name]
+
+			lastErrorStr := ""
+			if lastError != nil {
+				lastErrorStr = r.lastErrorText(lastError.Error())
+			}
+
+			l.released(lastError, lastErrorStr)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to unlock %d of %d locks: %v", failed, total, firstErr)
+	}
+
+	return nil
+}
+
+// unlockBatch releases the locks called names in table, recording
+// lastErrors on them, returning which of them were released (others were
+// lost).
+func (r *RLock) unlockBatch(table string, names []string, lastErrors map[string]error) (map[string]bool, error) {
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+
+	set := "in_use=0, last_error=''"
+	args := make([]interface{}, 0)
+	cases := make([]string, 0)
+
+	for _, name := range names {
+		if err := lastErrors[name]; err != nil {
+			cases = append(cases, "WHEN ? THEN ?")
+			args = append(args, name, r.lastErrorText(err.Error()))
+		}
+	}
+
+	if len(cases) > 0 {
+		set = fmt.Sprintf("in_use=0, last_error=CASE name %v ELSE '' END", strings.Join(cases, " "))
+	}
+
+	cond := []interface{}{r.owner}
+	for _, name := range names {
+		cond = append(cond, name)
+	}
+
+	op := r.startOp("unlock_all", "")
+	defer op.done()
+
+	query := fmt.Sprintf("UPDATE %v SET %v WHERE owner=? AND name IN (%v)", table, set, in)
+
+	result, err := r.exec(query, append(args, cond...)...)
+	if err != nil {
+		r.observeError(err)
+		withError(r.logger, err).Error("unable to unlock all locks")
+
+		return nil, fmt.Errorf("unable to unlock all locks: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine affected rows after unlocking all locks: %v", err)
+	}
+
+	released := make(map[string]bool, len(names))
+
+	if affected == int64(len(names)) {
+		for _, name := range names {
+			released[name] = true
+		}
+
+		return released, nil
+	}
+
+	// Some of the locks were no longer ours; find out which ones we released
+	r.logger.WithFields(golog.Fields{"affected": affected, "locks": len(names)}).
+		Warn("unexpected number of affected rows after unlocking all locks")
+
+	unlocked := make([]string, 0, len(names))
+
+	query = fmt.Sprintf("SELECT name FROM %v WHERE owner=? AND in_use=0 AND name IN (%v)", table, in)
+
+	if err := r.selectAll(&unlocked, query, cond...); err != nil {
+		r.observeError(err)
+		return nil, fmt.Errorf("unable to determine unlocked locks: %v", err)
+	}
+
+	for _, name := range unlocked {
+		released[name] = true
+	}
+
+	return released, nil
+}
@@ -0,0 +1,78 @@
+package rlock
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("UnlockAll", func() {
+	var (
+		mock     sqlmock.Sqlmock
+		rl       *RLock
+		foo, bar *Lock
+	)
+
+	BeforeEach(func() {
+		_, mock, rl = setupMocks()
+
+		foo = rl.newLock("foo", time.Minute, 1)
+		bar = rl.newLock("bar", time.Minute, 1)
+	})
+
+	It("unlocks every lock with a single statement", func() {
+		mock.ExpectExec(`UPDATE rlock SET in_use=0, last_error='' WHERE owner=\? AND name IN \(\?, \?\)`).
+			WithArgs(rl.owner, "bar", "foo").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		Expect(rl.UnlockAll(nil)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+
+		Expect(foo.Unlock(nil)).To(Equal(AlreadyUnlockedErr))
+		Expect(bar.Unlock(nil)).To(Equal(AlreadyUnlockedErr))
+		Expect(rl.heldLocks()).To(BeEmpty())
+
+		// Nothing left to unlock
+		Expect(rl.UnlockAll(nil)).To(Succeed())
+	})
+
+	It("records the last error of each lock", func() {
+		mock.ExpectExec(`UPDATE rlock SET in_use=0, last_error=CASE name WHEN \? THEN \? ELSE '' END WHERE owner=\? AND name IN \(\?, \?\)`).
+			WithArgs("foo", "boom", rl.owner, "bar", "foo").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		Expect(rl.UnlockAll(map[string]error{"foo": errors.New("boom"), "bar": nil})).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("reports locks that were lost", func() {
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT name FROM rlock WHERE owner=\? AND in_use=0 AND name IN \(\?, \?\)`).
+			WithArgs(rl.owner, "bar", "foo").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("bar"))
+
+		err := rl.UnlockAll(nil)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("1 of 2"))
+		Expect(err.Error()).To(ContainSubstring(LockLostErr.Error()))
+
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+		Expect(rl.heldLocks()).To(BeEmpty())
+	})
+
+	It("keeps the locks if the statement fails", func() {
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnError(errors.New("connection reset"))
+
+		err := rl.UnlockAll(nil)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("2 of 2"))
+
+		Expect(rl.heldLocks()).To(HaveLen(2))
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(0, 1))
+		Expect(foo.Unlock(nil)).To(Succeed())
+	})
+})
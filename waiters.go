@@ -0,0 +1,205 @@
+package rlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const waitersSchemaDDL = "CREATE TABLE IF NOT EXISTS `%v` (\n" +
+	"  `id` BIGINT NOT NULL AUTO_INCREMENT,\n" +
+	"  `name` VARCHAR(255) NOT NULL,\n" +
+	"  `owner` VARCHAR(255) NOT NULL,\n" +
+	"  `host` VARCHAR(255) NOT NULL DEFAULT '',\n" +
+	"  `pid` INT NOT NULL DEFAULT 0,\n" +
+	"  `since` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"  `seen_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"%v" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  UNIQUE KEY `name_owner` (`name`, `owner`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+// Columns added to the waiter table after its initial schema
+var waitersSchemaColumns = []column{
+	{"eligible", "BIT(1) NOT NULL DEFAULT b'0'"},
+	{"priority", "INT NOT NULL DEFAULT 0"},
+}
+
+type priorityKey struct{}
+
+// Waiters that have not been seen for this many (max) poll intervals are
+// assumed to have gone away
+const waiterTTLIntervals = 3
+
+// WithWaiterTracking registers contenders in a waiter table (see
+// WaitersSchema()) while they wait, so that Status(), ListLocks() and
+// FindLocks() report how many contenders are waiting on each lock
+// (LockEntry.Waiters). This costs an extra statement per poll.
+func WithWaiterTracking() Option {
+	return func(r *RLock) error {
+		r.trackWaiters = true
+		return nil
+	}
+}
+
+// WithQueuedHandoff hands released locks to the contender that has been
+// waiting the longest instead of whichever contender happens to poll first:
+// Unlock() marks the next waiter (see WithWaiterTracking, which this implies)
+// as eligible and other contenders leave the lock to it, bounding its handoff
+// latency to a single poll. Contenders that do not use queued handoff (ie.
+// instances running an older version) are not held back. If the eligible
+// waiter goes away, the lock is up for grabs again after a few poll
+// intervals.
+func WithQueuedHandoff() Option {
+	return func(r *RLock) error {
+		r.trackWaiters = true
+		r.queuedHandoff = true
+
+		return nil
+	}
+}
+
+// WithPriorityAging keeps low priority contenders from starving when using
+// WithQueuedHandoff: a waiter's priority (see ContextWithPriority) grows by
+// one for every interval it has been waiting, so long waiting contenders
+// eventually outrank newcomers. Without it, higher priority waiters always go
+// first.
+func WithPriorityAging(interval time.Duration) Option {
+	return func(r *RLock) error {
+		if interval <= 0 {
+			return fmt.Errorf("priority aging interval must be positive")
+		}
+
+		r.priorityAging = interval
+
+		return nil
+	}
+}
+
+// ContextWithPriority returns a copy of ctx that makes acquisitions using it
+// (ie. LockAll(), AcquireAny() or LockPriority()) wait with priority; when
+// using WithQueuedHandoff, released locks are handed to the waiter with the
+// highest priority first (and the longest waiting one among those). The
+// default priority is 0.
+func ContextWithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func priorityFrom(ctx context.Context) int {
+	priority, _ := ctx.Value(priorityKey{}).(int)
+	return priority
+}
+
+// WaitersSchema returns the MySQL DDL creating the waiter table for a lock
+// table called table (see WithWaiterTracking).
+func WaitersSchema(table string) string {
+	return fmt.Sprintf(waitersSchemaDDL, table+"_waiters", columnDDL(waitersSchemaColumns))
+}
+
+func (r *RLock) waitersTable() string {
+	return r.table + "_waiters"
+}
+
+// registerWaiter records (or refreshes) that we are waiting for name; failing
+// to do so is not worth failing the acquisition over.
+func (r *RLock) registerWaiter(name string, priority int) {
+	if !r.trackWaiters {
+		return
+	}
+
+	query := fmt.Sprintf("INSERT INTO %v (name, owner, host, pid, priority) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE seen_at=NOW()",
+		r.waitersTable())
+
+	if _, err := r.exec(query, name, r.owner, r.host, r.pid, priority); err != nil {
+		withError(r.logFor(name), err).Warn("unable to register as waiter")
+	}
+}
+
+// deregisterWaiter records that we stopped waiting for name.
+func (r *RLock) deregisterWaiter(name string) {
+	if !r.trackWaiters {
+		return
+	}
+
+	query := fmt.Sprintf("DELETE FROM %v WHERE name=? AND owner=?", r.waitersTable())
+
+	if _, err := r.exec(query, name, r.owner); err != nil {
+		withError(r.logFor(name), err).Warn("unable to deregister as waiter")
+	}
+}
+
+// markNextWaiter makes the highest priority (and, among those, the longest
+// waiting) contender for name eligible to take it over next (see
+// WithQueuedHandoff).
+func (r *RLock) markNextWaiter(name string) {
+	if !r.queuedHandoff {
+		return
+	}
+
+	priority := "priority"
+	args := []interface{}{name, r.clock.Now().Add(-r.waiterTTL())}
+
+	if r.priorityAging > 0 {
+		priority = "priority + FLOOR(TIMESTAMPDIFF(SECOND, since, NOW()) / ?)"
+		args = append(args, r.priorityAging.Seconds())
+	}
+
+	query := fmt.Sprintf("UPDATE %v SET eligible=1 WHERE name=? AND seen_at >= ? ORDER BY eligible DESC, %v DESC, id LIMIT 1",
+		r.waitersTable(), priority)
+
+	if _, err := r.exec(query, args...); err != nil {
+		withError(r.logFor(name), err).Warn("unable to mark the next waiter")
+	}
+}
+
+// waiterTTL returns how long a waiter that has not refreshed its registration
+// still counts as waiting.
+func (r *RLock) waiterTTL() time.Duration {
+	interval := PollInterval
+
+	if r.polling != nil && r.polling.max > interval {
+		interval = r.polling.max
+	}
+
+	if r.retry.maxBackoff > interval {
+		interval = r.retry.maxBackoff
+	}
+
+	return waiterTTLIntervals * interval
+}
+
+// selectLocks returns the lock entries of tables matching where (ie. "WHERE
+// name=?") read from db, including their waiter counts if waiters are
+// tracked.
+func (r *RLock) selectLocks(db *sqlx.DB, tables []string, where string, args ...interface{}) ([]*LockEntry, error) {
+	if r.trackWaiters {
+		args = append([]interface{}{r.clock.Now().Add(-r.waiterTTL())}, args...)
+	}
+
+	entries := make([]*LockEntry, 0)
+
+	for _, table := range tables {
+		query := fmt.Sprintf("SELECT * FROM %v %v", table, where)
+
+		if r.trackWaiters {
+			query = fmt.Sprintf("SELECT l.*, (SELECT COUNT(*) FROM %v w WHERE w.name=l.name AND w.seen_at >= ?) AS waiters FROM %v l %v",
+				r.waitersTable(), table, where)
+		}
+
+		found := make([]*LockEntry, 0)
+
+		if err := r.selectFrom(db, &found, query, args...); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, found...)
+	}
+
+	if len(tables) > 1 {
+		sortByName(entries)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,170 @@
+package rlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithWaiterTracking", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithWaiterTracking())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("registers as a waiter while waiting", func() {
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec(`INSERT INTO rlock_waiters \(name, owner, host, pid, priority\) VALUES \(\?, \?, \?, \?, \?\) ON DUPLICATE KEY UPDATE seen_at=NOW\(\)`).
+			WithArgs("foo", rl.owner, rl.host, rl.pid, 0).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET owner").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE rlock SET timeout_count").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DELETE FROM rlock_waiters WHERE name=\? AND owner=\?`).
+			WithArgs("foo", rl.owner).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := rl.Lock("foo", 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("reports how many contenders are waiting", func() {
+		mock.ExpectQuery(`SELECT l\.\*, \(SELECT COUNT\(\*\) FROM rlock_waiters w WHERE w\.name=l\.name AND w\.seen_at >= \?\) AS waiters FROM rlock l WHERE name=\?`).
+			WithArgs(sqlmock.AnyArg(), "foo").
+			WillReturnRows(sqlmock.NewRows(append(lockEntryColumns, "waiters")).
+				AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now(), 3))
+
+		entry, err := rl.Status("foo")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.Waiters).To(Equal(int64(3)))
+
+		mock.ExpectQuery(`SELECT l\.\*, .* AS waiters FROM rlock l WHERE name=\?`).
+			WillReturnRows(sqlmock.NewRows(append(lockEntryColumns, "waiters")))
+
+		_, err = rl.Status("bar")
+		Expect(err).To(Equal(KeyNotFoundErr))
+
+		mock.ExpectQuery(`SELECT l\.\*, .* AS waiters FROM rlock l ORDER BY name`).
+			WillReturnRows(sqlmock.NewRows(append(lockEntryColumns, "waiters")).
+				AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now(), 2))
+
+		entries, err := rl.ListLocks()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[0].Waiters).To(Equal(int64(2)))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("creates the waiter table", func() {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `rlock_waiters`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT column_name").WillReturnRows(sqlmock.NewRows([]string{"column_name"}).
+			AddRow("acquired_at").AddRow("acquire_count").AddRow("host").AddRow("pid").
+			AddRow("takeover_count").AddRow("timeout_count").AddRow("metadata").AddRow("correlation_id").AddRow("deleted_at").AddRow("owner_labels").AddRow("checkpoint"))
+		mock.ExpectQuery("SELECT column_name").WithArgs("rlock_waiters").
+			WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow("name"))
+		mock.ExpectExec("ALTER TABLE `rlock_waiters` ADD COLUMN `eligible`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("ALTER TABLE `rlock_waiters` ADD COLUMN `priority`").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectSchemaVersion(mock, SchemaVersion)
+
+		Expect(rl.EnsureSchema()).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("WithQueuedHandoff", func() {
+	var (
+		mock sqlmock.Sqlmock
+		rl   *RLock
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithQueuedHandoff())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("marks the longest waiting contender as eligible on unlock", func() {
+		l := rl.newLock("foo", time.Second, 1)
+
+		mock.ExpectExec("UPDATE rlock SET in_use=0").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE rlock_waiters SET eligible=1 WHERE name=\? AND seen_at >= \? ORDER BY eligible DESC, priority DESC, id LIMIT 1`).
+			WithArgs("foo", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(l.Unlock(nil)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("leaves released locks to the eligible waiter", func() {
+		mock.ExpectExec(`UPDATE rlock SET .* WHERE name=\? AND in_use=0 AND owner=\? AND NOT EXISTS \(SELECT 1 FROM rlock_waiters WHERE name=\? AND owner<>\? AND eligible=1 AND seen_at >= \?\)`).
+			WithArgs(rl.owner, rl.host, rl.pid, "foo", "other-owner", "foo", rl.owner, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := rl.takeover(context.Background(), "foo", "other-owner", false)
+		Expect(err).To(HaveOccurred())
+
+		// Stale locks are taken over regardless
+		mock.ExpectExec(`UPDATE rlock SET .* WHERE name=\? AND owner=\?$`).
+			WithArgs(rl.owner, rl.host, rl.pid, "foo", "other-owner").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err = rl.takeover(context.Background(), "foo", "other-owner", true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("registers waiters with their priority", func() {
+		mock.ExpectExec("INSERT INTO rlock ").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{1}, "", time.Now(), time.Now()))
+		mock.ExpectExec("INSERT INTO rlock_waiters").WithArgs("foo", rl.owner, rl.host, rl.pid, 5).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE rlock SET owner").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE rlock SET timeout_count").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("DELETE FROM rlock_waiters").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := rl.LockPriority("foo", 5, 0)
+
+		Expect(err).To(Equal(AcquireTimeoutErr))
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("ages the priority of waiters", func() {
+		db, m, _ := setupMocks()
+
+		rl, err := New(db, WithQueuedHandoff(), WithPriorityAging(30*time.Second))
+		Expect(err).ToNot(HaveOccurred())
+
+		m.ExpectExec(`UPDATE rlock_waiters SET eligible=1 WHERE name=\? AND seen_at >= \? ORDER BY eligible DESC, priority \+ FLOOR\(TIMESTAMPDIFF\(SECOND, since, NOW\(\)\) / \?\) DESC, id LIMIT 1`).
+			WithArgs("foo", sqlmock.AnyArg(), float64(30)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rl.markNextWaiter("foo")
+		Expect(m.ExpectationsWereMet()).ToNot(HaveOccurred())
+
+		_, err = New(db, WithPriorityAging(0))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,98 @@
+package rlock
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	golog "github.com/InVisionApp/go-logger"
+)
+
+// EventWaitSLA is delivered to notifiers when an acquisition has waited for
+// longer than the wait SLA of its lock (see WithWaitSLA); it keeps waiting.
+const EventWaitSLA EventType = "wait_sla"
+
+// WaitSLABreach describes an acquisition waiting for longer than the wait SLA
+// of its lock; see WithWaitSLA.
+type WaitSLABreach struct {
+	Name    string
+	Waited  time.Duration
+	MaxWait time.Duration
+
+	// The lock row of the current holder as of the breach (or, if it could
+	// not be fetched, as of when we started waiting)
+	Holder *LockEntry
+}
+
+type waitSLA struct {
+	pattern  *regexp.Regexp
+	maxWait  time.Duration
+	onBreach func(*WaitSLABreach)
+}
+
+// WithWaitSLA reports acquisitions of locks whose name matches pattern (a
+// glob, see FindLocks(); ie. "deploy-*") that have waited for longer than
+// maxWait, so that somebody can look into the holder long before the
+// acquisition times out. Breaches are reported once per acquisition, which
+// keeps waiting: onBreach (if not nil) is called with the current holder
+// (from the waiting goroutine, so it should return quickly) and an
+// EventWaitSLA notification is delivered to notifiers (see WithNotifier).
+// Breaches are noticed when the waiter wakes up to poll, ie. up to a poll
+// interval late. It can be passed several times; the first matching pattern
+// wins. Session locks (see WithSessionLocks) wait inside MySQL and are not
+// covered.
+func WithWaitSLA(pattern string, maxWait time.Duration, onBreach func(breach *WaitSLABreach)) Option {
+	return func(r *RLock) error {
+		if pattern == "" {
+			return fmt.Errorf("wait SLA pattern cannot be empty")
+		}
+
+		if maxWait <= 0 {
+			return fmt.Errorf("max wait must be positive")
+		}
+
+		r.waitSLAs = append(r.waitSLAs, waitSLA{globToRegexp(pattern), maxWait, onBreach})
+
+		return nil
+	}
+}
+
+// waitSLAFor returns the wait SLA applying to the lock called name, if any.
+func (r *RLock) waitSLAFor(name string) *waitSLA {
+	for i := range r.waitSLAs {
+		if r.waitSLAs[i].pattern.MatchString(name) {
+			return &r.waitSLAs[i]
+		}
+	}
+
+	return nil
+}
+
+// checkWaitSLA reports the acquisition of name (which started waiting at
+// start, behind holder) if it breached sla, returning whether it did.
+func (r *RLock) checkWaitSLA(sla *waitSLA, name string, start time.Time, holder *LockEntry) bool {
+	waited := r.clock.Now().Sub(start)
+	if waited < sla.maxWait {
+		return false
+	}
+
+	if current, err := r.getExistingByName(name); err == nil {
+		holder = current
+	} else {
+		withError(r.logFor(name), err).Warn("unable to fetch holder after breaching the wait SLA")
+	}
+
+	r.logFor(name).WithFields(golog.Fields{
+		"holder":      holder.Owner,
+		"wait_ms":     int64(waited / time.Millisecond),
+		"max_wait_ms": int64(sla.maxWait / time.Millisecond),
+	}).Warn("wait SLA breached")
+
+	if sla.onBreach != nil {
+		sla.onBreach(&WaitSLABreach{Name: name, Waited: waited, MaxWait: sla.maxWait, Holder: holder})
+	}
+
+	r.notify(EventWaitSLA, name, holder, fmt.Sprintf("waited %v (SLA %v)", waited, sla.maxWait))
+
+	return true
+}
@@ -0,0 +1,121 @@
+package rlock
+
+import (
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("WithWaitSLA", func() {
+	var (
+		mock     sqlmock.Sqlmock
+		rl       *RLock
+		clock    *FakeClock
+		notified chanNotifier
+		breaches chan *WaitSLABreach
+	)
+
+	BeforeEach(func() {
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		clock = NewFakeClock(time.Now())
+		notified = make(chanNotifier, 10)
+		breaches = make(chan *WaitSLABreach, 10)
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithClock(clock), WithNotifier(notified, EventWaitSLA),
+			WithWaitSLA("deploy-*", PollInterval, func(b *WaitSLABreach) { breaches <- b }))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	expectContended := func(owner string) {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "deploy-api", owner, []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+	}
+
+	It("validates its options", func() {
+		db, _, _ := setupMocks()
+
+		_, err := New(db, WithWaitSLA("", time.Minute, nil))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(db, WithWaitSLA("deploy-*", 0, nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports a breach once with the current holder and keeps waiting", func() {
+		expectContended("holder-a")
+		mock.ExpectQuery(`SELECT \* FROM rlock WHERE name=\?`).WithArgs("deploy-api").WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "deploy-api", "holder-b", []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		locks := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := rl.Lock("deploy-api", time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+
+			locks <- l
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(PollInterval)
+
+		var b *WaitSLABreach
+		Eventually(breaches).Should(Receive(&b))
+
+		Expect(b.Name).To(Equal("deploy-api"))
+		Expect(b.Waited).To(Equal(PollInterval))
+		Expect(b.MaxWait).To(Equal(PollInterval))
+		Expect(b.Holder.Owner).To(Equal("holder-b"))
+
+		var n *Notification
+		Eventually(notified).Should(Receive(&n))
+
+		Expect(n.Type).To(Equal(EventWaitSLA))
+		Expect(n.Lock.Owner).To(Equal("holder-b"))
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(PollInterval)
+
+		Eventually(locks).Should(Receive(Not(BeNil())))
+		Expect(breaches).ToNot(Receive())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+
+	It("leaves other locks alone", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "other", "holder-a", []byte{1}, "", clock.Now(), clock.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 0))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		locks := make(chan *Lock, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			l, err := rl.Lock("other", time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+
+			locks <- l
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(PollInterval)
+
+		Eventually(locks).Should(Receive(Not(BeNil())))
+		Expect(breaches).ToNot(Receive())
+		Expect(mock.ExpectationsWereMet()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,106 @@
+package rlock
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader holds the hex encoded HMAC-SHA256 of the request
+	// body (keyed with the webhook secret), prefixed with "sha256="
+	WebhookSignatureHeader = "X-Rlock-Signature"
+
+	// WebhookTimeout bounds how long delivering a webhook may take
+	WebhookTimeout = 10 * time.Second
+)
+
+// WebhookNotifier is a Notifier POSTing notifications (as JSON) to a URL,
+// signed with a shared secret (see WebhookSignatureHeader).
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookNotifier returns a Notifier POSTing to webhookURL.
+func NewWebhookNotifier(webhookURL, secret string) (*WebhookNotifier, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url '%v'", webhookURL)
+	}
+
+	if secret == "" {
+		return nil, fmt.Errorf("webhook secret cannot be empty")
+	}
+
+	return &WebhookNotifier{
+		url:    webhookURL,
+		secret: secret,
+		client: &http.Client{Timeout: WebhookTimeout},
+	}, nil
+}
+
+// WithTakeoverWebhook POSTs a signed Notification to webhookURL every time
+// this instance forcibly takes over a stale lock; Notification.Lock holds the
+// displaced owner's metadata. Displaced owners are usually wedged processes
+// someone should go look at. Shorthand for WithNotifier() with a
+// WebhookNotifier and EventTakeover.
+func WithTakeoverWebhook(webhookURL, secret string) Option {
+	return func(r *RLock) error {
+		n, err := NewWebhookNotifier(webhookURL, secret)
+		if err != nil {
+			return err
+		}
+
+		return WithNotifier(n, EventTakeover)(r)
+	}
+}
+
+// SignWebhook returns the WebhookSignatureHeader value for body.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature (the WebhookSignatureHeader value
+// of a webhook request) matches body.
+func VerifyWebhook(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, body)), []byte(signature))
+}
+
+func (w *WebhookNotifier) Notify(n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("unable to marshal payload: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.secret, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status '%v'", resp.Status)
+	}
+
+	return nil
+}
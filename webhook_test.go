@@ -0,0 +1,100 @@
+package rlock
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("Takeover webhook", func() {
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+
+	var (
+		mock       sqlmock.Sqlmock
+		rl         *RLock
+		server     *httptest.Server
+		deliveries chan *delivery
+	)
+
+	BeforeEach(func() {
+		deliveries = make(chan *delivery, 10)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			deliveries <- &delivery{body: body, signature: r.Header.Get(WebhookSignatureHeader)}
+		}))
+
+		mockDB, m, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		mock = m
+
+		rl, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithTakeoverWebhook(server.URL, "s3cret"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("posts the displaced owner's metadata on forced takeovers", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(append(lockEntryColumns, "host", "pid")).
+				AddRow(1, "foo", "wedged-owner", []byte{1}, "", time.Now().Add(-2*MaxAge), time.Now(), "wedged-host", 42))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		var d *delivery
+		Eventually(deliveries).Should(Receive(&d))
+
+		Expect(VerifyWebhook("s3cret", d.body, d.signature)).To(BeTrue())
+		Expect(VerifyWebhook("wrong", d.body, d.signature)).To(BeFalse())
+
+		payload := &Notification{}
+		Expect(json.Unmarshal(d.body, payload)).To(Succeed())
+
+		Expect(payload.Type).To(Equal(EventTakeover))
+		Expect(payload.Name).To(Equal("foo"))
+		Expect(payload.Owner).To(Equal(rl.owner))
+		Expect(payload.Details).To(ContainSubstring("in_use=true"))
+		Expect(payload.Lock.Owner).To(Equal("wedged-owner"))
+		Expect(payload.Lock.Host).To(Equal("wedged-host"))
+		Expect(payload.Lock.PID).To(Equal(42))
+	})
+
+	It("is not invoked for clean handoffs", func() {
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectQuery(`SELECT \* FROM`).WillReturnRows(
+			sqlmock.NewRows(lockEntryColumns).AddRow(1, "foo", "other-owner", []byte{0}, "", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		_, err := rl.Lock("foo", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Consistently(deliveries, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("validates its options", func() {
+		mockDB, _, err := sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithTakeoverWebhook("not a url", "s3cret"))
+		Expect(err).To(HaveOccurred())
+
+		_, err = New(sqlx.NewDb(mockDB, "sqlmock"), WithTakeoverWebhook("https://example.com/hook", ""))
+		Expect(err).To(HaveOccurred())
+	})
+})